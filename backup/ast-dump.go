@@ -0,0 +1,115 @@
+package main // ast-dump.go
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// nova build --emit=ast prints the linked program as a tree. Every node is
+// printed with its type and the position of its token, followed by the
+// fields that aren't empty, one to a line:
+//
+//	VariableDeclarationStatement 2:5 {
+//	    Name: "x"
+//	    Value: IntegerLiteral 2:13 {
+//	        Value: 1
+//	    }
+//	}
+//
+// A position includes the file when it's in another file than the node
+// around it, as in a module the program imports. Unlike Program.String, which is meant for messages, nothing the parser
+// recorded is left out. C headers are printed by path rather than in full.
+
+// dumpAST writes the tree of a program
+func dumpAST(w io.Writer, program *Program) error {
+	var out strings.Builder
+	dumpValue(&out, reflect.ValueOf(program), "", 0)
+	out.WriteString("\n")
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+var tokenType = reflect.TypeOf(Token{})
+
+// dumpValue writes a value starting on the current line. The lines of its
+// fields and elements are indented one level deeper than depth. file is the
+// file of the node the value is in.
+func dumpValue(out *strings.Builder, v reflect.Value, file string, depth int) {
+	indent := strings.Repeat(fmtIndent, depth)
+	switch x := v.Interface().(type) {
+	case *CHeader:
+		fmt.Fprintf(out, "CHeader %q", x.Path)
+		return
+	case *CFunction:
+		fmt.Fprintf(out, "CFunction %s", x.Name)
+		return
+	case Token:
+		fmt.Fprintf(out, "%d:%d %q", x.Line, x.Column, x.Literal)
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			out.WriteString("nil")
+			return
+		}
+		dumpValue(out, v.Elem(), file, depth)
+	case reflect.Struct:
+		t := v.Type()
+		out.WriteString(t.Name())
+		if field := v.FieldByName("Token"); field.IsValid() && field.Type() == tokenType {
+			tok := field.Interface().(Token)
+			out.WriteString(" ")
+			if tok.File != file {
+				out.WriteString(tok.File + ":")
+				file = tok.File
+			}
+			fmt.Fprintf(out, "%d:%d", tok.Line, tok.Column)
+		}
+		out.WriteString(" {")
+		empty := true
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Name == "Token" || !field.IsExported() || v.Field(i).IsZero() {
+				continue
+			}
+			fmt.Fprintf(out, "\n%s%s%s: ", indent, fmtIndent, field.Name)
+			dumpValue(out, v.Field(i), file, depth+1)
+			empty = false
+		}
+		if !empty {
+			out.WriteString("\n" + indent)
+		}
+		out.WriteString("}")
+	case reflect.Slice:
+		out.WriteString("[")
+		for i := 0; i < v.Len(); i++ {
+			out.WriteString("\n" + indent + fmtIndent)
+			dumpValue(out, v.Index(i), file, depth+1)
+		}
+		if v.Len() > 0 {
+			out.WriteString("\n" + indent)
+		}
+		out.WriteString("]")
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		out.WriteString("{")
+		for _, key := range keys {
+			fmt.Fprintf(out, "\n%s%s%q: ", indent, fmtIndent, fmt.Sprint(key))
+			dumpValue(out, v.MapIndex(key), file, depth+1)
+		}
+		if len(keys) > 0 {
+			out.WriteString("\n" + indent)
+		}
+		out.WriteString("}")
+	case reflect.String:
+		fmt.Fprintf(out, "%q", v.String())
+	default:
+		fmt.Fprint(out, v.Interface())
+	}
+}
@@ -46,9 +46,15 @@ type Expression interface {
 
 // Program is the root node of every AST
 type Program struct {
+	Module      *ModuleDeclaration
 	Imports     []*ImportStatement
 	Interfaces  []*InterfaceDefinition
+	Structs     []*StructDefinition
+	Enums       []*EnumDefinition
 	Functions   []*FunctionDefinition
+	Externs    []*ExternFunction
+	Links      []*LinkDirective
+	Headers    map[string]*CHeader // imported C headers by namespace
 }
 
 func (p *Program) TokenLiteral() string {
@@ -61,16 +67,41 @@ func (p *Program) TokenLiteral() string {
 func (p *Program) String() string {
 	var out strings.Builder
 	
+	if p.Module != nil {
+		out.WriteString(p.Module.String())
+		out.WriteString("\n")
+	}
+
 	for _, imp := range p.Imports {
 		out.WriteString(imp.String())
 		out.WriteString("\n")
 	}
 
+	for _, link := range p.Links {
+		out.WriteString(link.String())
+		out.WriteString("\n")
+	}
+
 	for _, intf := range p.Interfaces {
 		out.WriteString(intf.String())
 		out.WriteString("\n")
 	}
 
+	for _, st := range p.Structs {
+		out.WriteString(st.String())
+		out.WriteString("\n")
+	}
+
+	for _, enum := range p.Enums {
+		out.WriteString(enum.String())
+		out.WriteString("\n")
+	}
+
+	for _, ext := range p.Externs {
+		out.WriteString(ext.String())
+		out.WriteString("\n")
+	}
+
 	for _, fn := range p.Functions {
 		out.WriteString(fn.String())
 		out.WriteString("\n")
@@ -79,16 +110,74 @@ func (p *Program) String() string {
 	return out.String()
 }
 
-// ImportStatement represents an import statement
+// ModuleDeclaration names a module and the version it provides:
+// module "net/http" version "1.2.0"
+type ModuleDeclaration struct {
+	Token   Token // module token
+	Name    string
+	Version string
+}
+
+func (md *ModuleDeclaration) statementNode() {}
+func (md *ModuleDeclaration) TokenLiteral() string { return md.Token.Literal }
+func (md *ModuleDeclaration) String() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "module \"%s\"", md.Name)
+	if md.Version != "" {
+		fmt.Fprintf(&out, " version \"%s\"", md.Version)
+	}
+	out.WriteString(";")
+	return out.String()
+}
+
+// LinkDirective links a program with the libraries of a pkg-config
+// package: link "glib-2.0";
+type LinkDirective struct {
+	Token   Token // link token
+	Package string
+}
+
+func (ld *LinkDirective) statementNode() {}
+func (ld *LinkDirective) TokenLiteral() string { return ld.Token.Literal }
+func (ld *LinkDirective) String() string {
+	return fmt.Sprintf("link \"%s\";", ld.Package)
+}
+
+// ImportStatement represents an import statement. Besides the path it can
+// carry a source (from "virtual" import "stdio"), an alias (as sha), the
+// names it selects (fs.{read_file, write_file}) and a version constraint.
 type ImportStatement struct {
-	Token Token // import token
-	Path  string
+	Token   Token // import or from token
+	From    string
+	Path    string
+	Alias   string
+	Names   []*Identifier
+	Version string
 }
 
 func (is *ImportStatement) statementNode() {}
 func (is *ImportStatement) TokenLiteral() string { return is.Token.Literal }
 func (is *ImportStatement) String() string {
-	return fmt.Sprintf("import \"%s\";", is.Path)
+	var out strings.Builder
+	if is.From != "" {
+		fmt.Fprintf(&out, "from \"%s\" ", is.From)
+	}
+	fmt.Fprintf(&out, "import \"%s\"", is.Path)
+	if len(is.Names) > 0 {
+		names := make([]string, len(is.Names))
+		for i, name := range is.Names {
+			names[i] = name.Value
+		}
+		fmt.Fprintf(&out, ".{%s}", strings.Join(names, ", "))
+	}
+	if is.Alias != "" {
+		fmt.Fprintf(&out, " as %s", is.Alias)
+	}
+	if is.Version != "" {
+		fmt.Fprintf(&out, " version \"%s\"", is.Version)
+	}
+	out.WriteString(";")
+	return out.String()
 }
 
 // InterfaceDefinition represents an interface definition
@@ -116,6 +205,49 @@ func (id *InterfaceDefinition) String() string {
 	return out.String()
 }
 
+// StructDefinition represents a struct definition
+type StructDefinition struct {
+	Token  Token // struct name token
+	Name   string
+	Fields []*FieldDefinition
+}
+
+func (sd *StructDefinition) statementNode() {}
+func (sd *StructDefinition) TokenLiteral() string { return sd.Token.Literal }
+func (sd *StructDefinition) String() string {
+	var out strings.Builder
+	out.WriteString("struct ")
+	out.WriteString(sd.Name)
+	out.WriteString(" {\n")
+	
+	for _, field := range sd.Fields {
+		out.WriteString("    ")
+		out.WriteString(field.String())
+		out.WriteString("\n")
+	}
+	
+	out.WriteString("}")
+	return out.String()
+}
+
+// EnumDefinition represents an enum definition. Members are numbered from
+// zero in declaration order.
+type EnumDefinition struct {
+	Token   Token // enum name token
+	Name    string
+	Members []*Identifier
+}
+
+func (ed *EnumDefinition) statementNode() {}
+func (ed *EnumDefinition) TokenLiteral() string { return ed.Token.Literal }
+func (ed *EnumDefinition) String() string {
+	members := []string{}
+	for _, member := range ed.Members {
+		members = append(members, member.Value)
+	}
+	return "enum " + ed.Name + " { " + strings.Join(members, ", ") + " }"
+}
+
 // FieldDefinition represents a field in an interface or struct
 type FieldDefinition struct {
 	Token Token
@@ -129,23 +261,53 @@ func (fd *FieldDefinition) String() string {
 	return fmt.Sprintf("%s %s;", fd.Type.String(), fd.Name)
 }
 
-// TypeSpecifier represents a type specification
+// TypeSpecifier represents a type specification. Pointers, arrays and slices
+// wrap the specifier of their element type.
 type TypeSpecifier struct {
 	Token     Token
-	TypeName  string
-	IsPointer bool
+	TypeName  string         // name of a named type, empty for pointers, arrays, slices and functions
+	IsPointer bool           // pointer to Elem
+	Elem      *TypeSpecifier // element type of pointers, arrays and slices
+	Length    int            // array length, or -1 for a slice
+	Func      *FuncType      // signature of a function type
+}
+
+// FuncType is the signature of a function type: func(int, *void) -> int
+type FuncType struct {
+	Params     []TypeSpecifier
+	ReturnType TypeSpecifier // empty for functions that return nothing
+}
+
+// IsEmpty checks if no type was given, as in a declaration whose type is inferred
+func (ts *TypeSpecifier) IsEmpty() bool {
+	return ts.TypeName == "" && ts.Elem == nil && ts.Func == nil
 }
 
 func (ts *TypeSpecifier) String() string {
-	if ts.IsPointer {
-		return ts.TypeName + "*"
+	switch {
+	case ts.Func != nil:
+		params := make([]string, 0, len(ts.Func.Params))
+		for _, param := range ts.Func.Params {
+			params = append(params, param.String())
+		}
+		if ts.Func.ReturnType.IsEmpty() {
+			return "func(" + strings.Join(params, ", ") + ")"
+		}
+		return "func(" + strings.Join(params, ", ") + ") -> " + ts.Func.ReturnType.String()
+	case ts.IsPointer:
+		return "*" + ts.Elem.String()
+	case ts.Elem != nil && ts.Length < 0:
+		return "[]" + ts.Elem.String()
+	case ts.Elem != nil:
+		return fmt.Sprintf("[%d]%s", ts.Length, ts.Elem.String())
+	default:
+		return ts.TypeName
 	}
-	return ts.TypeName
 }
 
 // FunctionDefinition represents a function definition
 type FunctionDefinition struct {
-	Token      Token // func token
+	Token      Token // function name token
 	Name       string
 	Parameters []*ParameterDefinition
 	ReturnType TypeSpecifier
@@ -175,6 +337,63 @@ func (fd *FunctionDefinition) String() string {
 	return out.String()
 }
 
+// ExternFunction is a function implemented outside Nova. Nova code calls it
+// by Name with Nova types; it links against Symbol. Functions imported from C
+// headers keep the C declaration, which gives the exact types to declare.
+// Others are declared in the source:
+//
+//	@callconv("fast") extern "C" func name(params, ...) -> type;
+type ExternFunction struct {
+	Token       Token // where the function was declared or first used
+	Name        string
+	Symbol      string
+	Parameters  []*ParameterDefinition
+	ReturnType  TypeSpecifier
+	Variadic    bool
+	C           *CFunction
+	ABI         string
+	Attributes  []*Attribute
+	CallingConv string // set from the attributes by the checker
+	Linkage     string
+}
+
+func (ef *ExternFunction) statementNode() {}
+func (ef *ExternFunction) TokenLiteral() string { return ef.Token.Literal }
+func (ef *ExternFunction) String() string {
+	params := []string{}
+	for _, p := range ef.Parameters {
+		params = append(params, p.String())
+	}
+	if ef.Variadic {
+		params = append(params, "...")
+	}
+	var out strings.Builder
+	for _, attr := range ef.Attributes {
+		out.WriteString(attr.String() + " ")
+	}
+	out.WriteString("extern ")
+	if ef.ABI != "" {
+		out.WriteString(fmt.Sprintf("%q ", ef.ABI))
+	}
+	out.WriteString(fmt.Sprintf("func %s(%s) -> %s", ef.Name, strings.Join(params, ", "), ef.ReturnType.String()))
+	return out.String()
+}
+
+// Attribute annotates a declaration: @name("arg", ...)
+type Attribute struct {
+	Token Token // the name
+	Name  string
+	Args  []string
+}
+
+func (a *Attribute) String() string {
+	args := []string{}
+	for _, arg := range a.Args {
+		args = append(args, fmt.Sprintf("%q", arg))
+	}
+	return fmt.Sprintf("@%s(%s)", a.Name, strings.Join(args, ", "))
+}
+
 // ParameterDefinition represents a function parameter
 type ParameterDefinition struct {
 	Token Token
@@ -371,6 +590,18 @@ func (ws *WhileStatement) String() string {
 	return out.String()
 }
 
+// UnsafeStatement is a block in which pointers may be used in arithmetic
+type UnsafeStatement struct {
+	Token Token // unsafe token
+	Body  *BlockStatement
+}
+
+func (us *UnsafeStatement) statementNode() {}
+func (us *UnsafeStatement) TokenLiteral() string { return us.Token.Literal }
+func (us *UnsafeStatement) String() string {
+	return "unsafe " + us.Body.String()
+}
+
 // ForStatement represents a for loop
 type ForStatement struct {
 	Token      Token // for token
@@ -427,10 +658,11 @@ func (cs *ContinueStatement) statementNode() {}
 func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
 func (cs *ContinueStatement) String() string { return "continue;" }
 
-// IntegerLiteral represents an integer literal
+// IntegerLiteral represents an integer literal. Literals are never
+// negative, as -1 is a PrefixExpression, so a uint64 holds every one.
 type IntegerLiteral struct {
 	Token Token
-	Value int64
+	Value uint64
 }
 
 func (il *IntegerLiteral) expressionNode() {}
@@ -457,6 +689,15 @@ func (bl *BooleanLiteral) expressionNode() {}
 func (bl *BooleanLiteral) TokenLiteral() string { return bl.Token.Literal }
 func (bl *BooleanLiteral) String() string { return bl.Token.Literal }
 
+// NullLiteral is NULL, the null pointer. It converts to any pointer type.
+type NullLiteral struct {
+	Token Token
+}
+
+func (nl *NullLiteral) expressionNode() {}
+func (nl *NullLiteral) TokenLiteral() string { return nl.Token.Literal }
+func (nl *NullLiteral) String() string { return "NULL" }
+
 // CharLiteral represents a character literal
 type CharLiteral struct {
 	Token Token
@@ -595,6 +836,74 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// InterpolatedString represents a string with embedded expressions, like
+// "Hello, #{name}". Parts alternate between string literals and expressions.
+type InterpolatedString struct {
+	Token Token // The string token
+	Parts []Expression
+}
+
+func (is *InterpolatedString) expressionNode() {}
+func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
+func (is *InterpolatedString) String() string { return "\"" + is.Token.Literal + "\"" }
+
+// ConversionExpression represents a conversion to a built-in type, like int64(x)
+type ConversionExpression struct {
+	Token Token // The type name token
+	Type  TypeSpecifier
+	Value Expression
+}
+
+func (ce *ConversionExpression) expressionNode() {}
+func (ce *ConversionExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *ConversionExpression) String() string {
+	return ce.Type.String() + "(" + ce.Value.String() + ")"
+}
+
+// SliceExpression represents slicing an array or slice, like a[1:3]
+type SliceExpression struct {
+	Token Token // The '[' token
+	Left  Expression
+	Low   Expression // nil means 0
+	High  Expression // nil means the length
+}
+
+func (se *SliceExpression) expressionNode() {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) String() string {
+	var out strings.Builder
+	
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Low != nil {
+		out.WriteString(se.Low.String())
+	}
+	out.WriteString(":")
+	if se.High != nil {
+		out.WriteString(se.High.String())
+	}
+	out.WriteString("])")
+	
+	return out.String()
+}
+
+// ArrayLiteral represents an array literal like [1, 2, 3]
+type ArrayLiteral struct {
+	Token    Token // The '[' token
+	Elements []Expression
+}
+
+func (al *ArrayLiteral) expressionNode() {}
+func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) String() string {
+	elements := []string{}
+	for _, el := range al.Elements {
+		elements = append(elements, el.String())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
 // DotExpression represents a member access expression
 type DotExpression struct {
 	Token  Token // The '.' token
@@ -616,8 +925,10 @@ func (de *DotExpression) String() string {
 
 // StructLiteralExpression represents a struct literal
 type StructLiteralExpression struct {
-	Token  Token // The '{' token
-	Fields map[string]Expression
+	Token    Token // The '{' token
+	TypeName string // empty when the type comes from context
+	Fields   map[string]Expression
+	Names    []*Identifier // field names in source order
 }
 
 func (sl *StructLiteralExpression) expressionNode() {}
@@ -626,10 +937,11 @@ func (sl *StructLiteralExpression) String() string {
 	var out strings.Builder
 	pairs := []string{}
 	
-	for key, value := range sl.Fields {
-		pairs = append(pairs, key + ": " + value.String())
+	for _, name := range sl.Names {
+		pairs = append(pairs, name.Value + ": " + sl.Fields[name.Value].String())
 	}
 	
+	out.WriteString(sl.TypeName)
 	out.WriteString("{ ")
 	out.WriteString(strings.Join(pairs, ", "))
 	out.WriteString(" }")
@@ -654,4 +966,22 @@ func (ae *AssignmentExpression) String() string {
 	out.WriteString(ae.Value.String())
 	
 	return out.String()
-}
\ No newline at end of file
+}
+
+// ErrorStatement stands in for a statement that failed to parse
+type ErrorStatement struct {
+	Token Token // first token of the statement
+}
+
+func (es *ErrorStatement) statementNode() {}
+func (es *ErrorStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ErrorStatement) String() string { return "<error>;" }
+
+// ErrorExpression stands in for an expression that failed to parse
+type ErrorExpression struct {
+	Token Token // token where the error was found
+}
+
+func (ee *ErrorExpression) expressionNode() {}
+func (ee *ErrorExpression) TokenLiteral() string { return ee.Token.Literal }
+func (ee *ErrorExpression) String() string { return "<error>" }
@@ -0,0 +1,903 @@
+package main // cheaders.go
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// C headers are imported from JSON dumps of clang's AST, made with
+//
+//	clang -Xclang -ast-dump=json -fsyntax-only header.h
+//
+// and trimmed by headers/generate.sh. The dumps are checked in, so compiling
+// a program doesn't need clang or the header itself; headers/README.md says
+// how the ones there were made and checked. Functions, structs,
+// unions, enums and typedefs are read from the dump; everything else, such
+// as macros and variables, is ignored.
+
+// clangNode is a node of clang's JSON AST. Only the fields the header loader
+// uses are decoded.
+type clangNode struct {
+	ID                 string       `json:"id"`
+	Kind               string       `json:"kind"`
+	Name               string       `json:"name"`
+	Type               *clangType   `json:"type"`
+	TagUsed            string       `json:"tagUsed"`
+	CompleteDefinition bool         `json:"completeDefinition"`
+	StorageClass       string       `json:"storageClass"`
+	Variadic           bool         `json:"variadic"`
+	Value              string       `json:"value"`
+	Decl               *clangNode   `json:"decl"`
+	OwnedTagDecl       *clangNode   `json:"ownedTagDecl"`
+	Inner              []*clangNode `json:"inner"`
+}
+
+// clangType is the type of a node as clang spells it
+type clangType struct {
+	QualType string `json:"qualType"`
+}
+
+// CTypeKind identifies the kind of a C type
+type CTypeKind int
+
+const (
+	CKindVoid CTypeKind = iota
+	CKindBool
+	CKindInt
+	CKindFloat
+	CKindPointer
+	CKindArray
+	CKindFunc
+	CKindRecord
+	CKindEnum
+)
+
+// CType is a C type read from a header
+type CType struct {
+	Kind     CTypeKind
+	Bits     int        // size of CKindInt and CKindFloat types
+	Unsigned bool       // CKindInt
+	Char     bool       // plain char, whose pointers are C strings
+	Elem     *CType     // CKindPointer and CKindArray
+	Length   int        // CKindArray
+	Func     *CFunction // CKindFunc
+	Record   *CRecord   // CRecord
+	Enum     *CEnum     // CEnum
+	Spelling string     // the type as written in the header
+}
+
+// String returns the type as written in the header, or spelled out for
+// types that are only part of what the header wrote
+func (ct *CType) String() string {
+	if ct.Spelling != "" {
+		return ct.Spelling
+	}
+	switch ct.Kind {
+	case CKindVoid:
+		return "void"
+	case CKindBool:
+		return "_Bool"
+	case CKindInt:
+		name := map[int]string{8: "char", 16: "short", 32: "int", 64: "long"}[ct.Bits]
+		if ct.Unsigned {
+			return "unsigned " + name
+		}
+		return name
+	case CKindFloat:
+		return map[int]string{32: "float", 64: "double", 80: "long double"}[ct.Bits]
+	case CKindPointer:
+		return ct.Elem.String() + " *"
+	case CKindArray:
+		return fmt.Sprintf("%s [%d]", ct.Elem, ct.Length)
+	case CKindFunc:
+		return "function"
+	case CKindRecord:
+		return ct.Record.Tag + " " + ct.Record.Name
+	case CKindEnum:
+		return "enum " + ct.Enum.Name
+	}
+	return "?"
+}
+
+// CFunction is a function declared by a C header
+type CFunction struct {
+	Name     string
+	Return   *CType
+	Params   []*CType
+	Variadic bool
+}
+
+// CRecord is a struct or union declared by a C header. A record without a
+// complete definition can only be used through pointers.
+type CRecord struct {
+	Tag      string // struct or union
+	Name     string
+	Fields   []*CField
+	Complete bool
+}
+
+// CField is a field of a C record
+type CField struct {
+	Name string
+	Type *CType
+}
+
+// CEnum is an enum declared by a C header. Its constants are stored with
+// the header, since C doesn't qualify them with the enum.
+type CEnum struct {
+	Name string
+}
+
+// CHeader holds the declarations of a C header
+type CHeader struct {
+	Path      string // path the header was imported by
+	File      string // the JSON dump
+	Functions map[string]*CFunction
+	Constants map[string]int64  // enum constants
+	Types     map[string]*CType // typedefs, and tags no typedef hides
+
+	records     map[string]*CRecord // by tag and name, as in "struct _IO_FILE"
+	enums       map[string]*CEnum   // by "enum" and name
+	typedefs    map[string]*CType
+	decls       map[string]interface{} // records and enums by node id
+	pending     map[string]*clangNode  // typedefs not resolved yet
+	recordNames map[*CRecord]string    // the name Nova calls each record by
+}
+
+// LoadCHeader reads the JSON AST dump of a C header
+func LoadCHeader(importPath, file string) (*CHeader, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCHeader(importPath, file, data)
+}
+
+// ParseCHeader reads a JSON AST dump that has already been read from file
+func ParseCHeader(importPath, file string, data []byte) (*CHeader, error) {
+	var root clangNode
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	if root.Kind != "TranslationUnitDecl" {
+		return nil, fmt.Errorf("%s: not a clang AST dump", file)
+	}
+
+	h := &CHeader{
+		Path:        importPath,
+		File:        file,
+		Functions:   make(map[string]*CFunction),
+		Constants:   make(map[string]int64),
+		Types:       make(map[string]*CType),
+		records:     make(map[string]*CRecord),
+		enums:       make(map[string]*CEnum),
+		typedefs:    make(map[string]*CType),
+		decls:       make(map[string]interface{}),
+		pending:     make(map[string]*clangNode),
+		recordNames: make(map[*CRecord]string),
+	}
+
+	// Tags and typedef names are collected first so declarations can refer
+	// to types declared after them
+	for _, node := range root.Inner {
+		switch node.Kind {
+		case "RecordDecl":
+			h.declareRecord(node)
+		case "EnumDecl":
+			h.declareEnum(node)
+		case "TypedefDecl":
+			h.pending[node.Name] = node
+		}
+	}
+	for _, node := range root.Inner {
+		if node.Kind == "TypedefDecl" {
+			h.typedef(node.Name)
+		}
+	}
+	for _, node := range root.Inner {
+		switch node.Kind {
+		case "RecordDecl":
+			h.defineRecord(node)
+		case "FunctionDecl":
+			h.declareFunction(node)
+		}
+	}
+	h.exportTypes(&root)
+
+	return h, nil
+}
+
+// exportTypes collects the types Nova code can name: every typedef, and
+// every struct, union or enum tag no typedef hides. A record is called by
+// the first typedef that names it, or else by its tag, so typedef struct
+// _XDisplay Display makes Display and _XDisplay the same type.
+func (h *CHeader) exportTypes(root *clangNode) {
+	for _, node := range root.Inner {
+		if node.Kind != "TypedefDecl" {
+			continue
+		}
+		typ, ok := h.typedefs[node.Name]
+		if !ok {
+			continue
+		}
+		h.Types[node.Name] = typ
+		if typ.Kind == CKindRecord && h.recordNames[typ.Record] == "" {
+			h.recordNames[typ.Record] = node.Name
+		}
+	}
+
+	for _, node := range root.Inner {
+		var typ *CType
+		switch decl := h.decls[node.ID].(type) {
+		case *CRecord:
+			typ = &CType{Kind: CKindRecord, Record: decl}
+		case *CEnum:
+			typ = &CType{Kind: CKindEnum, Enum: decl}
+		}
+		if typ == nil || node.Name == "" {
+			continue
+		}
+		if _, hidden := h.Types[node.Name]; hidden {
+			continue
+		}
+		h.Types[node.Name] = typ
+		if typ.Kind == CKindRecord && h.recordNames[typ.Record] == "" {
+			h.recordNames[typ.Record] = node.Name
+		}
+	}
+}
+
+// RecordName returns the name Nova calls a record of the header by, or ""
+// for a record that can't be named, such as an anonymous struct in a union
+func (h *CHeader) RecordName(rec *CRecord) string {
+	return h.recordNames[rec]
+}
+
+// declareRecord registers a struct or union by its tag
+func (h *CHeader) declareRecord(node *clangNode) {
+	key := node.TagUsed + " " + node.Name
+	rec, ok := h.records[key]
+	if !ok || node.Name == "" {
+		rec = &CRecord{Tag: node.TagUsed, Name: node.Name}
+		if node.Name != "" {
+			h.records[key] = rec
+		}
+	}
+	h.decls[node.ID] = rec
+}
+
+// defineRecord resolves the fields of a record with a complete definition.
+// A record with a field whose type can't be read stays incomplete.
+func (h *CHeader) defineRecord(node *clangNode) {
+	rec, _ := h.decls[node.ID].(*CRecord)
+	if rec == nil || !node.CompleteDefinition || rec.Complete {
+		return
+	}
+
+	fields := []*CField{}
+	for _, inner := range node.Inner {
+		if inner.Kind != "FieldDecl" {
+			continue
+		}
+		typ, err := h.parseType(inner.Type.QualType)
+		if err != nil {
+			return
+		}
+		fields = append(fields, &CField{Name: inner.Name, Type: typ})
+	}
+	rec.Fields = fields
+	rec.Complete = true
+}
+
+// declareEnum registers an enum and its constants. Constants without an
+// explicit value follow the previous one.
+func (h *CHeader) declareEnum(node *clangNode) {
+	enum := &CEnum{Name: node.Name}
+	if node.Name != "" {
+		h.enums["enum "+node.Name] = enum
+	}
+	h.decls[node.ID] = enum
+
+	next := int64(0)
+	for _, inner := range node.Inner {
+		if inner.Kind != "EnumConstantDecl" {
+			continue
+		}
+		if value, ok := constantExprValue(inner); ok {
+			next = value
+		}
+		h.Constants[inner.Name] = next
+		next++
+	}
+}
+
+// constantExprValue finds the value clang computed for an enum constant's
+// initializer
+func constantExprValue(node *clangNode) (int64, bool) {
+	for _, inner := range node.Inner {
+		if inner.Kind == "ConstantExpr" && inner.Value != "" {
+			value, err := strconv.ParseInt(inner.Value, 10, 64)
+			if err != nil {
+				// Values that only fit unsigned keep their bits
+				u, err := strconv.ParseUint(inner.Value, 10, 64)
+				if err != nil {
+					return 0, false
+				}
+				value = int64(u)
+			}
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+// typedef resolves a typedef name, resolving the typedefs it refers to first
+func (h *CHeader) typedef(name string) (*CType, bool) {
+	if typ, ok := h.typedefs[name]; ok {
+		return typ, typ != nil
+	}
+	node, ok := h.pending[name]
+	if !ok {
+		return nil, false
+	}
+
+	// Mark the name while it's resolved so a typedef that refers to itself
+	// fails instead of recursing
+	h.typedefs[name] = nil
+	delete(h.pending, name)
+
+	var typ *CType
+	if decl := h.tagDecl(node); decl != nil {
+		// Anonymous structs and enums can only be named through the typedef
+		switch decl := decl.(type) {
+		case *CRecord:
+			if decl.Name == "" {
+				decl.Name = name
+			}
+			typ = &CType{Kind: CKindRecord, Record: decl}
+		case *CEnum:
+			if decl.Name == "" {
+				decl.Name = name
+			}
+			typ = &CType{Kind: CKindEnum, Enum: decl}
+		}
+	} else {
+		parsed, err := h.parseType(node.Type.QualType)
+		if err != nil {
+			return nil, false
+		}
+		typ = parsed
+	}
+
+	// Keep the typedef name, which is how the header spells the type
+	typ = typ.withSpelling(name)
+	h.typedefs[name] = typ
+	return typ, true
+}
+
+// tagDecl returns the record or enum a typedef names directly, as in
+// typedef struct { ... } GFoo
+func (h *CHeader) tagDecl(node *clangNode) interface{} {
+	spelling := node.Type.QualType
+	if !strings.HasPrefix(spelling, "struct ") && !strings.HasPrefix(spelling, "union ") &&
+		!strings.HasPrefix(spelling, "enum ") {
+		return nil
+	}
+
+	var found interface{}
+	var visit func(n *clangNode)
+	visit = func(n *clangNode) {
+		for _, ref := range []*clangNode{n.OwnedTagDecl, n.Decl} {
+			if ref != nil && found == nil {
+				found = h.decls[ref.ID]
+			}
+		}
+		for _, inner := range n.Inner {
+			visit(inner)
+		}
+	}
+	visit(node)
+	return found
+}
+
+// declareFunction registers a function with external linkage
+func (h *CHeader) declareFunction(node *clangNode) {
+	if node.StorageClass == "static" || node.Type == nil {
+		return
+	}
+	typ, err := h.parseType(node.Type.QualType)
+	if err != nil || typ.Kind != CKindFunc {
+		return
+	}
+	fn := *typ.Func
+	fn.Name = node.Name
+	h.Functions[node.Name] = &fn
+}
+
+// withSpelling returns a copy of the type spelled differently
+func (ct *CType) withSpelling(spelling string) *CType {
+	copy := *ct
+	copy.Spelling = spelling
+	return &copy
+}
+
+// NovaType returns the type a value of a C type has in Nova, or an error
+// saying why it has none. A char * parameter or result is a Nova string, so
+// string literals can be passed to C directly.
+func (ct *CType) NovaType(top bool) (string, error) {
+	switch ct.Kind {
+	case CKindVoid:
+		return "void", nil
+	case CKindBool:
+		return "bool", nil
+	case CKindInt:
+		switch {
+		case ct.Bits == 32 && !ct.Unsigned:
+			return "int", nil
+		case ct.Unsigned:
+			return fmt.Sprintf("uint%d", ct.Bits), nil
+		default:
+			return fmt.Sprintf("int%d", ct.Bits), nil
+		}
+	case CKindFloat:
+		switch ct.Bits {
+		case 32:
+			return "float", nil
+		case 64:
+			return "float64", nil
+		}
+	case CKindEnum:
+		return "int", nil
+	case CKindPointer:
+		if top && ct.Elem.Kind == CKindInt && ct.Elem.Char {
+			return "string", nil
+		}
+		if ct.Elem.Kind == CKindFunc {
+			return ct.Elem.Func.novaFuncType(), nil
+		}
+		// Pointers to records are opaque in Nova
+		elem, err := ct.Elem.NovaType(false)
+		if err != nil || ct.Elem.Kind == CKindRecord {
+			elem = "void"
+		}
+		return "*" + elem, nil
+	case CKindRecord:
+		return "", fmt.Errorf("%s can't be passed by value", ct)
+	}
+	return "", fmt.Errorf("%s has no Nova equivalent", ct)
+}
+
+// novaValueType returns the Nova type of a C value that lives in memory, such
+// as a field, in a header imported as namespace. Unlike NovaType, records
+// and arrays have one: a record is named by the header's namespace, and an
+// array is a Nova array of the same layout. Nova bools are bits, so _Bool
+// has no equivalent in memory.
+func (h *CHeader) novaValueType(namespace string, ct *CType) (string, error) {
+	switch ct.Kind {
+	case CKindBool:
+		return "", fmt.Errorf("%s is a byte in memory, not a Nova bool", ct)
+	case CKindRecord:
+		name := h.RecordName(ct.Record)
+		if name == "" {
+			return "", fmt.Errorf("%s has no name Nova can refer to", ct)
+		}
+		return namespace + "." + name, nil
+	case CKindArray:
+		elem, err := h.novaValueType(namespace, ct.Elem)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("[%d]%s", ct.Length, elem), nil
+	}
+	return ct.NovaType(false)
+}
+
+// cSizeAlign returns the size and alignment in bytes of a C type on x86-64
+func cSizeAlign(ct *CType) (size, align int) {
+	switch ct.Kind {
+	case CKindBool:
+		return 1, 1
+	case CKindInt:
+		return ct.Bits / 8, ct.Bits / 8
+	case CKindFloat:
+		// long double is padded to 16 bytes
+		if ct.Bits == 80 {
+			return 16, 16
+		}
+		return ct.Bits / 8, ct.Bits / 8
+	case CKindEnum:
+		return 4, 4
+	case CKindPointer:
+		return 8, 8
+	case CKindArray:
+		size, align = cSizeAlign(ct.Elem)
+		return size * ct.Length, align
+	case CKindRecord:
+		return cRecordSizeAlign(ct.Record)
+	}
+	return 0, 1
+}
+
+// cRecordSizeAlign returns the size and alignment of a struct or union. Each
+// struct field is aligned after the one before it, while the fields of a
+// union overlap; either way the size is rounded up to the alignment.
+func cRecordSizeAlign(rec *CRecord) (size, align int) {
+	align = 1
+	for _, field := range rec.Fields {
+		fieldSize, fieldAlign := cSizeAlign(field.Type)
+		if fieldAlign > align {
+			align = fieldAlign
+		}
+		if rec.Tag == "union" {
+			if fieldSize > size {
+				size = fieldSize
+			}
+			continue
+		}
+		size = alignUp(size, fieldAlign) + fieldSize
+	}
+	return alignUp(size, align), align
+}
+
+// alignUp rounds n up to a multiple of align
+func alignUp(n, align int) int {
+	return (n + align - 1) / align * align
+}
+
+// cHasLayout checks if a record has a definition, as do the records it
+// holds by value, so its size is known
+func cHasLayout(rec *CRecord, seen map[*CRecord]bool) bool {
+	if !rec.Complete || seen[rec] {
+		return false
+	}
+	seen[rec] = true
+	defer delete(seen, rec)
+
+	for _, field := range rec.Fields {
+		typ := field.Type
+		for typ.Kind == CKindArray {
+			typ = typ.Elem
+		}
+		if typ.Kind == CKindRecord && !cHasLayout(typ.Record, seen) {
+			return false
+		}
+	}
+	return true
+}
+
+// novaFuncType returns the Nova function type a pointer to a C function
+// has, so only functions with the same signature can be passed as callbacks.
+// Callbacks are called by C, so their strings are plain char *. A pointer to
+// a variadic function, or to one Nova can't express, is opaque.
+func (fn *CFunction) novaFuncType() string {
+	if fn.Variadic {
+		return "*void"
+	}
+	params := make([]string, 0, len(fn.Params))
+	for _, param := range fn.Params {
+		typ, err := param.NovaType(false)
+		if err != nil {
+			return "*void"
+		}
+		params = append(params, typ)
+	}
+	ret, err := fn.Return.NovaType(false)
+	if err != nil {
+		return "*void"
+	}
+	return funcTypeString(params, ret)
+}
+
+// cTypeParser parses the spelling of a type in clang's AST, such as
+// "const char *restrict" or "void (*)(int)"
+type cTypeParser struct {
+	header *CHeader
+	tokens []string
+	pos    int
+}
+
+// parseType parses the spelling of a type
+func (h *CHeader) parseType(spelling string) (*CType, error) {
+	spelling = stripCAttributes(spelling)
+	p := &cTypeParser{header: h, tokens: tokenizeCType(spelling)}
+	typ, err := p.typeName()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", spelling, err)
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("%s: unexpected %q", spelling, p.tokens[p.pos])
+	}
+	return typ.withSpelling(spelling), nil
+}
+
+// stripCAttributes removes GNU attributes, which clang keeps in the
+// spelling of function types such as "void (int) __attribute__((noreturn))"
+func stripCAttributes(s string) string {
+	for {
+		start := strings.Index(s, "__attribute__")
+		if start < 0 {
+			return strings.TrimSpace(s)
+		}
+		end := start + len("__attribute__")
+		depth := 0
+		for end < len(s) {
+			if s[end] == '(' {
+				depth++
+			} else if s[end] == ')' {
+				depth--
+			}
+			end++
+			if depth == 0 && s[end-1] == ')' {
+				break
+			}
+		}
+		s = s[:start] + s[end:]
+	}
+}
+
+// tokenizeCType splits a type spelling into identifiers, numbers and
+// punctuation. Clang's names for anonymous types, such as
+// "(unnamed struct at x.h:3:9)", are kept as one token.
+func tokenizeCType(s string) []string {
+	tokens := []string{}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ':
+			i++
+		case strings.HasPrefix(s[i:], "(unnamed") || strings.HasPrefix(s[i:], "(anonymous"):
+			end := strings.IndexByte(s[i:], ')')
+			if end < 0 {
+				end = len(s) - i - 1
+			}
+			tokens = append(tokens, s[i:i+end+1])
+			i += end + 1
+		case strings.HasPrefix(s[i:], "..."):
+			tokens = append(tokens, "...")
+			i += 3
+		case isLetter(rune(c)) || isDigit(rune(c)):
+			start := i
+			for i < len(s) && (isLetter(rune(s[i])) || isDigit(rune(s[i]))) {
+				i++
+			}
+			tokens = append(tokens, s[start:i])
+		default:
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+	return tokens
+}
+
+// peek returns the current token, or "" at the end
+func (p *cTypeParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// expect skips a token that must come next
+func (p *cTypeParser) expect(tok string) error {
+	if p.peek() != tok {
+		return fmt.Errorf("expected %q, got %q", tok, p.peek())
+	}
+	p.pos++
+	return nil
+}
+
+// isQualifier checks if a token is a qualifier, which doesn't change how a
+// type is lowered
+func isQualifier(tok string) bool {
+	switch tok {
+	case "const", "volatile", "restrict", "__restrict", "_Atomic", "_Nonnull", "_Nullable", "_Null_unspecified":
+		return true
+	}
+	return false
+}
+
+// typeName parses specifiers followed by an abstract declarator
+func (p *cTypeParser) typeName() (*CType, error) {
+	base, err := p.specifiers()
+	if err != nil {
+		return nil, err
+	}
+	wrap, err := p.declarator()
+	if err != nil {
+		return nil, err
+	}
+	return wrap(base), nil
+}
+
+// specifiers parses the type specifiers that start a type
+func (p *cTypeParser) specifiers() (*CType, error) {
+	var named *CType
+	words := map[string]int{}
+
+	for {
+		tok := p.peek()
+		switch {
+		case isQualifier(tok):
+			p.pos++
+		case tok == "struct" || tok == "union" || tok == "enum":
+			p.pos++
+			name := p.peek()
+			p.pos++
+			typ, err := p.tag(tok, name)
+			if err != nil {
+				return nil, err
+			}
+			named = typ
+		case tok == "void" || tok == "_Bool" || tok == "char" || tok == "short" || tok == "int" ||
+			tok == "long" || tok == "float" || tok == "double" || tok == "signed" || tok == "unsigned":
+			words[tok]++
+			p.pos++
+		case tok != "" && named == nil && len(words) == 0 && (isLetter(rune(tok[0]))):
+			typ, ok := p.header.typedef(tok)
+			if !ok {
+				return nil, fmt.Errorf("unknown type %s", tok)
+			}
+			named = typ
+			p.pos++
+		default:
+			if named != nil {
+				return named, nil
+			}
+			return builtinCType(words)
+		}
+	}
+}
+
+// tag resolves struct, union or enum followed by a tag name
+func (p *cTypeParser) tag(keyword, name string) (*CType, error) {
+	if keyword == "enum" {
+		enum, ok := p.header.enums["enum "+name]
+		if !ok {
+			return nil, fmt.Errorf("unknown type enum %s", name)
+		}
+		return &CType{Kind: CKindEnum, Enum: enum}, nil
+	}
+
+	rec, ok := p.header.records[keyword+" "+name]
+	if !ok {
+		// A tag that is only ever used through pointers needn't be declared
+		if strings.HasPrefix(name, "(") {
+			return nil, fmt.Errorf("unknown type %s %s", keyword, name)
+		}
+		rec = &CRecord{Tag: keyword, Name: name}
+		p.header.records[keyword+" "+name] = rec
+	}
+	return &CType{Kind: CKindRecord, Record: rec}, nil
+}
+
+// builtinCType returns the built-in type named by a set of specifier words,
+// sized as on x86-64 Linux
+func builtinCType(words map[string]int) (*CType, error) {
+	unsigned := words["unsigned"] > 0
+	switch {
+	case words["void"] > 0:
+		return &CType{Kind: CKindVoid}, nil
+	case words["_Bool"] > 0:
+		return &CType{Kind: CKindBool}, nil
+	case words["char"] > 0:
+		// Plain char is signed on x86-64
+		return &CType{Kind: CKindInt, Bits: 8, Unsigned: unsigned, Char: words["signed"] == 0 && !unsigned}, nil
+	case words["short"] > 0:
+		return &CType{Kind: CKindInt, Bits: 16, Unsigned: unsigned}, nil
+	case words["float"] > 0:
+		return &CType{Kind: CKindFloat, Bits: 32}, nil
+	case words["double"] > 0:
+		if words["long"] > 0 {
+			return &CType{Kind: CKindFloat, Bits: 80}, nil
+		}
+		return &CType{Kind: CKindFloat, Bits: 64}, nil
+	case words["long"] > 0:
+		return &CType{Kind: CKindInt, Bits: 64, Unsigned: unsigned}, nil
+	case words["int"] > 0 || words["signed"] > 0 || unsigned:
+		return &CType{Kind: CKindInt, Bits: 32, Unsigned: unsigned}, nil
+	}
+	return nil, fmt.Errorf("missing type specifier")
+}
+
+// declarator parses an abstract declarator and returns a function that
+// applies it to the type it declares. A declarator reads inside out:
+// in void (*)(int) the parameter list applies before the pointer.
+func (p *cTypeParser) declarator() (func(*CType) *CType, error) {
+	pointers := 0
+	for p.peek() == "*" || isQualifier(p.peek()) {
+		if p.peek() == "*" {
+			pointers++
+		}
+		p.pos++
+	}
+
+	inner := func(t *CType) *CType { return t }
+	if p.peek() == "(" && p.pos+1 < len(p.tokens) && p.tokens[p.pos+1] == "*" {
+		p.pos++ // Skip '('
+		wrap, err := p.declarator()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		inner = wrap
+	}
+
+	suffixes := []func(*CType) *CType{}
+	for p.peek() == "[" || p.peek() == "(" {
+		if p.peek() == "[" {
+			p.pos++ // Skip '['
+			length := 0
+			if n, err := strconv.Atoi(p.peek()); err == nil {
+				length = n
+				p.pos++
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			suffixes = append(suffixes, func(t *CType) *CType {
+				return &CType{Kind: CKindArray, Elem: t, Length: length}
+			})
+			continue
+		}
+
+		fn, err := p.parameters()
+		if err != nil {
+			return nil, err
+		}
+		suffixes = append(suffixes, func(t *CType) *CType {
+			f := *fn
+			f.Return = t
+			return &CType{Kind: CKindFunc, Func: &f}
+		})
+	}
+
+	return func(t *CType) *CType {
+		for i := 0; i < pointers; i++ {
+			t = &CType{Kind: CKindPointer, Elem: t}
+		}
+		for i := len(suffixes) - 1; i >= 0; i-- {
+			t = suffixes[i](t)
+		}
+		return inner(t)
+	}, nil
+}
+
+// parameters parses a parameter list. (void) declares no parameters, and
+// array parameters are pointers as in C.
+func (p *cTypeParser) parameters() (*CFunction, error) {
+	p.pos++ // Skip '('
+	fn := &CFunction{}
+
+	if p.peek() == "void" && p.pos+1 < len(p.tokens) && p.tokens[p.pos+1] == ")" {
+		p.pos++
+	}
+	for p.peek() != ")" {
+		if p.peek() == "..." {
+			p.pos++
+			fn.Variadic = true
+			break
+		}
+		param, err := p.typeName()
+		if err != nil {
+			return nil, err
+		}
+		if param.Kind == CKindArray {
+			param = &CType{Kind: CKindPointer, Elem: param.Elem}
+		}
+		fn.Params = append(fn.Params, param)
+
+		if p.peek() != "," {
+			break
+		}
+		p.pos++ // Skip ','
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return fn, nil
+}
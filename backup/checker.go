@@ -0,0 +1,2086 @@
+package main // checker.go
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/deep-neural/nova-lang/compiler"
+)
+
+// FuncSignature describes the parameter and return types of a function
+type FuncSignature struct {
+	Token      Token // name token of the declaration, zero for built-ins
+	Params     []string
+	ReturnType string
+	Variadic   bool
+	Extern     bool // passes strings to C as char *, so NULL can stand for one
+}
+
+// structSymbol is a struct type known to the checker
+type structSymbol struct {
+	Def    *StructDefinition
+	Fields map[string]string // field name to type
+}
+
+// varSymbol is a variable known to the checker
+type varSymbol struct {
+	Type  string
+	Token Token // name token of the declaration
+}
+
+// Checker resolves types over the AST and reports semantic errors before
+// any LLVM IR is generated
+type Checker struct {
+	functions   map[string]*FuncSignature
+	signatures  map[*FunctionDefinition]*FuncSignature
+	externs     map[string]*ExternFunction
+	interfaces  map[string]*InterfaceDefinition
+	structs     map[string]*structSymbol
+	enums       map[string]*EnumDefinition
+	headers     map[string]*CHeader // imported C headers by namespace
+	variables   *compiler.Scope[*varSymbol]
+	exprTypes   map[Expression]string
+	constants   []Expression // untyped constants, in the order they were checked
+	currentFn   *FunctionDefinition
+	currentSig  *FuncSignature
+	loopDepth   int // number of loops around the current statement
+	switchDepth int // number of switches around the current statement
+	unsafeDepth int // number of unsafe blocks around the current statement
+	diagnostics compiler.DiagnosticList
+}
+
+// NewChecker creates a new type checker
+func NewChecker() *Checker {
+	return &Checker{
+		functions: map[string]*FuncSignature{
+			"printf": {Params: []string{"string"}, ReturnType: "int", Variadic: true},
+		},
+		signatures: make(map[*FunctionDefinition]*FuncSignature),
+		externs:    make(map[string]*ExternFunction),
+		interfaces: make(map[string]*InterfaceDefinition),
+		structs:    make(map[string]*structSymbol),
+		enums:      make(map[string]*EnumDefinition),
+		variables:  compiler.NewScope[*varSymbol](nil),
+		exprTypes:  make(map[Expression]string),
+	}
+}
+
+// Diagnostics returns any problems found during checking
+func (c *Checker) Diagnostics() compiler.DiagnosticList {
+	return c.diagnostics
+}
+
+// TypeOf returns the type resolved for an expression, or "" if it could not
+// be resolved
+func (c *Checker) TypeOf(expr Expression) string {
+	return c.exprTypes[expr]
+}
+
+// Check type checks a program
+func (c *Checker) Check(program *Program) {
+	c.headers = program.Headers
+
+	// Collect type names so signatures can refer to them
+	for _, intf := range program.Interfaces {
+		if prev, exists := c.interfaces[intf.Name]; exists {
+			d := c.addError(TokenSpan(intf.Token), compiler.CodeRedeclared, "interface %s redeclared", intf.Name)
+			d.Notes = append(d.Notes, fmt.Sprintf("previous declaration at line %d", prev.Token.Line))
+			continue
+		}
+		c.interfaces[intf.Name] = intf
+	}
+	for _, st := range program.Structs {
+		if c.isTypeName(st.Name) {
+			c.addError(TokenSpan(st.Token), compiler.CodeRedeclared, "type %s redeclared", st.Name)
+			continue
+		}
+		c.structs[st.Name] = &structSymbol{Def: st, Fields: make(map[string]string)}
+	}
+	for _, enum := range program.Enums {
+		if c.isTypeName(enum.Name) {
+			c.addError(TokenSpan(enum.Token), compiler.CodeRedeclared, "type %s redeclared", enum.Name)
+			continue
+		}
+		c.checkEnumDefinition(enum)
+		c.enums[enum.Name] = enum
+	}
+
+	// Field types can refer to any struct, so they're resolved once all
+	// names are known
+	for _, st := range program.Structs {
+		c.checkStructDefinition(st)
+	}
+	for _, st := range program.Structs {
+		c.checkRecursiveStruct(st)
+	}
+
+	// First pass: collect all function signatures
+	for _, fn := range program.Functions {
+		params := make([]string, 0, len(fn.Parameters))
+		for _, param := range fn.Parameters {
+			params = append(params, c.resolveValueType(param.Type))
+		}
+		sig := &FuncSignature{Token: fn.Token, Params: params, ReturnType: c.resolveValueType(fn.ReturnType)}
+		c.signatures[fn] = sig
+		c.checkReservedName(fn.Token, fn.Name)
+
+		if prev, exists := c.functions[fn.Name]; exists {
+			d := c.addError(TokenSpan(fn.Token), compiler.CodeRedeclared, "function %s redeclared", fn.Name)
+			if prev.Token.Line > 0 {
+				d.Notes = append(d.Notes, fmt.Sprintf("previous declaration at line %d", prev.Token.Line))
+			} else {
+				d.Notes = append(d.Notes, fmt.Sprintf("%s is a built-in function", fn.Name))
+			}
+			continue
+		}
+		c.functions[fn.Name] = sig
+	}
+
+	// Extern functions are called like any other. Those from C headers
+	// weren't declared in the source, so errors can't point at a declaration.
+	symbols := make(map[string]*ExternFunction)
+	for _, ext := range program.Externs {
+		if ext.C == nil {
+			c.checkExternDeclaration(ext)
+			c.checkReservedName(ext.Token, ext.Symbol)
+		}
+
+		params := make([]string, 0, len(ext.Parameters))
+		for _, param := range ext.Parameters {
+			params = append(params, c.resolveType(param.Type))
+		}
+		sig := &FuncSignature{Params: params, ReturnType: c.resolveType(ext.ReturnType), Variadic: ext.Variadic, Extern: true}
+		if ext.C == nil {
+			sig.Token = ext.Token
+			for i, param := range ext.Parameters {
+				c.checkExternCallback(ext, param.Type, params[i])
+			}
+			c.checkExternCallback(ext, ext.ReturnType, sig.ReturnType)
+		}
+
+		if prev, exists := c.functions[ext.Name]; exists {
+			d := c.addError(TokenSpan(ext.Token), compiler.CodeRedeclared, "function %s redeclared", ext.Name)
+			if prev.Token.Line > 0 {
+				d.Notes = append(d.Notes, fmt.Sprintf("previous declaration at line %d", prev.Token.Line))
+			} else if ext.C == nil {
+				d.Notes = append(d.Notes, fmt.Sprintf("%s is a built-in function", ext.Name))
+			}
+			continue
+		}
+		c.functions[ext.Name] = sig
+		c.externs[ext.Name] = ext
+
+		// Externs sharing a symbol are one function, so they must agree
+		if prev, exists := symbols[ext.Symbol]; exists &&
+			(prev.CallingConv != ext.CallingConv || !sameSignature(c.functions[prev.Name], sig)) {
+			d := c.addError(TokenSpan(ext.Token), compiler.CodeRedeclared, "C function %s declared with different types", ext.Symbol)
+			if prev.C == nil {
+				d.Notes = append(d.Notes, fmt.Sprintf("previous declaration at line %d", prev.Token.Line))
+			} else {
+				d.Notes = append(d.Notes, fmt.Sprintf("previous declaration is %s from a C header", prev.Name))
+			}
+		} else if !exists {
+			symbols[ext.Symbol] = ext
+		}
+
+		// A Nova function with the name of the C symbol would be linked
+		// in its place
+		if prev, exists := c.functions[ext.Symbol]; exists && c.externs[ext.Symbol] == nil && prev.Token.Line > 0 {
+			d := c.addError(TokenSpan(prev.Token), compiler.CodeRedeclared, "function %s redeclared", ext.Symbol)
+			d.Notes = append(d.Notes, fmt.Sprintf("%s is also the symbol of C function %s", ext.Symbol, ext.Name))
+		}
+	}
+
+	// Second pass: check function bodies
+	for _, fn := range program.Functions {
+		c.checkFunction(fn)
+	}
+}
+
+// checkReservedName checks a function doesn't take the name of one the
+// code generator defines, which would be a second definition of the symbol
+func (c *Checker) checkReservedName(tok Token, name string) {
+	if strings.HasPrefix(name, runtimePrefix) {
+		d := c.addError(TokenSpan(tok), compiler.CodeRedeclared, "function name %s is reserved", name)
+		d.Notes = append(d.Notes, fmt.Sprintf("names starting with %s are used by the compiler's runtime", runtimePrefix))
+	}
+}
+
+// checkExternDeclaration checks the ABI and attributes of an extern declared
+// in the source and applies the attributes to it
+func (c *Checker) checkExternDeclaration(ext *ExternFunction) {
+	if ext.ABI != "C" {
+		c.addError(TokenSpan(ext.Token), compiler.CodeUnsupported, "unsupported ABI %q for extern function %s", ext.ABI, ext.Name)
+	}
+
+	seen := make(map[string]*Attribute)
+	for _, attr := range ext.Attributes {
+		if prev, ok := seen[attr.Name]; ok {
+			d := c.addError(TokenSpan(attr.Token), compiler.CodeRedeclared, "attribute @%s repeated", attr.Name)
+			d.Notes = append(d.Notes, fmt.Sprintf("previous at line %d", prev.Token.Line))
+			continue
+		}
+		seen[attr.Name] = attr
+
+		var valid []string
+		switch attr.Name {
+		case "callconv":
+			valid = sortedKeys(externCallingConvs)
+		case "linkage":
+			valid = sortedKeys(externLinkages)
+		case "symbol":
+		default:
+			d := c.addError(TokenSpan(attr.Token), compiler.CodeUnsupported, "unknown attribute @%s", attr.Name)
+			d.Notes = append(d.Notes, "extern functions take @callconv, @linkage and @symbol")
+			continue
+		}
+
+		if len(attr.Args) != 1 {
+			c.addError(TokenSpan(attr.Token), compiler.CodeArgumentCount, "attribute @%s takes 1 argument, got %d", attr.Name, len(attr.Args))
+			continue
+		}
+		arg := attr.Args[0]
+
+		switch attr.Name {
+		case "callconv":
+			if _, ok := externCallingConvs[arg]; !ok {
+				d := c.addError(TokenSpan(attr.Token), compiler.CodeUnsupported, "unknown calling convention %q", arg)
+				d.Notes = append(d.Notes, "expected one of "+strings.Join(valid, ", "))
+				continue
+			}
+			ext.CallingConv = arg
+		case "linkage":
+			if _, ok := externLinkages[arg]; !ok {
+				d := c.addError(TokenSpan(attr.Token), compiler.CodeUnsupported, "unknown linkage %q for a declaration", arg)
+				d.Notes = append(d.Notes, "expected one of "+strings.Join(valid, ", "))
+				continue
+			}
+			ext.Linkage = arg
+		case "symbol":
+			if arg == "" {
+				c.addError(TokenSpan(attr.Token), compiler.CodeUnsupported, "empty symbol name")
+				continue
+			}
+			ext.Symbol = arg
+		}
+	}
+}
+
+// checkExternCallback checks that a function type in the signature of an
+// extern only has types C has. C calls the function with its own values, so
+// a Nova string, which C would pass as char *, can't be converted.
+func (c *Checker) checkExternCallback(ext *ExternFunction, ts TypeSpecifier, typ string) {
+	params, ret, ok := splitFuncType(typ)
+	if !ok {
+		return
+	}
+	for _, t := range append(params, ret) {
+		if c.isCCallbackType(t) {
+			continue
+		}
+		d := c.addError(TokenSpan(ts.Token), compiler.CodeUnsupported, "extern function %s cannot use callback type %s", ext.Name, typ)
+		if t == "string" {
+			d.Notes = append(d.Notes, "C passes strings to callbacks as char *; use *int8")
+		} else {
+			d.Notes = append(d.Notes, fmt.Sprintf("%s has no C equivalent", t))
+		}
+		return
+	}
+}
+
+// isCCallbackType checks if a value of a type can be passed between C and a
+// callback unchanged: a number, bool, enum, pointer or function type made of
+// those
+func (c *Checker) isCCallbackType(typ string) bool {
+	if _, ok := c.enums[typ]; ok {
+		return true
+	}
+	switch {
+	case typ == "" || typ == "void" || typ == "bool" || isNumericType(typ) || isPointerType(typ):
+		return true
+	case isFuncType(typ):
+		params, ret, _ := splitFuncType(typ)
+		for _, t := range append(params, ret) {
+			if !c.isCCallbackType(t) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// sortedKeys returns the keys of a map in order, for listing them in notes
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sameSignature checks if two functions have the same type
+func sameSignature(a, b *FuncSignature) bool {
+	if a.Variadic != b.Variadic || a.ReturnType != b.ReturnType || len(a.Params) != len(b.Params) {
+		return false
+	}
+	for i := range a.Params {
+		if a.Params[i] != b.Params[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveType checks that a type specifier names a known type and returns
+// its type string, or "" if it doesn't
+func (c *Checker) resolveType(ts TypeSpecifier) string {
+	if ts.Func != nil {
+		return c.resolveFuncType(ts)
+	}
+
+	if ts.IsPointer {
+		elem := c.resolveType(*ts.Elem)
+		if elem == "" {
+			return ""
+		}
+		return "*" + elem
+	}
+
+	if ts.Elem != nil {
+		elem := c.resolveType(*ts.Elem)
+		if elem == "" {
+			return ""
+		}
+		if elem == "void" {
+			c.addError(TokenSpan(ts.Token), compiler.CodeInvalidOperation, "invalid element type void in %s", ts.String())
+			return ""
+		}
+		if ts.Length < 0 {
+			return "[]" + elem
+		}
+		return fmt.Sprintf("[%d]%s", ts.Length, elem)
+	}
+
+	switch ts.TypeName {
+	case "string", "bool", "void":
+		return ts.TypeName
+	}
+
+	if alias, ok := typeAliases[ts.TypeName]; ok {
+		return alias
+	}
+	if isNumericType(ts.TypeName) {
+		return ts.TypeName
+	}
+
+	if _, ok := c.structs[ts.TypeName]; ok {
+		return ts.String()
+	}
+	if _, ok := c.enums[ts.TypeName]; ok {
+		return ts.TypeName
+	}
+
+	if _, ok := c.interfaces[ts.TypeName]; ok {
+		c.addError(TokenSpan(ts.Token), compiler.CodeUnsupported, "interface type %s is not supported yet", ts.TypeName)
+		return ""
+	}
+
+	if ct, typ, err := cTypeNamed(c.headers, ts.TypeName); ct != nil {
+		if err != nil {
+			d := c.addError(TokenSpan(ts.Token), compiler.CodeUnsupported, "cannot use C type %s", ts.TypeName)
+			d.Notes = append(d.Notes, err.Error())
+			return ""
+		}
+		return typ
+	}
+
+	c.addError(TokenSpan(ts.Token), compiler.CodeUndefined, "undefined type: %s", ts.TypeName)
+	return ""
+}
+
+// resolveValueType resolves the type of a variable, field, parameter or
+// result. A C struct or union its header doesn't define has no size, so it
+// can only be held through a pointer.
+func (c *Checker) resolveValueType(ts TypeSpecifier) string {
+	typ := c.resolveType(ts)
+	elem := typ
+	for {
+		_, arrayElem, ok := splitArrayType(elem)
+		if !ok {
+			break
+		}
+		elem = arrayElem
+	}
+	if ct, _, _ := cTypeNamed(c.headers, elem); ct != nil && ct.Kind == CKindRecord &&
+		!cHasLayout(ct.Record, map[*CRecord]bool{}) {
+		d := c.addError(TokenSpan(ts.Token), compiler.CodeUnsupported, "%s has no definition in its header", elem)
+		d.Notes = append(d.Notes, "use it through a pointer")
+		return ""
+	}
+	return typ
+}
+
+// resolveFuncType resolves a function type to its type string
+func (c *Checker) resolveFuncType(ts TypeSpecifier) string {
+	ok := true
+	params := make([]string, 0, len(ts.Func.Params))
+	for _, param := range ts.Func.Params {
+		typ := c.resolveType(param)
+		if typ == "void" {
+			c.addError(TokenSpan(param.Token), compiler.CodeInvalidOperation, "invalid parameter type void in %s", ts.String())
+			typ = ""
+		}
+		ok = ok && typ != ""
+		params = append(params, typ)
+	}
+
+	ret := "void"
+	if !ts.Func.ReturnType.IsEmpty() {
+		ret = c.resolveType(ts.Func.ReturnType)
+	}
+	if !ok || ret == "" {
+		return ""
+	}
+	return funcTypeString(params, ret)
+}
+
+// isTypeName checks if a name is already used by a built-in or declared type
+func (c *Checker) isTypeName(name string) bool {
+	switch name {
+	case "string", "bool", "void":
+		return true
+	}
+	if _, ok := typeAliases[name]; ok || isNumericType(name) {
+		return true
+	}
+	_, isStruct := c.structs[name]
+	_, isEnum := c.enums[name]
+	_, isInterface := c.interfaces[name]
+	return isStruct || isEnum || isInterface
+}
+
+// checkEnumDefinition reports enum members declared more than once
+func (c *Checker) checkEnumDefinition(enum *EnumDefinition) {
+	seen := make(map[string]bool)
+	for _, member := range enum.Members {
+		if seen[member.Value] {
+			c.addError(TokenSpan(member.Token), compiler.CodeRedeclared, "duplicate member %s in enum %s", member.Value, enum.Name)
+		}
+		seen[member.Value] = true
+	}
+}
+
+// checkStructDefinition resolves the field types of a struct
+func (c *Checker) checkStructDefinition(st *StructDefinition) {
+	sym := c.structs[st.Name]
+	if sym == nil || sym.Def != st {
+		return
+	}
+
+	for _, field := range st.Fields {
+		if _, exists := sym.Fields[field.Name]; exists {
+			c.addError(TokenSpan(field.Token), compiler.CodeRedeclared, "duplicate field %s in struct %s", field.Name, st.Name)
+			continue
+		}
+		sym.Fields[field.Name] = c.resolveValueType(field.Type)
+	}
+}
+
+// checkRecursiveStruct reports a struct that contains itself, directly or
+// through other structs. A struct can hold a pointer to itself, but not itself.
+func (c *Checker) checkRecursiveStruct(st *StructDefinition) {
+	sym := c.structs[st.Name]
+	if sym == nil || sym.Def != st {
+		return
+	}
+
+	for _, field := range st.Fields {
+		if c.containsStruct(sym.Fields[field.Name], st.Name, map[string]bool{}) {
+			c.addError(TokenSpan(field.Token), compiler.CodeInvalidOperation, "invalid recursive type %s", st.Name)
+			break
+		}
+	}
+}
+
+// containsStruct checks if a value of type typ embeds the named struct.
+// Arrays embed their elements; pointers and slices don't.
+func (c *Checker) containsStruct(typ, name string, seen map[string]bool) bool {
+	if length, elem, ok := splitArrayType(typ); ok && length >= 0 {
+		return c.containsStruct(elem, name, seen)
+	}
+	if typ == name {
+		return true
+	}
+	sym, ok := c.structs[typ]
+	if !ok || seen[typ] {
+		return false
+	}
+	seen[typ] = true
+
+	for _, field := range sym.Def.Fields {
+		if c.containsStruct(sym.Fields[field.Name], name, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFunction checks a function body
+func (c *Checker) checkFunction(fn *FunctionDefinition) {
+	c.currentFn = fn
+	c.currentSig = c.signatures[fn]
+
+	// Parameters share the function body's scope so they can't be redeclared
+	c.variables = compiler.NewScope[*varSymbol](nil)
+	for i, param := range fn.Parameters {
+		c.declareVariable(param.Token, param.Name, c.currentSig.Params[i])
+	}
+	if fn.Body != nil {
+		c.checkStatements(fn.Body.Statements)
+		if ret := c.currentSig.ReturnType; ret != "void" && ret != "" && !terminates(fn.Body) {
+			d := c.addError(TokenSpan(fn.Token), compiler.CodeMissingReturn, "missing return at end of function %s returning %s", fn.Name, ret)
+			d.Notes = append(d.Notes, "every path through the function must end in a return")
+		}
+	}
+
+	c.currentFn = nil
+	c.currentSig = nil
+}
+
+// checkBlock checks a block of statements in a new scope
+func (c *Checker) checkBlock(block *BlockStatement) {
+	if block == nil {
+		return
+	}
+
+	c.variables = compiler.NewScope(c.variables)
+	c.checkStatements(block.Statements)
+	c.variables = c.variables.Parent()
+}
+
+// checkStatements checks a list of statements in the current scope
+func (c *Checker) checkStatements(stmts []Statement) {
+	for _, stmt := range stmts {
+		c.checkStatement(stmt)
+	}
+}
+
+// declareVariable declares a variable in the current scope
+func (c *Checker) declareVariable(tok Token, name, typ string) {
+	if !c.variables.Declare(name, &varSymbol{Type: typ, Token: tok}) {
+		prev, _ := c.variables.Lookup(name)
+		d := c.addError(TokenSpan(tok), compiler.CodeRedeclared, "%s redeclared in this scope", name)
+		d.Notes = append(d.Notes, fmt.Sprintf("previous declaration at line %d", prev.Token.Line))
+	}
+}
+
+// checkStatement checks a statement
+func (c *Checker) checkStatement(stmt Statement) {
+	switch stmt := stmt.(type) {
+	case *BlockStatement:
+		c.checkBlock(stmt)
+	case *VariableDeclarationStatement:
+		c.checkVarDecl(stmt)
+	case *ReturnStatement:
+		c.checkReturn(stmt)
+	case *IfStatement:
+		c.checkCondition(stmt.Condition, "if")
+		c.checkBlock(stmt.Consequence)
+		c.checkBlock(stmt.Alternative)
+	case *WhileStatement:
+		c.checkCondition(stmt.Condition, "while")
+		c.checkLoopBody(stmt.Body)
+	case *ForStatement:
+		c.checkFor(stmt)
+	case *SwitchStatement:
+		c.checkSwitch(stmt)
+	case *UnsafeStatement:
+		c.unsafeDepth++
+		c.checkBlock(stmt.Body)
+		c.unsafeDepth--
+	case *BreakStatement:
+		if c.loopDepth == 0 && c.switchDepth == 0 {
+			c.addError(TokenSpan(stmt.Token), compiler.CodeInvalidOperation, "break is not in a loop or switch")
+		}
+	case *ContinueStatement:
+		if c.loopDepth == 0 {
+			c.addError(TokenSpan(stmt.Token), compiler.CodeInvalidOperation, "continue is not in a loop")
+		}
+	case *ExpressionStatement:
+		if stmt.Expression != nil {
+			c.checkExpression(stmt.Expression)
+		}
+	}
+	c.checkConstants()
+}
+
+// checkFor checks a for loop. Variables declared by the init clause are
+// scoped to the loop.
+func (c *Checker) checkFor(stmt *ForStatement) {
+	c.variables = compiler.NewScope(c.variables)
+	defer func() { c.variables = c.variables.Parent() }()
+
+	if stmt.Init != nil {
+		c.checkStatement(stmt.Init)
+	}
+	if stmt.Condition != nil {
+		c.checkCondition(stmt.Condition, "for")
+	}
+	if stmt.Update != nil {
+		c.checkExpression(stmt.Update)
+	}
+	c.checkLoopBody(stmt.Body)
+}
+
+// checkLoopBody checks the body of a loop, where break and continue are allowed
+func (c *Checker) checkLoopBody(body *BlockStatement) {
+	c.loopDepth++
+	c.checkBlock(body)
+	c.loopDepth--
+}
+
+// checkSwitch checks a switch statement. Cases must be constants of the
+// switch value's type and each may appear only once. A switch on an enum
+// without a default must cover every member.
+func (c *Checker) checkSwitch(stmt *SwitchStatement) {
+	valueType := c.checkValue(stmt.Value)
+	_, isEnum := c.enums[valueType]
+	if valueType != "" && !isIntegerType(valueType) && valueType != "string" && !isEnum {
+		c.addError(exprSpan(stmt.Value), compiler.CodeTypeMismatch, "cannot switch on %s (type %s)", stmt.Value, valueType)
+		valueType = ""
+	}
+
+	seen := make(map[string]*CaseStatement)
+	for _, cs := range stmt.Cases {
+		caseType := c.checkValueAs(cs.Value, valueType)
+		if valueType != "" && caseType != "" && !isAssignable(caseType, valueType) {
+			c.addError(exprSpan(cs.Value), compiler.CodeTypeMismatch, "cannot use %s (type %s) as case of switch on %s", cs.Value, caseType, valueType)
+		} else if key, ok := c.caseKey(cs.Value); ok {
+			if prev, exists := seen[key]; exists {
+				d := c.addError(exprSpan(cs.Value), compiler.CodeDuplicateCase, "duplicate case %s in switch", cs.Value)
+				d.Notes = append(d.Notes, fmt.Sprintf("previous case at line %d", prev.Token.Line))
+			} else {
+				seen[key] = cs
+			}
+		} else if valueType != "" && caseType != "" {
+			c.addError(exprSpan(cs.Value), compiler.CodeInvalidOperation, "case %s is not a constant", cs.Value)
+		}
+
+		c.checkCaseBody(cs.Block)
+	}
+	if stmt.Default != nil {
+		c.checkCaseBody(stmt.Default)
+	}
+
+	if isEnum && stmt.Default == nil {
+		missing := []string{}
+		for _, member := range c.enums[valueType].Members {
+			if _, covered := seen[member.Value]; !covered {
+				missing = append(missing, member.Value)
+			}
+		}
+		if len(missing) > 0 {
+			d := c.addError(TokenSpan(stmt.Token), compiler.CodeMissingCases, "switch on %s is missing cases: %s", valueType, strings.Join(missing, ", "))
+			d.Notes = append(d.Notes, "add the missing cases or a default")
+		}
+	}
+}
+
+// checkCaseBody checks the statements of a case in their own scope, where
+// break leaves the switch
+func (c *Checker) checkCaseBody(body *BlockStatement) {
+	c.switchDepth++
+	c.checkBlock(body)
+	c.switchDepth--
+}
+
+// caseKey identifies the value of a constant case so duplicates can be
+// found. Enum members are keyed by name, which is what exhaustiveness
+// checking reports.
+func (c *Checker) caseKey(expr Expression) (string, bool) {
+	if lit, ok := expr.(*StringLiteral); ok {
+		return strconv.Quote(lit.Value), true
+	}
+	if dot, ok := expr.(*DotExpression); ok {
+		if _, _, isMember := c.enumMember(dot); isMember {
+			return dot.Member.Value, true
+		}
+		return "", false
+	}
+	if value, ok := untypedConstant(expr); ok {
+		return value.String(), true
+	}
+	return "", false
+}
+
+// maxConstantShift is the largest shift count of a constant, past which
+// every integer type is shifted to zero anyway
+const maxConstantShift = 1024
+
+// untypedConstant returns the value of an expression made only of integer
+// literals and the arithmetic, bitwise and shift operators. Such a constant
+// is worked out exactly and has no type of its own: it takes the type its
+// context expects, or int, and must fit that type.
+func untypedConstant(expr Expression) (*big.Int, bool) {
+	switch expr := expr.(type) {
+	case *IntegerLiteral:
+		return new(big.Int).SetUint64(expr.Value), true
+	case *PrefixExpression:
+		x, ok := untypedConstant(expr.Right)
+		if !ok {
+			return nil, false
+		}
+		switch expr.Operator {
+		case "-":
+			return x.Neg(x), true
+		case "~":
+			return x.Not(x), true
+		}
+	case *InfixExpression:
+		x, ok := untypedConstant(expr.Left)
+		if !ok {
+			return nil, false
+		}
+		y, ok := untypedConstant(expr.Right)
+		if !ok {
+			return nil, false
+		}
+		switch expr.Operator {
+		case "+":
+			return x.Add(x, y), true
+		case "-":
+			return x.Sub(x, y), true
+		case "*":
+			return x.Mul(x, y), true
+		case "/", "%":
+			// Division by zero is left to fail at run time
+			if y.Sign() == 0 {
+				return nil, false
+			}
+			if expr.Operator == "/" {
+				return x.Quo(x, y), true
+			}
+			return x.Rem(x, y), true
+		case "&":
+			return x.And(x, y), true
+		case "|":
+			return x.Or(x, y), true
+		case "^":
+			return x.Xor(x, y), true
+		case "<<", ">>":
+			if y.Sign() < 0 || y.Cmp(big.NewInt(maxConstantShift)) > 0 {
+				return nil, false
+			}
+			if expr.Operator == "<<" {
+				return x.Lsh(x, uint(y.Int64())), true
+			}
+			return x.Rsh(x, uint(y.Int64())), true
+		}
+	}
+	return nil, false
+}
+
+// checkConstants reports the untyped constants that don't fit the type
+// they were given. It runs after each statement, when every constant in it
+// has the type its context gives it.
+func (c *Checker) checkConstants() {
+	for _, expr := range c.constants {
+		value, _ := untypedConstant(expr)
+		if typ := c.exprTypes[expr]; isIntegerType(typ) && !constantFits(value, typ) {
+			c.addError(exprSpan(expr), compiler.CodeTypeMismatch, "constant %s overflows %s", value, typ)
+		}
+	}
+	c.constants = nil
+}
+
+// enumMember resolves Enum.Member to the enum and the member's index. A
+// variable with the same name as the enum hides it.
+func (c *Checker) enumMember(dot *DotExpression) (*EnumDefinition, int, bool) {
+	ident, ok := dot.Object.(*Identifier)
+	if !ok {
+		return nil, 0, false
+	}
+	if _, isVar := c.variables.Lookup(ident.Value); isVar {
+		return nil, 0, false
+	}
+	enum, ok := c.enums[ident.Value]
+	if !ok {
+		return nil, 0, false
+	}
+	for i, member := range enum.Members {
+		if member.Value == dot.Member.Value {
+			return enum, i, true
+		}
+	}
+	return enum, -1, false
+}
+
+// checkVarDecl checks a variable declaration and records its type
+func (c *Checker) checkVarDecl(varDecl *VariableDeclarationStatement) {
+	// The initializer is checked before the name comes into scope
+	if varDecl.Type.IsEmpty() {
+		valueType := c.checkValue(varDecl.Value)
+		c.declareVariable(varDecl.Token, varDecl.Name, valueType)
+		return
+	}
+
+	varType := c.resolveValueType(varDecl.Type)
+	if varDecl.Value != nil {
+		valueType := c.checkValueAs(varDecl.Value, varType)
+		if !isAssignable(valueType, varType) {
+			c.addError(exprSpan(varDecl.Value), compiler.CodeTypeMismatch, "cannot use %s value as %s in declaration of %s",
+				valueType, varType, varDecl.Name)
+		}
+	}
+	c.declareVariable(varDecl.Token, varDecl.Name, varType)
+}
+
+// checkAssignment checks an assignment expression and returns the type of
+// the assigned variable
+func (c *Checker) checkAssignment(assign *AssignmentExpression) string {
+	targetType, ok := c.checkAssignTarget(assign.Left)
+	if !ok {
+		c.checkExpression(assign.Value)
+		return ""
+	}
+	valueType := c.checkValueAs(assign.Value, targetType)
+	if !isAssignable(valueType, targetType) {
+		c.addError(exprSpan(assign.Value), compiler.CodeTypeMismatch, "cannot assign %s value to %s (type %s)",
+			valueType, assign.Left, targetType)
+	}
+	return targetType
+}
+
+// checkCompoundAssignment checks x op= v, which is typed like x = x op v
+func (c *Checker) checkCompoundAssignment(assign *CompoundAssignmentExpression) string {
+	targetType, ok := c.checkAssignTarget(assign.Left)
+	if !ok || targetType == "" {
+		c.checkExpression(assign.Value)
+		return ""
+	}
+
+	binOp := &InfixExpression{
+		Token:    assign.Token,
+		Left:     assign.Left,
+		Operator: strings.TrimSuffix(assign.Operator, "="),
+		Right:    assign.Value,
+	}
+	resultType := c.checkInfixExpression(binOp)
+	if !isAssignable(resultType, targetType) {
+		c.addError(exprSpan(assign), compiler.CodeTypeMismatch, "cannot assign %s value to %s (type %s)",
+			resultType, assign.Left, targetType)
+	}
+	return targetType
+}
+
+// checkPostfixExpression checks an increment or decrement, which yields the
+// value from before the update
+func (c *Checker) checkPostfixExpression(postfix *PostfixExpression) string {
+	targetType, ok := c.checkAssignTarget(postfix.Left)
+	if !ok || targetType == "" {
+		return ""
+	}
+	if isPointerType(targetType) {
+		if !c.checkPointerArithmetic(exprSpan(postfix), "pointer arithmetic", targetType) {
+			return ""
+		}
+		return targetType
+	}
+	if !isNumericType(targetType) {
+		c.addError(exprSpan(postfix), compiler.CodeInvalidOperation, "invalid operation: %s%s (non-numeric type %s)", postfix.Left, postfix.Operator, targetType)
+		return ""
+	}
+	return targetType
+}
+
+// checkAssignTarget checks that an expression can be assigned to and returns
+// its type. It reports an error and returns false if it can't.
+func (c *Checker) checkAssignTarget(left Expression) (string, bool) {
+	switch left := left.(type) {
+	case *Identifier:
+		if _, ok := c.variables.Lookup(left.Value); !ok {
+			c.addError(TokenSpan(left.Token), compiler.CodeUndefined, "undefined variable: %s", left.Value)
+			return "", false
+		}
+	case *DotExpression, *IndexExpression:
+	case *PrefixExpression:
+		if left.Operator != "*" {
+			c.addError(exprSpan(left), compiler.CodeInvalidOperation, "cannot assign to %s", left)
+			return "", false
+		}
+	default:
+		c.addError(exprSpan(left), compiler.CodeInvalidOperation, "cannot assign to %s", left)
+		return "", false
+	}
+
+	targetType := c.checkExpression(left)
+	if idx, ok := left.(*IndexExpression); ok && c.TypeOf(idx.Left) == "string" {
+		c.addError(exprSpan(left), compiler.CodeInvalidOperation, "cannot assign to %s (strings are immutable)", left)
+		return "", false
+	}
+	if targetType != "" && !c.isAddressable(left) {
+		c.addError(exprSpan(left), compiler.CodeInvalidOperation, "cannot assign to %s (value is not addressable)", left)
+		return "", false
+	}
+	return targetType, true
+}
+
+// isAddressable checks if an expression denotes storage that can be assigned
+// to: a variable, a field or element of addressable storage, a field reached
+// through a pointer, an element of a slice, or the target of a pointer
+func (c *Checker) isAddressable(expr Expression) bool {
+	switch expr := expr.(type) {
+	case *Identifier:
+		return true
+	case *PrefixExpression:
+		return expr.Operator == "*"
+	case *DotExpression:
+		if strings.HasPrefix(c.TypeOf(expr.Object), "*") {
+			return true
+		}
+		return c.isAddressable(expr.Object)
+	case *IndexExpression:
+		// Strings are immutable
+		switch typ := c.TypeOf(expr.Left); {
+		case typ == "string":
+			return false
+		case strings.HasPrefix(typ, "[]"), isPointerType(typ):
+			return true
+		}
+		return c.isAddressable(expr.Left)
+	default:
+		return false
+	}
+}
+
+// checkReturn checks a return statement against the enclosing function
+func (c *Checker) checkReturn(ret *ReturnStatement) {
+	retType := c.currentSig.ReturnType
+
+	if ret.ReturnValue == nil {
+		if retType != "void" && retType != "" {
+			c.addError(TokenSpan(ret.Token), compiler.CodeTypeMismatch, "missing return value in function %s returning %s", c.currentFn.Name, retType)
+		}
+		return
+	}
+
+	if retType == "void" {
+		c.addError(TokenSpan(ret.Token), compiler.CodeTypeMismatch, "unexpected return value in void function %s", c.currentFn.Name)
+		c.checkExpression(ret.ReturnValue)
+		return
+	}
+
+	valueType := c.checkValueAs(ret.ReturnValue, retType)
+	if !isAssignable(valueType, retType) {
+		c.addError(exprSpan(ret.ReturnValue), compiler.CodeTypeMismatch, "cannot return %s value from function %s returning %s",
+			valueType, c.currentFn.Name, retType)
+	}
+}
+
+// terminates checks if every path through a statement ends in a return.
+// A loop terminates if it has no condition, or true, and doesn't break; a
+// switch terminates if it has a default and no case breaks or falls off
+// its end.
+func terminates(stmt Statement) bool {
+	switch stmt := stmt.(type) {
+	case *ReturnStatement:
+		return true
+	case *BlockStatement:
+		return stmt != nil && len(stmt.Statements) > 0 && terminates(stmt.Statements[len(stmt.Statements)-1])
+	case *UnsafeStatement:
+		return terminates(stmt.Body)
+	case *IfStatement:
+		return stmt.Alternative != nil && terminates(stmt.Consequence) && terminates(stmt.Alternative)
+	case *WhileStatement:
+		return isTrue(stmt.Condition) && !breaks(stmt.Body)
+	case *ForStatement:
+		return (stmt.Condition == nil || isTrue(stmt.Condition)) && !breaks(stmt.Body)
+	case *SwitchStatement:
+		if stmt.Default == nil || !terminates(stmt.Default) || breaks(stmt.Default) {
+			return false
+		}
+		for _, cs := range stmt.Cases {
+			if !terminates(cs.Block) || breaks(cs.Block) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// breaks checks if a statement has a break that leaves the loop or switch
+// around it, rather than one nested in the statement
+func breaks(stmt Statement) bool {
+	switch stmt := stmt.(type) {
+	case *BreakStatement:
+		return true
+	case *BlockStatement:
+		if stmt == nil {
+			return false
+		}
+		for _, s := range stmt.Statements {
+			if breaks(s) {
+				return true
+			}
+		}
+	case *UnsafeStatement:
+		return breaks(stmt.Body)
+	case *IfStatement:
+		return breaks(stmt.Consequence) || breaks(stmt.Alternative)
+	}
+	return false
+}
+
+// isTrue checks if an expression is the literal true
+func isTrue(expr Expression) bool {
+	lit, ok := expr.(*BooleanLiteral)
+	return ok && lit.Value
+}
+
+// checkCondition checks that a condition is a boolean expression
+func (c *Checker) checkCondition(cond Expression, stmt string) {
+	condType := c.checkValue(cond)
+	if condType != "" && condType != "bool" {
+		c.addError(exprSpan(cond), compiler.CodeTypeMismatch, "non-bool %s condition (type %s)", stmt, condType)
+	}
+}
+
+// checkValueAs checks an expression that must produce a value of the
+// expected type. Untyped struct literals and array literals take their type
+// from the context.
+func (c *Checker) checkValueAs(expr Expression, expected string) string {
+	if c.convertConstant(expr, expected) {
+		return expected
+	}
+	if lit, ok := expr.(*ArrayLiteral); ok {
+		typ := c.checkArrayLiteral(lit, expected)
+		c.exprTypes[lit] = typ
+		return typ
+	}
+	if lit, ok := expr.(*StructLiteralExpression); ok && lit.TypeName == "" {
+		if _, isStruct := c.structs[expected]; isStruct {
+			lit.TypeName = expected
+		}
+	}
+	return c.checkValue(expr)
+}
+
+// convertConstant gives an untyped constant or a float literal the numeric
+// type its context expects. Whether an integer constant fits the type is
+// checked by checkConstants. It returns false if expr is not a constant of
+// a kind that converts to typ.
+func (c *Checker) convertConstant(expr Expression, typ string) bool {
+	if _, ok := untypedConstant(expr); ok {
+		if !isNumericType(typ) {
+			return false
+		}
+		if _, seen := c.exprTypes[expr]; !seen {
+			c.constants = append(c.constants, expr)
+		}
+		c.exprTypes[expr] = typ
+		return true
+	}
+
+	switch lit := expr.(type) {
+	case *FloatLiteral:
+		if !isFloatType(typ) {
+			return false
+		}
+	case *PrefixExpression:
+		if _, isFloat := lit.Right.(*FloatLiteral); lit.Operator != "-" || !isFloat || !isFloatType(typ) {
+			return false
+		}
+		c.exprTypes[lit.Right] = typ
+	default:
+		return false
+	}
+
+	c.exprTypes[expr] = typ
+	return true
+}
+
+// checkValue checks an expression that must produce a value
+func (c *Checker) checkValue(expr Expression) string {
+	typ := c.checkExpression(expr)
+	if callExpr, ok := expr.(*CallExpression); ok && typ == "void" {
+		c.addError(exprSpan(callExpr), compiler.CodeNoValue, "%s() returns no value and cannot be used as one", callExpr.Function)
+		return ""
+	}
+	return typ
+}
+
+// checkExpression resolves the type of an expression
+func (c *Checker) checkExpression(expr Expression) string {
+	// An untyped constant is an int until its context gives it another
+	// type: -128 fits in an int8 and 1 << 40 in an int64
+	if c.convertConstant(expr, "int") {
+		return "int"
+	}
+
+	var typ string
+	switch expr := expr.(type) {
+	case *FloatLiteral:
+		typ = "float"
+	case *StringLiteral:
+		typ = "string"
+	case *InterpolatedString:
+		typ = c.checkInterpolatedString(expr)
+	case *BooleanLiteral:
+		typ = "bool"
+	case *NullLiteral:
+		typ = "*void"
+	case *Identifier:
+		sym, ok := c.variables.Lookup(expr.Value)
+		if !ok {
+			// A function name is a pointer to the function
+			if sig, isFunc := c.functions[expr.Value]; isFunc {
+				typ = c.checkFunctionValue(expr, sig)
+				break
+			}
+			c.addError(TokenSpan(expr.Token), compiler.CodeUndefined, "undefined variable: %s", expr.Value)
+			break
+		}
+		typ = sym.Type
+	case *PrefixExpression:
+		typ = c.checkPrefixExpression(expr)
+	case *InfixExpression:
+		typ = c.checkInfixExpression(expr)
+	case *CallExpression:
+		typ = c.checkCall(expr)
+	case *AssignmentExpression:
+		typ = c.checkAssignment(expr)
+	case *IndexExpression:
+		typ = c.checkIndexExpression(expr)
+	case *SliceExpression:
+		typ = c.checkSliceExpression(expr)
+	case *ArrayLiteral:
+		typ = c.checkArrayLiteral(expr, "")
+	case *ConversionExpression:
+		typ = c.checkConversion(expr)
+	case *DotExpression:
+		typ = c.checkDotExpression(expr)
+	case *StructLiteralExpression:
+		typ = c.checkStructLiteral(expr)
+	case *CompoundAssignmentExpression:
+		typ = c.checkCompoundAssignment(expr)
+	case *PostfixExpression:
+		typ = c.checkPostfixExpression(expr)
+	default:
+		return ""
+	}
+
+	c.exprTypes[expr] = typ
+	return typ
+}
+
+// checkFunctionValue resolves the type of a function used as a value.
+// Function values are called with the C calling convention and the types
+// in their signature, so they can be passed to C as callbacks; functions
+// that are called any other way can't be used as values.
+func (c *Checker) checkFunctionValue(ident *Identifier, sig *FuncSignature) string {
+	if sig.Variadic {
+		c.addError(TokenSpan(ident.Token), compiler.CodeUnsupported, "cannot use variadic function %s as a value", ident.Value)
+		return ""
+	}
+
+	if ext, ok := c.externs[ident.Value]; ok {
+		if ext.CallingConv != "" && ext.CallingConv != "c" {
+			d := c.addError(TokenSpan(ident.Token), compiler.CodeInvalidOperation, "cannot use %s as a value: it uses the %s calling convention", ident.Value, ext.CallingConv)
+			d.Notes = append(d.Notes, "function values are called with the C calling convention")
+			return ""
+		}
+		// Strings are converted to char * at each call, which a call
+		// through a pointer can't do
+		for _, typ := range append([]string{sig.ReturnType}, sig.Params...) {
+			if typ == "string" {
+				d := c.addError(TokenSpan(ident.Token), compiler.CodeUnsupported, "cannot use C function %s as a value", ident.Value)
+				d.Notes = append(d.Notes, "its strings are converted to char * at each call")
+				return ""
+			}
+		}
+	}
+
+	for _, typ := range sig.Params {
+		if typ == "" {
+			return ""
+		}
+	}
+	if sig.ReturnType == "" {
+		return ""
+	}
+	return funcTypeString(sig.Params, sig.ReturnType)
+}
+
+// checkDotExpression resolves the type of a field access. Fields of a
+// pointer to a struct are reached through the pointer.
+func (c *Checker) checkDotExpression(dot *DotExpression) string {
+	if enum, _, ok := c.enumMember(dot); enum != nil {
+		if !ok {
+			d := c.addError(TokenSpan(dot.Member.Token), compiler.CodeUndefined, "%s has no member %s", enum.Name, dot.Member.Value)
+			d.Notes = append(d.Notes, fmt.Sprintf("%s declared at line %d", enum.Name, enum.Token.Line))
+			return ""
+		}
+		return enum.Name
+	}
+
+	objType := c.checkValue(dot.Object)
+	if objType == "" {
+		return ""
+	}
+
+	name := strings.TrimPrefix(objType, "*")
+	sym, ok := c.structs[name]
+	if !ok {
+		if ct, _, _ := cTypeNamed(c.headers, name); ct != nil && ct.Kind == CKindRecord {
+			return c.checkCField(dot, name, ct.Record)
+		}
+		c.addError(exprSpan(dot), compiler.CodeInvalidOperation, "%s (type %s) has no field %s", dot.Object, objType, dot.Member.Value)
+		return ""
+	}
+
+	fieldType, ok := sym.Fields[dot.Member.Value]
+	if !ok {
+		d := c.addError(TokenSpan(dot.Member.Token), compiler.CodeUndefined, "%s has no field %s", sym.Def.Name, dot.Member.Value)
+		d.Notes = append(d.Notes, fmt.Sprintf("%s declared at line %d", sym.Def.Name, sym.Def.Token.Line))
+		return ""
+	}
+	return fieldType
+}
+
+// checkCField resolves the type of a field of a C struct or union, which is
+// named typ
+func (c *Checker) checkCField(dot *DotExpression, typ string, rec *CRecord) string {
+	if !cHasLayout(rec, map[*CRecord]bool{}) {
+		d := c.addError(exprSpan(dot), compiler.CodeUnsupported, "%s has no definition in its header", typ)
+		d.Notes = append(d.Notes, "its fields can't be used")
+		return ""
+	}
+
+	for _, field := range rec.Fields {
+		if field.Name == "" || field.Name != dot.Member.Value {
+			continue
+		}
+		namespace := typ[:strings.LastIndex(typ, ".")]
+		fieldType, err := c.headers[namespace].novaValueType(namespace, field.Type)
+		if err != nil {
+			d := c.addError(TokenSpan(dot.Member.Token), compiler.CodeUnsupported, "cannot use field %s of %s", field.Name, typ)
+			d.Notes = append(d.Notes, err.Error())
+			return ""
+		}
+		return fieldType
+	}
+	c.addError(TokenSpan(dot.Member.Token), compiler.CodeUndefined, "%s has no field %s", typ, dot.Member.Value)
+	return ""
+}
+
+// checkIndexExpression resolves the type of an element of an array or slice
+func (c *Checker) checkIndexExpression(idx *IndexExpression) string {
+	leftType := c.checkValue(idx.Left)
+	c.checkIndex(idx.Index)
+	if leftType == "" {
+		return ""
+	}
+
+	// Indexing a string gives a byte
+	if leftType == "string" {
+		return "uint8"
+	}
+
+	// p[i] is *(p + i), which isn't bounds checked
+	if isPointerType(leftType) {
+		if !c.checkPointerArithmetic(exprSpan(idx), "indexing a pointer", leftType) {
+			return ""
+		}
+		return leftType[1:]
+	}
+
+	length, elem, ok := splitArrayType(leftType)
+	if !ok {
+		c.addError(exprSpan(idx), compiler.CodeInvalidOperation, "cannot index %s (type %s)", idx.Left, leftType)
+		return ""
+	}
+
+	// Constant indexes into arrays are checked now rather than at run time
+	if value, ok := untypedConstant(idx.Index); ok && length >= 0 && (value.Sign() < 0 || value.Cmp(big.NewInt(int64(length))) >= 0) {
+		c.addError(exprSpan(idx.Index), compiler.CodeInvalidOperation, "index %s out of range for %s", value, leftType)
+	}
+	return elem
+}
+
+// checkSliceExpression resolves the type of a slice of an array or slice
+func (c *Checker) checkSliceExpression(slice *SliceExpression) string {
+	leftType := c.checkValue(slice.Left)
+	if slice.Low != nil {
+		c.checkIndex(slice.Low)
+	}
+	if slice.High != nil {
+		c.checkIndex(slice.High)
+	}
+	if leftType == "" || leftType == "string" {
+		return leftType
+	}
+
+	_, elem, ok := splitArrayType(leftType)
+	if !ok {
+		c.addError(exprSpan(slice), compiler.CodeInvalidOperation, "cannot slice %s (type %s)", slice.Left, leftType)
+		return ""
+	}
+	return "[]" + elem
+}
+
+// checkIndex checks that an index or slice bound is an integer
+func (c *Checker) checkIndex(index Expression) {
+	indexType := c.checkValueAs(index, "int")
+	if indexType != "" && !isIntegerType(indexType) {
+		c.addError(exprSpan(index), compiler.CodeTypeMismatch, "invalid index %s (type %s must be an integer)", index, indexType)
+	}
+}
+
+// checkInterpolatedString checks the expressions interpolated into a string,
+// which must be strings, numbers or bools
+func (c *Checker) checkInterpolatedString(str *InterpolatedString) string {
+	for _, part := range str.Parts {
+		typ := c.checkValue(part)
+		if typ != "" && typ != "string" && typ != "bool" && !isNumericType(typ) {
+			c.addError(exprSpan(part), compiler.CodeTypeMismatch, "cannot interpolate %s (type %s) into a string", part, typ)
+		}
+	}
+	return "string"
+}
+
+// checkConversion checks a conversion between numeric types
+func (c *Checker) checkConversion(conv *ConversionExpression) string {
+	target := c.resolveType(conv.Type)
+	if c.convertConstant(conv.Value, target) {
+		return target
+	}
+
+	valueType := c.checkValue(conv.Value)
+	if valueType == "" || target == "" || valueType == target {
+		return target
+	}
+	if !isNumericType(valueType) || !isNumericType(target) {
+		c.addError(exprSpan(conv), compiler.CodeTypeMismatch, "cannot convert %s (type %s) to %s", conv.Value, valueType, target)
+	}
+	return target
+}
+
+// checkArrayLiteral checks the elements of an array literal. The literal
+// takes its type from the expected array or slice type, or else from its
+// first element.
+func (c *Checker) checkArrayLiteral(lit *ArrayLiteral, expected string) string {
+	length, elem, ok := splitArrayType(expected)
+	if !ok {
+		if len(lit.Elements) == 0 {
+			c.addError(TokenSpan(lit.Token), compiler.CodeTypeMismatch, "cannot infer the type of empty array literal")
+			return ""
+		}
+		elem = c.checkValue(lit.Elements[0])
+		if elem == "" {
+			for _, el := range lit.Elements[1:] {
+				c.checkExpression(el)
+			}
+			return ""
+		}
+		length = len(lit.Elements)
+		expected = fmt.Sprintf("[%d]%s", length, elem)
+	}
+
+	if length >= 0 && len(lit.Elements) > length {
+		c.addError(exprSpan(lit.Elements[length]), compiler.CodeTypeMismatch, "index %d out of range for %s literal", length, expected)
+	}
+
+	for _, el := range lit.Elements {
+		valueType := c.checkValueAs(el, elem)
+		if !isAssignable(valueType, elem) {
+			c.addError(exprSpan(el), compiler.CodeTypeMismatch, "cannot use %s value as %s in array literal", valueType, elem)
+		}
+	}
+	return expected
+}
+
+// checkStructLiteral checks the fields of a struct literal. Fields that are
+// left out are zero.
+func (c *Checker) checkStructLiteral(lit *StructLiteralExpression) string {
+	if lit.TypeName == "" {
+		c.addError(TokenSpan(lit.Token), compiler.CodeTypeMismatch, "cannot infer the type of struct literal")
+		for _, name := range lit.Names {
+			c.checkExpression(lit.Fields[name.Value])
+		}
+		return ""
+	}
+
+	sym, ok := c.structs[lit.TypeName]
+	if !ok {
+		c.addError(TokenSpan(lit.Token), compiler.CodeUndefined, "undefined struct type: %s", lit.TypeName)
+		for _, name := range lit.Names {
+			c.checkExpression(lit.Fields[name.Value])
+		}
+		return ""
+	}
+
+	for _, name := range lit.Names {
+		value := lit.Fields[name.Value]
+
+		fieldType, ok := sym.Fields[name.Value]
+		if !ok {
+			c.addError(TokenSpan(name.Token), compiler.CodeUndefined, "%s has no field %s", lit.TypeName, name.Value)
+			c.checkExpression(value)
+			continue
+		}
+
+		valueType := c.checkValueAs(value, fieldType)
+		if !isAssignable(valueType, fieldType) {
+			c.addError(exprSpan(value), compiler.CodeTypeMismatch, "cannot use %s value as %s in field %s of %s",
+				valueType, fieldType, name.Value, lit.TypeName)
+		}
+	}
+
+	return lit.TypeName
+}
+
+// checkInfixExpression resolves the type of a binary operation
+func (c *Checker) checkInfixExpression(binOp *InfixExpression) string {
+	left := c.checkValue(binOp.Left)
+	right := c.checkValue(binOp.Right)
+
+	// Don't cascade errors from operands that failed to resolve
+	if left == "" || right == "" {
+		return ""
+	}
+
+	if isPointerLike(left) || isPointerLike(right) {
+		return c.checkPointerOperation(binOp, left, right)
+	}
+
+	// Strings are concatenated and compared by content
+	if left == "string" && right == "string" {
+		switch binOp.Operator {
+		case "+":
+			return "string"
+		case "==", "!=", "<", "<=", ">", ">=":
+			return "bool"
+		}
+	}
+
+	switch binOp.Operator {
+	case "+", "-", "*", "/":
+		if typ := c.operandType(binOp, left, right); typ != "" {
+			return typ
+		}
+	case "%", "&", "|", "^":
+		if typ := c.operandType(binOp, left, right); isIntegerType(typ) {
+			return typ
+		}
+	case "<<", ">>":
+		// The result has the type of the left operand; the count can be
+		// any integer
+		if isIntegerType(left) && isIntegerType(right) {
+			c.convertConstant(binOp.Right, left)
+			return left
+		}
+	case "&&", "||":
+		if left == "bool" && right == "bool" {
+			return "bool"
+		}
+	case "==", "!=":
+		if c.operandType(binOp, left, right) != "" {
+			return "bool"
+		}
+		if left == "bool" && right == "bool" {
+			return "bool"
+		}
+		if _, isEnum := c.enums[left]; isEnum && left == right {
+			return "bool"
+		}
+	case "<", "<=", ">", ">=":
+		if c.operandType(binOp, left, right) != "" {
+			return "bool"
+		}
+	}
+
+	c.addError(exprSpan(binOp), compiler.CodeInvalidOperation, "invalid operation: %s %s %s", left, binOp.Operator, right)
+	return ""
+}
+
+// checkPrefixExpression resolves the type of a unary operation
+func (c *Checker) checkPrefixExpression(prefix *PrefixExpression) string {
+	switch prefix.Operator {
+	case "&":
+		return c.checkAddressOf(prefix)
+	case "*":
+		return c.checkDereference(prefix)
+	}
+
+	operand := c.checkValue(prefix.Right)
+	if operand == "" {
+		return ""
+	}
+
+	switch prefix.Operator {
+	case "-":
+		if isNumericType(operand) {
+			return operand
+		}
+	case "!":
+		if operand == "bool" {
+			return "bool"
+		}
+	case "~":
+		if isIntegerType(operand) {
+			return operand
+		}
+	}
+
+	c.addError(exprSpan(prefix), compiler.CodeInvalidOperation, "invalid operation: %s%s", prefix.Operator, operand)
+	return ""
+}
+
+// checkAddressOf resolves the type of &x, a pointer to addressable storage
+func (c *Checker) checkAddressOf(prefix *PrefixExpression) string {
+	operand := c.checkValue(prefix.Right)
+	if operand == "" {
+		return ""
+	}
+	if !c.isAddressable(prefix.Right) {
+		c.addError(exprSpan(prefix), compiler.CodeInvalidOperation, "cannot take the address of %s", prefix.Right)
+		return ""
+	}
+	if ident, ok := prefix.Right.(*Identifier); ok {
+		if _, isVar := c.variables.Lookup(ident.Value); !isVar {
+			d := c.addError(exprSpan(prefix), compiler.CodeInvalidOperation, "cannot take the address of function %s", ident.Value)
+			d.Notes = append(d.Notes, "a function name is already a pointer to the function")
+			return ""
+		}
+	}
+	return "*" + operand
+}
+
+// checkDereference resolves the type of *p, the value p points to
+func (c *Checker) checkDereference(prefix *PrefixExpression) string {
+	operand := c.checkValue(prefix.Right)
+	if operand == "" {
+		return ""
+	}
+	if !isPointerType(operand) {
+		c.addError(exprSpan(prefix), compiler.CodeInvalidOperation, "invalid operation: cannot dereference %s (type %s)", prefix.Right, operand)
+		return ""
+	}
+	if operand == "*void" {
+		d := c.addError(exprSpan(prefix), compiler.CodeInvalidOperation, "invalid operation: cannot dereference %s (type *void)", prefix.Right)
+		d.Notes = append(d.Notes, "convert it to a typed pointer first")
+		return ""
+	}
+	return operand[1:]
+}
+
+// checkPointerOperation resolves the type of a binary operation with a
+// pointer operand. Pointers can be compared for equality anywhere; offsets,
+// differences and ordering are only allowed in an unsafe block.
+func (c *Checker) checkPointerOperation(binOp *InfixExpression, left, right string) string {
+	span := exprSpan(binOp)
+
+	switch binOp.Operator {
+	case "==", "!=":
+		if comparablePointers(left, right) {
+			return "bool"
+		}
+	case "<", "<=", ">", ">=":
+		if comparablePointers(left, right) {
+			if !c.checkUnsafe(span, "ordering pointers") {
+				return ""
+			}
+			return "bool"
+		}
+	case "+":
+		// p + n and n + p
+		ptr, offset := left, right
+		if !isPointerType(ptr) {
+			ptr, offset = right, left
+		}
+		if isPointerType(ptr) && isIntegerType(offset) {
+			if !c.checkPointerArithmetic(span, "pointer arithmetic", ptr) {
+				return ""
+			}
+			return ptr
+		}
+	case "-":
+		if isPointerType(left) && isIntegerType(right) {
+			if !c.checkPointerArithmetic(span, "pointer arithmetic", left) {
+				return ""
+			}
+			return left
+		}
+		// The difference of two pointers counts elements
+		if isPointerType(left) && left == right {
+			if !c.checkPointerArithmetic(span, "pointer arithmetic", left) {
+				return ""
+			}
+			return "int64"
+		}
+	}
+
+	c.addError(span, compiler.CodeInvalidOperation, "invalid operation: %s %s %s", left, binOp.Operator, right)
+	return ""
+}
+
+// checkPointerArithmetic checks that arithmetic on a pointer is allowed: it
+// must be in an unsafe block and point to something with a size
+func (c *Checker) checkPointerArithmetic(span compiler.Span, what, ptr string) bool {
+	if !c.checkUnsafe(span, what) {
+		return false
+	}
+	if ptr == "*void" {
+		c.addError(span, compiler.CodeInvalidOperation, "invalid operation: arithmetic on *void pointer")
+		return false
+	}
+	return true
+}
+
+// checkUnsafe reports an error if the current statement isn't in an unsafe
+// block. what describes the operation that needs one.
+func (c *Checker) checkUnsafe(span compiler.Span, what string) bool {
+	if c.unsafeDepth > 0 {
+		return true
+	}
+	d := c.addError(span, compiler.CodeInvalidOperation, "%s is only allowed in an unsafe block", what)
+	d.Notes = append(d.Notes, "wrap the statement in unsafe { ... }")
+	return false
+}
+
+// operandType finds the numeric type both operands of a binary operation are
+// converted to, or "" if there is none. A literal takes the type of the other
+// operand, and an integer operand is converted to the other's float type.
+func (c *Checker) operandType(binOp *InfixExpression, left, right string) string {
+	if !isNumericType(left) || !isNumericType(right) {
+		return ""
+	}
+
+	switch {
+	case left == right:
+		return left
+	case c.convertConstant(binOp.Right, left):
+		return left
+	case c.convertConstant(binOp.Left, right):
+		return right
+	case isFloatType(left) && isIntegerType(right):
+		return left
+	case isIntegerType(left) && isFloatType(right):
+		return right
+	default:
+		return ""
+	}
+}
+
+// checkCall resolves the type of a function call and checks its arguments
+func (c *Checker) checkCall(callExpr *CallExpression) string {
+	ident, ok := callExpr.Function.(*Identifier)
+	if !ok {
+		if dot, isDot := callExpr.Function.(*DotExpression); isDot {
+			if c.isFuncField(dot) {
+				return c.checkIndirectCall(callExpr)
+			}
+			c.checkDotCallee(dot)
+			for _, arg := range callExpr.Arguments {
+				c.checkExpression(arg)
+			}
+			return ""
+		}
+		return c.checkIndirectCall(callExpr)
+	}
+	name := ident.Value
+
+	// A variable holding a function is called through the pointer. Other
+	// variables can't be called, unless a function has the same name.
+	if sym, isVar := c.variables.Lookup(name); isVar {
+		_, isFunc := c.functions[name]
+		isBuiltin := isPrintBuiltin(name) || name == "len" || name == "cap"
+		if isFuncType(sym.Type) || (!isFunc && !isBuiltin) {
+			return c.checkIndirectCall(callExpr)
+		}
+	}
+
+	// Built-in len and cap take an array or slice
+	sig, declared := c.functions[name]
+	if !declared && (name == "len" || name == "cap") {
+		if len(callExpr.Arguments) != 1 {
+			c.addError(exprSpan(callExpr), compiler.CodeArgumentCount, "%s requires exactly one argument, got %d",
+				name, len(callExpr.Arguments))
+		}
+		for _, arg := range callExpr.Arguments {
+			argType := c.checkValue(arg)
+			if argType == "string" && name == "len" {
+				continue
+			}
+			if _, _, ok := splitArrayType(argType); argType != "" && !ok {
+				c.addError(exprSpan(arg), compiler.CodeTypeMismatch, "invalid argument %s (type %s) for %s", arg, argType, name)
+			}
+		}
+		return "int"
+	}
+
+	// Built-in print functions take a single value of any type, unless a
+	// declared function has the same name
+	if !declared && isPrintBuiltin(name) {
+		if len(callExpr.Arguments) != 1 {
+			c.addError(exprSpan(callExpr), compiler.CodeArgumentCount, "%s requires exactly one argument, got %d",
+				name, len(callExpr.Arguments))
+		}
+		for _, arg := range callExpr.Arguments {
+			c.checkValue(arg)
+		}
+		return "void"
+	}
+
+	if !declared {
+		c.addError(TokenSpan(ident.Token), compiler.CodeUndefined, "undefined function: %s", name)
+		for _, arg := range callExpr.Arguments {
+			c.checkExpression(arg)
+		}
+		return ""
+	}
+
+	c.checkArguments(callExpr, name, sig)
+	return sig.ReturnType
+}
+
+// isFuncField checks if x.f is a field of a struct that holds a function,
+// rather than a method or a member of a module
+func (c *Checker) isFuncField(dot *DotExpression) bool {
+	if enum, _, _ := c.enumMember(dot); enum != nil {
+		return false
+	}
+	if ident, ok := dot.Object.(*Identifier); ok {
+		if _, isVar := c.variables.Lookup(ident.Value); !isVar {
+			return false
+		}
+	}
+	sym, ok := c.structs[strings.TrimPrefix(c.checkValue(dot.Object), "*")]
+	return ok && isFuncType(sym.Fields[dot.Member.Value])
+}
+
+// checkDotCallee reports why x.f can't be called when it is neither a
+// function of an imported namespace nor a field holding a function.
+// Namespaces a module imports another way are reported when modules are
+// linked.
+func (c *Checker) checkDotCallee(dot *DotExpression) {
+	if ident, ok := dot.Object.(*Identifier); ok {
+		_, isVar := c.variables.Lookup(ident.Value)
+		if enum, _, _ := c.enumMember(dot); !isVar && enum == nil {
+			d := c.addError(TokenSpan(ident.Token), compiler.CodeUndefined, "undefined: %s", ident.Value)
+			d.Notes = append(d.Notes, fmt.Sprintf("%s is not a variable or an imported namespace", ident.Value))
+			return
+		}
+	}
+	if typ := c.checkDotExpression(dot); typ != "" {
+		c.addError(exprSpan(dot), compiler.CodeTypeMismatch, "cannot call %s (type %s)", dot, typ)
+	}
+}
+
+// checkIndirectCall resolves the type of a call through a function value
+func (c *Checker) checkIndirectCall(callExpr *CallExpression) string {
+	typ := c.checkValue(callExpr.Function)
+	if typ == "" {
+		for _, arg := range callExpr.Arguments {
+			c.checkExpression(arg)
+		}
+		return ""
+	}
+
+	params, ret, ok := splitFuncType(typ)
+	if !ok {
+		c.addError(exprSpan(callExpr.Function), compiler.CodeInvalidOperation, "cannot call %s (type %s)", callExpr.Function, typ)
+		for _, arg := range callExpr.Arguments {
+			c.checkExpression(arg)
+		}
+		return ""
+	}
+
+	c.checkArguments(callExpr, callExpr.Function.String(), &FuncSignature{Params: params, ReturnType: ret})
+	return ret
+}
+
+// checkArguments checks the arguments of a call against the signature of
+// the function called
+func (c *Checker) checkArguments(callExpr *CallExpression, name string, sig *FuncSignature) {
+	if len(callExpr.Arguments) < len(sig.Params) ||
+		(!sig.Variadic && len(callExpr.Arguments) > len(sig.Params)) {
+		d := c.addError(exprSpan(callExpr), compiler.CodeArgumentCount, "wrong number of arguments to %s: expected %d, got %d",
+			name, len(sig.Params), len(callExpr.Arguments))
+		if sig.Token.Line > 0 {
+			d.Notes = append(d.Notes, fmt.Sprintf("%s declared at line %d", name, sig.Token.Line))
+		}
+	}
+
+	for i, arg := range callExpr.Arguments {
+		var argType string
+		if i < len(sig.Params) {
+			argType = c.checkValueAs(arg, sig.Params[i])
+		} else {
+			argType = c.checkValue(arg)
+		}
+		// C takes NULL for a char *, which is how strings are passed to it
+		if _, isNull := arg.(*NullLiteral); isNull && sig.Extern && i < len(sig.Params) && sig.Params[i] == "string" {
+			continue
+		}
+		if i < len(sig.Params) && !isAssignable(argType, sig.Params[i]) {
+			c.addError(exprSpan(arg), compiler.CodeTypeMismatch, "cannot use %s value as %s argument %d to %s",
+				argType, sig.Params[i], i+1, name)
+		}
+	}
+}
+
+// addError reports an error and returns it so notes can be attached
+func (c *Checker) addError(span compiler.Span, code string, format string, args ...interface{}) *compiler.Diagnostic {
+	d := compiler.NewDiagnostic(code, span, format, args...)
+	c.diagnostics = append(c.diagnostics, d)
+	return d
+}
+
+// exprSpan returns the source span covered by an expression
+func exprSpan(expr Expression) compiler.Span {
+	switch expr := expr.(type) {
+	case *PrefixExpression:
+		return TokenSpan(expr.Token).Join(exprSpan(expr.Right))
+	case *InfixExpression:
+		return exprSpan(expr.Left).Join(TokenSpan(expr.Token)).Join(exprSpan(expr.Right))
+	case *AssignmentExpression:
+		return exprSpan(expr.Left).Join(exprSpan(expr.Value))
+	case *CompoundAssignmentExpression:
+		return exprSpan(expr.Left).Join(exprSpan(expr.Value))
+	case *PostfixExpression:
+		return exprSpan(expr.Left).Join(TokenSpan(expr.Token))
+	case *CallExpression:
+		return exprSpan(expr.Function).Join(TokenSpan(expr.Token))
+	case *IndexExpression:
+		return exprSpan(expr.Left).Join(exprSpan(expr.Index))
+	case *SliceExpression:
+		return exprSpan(expr.Left).Join(TokenSpan(expr.Token))
+	case *ArrayLiteral:
+		return TokenSpan(expr.Token)
+	case *DotExpression:
+		return exprSpan(expr.Object).Join(TokenSpan(expr.Member.Token))
+	case *StructLiteralExpression:
+		return TokenSpan(expr.Token)
+	case *ConversionExpression:
+		return TokenSpan(expr.Token).Join(exprSpan(expr.Value))
+	case *Identifier:
+		return TokenSpan(expr.Token)
+	case *IntegerLiteral:
+		return TokenSpan(expr.Token)
+	case *FloatLiteral:
+		return TokenSpan(expr.Token)
+	case *StringLiteral:
+		return TokenSpan(expr.Token)
+	case *InterpolatedString:
+		return TokenSpan(expr.Token)
+	case *BooleanLiteral:
+		return TokenSpan(expr.Token)
+	case *NullLiteral:
+		return TokenSpan(expr.Token)
+	case *ErrorExpression:
+		return TokenSpan(expr.Token)
+	default:
+		return compiler.Span{}
+	}
+}
+
+// printBuiltins are the built-in print functions. Each prints one value of
+// any type followed by a newline.
+var printBuiltins = map[string]bool{
+	"print":        true,
+	"println":      true,
+	"print_int":    true,
+	"print_uint":   true,
+	"print_float":  true,
+	"print_bool":   true,
+	"print_string": true,
+}
+
+// runtimePrefix starts the names of the functions the code generator defines
+// for generated code to call, which programs can't use
+const runtimePrefix = "nova_"
+
+// isPrintBuiltin checks if a function name refers to a built-in print function
+func isPrintBuiltin(name string) bool {
+	return printBuiltins[name]
+}
+
+// splitArrayType splits an array or slice type into its length and element
+// type. The length of a slice is -1.
+func splitArrayType(typ string) (int, string, bool) {
+	if !strings.HasPrefix(typ, "[") {
+		return 0, "", false
+	}
+	end := strings.Index(typ, "]")
+	if end < 0 {
+		return 0, "", false
+	}
+	if end == 1 {
+		return -1, typ[2:], true
+	}
+
+	length, err := strconv.Atoi(typ[1:end])
+	if err != nil {
+		return 0, "", false
+	}
+	return length, typ[end+1:], true
+}
+
+// numericType describes the representation of a built-in numeric type
+type numericType struct {
+	Bits     int
+	Float    bool
+	Unsigned bool
+}
+
+// numericTypes are the built-in integer and floating point types
+var numericTypes = map[string]numericType{
+	"int":     {Bits: 32},
+	"int8":    {Bits: 8},
+	"int16":   {Bits: 16},
+	"int32":   {Bits: 32},
+	"int64":   {Bits: 64},
+	"uint8":   {Bits: 8, Unsigned: true},
+	"uint16":  {Bits: 16, Unsigned: true},
+	"uint32":  {Bits: 32, Unsigned: true},
+	"uint64":  {Bits: 64, Unsigned: true},
+	"uintptr": {Bits: 64, Unsigned: true},
+	"float":   {Bits: 32, Float: true},
+	"float64": {Bits: 64, Float: true},
+}
+
+// typeAliases are built-in names for other built-in types
+var typeAliases = map[string]string{
+	"byte":    "uint8",
+	"rune":    "int32",
+	"float32": "float",
+}
+
+// isNumericType checks if a type supports arithmetic
+func isNumericType(typ string) bool {
+	_, ok := numericTypes[typ]
+	return ok
+}
+
+// isIntegerType checks if a type is a signed or unsigned integer
+func isIntegerType(typ string) bool {
+	info, ok := numericTypes[typ]
+	return ok && !info.Float
+}
+
+// isUnsignedType checks if a type is an unsigned integer
+func isUnsignedType(typ string) bool {
+	return numericTypes[typ].Unsigned
+}
+
+// isFloatType checks if a type is a floating point type
+func isFloatType(typ string) bool {
+	return numericTypes[typ].Float
+}
+
+// constantFits checks if an integer constant can be represented by an
+// integer type
+func constantFits(value *big.Int, typ string) bool {
+	info := numericTypes[typ]
+	if info.Unsigned {
+		return value.Sign() >= 0 && value.BitLen() <= info.Bits
+	}
+	// BitLen ignores the sign, so -2^(n-1) is the one value using all n bits
+	if value.Sign() < 0 {
+		return new(big.Int).Add(value, big.NewInt(1)).BitLen() < info.Bits
+	}
+	return value.BitLen() < info.Bits
+}
+
+// isPointerType checks if a type is a pointer
+func isPointerType(typ string) bool {
+	return strings.HasPrefix(typ, "*")
+}
+
+// isFuncType checks if a type is a function type
+func isFuncType(typ string) bool {
+	return strings.HasPrefix(typ, "func(")
+}
+
+// isPointerLike checks if a value of a type is an address: a pointer or a
+// function
+func isPointerLike(typ string) bool {
+	return isPointerType(typ) || isFuncType(typ)
+}
+
+// comparablePointers checks if two types are addresses that can be
+// compared. NULL and other *void pointers compare with any address.
+func comparablePointers(a, b string) bool {
+	if !isPointerLike(a) || !isPointerLike(b) {
+		return false
+	}
+	return a == b || a == "*void" || b == "*void"
+}
+
+// funcTypeString makes the type string of a function type. Functions that
+// return nothing have no "->".
+func funcTypeString(params []string, ret string) string {
+	typ := "func(" + strings.Join(params, ", ") + ")"
+	if ret != "void" {
+		typ += " -> " + ret
+	}
+	return typ
+}
+
+// splitFuncType splits a function type into its parameter and return types
+func splitFuncType(typ string) ([]string, string, bool) {
+	if !isFuncType(typ) {
+		return nil, "", false
+	}
+
+	// Parameters can be function types themselves, so commas only
+	// separate them outside parentheses
+	var params []string
+	depth, start := 0, len("func(")
+	for i := start; i < len(typ); i++ {
+		switch typ[i] {
+		case '(':
+			depth++
+		case ',':
+			if depth == 0 {
+				params = append(params, typ[start:i])
+				start = i + len(", ")
+			}
+		case ')':
+			if depth > 0 {
+				depth--
+				continue
+			}
+			if i > start {
+				params = append(params, typ[start:i])
+			}
+			rest := typ[i+1:]
+			if rest == "" {
+				return params, "void", true
+			}
+			ret, ok := strings.CutPrefix(rest, " -> ")
+			return params, ret, ok
+		}
+	}
+	return nil, "", false
+}
+
+// isAssignable checks if a value of type from can be stored as type to.
+// Unresolved types are accepted so one error doesn't cascade into many.
+func isAssignable(from, to string) bool {
+	if from == "" || to == "" || from == to {
+		return true
+	}
+	// *void converts to and from any pointer, like C's void *
+	if comparablePointers(from, to) {
+		return true
+	}
+	return isIntegerType(from) && isFloatType(to)
+}
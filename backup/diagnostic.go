@@ -0,0 +1,26 @@
+package main // diagnostic.go
+
+import (
+	"unicode/utf8"
+
+	"github.com/deep-neural/nova-lang/compiler"
+)
+
+// TokenSpan returns the span covered by a token
+func TokenSpan(tok Token) compiler.Span {
+	width := utf8.RuneCountInString(tok.Literal)
+	if tok.Type == TOKEN_STRING {
+		width += 2 // quotes aren't part of the literal
+	}
+	if width == 0 {
+		width = 1
+	}
+
+	return compiler.Span{
+		File:        tok.File,
+		StartLine:   tok.Line,
+		StartColumn: tok.Column,
+		EndLine:     tok.Line,
+		EndColumn:   tok.Column + width,
+	}
+}
@@ -0,0 +1,301 @@
+package main // driver.go
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/deep-neural/nova-lang/compiler"
+	"github.com/llir/llvm/ir"
+)
+
+// The driver runs the stages of the compiler for the nova commands. Each
+// stage renders its own diagnostics and returns an exit code, so a command
+// stops at the first stage that fails.
+
+// buildOptions are the flags of the commands that compile a program
+type buildOptions struct {
+	output   string // -o; empty for the default
+	optLevel int    // -O0 to -O3
+	target   string // target triple; empty for the host
+	emit     string // tokens, ast, ir, obj or exe
+	libs     []string
+	libDirs  []string
+	log      *compiler.Logger
+}
+
+// logFlags are the flags that control what nova logs on stderr
+type logFlags struct {
+	verbose bool
+	trace   string
+}
+
+// logger creates the logger the flags ask for, reporting a bad --trace
+func (lf *logFlags) logger(cmd string) (*compiler.Logger, bool) {
+	log, err := compiler.NewLogger(os.Stderr, lf.verbose, lf.trace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nova %s: %v\n", cmd, err)
+		return nil, false
+	}
+	return log, true
+}
+
+// validEmit checks the value of --emit
+func validEmit(emit string) bool {
+	switch emit {
+	case "tokens", "ast", "ir", "obj", "exe":
+		return true
+	}
+	return false
+}
+
+// outputPath returns where a build writes its output: the -o flag, or the
+// name of the input with the extension of what is emitted. Tokens and ASTs
+// are printed unless -o is given.
+func (opts *buildOptions) outputPath(input string) string {
+	if opts.output != "" {
+		return opts.output
+	}
+	base := strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))
+	switch opts.emit {
+	case "tokens", "ast":
+		return "-"
+	case "ir":
+		return base + ".ll"
+	case "obj":
+		return base + ".o"
+	}
+	return base
+}
+
+// compilation is a program that has been loaded and checked
+type compilation struct {
+	file     string
+	program  *Program
+	checker  *Checker
+	renderer *compiler.DiagnosticRenderer
+	log      *compiler.Logger
+}
+
+// compile loads a program and every module it imports, links them and
+// checks the result, rendering any diagnostics
+func compile(file string, log *compiler.Logger) (*compilation, int) {
+	// Imports are resolved relative to the directory of the input file
+	loader := NewModuleLoader(filepath.Dir(file))
+	loader.log = log
+	if err := loader.Load(file); err != nil {
+		fmt.Fprintf(os.Stderr, "nova: %v\n", err)
+		return nil, exitIO
+	}
+
+	// All modules end up in one program, and so in one LLVM module. Linking
+	// can report errors too, such as a C function Nova can't call.
+	program := loader.Link()
+	renderer := loader.Renderer()
+	if code := report(renderer, loader.Diagnostics()); code != exitOK {
+		return nil, code
+	}
+
+	log.Verbosef("checking %s", file)
+	checker := NewChecker()
+	checker.Check(program)
+	if code := report(renderer, checker.Diagnostics()); code != exitOK {
+		return nil, code
+	}
+
+	return &compilation{file: file, program: program, checker: checker, renderer: renderer, log: log}, exitOK
+}
+
+// report renders diagnostics and returns the exit code for them
+func report(renderer *compiler.DiagnosticRenderer, diagnostics compiler.DiagnosticList) int {
+	renderer.RenderAll(os.Stderr, diagnostics)
+	for _, d := range diagnostics {
+		if d.Code == compiler.CodeInternal {
+			return exitInternal
+		}
+	}
+	if diagnostics.HasErrors() {
+		return exitErrors
+	}
+	return exitOK
+}
+
+// generate generates the LLVM IR of a checked program
+func (c *compilation) generate(opts *buildOptions) (*ir.Module, int) {
+	c.log.Verbosef("generating LLVM IR for %s", c.file)
+	generator := NewCodeGenerator(filepath.Base(c.file), c.checker)
+	generator.log = c.log
+	module, err := generator.Generate(c.program)
+	c.renderer.RenderAll(os.Stderr, generator.Diagnostics())
+	if err != nil {
+		d, ok := err.(*compiler.Diagnostic)
+		if !ok {
+			d = compiler.NewDiagnostic(compiler.CodeCodegen, compiler.Span{}, "%v", err)
+		}
+		return nil, report(c.renderer, compiler.DiagnosticList{d})
+	}
+
+	module.TargetTriple = opts.target
+	return module, exitOK
+}
+
+// build compiles a program to what opts.emit asks for
+func build(file string, opts *buildOptions) int {
+	output := opts.outputPath(file)
+
+	if opts.emit == "tokens" {
+		source, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "nova: %v\n", err)
+			return exitIO
+		}
+		return writeOutput(output, func(w io.Writer) error {
+			return emitTokens(w, file, string(source))
+		})
+	}
+
+	c, code := compile(file, opts.log)
+	if code != exitOK {
+		return code
+	}
+	if opts.emit == "ast" {
+		return writeOutput(output, func(w io.Writer) error {
+			return dumpAST(w, c.program)
+		})
+	}
+
+	module, code := c.generate(opts)
+	if code != exitOK {
+		return code
+	}
+	if opts.emit == "ir" {
+		c.log.Verbosef("writing %s", output)
+		return writeOutput(output, func(w io.Writer) error {
+			_, err := io.WriteString(w, module.String())
+			return err
+		})
+	}
+	return c.buildNative(module, opts, output)
+}
+
+// writeOutput writes to a file, or to stdout for "-"
+func writeOutput(path string, write func(w io.Writer) error) int {
+	if path == "-" {
+		if err := write(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "nova: %v\n", err)
+			return exitIO
+		}
+		return exitOK
+	}
+
+	f, err := os.Create(path)
+	if err == nil {
+		err = write(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nova: %v\n", err)
+		return exitIO
+	}
+	return exitOK
+}
+
+// emitTokens prints the tokens of a file, one per line with its position
+func emitTokens(w io.Writer, file, source string) error {
+	tokenizer := NewFileTokenizer(file, source)
+	for {
+		tok := tokenizer.NextToken()
+		if tok.Type == TOKEN_EOF {
+			return nil
+		}
+		literal := tok.Literal
+		if tok.Type == TOKEN_STRING {
+			literal = fmt.Sprintf("%q", literal)
+		}
+		if _, err := fmt.Fprintf(w, "%d:%d\t%s\t%s\n", tok.Line, tok.Column, tokenKind(tok), literal); err != nil {
+			return err
+		}
+	}
+}
+
+// tokenKind describes the kind of a token for --emit=tokens
+func tokenKind(tok Token) string {
+	switch tok.Type {
+	case TOKEN_IDENT:
+		if lookupIdent(tok.Literal) != TOKEN_IDENT {
+			return "keyword"
+		}
+		return "ident"
+	case TOKEN_NUMBER:
+		return "number"
+	case TOKEN_FLOAT:
+		return "float"
+	case TOKEN_STRING:
+		return "string"
+	case TOKEN_COMMENT:
+		return "comment"
+	case TOKEN_ILLEGAL:
+		return "illegal"
+	}
+	if _, ok := keywords[tok.Literal]; ok {
+		return "keyword"
+	}
+	return "punct"
+}
+
+// run builds a program in a temporary directory and runs it with args. The
+// exit code is the program's once it has been built.
+func run(file string, opts *buildOptions, args []string) int {
+	dir, err := ioutil.TempDir("", "nova-run")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nova: %v\n", err)
+		return exitIO
+	}
+	defer os.RemoveAll(dir)
+
+	exe, code := buildIn(dir, file, opts)
+	if code != exitOK {
+		return code
+	}
+	opts.log.Verbosef("running %s", file)
+	status, err := execute(exe, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nova: %v\n", err)
+		return exitIO
+	}
+	return status
+}
+
+// buildIn builds the executable of a program in a directory
+func buildIn(dir, file string, opts *buildOptions) (string, int) {
+	exe := *opts
+	exe.emit = "exe"
+	exe.output = filepath.Join(dir, strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)))
+	return exe.output, build(file, &exe)
+}
+
+// execute runs a program with nova's standard streams and returns its exit
+// status
+func execute(exe string, args []string) (int, error) {
+	return runCommand(exe, args, os.Stdout, os.Stderr)
+}
+
+// runCommand runs a program with stdin and the given output streams and
+// returns its exit status
+func runCommand(name string, args []string, stdout, stderr io.Writer) (int, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, stdout, stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	return 0, err
+}
@@ -0,0 +1,145 @@
+package main // format.go
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/deep-neural/nova-lang/compiler"
+)
+
+// nova fmt fixes the indentation and blank lines of source files and
+// nothing else. What is on each line, such as the spacing around operators
+// or a closing brace after a statement, is left as it was written, so
+// "x=2;}" stays as it is. Each open brace, bracket or parenthesis indents
+// the lines inside it by four spaces, and the statements of a switch case
+// by one more. Trailing whitespace is removed, runs of blank lines are
+// collapsed to one, and blank lines before a closing brace are dropped.
+// The lines inside block comments aren't touched.
+
+// fmtIndent is one level of indentation
+const fmtIndent = "    "
+
+// formatFile formats a file, writing the result back to it or to stdout.
+// Files with syntax errors are reported and left alone.
+func formatFile(file string, write bool) int {
+	source, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nova: %v\n", err)
+		return exitIO
+	}
+
+	parser := NewParser(NewFileTokenizer(file, string(source)))
+	parser.Parse()
+	if diagnostics := parser.Diagnostics(); diagnostics.HasErrors() {
+		return report(compiler.NewDiagnosticRenderer(file, string(source)), diagnostics)
+	}
+
+	formatted := formatSource(file, string(source))
+	if !write {
+		fmt.Print(formatted)
+		return exitOK
+	}
+	if formatted == string(source) {
+		return exitOK
+	}
+	if err := ioutil.WriteFile(file, []byte(formatted), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "nova: %v\n", err)
+		return exitIO
+	}
+	return exitOK
+}
+
+// fmtLevel is a brace, bracket or parenthesis still open in formatSource
+type fmtLevel struct {
+	inCase bool // after a case label, so statements are indented once more
+}
+
+// formatSource formats source that has already been parsed
+func formatSource(file, source string) string {
+	lines := strings.Split(source, "\n")
+
+	// Group the tokens by the line they start on
+	tokens := make([][]Token, len(lines)+1)
+	verbatim := make([]bool, len(lines)+1)
+	tokenizer := NewFileTokenizer(file, source)
+	for tok := tokenizer.NextToken(); tok.Type != TOKEN_EOF; tok = tokenizer.NextToken() {
+		tokens[tok.Line] = append(tokens[tok.Line], tok)
+		// The tokenizer is left on the last line of a block comment
+		if tok.Type == TOKEN_COMMENT {
+			for line := tok.Line + 1; line <= tokenizer.line; line++ {
+				verbatim[line] = true
+			}
+		}
+	}
+
+	var out strings.Builder
+	var levels []fmtLevel
+	blank := false
+	for i, line := range lines {
+		lineNo := i + 1
+		if verbatim[lineNo] {
+			out.WriteString(strings.TrimRight(line, " \t\r") + "\n")
+			blank = false
+			continue
+		}
+
+		text := strings.TrimSpace(line)
+		if text == "" {
+			blank = out.Len() > 0
+			continue
+		}
+
+		// A line that closes a level is indented like the line that opened
+		// it, with no blank line before it
+		depth := len(levels)
+		toks := tokens[lineNo]
+		if len(toks) > 0 {
+			switch toks[0].Type {
+			case TOKEN_RBRACE, TOKEN_RBRACKET, TOKEN_RPAREN:
+				depth--
+				blank = false
+			case TOKEN_CASE, TOKEN_DEFAULT:
+				if depth > 0 {
+					levels[depth-1].inCase = false
+				}
+			}
+		}
+		if blank {
+			out.WriteString("\n")
+			blank = false
+		}
+		out.WriteString(strings.Repeat(fmtIndent, indentOf(levels, depth)) + text + "\n")
+
+		for _, tok := range toks {
+			switch tok.Type {
+			case TOKEN_LBRACE, TOKEN_LBRACKET, TOKEN_LPAREN:
+				levels = append(levels, fmtLevel{})
+			case TOKEN_RBRACE, TOKEN_RBRACKET, TOKEN_RPAREN:
+				if len(levels) > 0 {
+					levels = levels[:len(levels)-1]
+				}
+			case TOKEN_CASE, TOKEN_DEFAULT:
+				if len(levels) > 0 {
+					levels[len(levels)-1].inCase = true
+				}
+			}
+		}
+	}
+	return out.String()
+}
+
+// indentOf counts the indentation of a line inside the first depth levels
+func indentOf(levels []fmtLevel, depth int) int {
+	if depth < 0 {
+		depth = 0
+	}
+	indent := depth
+	for _, level := range levels[:depth] {
+		if level.inCase {
+			indent++
+		}
+	}
+	return indent
+}
@@ -0,0 +1,57 @@
+#!/bin/sh
+# Checks a JSON dump against the header it was made from:
+#
+#   ./check.sh <header.h> <import path>
+#
+#   ./check.sh stdio.h stdio
+#   ./check.sh X11/Xlib.h Xlib/x11
+#
+# Each function is redeclared and each typedef and record field is compared
+# with the header's, so cc fails on any type that doesn't match. Fields must
+# come in the header's order, and the ones of a struct must end where the
+# header's struct does; the fields a dump leaves out of a union can't be
+# noticed. Typedefs of function types are skipped, as C can't spell them.
+set -e
+
+if [ $# -ne 2 ]; then
+    echo "usage: $0 <header.h> <import path>" >&2
+    exit 1
+fi
+
+dump=$(dirname "$0")/$2.json
+src=$(mktemp --suffix=.c)
+trap 'rm -f "$src"' EXIT
+
+{
+    echo "#include <$1>"
+    echo "#include <stddef.h>"
+    jq -r -f /dev/stdin "$dump" <<'EOF'
+def same($a; $b; $what): "_Static_assert(__builtin_types_compatible_p(\($a), \($b)), \"\($what)\");";
+
+.inner as $all
+| ($all | map(select(.kind == "TypedefDecl")
+              | {key: (.inner[0].ownedTagDecl?.id // ""), value: .name})
+        | map(select(.key != "")) | from_entries) as $anon
+| $all[]
+| if .kind == "FunctionDecl" then
+    .name as $name
+    | "extern " + (.type.qualType | sub(" __attribute__.*"; "") | sub("\\("; " \($name)(")) + ";"
+  elif .kind == "TypedefDecl" and (.type.qualType | contains("(") | not)
+       and (.inner[0].ownedTagDecl?.name // "x") != "" then
+    same(.name; .type.qualType; .name)
+  elif .kind == "RecordDecl" and .completeDefinition then
+    (if (.name // "") != "" then "\(.tagUsed) \(.name)" else $anon[.id] end) as $rec
+    | [.inner[]? | select(.kind == "FieldDecl")] as $fields
+    | ($fields[] | same("__typeof__(((\($rec) *)0)->\(.name))"; .type.qualType; "\($rec).\(.name)")),
+      (if .tagUsed == "struct" and ($fields | length) > 0 then
+         (range(1; $fields | length) as $i
+          | "_Static_assert(offsetof(\($rec), \($fields[$i - 1].name)) < offsetof(\($rec), \($fields[$i].name)), \"\($rec).\($fields[$i].name)\");"),
+         ($fields[-1].name as $last
+          | "_Static_assert(sizeof(\($rec)) - offsetof(\($rec), \($last)) - sizeof(((\($rec) *)0)->\($last)) < _Alignof(\($rec)), \"\($rec) ends after \($last)\");")
+       else empty end)
+  else empty end
+EOF
+} > "$src"
+
+${CC:-cc} -std=gnu11 -fsyntax-only $CFLAGS "$src"
+echo "$dump matches <$1>"
@@ -0,0 +1,31 @@
+#!/bin/sh
+# Generates the JSON AST dump that a virtual import of a C header reads:
+#
+#   ./generate.sh <header.h> <import path> [names...]
+#
+#   ./generate.sh stdio.h stdio printf puts fopen
+#   CFLAGS="$(pkg-config --cflags glib-2.0)" ./generate.sh glib.h glib g_main_loop_new
+#
+# clang dumps every declaration the header pulls in. trim.jq keeps the named
+# functions and enum constants, or every function when no names are given,
+# with the declarations their types refer to, and drops source locations so
+# the output doesn't depend on where the headers are installed.
+set -e
+
+if [ $# -lt 2 ]; then
+    echo "usage: $0 <header.h> <import path> [names...]" >&2
+    exit 1
+fi
+
+header=$1
+out=$(dirname "$0")/$2.json
+shift 2
+
+names=$(printf '%s\n' "$@" | jq -R 'select(. != "")' | jq -s .)
+
+mkdir -p "$(dirname "$out")"
+echo "#include <$header>" |
+    clang -Xclang -ast-dump=json -fsyntax-only $CFLAGS -x c - |
+    jq --argjson names "$names" -f "$(dirname "$0")/trim.jq" > "$out"
+
+echo "wrote $out"
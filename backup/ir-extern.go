@@ -0,0 +1,275 @@
+package main // ir-extern.go
+
+import (
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Extern functions are declared with the LLVM types clang would give them, so
+// the declarations match the C library they link against. Nova values are
+// converted at each call: strings are passed as char * and pointers are cast
+// to the pointer type C expects.
+
+// externCallingConvs maps the names @callconv accepts to LLVM calling
+// conventions
+var externCallingConvs = map[string]enum.CallingConv{
+	"c":             enum.CallingConvC,
+	"fast":          enum.CallingConvFast,
+	"cold":          enum.CallingConvCold,
+	"preserve_most": enum.CallingConvPreserveMost,
+	"preserve_all":  enum.CallingConvPreserveAll,
+	"stdcall":       enum.CallingConvX86StdCall,
+	"fastcall":      enum.CallingConvX86FastCall,
+	"thiscall":      enum.CallingConvX86ThisCall,
+	"vectorcall":    enum.CallingConvX86VectorCall,
+	"sysv":          enum.CallingConvX86_64SysV,
+	"win64":         enum.CallingConvWin64,
+}
+
+// externLinkages maps the names @linkage accepts to the LLVM linkages a
+// declaration can have. An extern_weak function is null if it isn't linked.
+var externLinkages = map[string]enum.Linkage{
+	"external":    enum.LinkageExternal,
+	"extern_weak": enum.LinkageExternWeak,
+}
+
+// declareExterns declares the extern functions a program uses. A function
+// that is already declared, such as printf, is shared.
+func (g *CodeGenerator) declareExterns(externs []*ExternFunction) {
+	for _, ext := range externs {
+		var retType types.Type
+		paramTypes := make([]types.Type, 0, len(ext.Parameters))
+
+		if ext.C != nil {
+			retType = g.cType(ext.C.Return)
+			for _, param := range ext.C.Params {
+				paramTypes = append(paramTypes, g.cType(param))
+			}
+		} else {
+			retType = g.externType(ext.ReturnType)
+			for _, param := range ext.Parameters {
+				paramTypes = append(paramTypes, g.externType(param.Type))
+			}
+		}
+
+		fn := g.runtimeFunc(ext.Symbol, retType, ext.Variadic, paramTypes...)
+		if ext.CallingConv != "" {
+			fn.CallingConv = externCallingConvs[ext.CallingConv]
+		}
+		if ext.Linkage != "" {
+			fn.Linkage = externLinkages[ext.Linkage]
+		}
+		g.externs[ext.Name] = ext
+		g.functions[ext.Name] = fn
+	}
+}
+
+// externType lowers a Nova type as it is passed to C, where strings are
+// char *
+func (g *CodeGenerator) externType(ts TypeSpecifier) types.Type {
+	typ := g.llvmType(ts)
+	if g.isStringType(typ) {
+		return types.NewPointer(types.I8)
+	}
+	return typ
+}
+
+// cType lowers a C type the way clang does on x86-64
+func (g *CodeGenerator) cType(ct *CType) types.Type {
+	switch ct.Kind {
+	case CKindBool:
+		return types.I1
+	case CKindInt:
+		return types.NewInt(uint64(ct.Bits))
+	case CKindFloat:
+		switch ct.Bits {
+		case 32:
+			return types.Float
+		case 80:
+			return types.X86_FP80
+		}
+		return types.Double
+	case CKindEnum:
+		return types.I32
+	case CKindPointer:
+		// LLVM has no void*, so use i8* like C
+		if ct.Elem.Kind == CKindVoid {
+			return types.NewPointer(types.I8)
+		}
+		return types.NewPointer(g.cType(ct.Elem))
+	case CKindArray:
+		return types.NewArray(uint64(ct.Length), g.cType(ct.Elem))
+	case CKindFunc:
+		params := make([]types.Type, 0, len(ct.Func.Params))
+		for _, param := range ct.Func.Params {
+			params = append(params, g.cType(param))
+		}
+		sig := types.NewFunc(g.cType(ct.Func.Return), params...)
+		sig.Variadic = ct.Func.Variadic
+		return sig
+	case CKindRecord:
+		return g.cRecordType(ct.Record)
+	}
+	return types.Void
+}
+
+// cRecordType returns the named LLVM type of a C struct or union, defining it
+// on first use. A union is its most aligned field padded to the union's
+// size. Records without a definition are opaque; they can still be used
+// through pointers.
+func (g *CodeGenerator) cRecordType(rec *CRecord) *types.StructType {
+	if typ, ok := g.cRecords[rec]; ok {
+		return typ
+	}
+
+	typ := types.NewStruct()
+	typ.Opaque = true
+	g.module.NewTypeDef(rec.Tag+"."+rec.Name, typ)
+	g.cRecords[rec] = typ
+	g.cRecordDefs[typ] = rec
+
+	if !cHasLayout(rec, map[*CRecord]bool{}) {
+		return typ
+	}
+	if rec.Tag == "union" {
+		typ.Fields = g.cUnionFields(rec)
+	} else {
+		for _, field := range rec.Fields {
+			typ.Fields = append(typ.Fields, g.cFieldType(field.Type))
+		}
+	}
+	typ.Opaque = false
+	return typ
+}
+
+// cFieldType lowers the type of a C field. _Bool is a byte in memory.
+func (g *CodeGenerator) cFieldType(ct *CType) types.Type {
+	if ct.Kind == CKindBool {
+		return types.I8
+	}
+	return g.cType(ct)
+}
+
+// cUnionFields lays out a union as the field with the largest alignment,
+// and the largest size among those, followed by enough bytes to fill the
+// rest of the union
+func (g *CodeGenerator) cUnionFields(rec *CRecord) []types.Type {
+	var widest *CField
+	widestSize, widestAlign := 0, 0
+	for _, field := range rec.Fields {
+		size, align := cSizeAlign(field.Type)
+		if align > widestAlign || (align == widestAlign && size > widestSize) {
+			widest, widestSize, widestAlign = field, size, align
+		}
+	}
+	if widest == nil {
+		return nil
+	}
+
+	fields := []types.Type{g.cFieldType(widest.Type)}
+	if size, _ := cRecordSizeAlign(rec); size > widestSize {
+		fields = append(fields, types.NewArray(uint64(size-widestSize), types.I8))
+	}
+	return fields
+}
+
+// cFieldPointer generates a pointer to a field of the C record base points
+// to. Struct fields are in C's order, while every field of a union starts
+// at its beginning. The pointer has the field's Nova type, which for a
+// pointer field can differ from its C type.
+func (g *CodeGenerator) cFieldPointer(dot *DotExpression, rec *CRecord, structType *types.StructType, base value.Value) (value.Value, error) {
+	for i, field := range rec.Fields {
+		if field.Name != dot.Member.Value {
+			continue
+		}
+
+		ptr := base
+		if rec.Tag != "union" {
+			zero := constant.NewInt(types.I32, 0)
+			ptr = g.currentBlk.NewGetElementPtr(structType, base, zero, constant.NewInt(types.I32, int64(i)))
+		}
+		ptrType := types.NewPointer(g.llvmTypeOf(g.typeOf(dot)))
+		if !types.Equal(ptr.Type(), ptrType) {
+			ptr = g.currentBlk.NewBitCast(ptr, ptrType)
+		}
+		return ptr, nil
+	}
+	return nil, g.errorf(TokenSpan(dot.Member.Token), "%s %s has no field %s", rec.Tag, rec.Name, dot.Member.Value)
+}
+
+// generateExternCall generates a call to an extern function, converting the
+// arguments to the types it was declared with
+func (g *CodeGenerator) generateExternCall(callExpr *CallExpression, ext *ExternFunction) (value.Value, error) {
+	fn := g.functions[ext.Name]
+
+	args := make([]value.Value, 0, len(callExpr.Arguments))
+	for i, arg := range callExpr.Arguments {
+		// NULL passed as a char * is a null pointer, not an empty string
+		if _, isNull := arg.(*NullLiteral); isNull && i < len(ext.Parameters) {
+			if ptrType, ok := fn.Params[i].Type().(*types.PointerType); ok {
+				args = append(args, constant.NewNull(ptrType))
+				continue
+			}
+		}
+
+		// Arguments passed through "..." get C's default promotions
+		if i >= len(ext.Parameters) {
+			val, err := g.generateExpression(arg)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, g.promoteVariadic(val, g.typeOf(arg)))
+			continue
+		}
+
+		val, err := g.generateExpressionAs(arg, g.llvmType(ext.Parameters[i].Type))
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, g.externArg(val, fn.Params[i].Type()))
+	}
+
+	// Calls must use the callee's calling convention
+	result := g.currentBlk.NewCall(fn, args...)
+	result.CallingConv = fn.CallingConv
+	if types.Equal(fn.Sig.RetType, types.Void) {
+		// Return constant int as placeholder that will be ignored
+		return constant.NewInt(types.I32, 0), nil
+	}
+	return g.externResult(result, g.llvmType(ext.ReturnType)), nil
+}
+
+// externArg converts a Nova value to the type an extern function takes
+func (g *CodeGenerator) externArg(val value.Value, typ types.Type) value.Value {
+	if g.isStringType(val.Type()) {
+		val = g.stringData(val)
+	}
+	if types.Equal(val.Type(), typ) {
+		return val
+	}
+	if types.IsPointer(val.Type()) && types.IsPointer(typ) {
+		return g.currentBlk.NewBitCast(val, typ)
+	}
+	return val
+}
+
+// externResult converts the result of an extern function to its Nova type.
+// A char * result becomes a string.
+func (g *CodeGenerator) externResult(val value.Value, typ types.Type) value.Value {
+	if types.Equal(val.Type(), typ) {
+		return val
+	}
+	if g.isStringType(typ) {
+		i8ptr := types.NewPointer(types.I8)
+		if !types.Equal(val.Type(), i8ptr) {
+			val = g.currentBlk.NewBitCast(val, i8ptr)
+		}
+		return g.currentBlk.NewCall(g.stringFromC(), val)
+	}
+	if types.IsPointer(val.Type()) && types.IsPointer(typ) {
+		return g.currentBlk.NewBitCast(val, typ)
+	}
+	return val
+}
@@ -3,8 +3,10 @@ package main // ir-gen.go
 import (
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"strings"
 
+	"github.com/deep-neural/nova-lang/compiler"
 	"github.com/llir/llvm/ir"
 	"github.com/llir/llvm/ir/constant"
 	"github.com/llir/llvm/ir/enum"
@@ -16,29 +18,77 @@ import (
 type CodeGenerator struct {
 	module     *ir.Module
 	functions  map[string]*ir.Func
-	variables  map[string]value.Value
+	variables  *compiler.Scope[value.Value]
+	localNames map[string]int
+	preds      map[*ir.Block]int // branches to each block of the current function
 	currentFn  *ir.Func
 	currentBlk *ir.Block
 	stringLit  map[string]*ir.Global
+	structs    map[string]*structInfo
+	enums      map[string]map[string]int // enum name to member indexes
+	externs    map[string]*ExternFunction
+	cRecords   map[*CRecord]*types.StructType
+	cRecordDefs map[*types.StructType]*CRecord
+	headers    map[string]*CHeader // imported C headers by namespace
+	loops      []loopTargets // innermost loop or switch last
+	conditions []condition   // branch conditions of the current function
+	sources    map[*ir.Block][]sourceMark // where the code of each block came from
+	generating []compiler.Span // source being generated, innermost last
+	stringType *types.StructType
+	checker    *Checker // types resolved by semantic analysis
+	sourceName string   // reported by runtime errors in the main file
 	nextTemp   int
+	log        *compiler.Logger // traces the nodes generated
+	
+	diagnostics compiler.DiagnosticList
+}
+
+// structInfo is the LLVM lowering of a struct definition
+type structInfo struct {
+	typ    *types.StructType
+	fields map[string]int // field name to index
+}
+
+// loopTargets are the blocks that break and continue jump to in a loop or
+// switch. A switch outside any loop has no continue block.
+type loopTargets struct {
+	breakBlock    *ir.Block
+	continueBlock *ir.Block
 }
 
 // NewCodeGenerator creates a new code generator
-func NewCodeGenerator(moduleName string) *CodeGenerator {
+func NewCodeGenerator(moduleName string, checker *Checker) *CodeGenerator {
 	return &CodeGenerator{
 		module:    ir.NewModule(),
 		functions: make(map[string]*ir.Func),
-		variables: make(map[string]value.Value),
+		variables: compiler.NewScope[value.Value](nil),
 		stringLit: make(map[string]*ir.Global),
-		nextTemp:  1,
+		structs:    make(map[string]*structInfo),
+		enums:      make(map[string]map[string]int),
+		externs:    make(map[string]*ExternFunction),
+		cRecords:   make(map[*CRecord]*types.StructType),
+		cRecordDefs: make(map[*types.StructType]*CRecord),
+		sources:    make(map[*ir.Block][]sourceMark),
+		checker:    checker,
+		sourceName: moduleName,
+		nextTemp:   1,
 	}
 }
 
 // Generate generates LLVM IR for a program
-func (g *CodeGenerator) Generate(program *Program) (*ir.Module, error) {
+func (g *CodeGenerator) Generate(program *Program) (module *ir.Module, err error) {
+	defer g.recoverPanic(&err)
+	g.headers = program.Headers
+	
 	// Declare built-in functions
 	g.declareBuiltins()
 	
+	// Types come first since signatures can refer to them, and enums before
+	// structs since fields can
+	g.declareEnums(program.Enums)
+	g.declareStructs(program.Structs)
+	g.declareExterns(program.Externs)
+	
 	// First pass: declare all functions
 	for _, fn := range program.Functions {
 		if err := g.declareFunction(fn); err != nil {
@@ -53,9 +103,25 @@ func (g *CodeGenerator) Generate(program *Program) (*ir.Module, error) {
 		}
 	}
 	
+	// Catch bad IR here rather than in clang
+	if err := g.verifyModule(); err != nil {
+		return nil, err
+	}
+	
 	return g.module, nil
 }
 
+// Diagnostics returns any warnings reported during code generation. Errors are
+// returned from Generate as *Diagnostic values.
+func (g *CodeGenerator) Diagnostics() compiler.DiagnosticList {
+	return g.diagnostics
+}
+
+// errorf creates a code generation error for a span of source
+func (g *CodeGenerator) errorf(span compiler.Span, format string, args ...interface{}) error {
+	return compiler.NewDiagnostic(compiler.CodeCodegen, span, format, args...)
+}
+
 // SaveModule saves the LLVM IR to a file
 func SaveModule(m *ir.Module, filename string) error {
 	// Convert module to string
@@ -65,24 +131,59 @@ func SaveModule(m *ir.Module, filename string) error {
 	return ioutil.WriteFile(filename, []byte(moduleStr), 0644)
 }
 
+// declareStructs lowers struct definitions to named LLVM struct types. All
+// names are declared before any fields so structs can point to each other.
+func (g *CodeGenerator) declareStructs(structs []*StructDefinition) {
+	for _, st := range structs {
+		typ := types.NewStruct()
+		g.module.NewTypeDef(st.Name, typ)
+		g.structs[st.Name] = &structInfo{typ: typ, fields: make(map[string]int)}
+	}
+	
+	for _, st := range structs {
+		info := g.structs[st.Name]
+		for i, field := range st.Fields {
+			info.typ.Fields = append(info.typ.Fields, g.llvmType(field.Type))
+			info.fields[field.Name] = i
+		}
+	}
+}
+
+// declareEnums records the members of each enum. Enum values are i32 member
+// indexes.
+func (g *CodeGenerator) declareEnums(enums []*EnumDefinition) {
+	for _, enum := range enums {
+		members := make(map[string]int)
+		for i, member := range enum.Members {
+			members[member.Value] = i
+		}
+		g.enums[enum.Name] = members
+	}
+}
+
 // declareBuiltins declares built-in functions like print
 func (g *CodeGenerator) declareBuiltins() {
+	g.declareStringType()
+	
 	// Declare printf
 	printf := g.module.NewFunc("printf", types.I32, ir.NewParam("format", types.NewPointer(types.I8)))
 	printf.Sig.Variadic = true
 	g.functions["printf"] = printf
 
-	// Add print functions for each type
-	g.addPrintFunction("print_int", types.I32)
-	g.addPrintFunction("print_float", types.Float)
-	g.addPrintFunction("print_bool", types.I1)
+	// Add print functions for each type. Integers are printed as 64 bits and
+	// floats as doubles, so one function covers every width. Floats use %g
+	// like interpolation, so print(x) and "#{x}" agree.
+	g.addPrintFunction("nova_print_int", types.I64, "%lld\n")
+	g.addPrintFunction("nova_print_uint", types.I64, "%llu\n")
+	g.addPrintFunction("nova_print_float", types.Double, "%g\n")
+	g.addPrintFunction("nova_print_bool", types.I1, "%s\n")
 	
 	// print_string is special
 	g.addPrintStringFunction()
 }
 
 // addPrintFunction adds a print function for a specific type
-func (g *CodeGenerator) addPrintFunction(name string, typ types.Type) {
+func (g *CodeGenerator) addPrintFunction(name string, typ types.Type, format string) {
 	// Create function
 	fn := g.module.NewFunc(name, types.Void, ir.NewParam("value", typ))
 	g.functions[name] = fn
@@ -90,16 +191,6 @@ func (g *CodeGenerator) addPrintFunction(name string, typ types.Type) {
 	// Create block for function
 	block := fn.NewBlock("")
 	
-	// Create format string
-	var format string
-	if typ == types.I1 { // Boolean
-		format = "%s\n"
-	} else if typ == types.Float {
-		format = "%f\n"
-	} else {
-		format = "%d\n"
-	}
-	
 	// Temporarily set currentBlk so getStringLiteral can use it
 	oldBlk := g.currentBlk
 	g.currentBlk = block
@@ -134,8 +225,8 @@ func (g *CodeGenerator) addPrintFunction(name string, typ types.Type) {
 // addPrintStringFunction adds a specialized print function for strings
 func (g *CodeGenerator) addPrintStringFunction() {
 	// Create function
-	fn := g.module.NewFunc("print_string", types.Void, ir.NewParam("value", types.NewPointer(types.I8)))
-	g.functions["print_string"] = fn
+	fn := g.module.NewFunc("nova_print_string", types.Void, ir.NewParam("value", g.stringType))
+	g.functions["nova_print_string"] = fn
 	
 	block := fn.NewBlock("")
 	
@@ -143,28 +234,40 @@ func (g *CodeGenerator) addPrintStringFunction() {
 	oldBlk := g.currentBlk
 	g.currentBlk = block
 	
-	// Create format string
-	formatStr := g.getStringLiteral("%s\n")
+	// Create format string. The length is passed as the precision since
+	// strings carry their length.
+	formatStr := g.getStringLiteral("%.*s\n")
 	
 	// Call printf with the string
-	block.NewCall(g.functions["printf"], formatStr, fn.Params[0])
+	length := block.NewTrunc(block.NewExtractValue(fn.Params[0], 1), types.I32)
+	block.NewCall(g.functions["printf"], formatStr, length, block.NewExtractValue(fn.Params[0], 0))
 	block.NewRet(nil)
 	
 	// Restore original currentBlk
 	g.currentBlk = oldBlk
 }
 
-// getStringLiteral creates a global string constant
+// getStringLiteral returns a pointer to a NUL-terminated global copy of a
+// string, for use as a C string
 func (g *CodeGenerator) getStringLiteral(str string) value.Value {
+	global := g.stringGlobal(str)
+	
+	// If we're currently in a function block
+	if g.currentBlk != nil {
+		// Return a pointer to the first character of the string
+		zero := constant.NewInt(types.I32, 0)
+		return g.currentBlk.NewGetElementPtr(global.ContentType, global, zero, zero)
+	}
+	
+	// Otherwise, just return the global (used during init)
+	return global
+}
+
+// stringGlobal creates a global string constant, or reuses the one already
+// created for the same string
+func (g *CodeGenerator) stringGlobal(str string) *ir.Global {
 	// Check if we already have this string
 	if global, ok := g.stringLit[str]; ok {
-		// If we're currently in a function block
-		if g.currentBlk != nil {
-			// Return a pointer to the first character of the string
-			zero := constant.NewInt(types.I32, 0)
-			return g.currentBlk.NewGetElementPtr(global.ContentType, global, zero, zero)
-		}
-		// Otherwise, just return the global (used during init)
 		return global
 	}
 	
@@ -181,15 +284,6 @@ func (g *CodeGenerator) getStringLiteral(str string) value.Value {
 	
 	// Store in cache
 	g.stringLit[str] = global
-	
-	// If we're currently in a function block
-	if g.currentBlk != nil {
-		// Return a pointer to the first character
-		zero := constant.NewInt(types.I32, 0)
-		return g.currentBlk.NewGetElementPtr(global.ContentType, global, zero, zero)
-	}
-	
-	// Otherwise, just return the global (used during init)
 	return global
 }
 
@@ -201,31 +295,151 @@ func (g *CodeGenerator) getNextTemp() string {
 }
 
 // llvmType converts a language type to an LLVM type
-func (g *CodeGenerator) llvmType(typeName string) types.Type {
-	switch typeName {
-	case "int":
-		return types.I32
+func (g *CodeGenerator) llvmType(ts TypeSpecifier) types.Type {
+	// Function values are pointers to functions
+	if ts.Func != nil {
+		params := make([]types.Type, 0, len(ts.Func.Params))
+		for _, param := range ts.Func.Params {
+			params = append(params, g.llvmType(param))
+		}
+		var ret types.Type = types.Void
+		if !ts.Func.ReturnType.IsEmpty() {
+			ret = g.llvmType(ts.Func.ReturnType)
+		}
+		return types.NewPointer(types.NewFunc(ret, params...))
+	}
+	
+	if ts.IsPointer {
+		elem := g.llvmType(*ts.Elem)
+		
+		// LLVM has no void*, so use i8* like C
+		if elem == types.Void {
+			elem = types.I8
+		}
+		return types.NewPointer(elem)
+	}
+	
+	// Arrays are LLVM arrays; slices are {ptr, len, cap}
+	if ts.Elem != nil {
+		elem := g.llvmType(*ts.Elem)
+		if ts.Length < 0 {
+			return sliceType(elem)
+		}
+		return types.NewArray(uint64(ts.Length), elem)
+	}
+	
+	var typ types.Type
+	
+	name := ts.TypeName
+	if alias, ok := typeAliases[name]; ok {
+		name = alias
+	}
+	
+	switch name {
 	case "float":
-		return types.Float
+		typ = types.Float
+	case "float64":
+		typ = types.Double
 	case "string":
-		return types.NewPointer(types.I8) // char*
+		typ = g.stringType
 	case "bool":
-		return types.I1
+		typ = types.I1
 	case "void":
-		return types.Void
+		typ = types.Void
 	default:
+		if isIntegerType(name) {
+			typ = types.NewInt(uint64(numericTypes[name].Bits))
+			break
+		}
+		if info, ok := g.structs[name]; ok {
+			typ = info.typ
+			break
+		}
+		if _, ok := g.enums[name]; ok {
+			typ = types.I32
+			break
+		}
+		if ct, novaType, err := cTypeNamed(g.headers, name); ct != nil && err == nil {
+			if ct.Kind == CKindRecord {
+				typ = g.cRecordType(ct.Record)
+			} else {
+				typ = g.llvmTypeOf(novaType)
+			}
+			break
+		}
+		
 		// Default to int for unknown types
-		fmt.Printf("Warning: Unknown type %s, using i32\n", typeName)
-		return types.I32
+		d := compiler.NewDiagnostic(compiler.CodeUnknownType, TokenSpan(ts.Token), "unknown type %s, using i32", ts.TypeName)
+		d.Severity = compiler.SeverityWarning
+		g.diagnostics = append(g.diagnostics, d)
+		typ = types.I32
+	}
+	
+	return typ
+}
+
+// llvmTypeOf converts a type string resolved by the checker to an LLVM type
+func (g *CodeGenerator) llvmTypeOf(typ string) types.Type {
+	if params, ret, ok := splitFuncType(typ); ok {
+		paramTypes := make([]types.Type, 0, len(params))
+		for _, param := range params {
+			paramTypes = append(paramTypes, g.llvmTypeOf(param))
+		}
+		return types.NewPointer(types.NewFunc(g.llvmTypeOf(ret), paramTypes...))
+	}
+	if strings.HasPrefix(typ, "*") {
+		// LLVM has no void*, so use i8* like C
+		elem := g.llvmTypeOf(typ[1:])
+		if elem == types.Void {
+			elem = types.I8
+		}
+		return types.NewPointer(elem)
+	}
+	if length, elem, ok := splitArrayType(typ); ok {
+		elemType := g.llvmTypeOf(elem)
+		if length < 0 {
+			return sliceType(elemType)
+		}
+		return types.NewArray(uint64(length), elemType)
+	}
+	return g.llvmType(TypeSpecifier{TypeName: typ})
+}
+
+// typeOf returns the type the checker resolved for an expression, or "" if
+// there is none
+func (g *CodeGenerator) typeOf(expr Expression) string {
+	if g.checker == nil {
+		return ""
+	}
+	return g.checker.TypeOf(expr)
+}
+
+// sliceType returns the LLVM type of a slice: a pointer to the first element,
+// the length and the capacity
+func sliceType(elem types.Type) *types.StructType {
+	return types.NewStruct(types.NewPointer(elem), types.I64, types.I64)
+}
+
+// sliceElemType returns the element type of a slice type, or nil if typ is
+// not a slice
+func sliceElemType(typ types.Type) types.Type {
+	st, ok := typ.(*types.StructType)
+	if !ok || st.Name() != "" || len(st.Fields) != 3 {
+		return nil
+	}
+	ptrType, ok := st.Fields[0].(*types.PointerType)
+	if !ok || !types.Equal(st.Fields[1], types.I64) || !types.Equal(st.Fields[2], types.I64) {
+		return nil
 	}
+	return ptrType.ElemType
 }
 
 // declareFunction declares a function (first pass)
-func (g *CodeGenerator) declareFunction(fnDecl *FunctionDecl) error {
+func (g *CodeGenerator) declareFunction(fnDecl *FunctionDefinition) error {
 	// Convert parameter types
 	params := make([]*ir.Param, 0, len(fnDecl.Parameters))
 	for _, param := range fnDecl.Parameters {
-		llvmType := g.llvmType(param.VarType)
+		llvmType := g.llvmType(param.Type)
 		params = append(params, ir.NewParam(param.Name, llvmType))
 	}
 	
@@ -242,7 +456,7 @@ func (g *CodeGenerator) declareFunction(fnDecl *FunctionDecl) error {
 }
 
 // generateFunction generates code for a function body (second pass)
-func (g *CodeGenerator) generateFunction(fnDecl *FunctionDecl) error {
+func (g *CodeGenerator) generateFunction(fnDecl *FunctionDefinition) error {
 	// Get the function
 	fn := g.functions[fnDecl.Name]
 	g.currentFn = fn
@@ -250,9 +464,14 @@ func (g *CodeGenerator) generateFunction(fnDecl *FunctionDecl) error {
 	// Create a new block
 	entry := fn.NewBlock("")
 	g.currentBlk = entry
+	g.enterSource(fnDecl, TokenSpan(fnDecl.Token))
+	defer g.leaveSource()
 	
-	// Clear the local variables
-	g.variables = make(map[string]value.Value)
+	// Start a fresh scope; parameters share it with the function body
+	g.variables = compiler.NewScope[value.Value](nil)
+	g.localNames = make(map[string]int)
+	g.preds = make(map[*ir.Block]int)
+	g.conditions = nil
 	
 	// Add parameters to variables
 	for i, param := range fnDecl.Parameters {
@@ -265,12 +484,17 @@ func (g *CodeGenerator) generateFunction(fnDecl *FunctionDecl) error {
 		// Store the parameter value
 		g.currentBlk.NewStore(fn.Params[i], paramAlloca)
 		
+		// Reserve the parameter's own name so shadowing locals don't reuse it
+		g.localNames[param.Name]++
+		
 		// Associate the variable name with the allocated memory
-		g.variables[param.Name] = paramAlloca
+		if err := g.declareVariable(param.Token, param.Name, paramAlloca); err != nil {
+			return err
+		}
 	}
 	
 	// Generate code for the body
-	if err := g.generateBlock(fnDecl.Body); err != nil {
+	if err := g.generateStatements(fnDecl.Body.Statements); err != nil {
 		return err
 	}
 	
@@ -302,12 +526,26 @@ func (g *CodeGenerator) generateFunction(fnDecl *FunctionDecl) error {
 		}
 	}
 	
-	return nil
+	g.removeDeadBlocks(fn)
+	return g.verifyFunction(fn, fnDecl)
+}
+
+// generateBlock generates code for a block of statements in a new scope
+func (g *CodeGenerator) generateBlock(block *BlockStatement) error {
+	g.variables = compiler.NewScope(g.variables)
+	defer func() { g.variables = g.variables.Parent() }()
+	
+	return g.generateStatements(block.Statements)
 }
 
-// generateBlock generates code for a block of statements
-func (g *CodeGenerator) generateBlock(block *Block) error {
-	for _, stmt := range block.Statements {
+// generateStatements generates code for a list of statements in the current
+// scope. Statements after a return, break or continue are unreachable and
+// aren't generated.
+func (g *CodeGenerator) generateStatements(stmts []Statement) error {
+	for _, stmt := range stmts {
+		if g.currentBlk.Term != nil {
+			break
+		}
 		if err := g.generateStatement(stmt); err != nil {
 			return err
 		}
@@ -315,10 +553,51 @@ func (g *CodeGenerator) generateBlock(block *Block) error {
 	return nil
 }
 
+// newTemp allocates unnamed stack space in the entry block, so temporaries
+// created inside loops don't grow the stack
+func (g *CodeGenerator) newTemp(typ types.Type) *ir.InstAlloca {
+	alloca := ir.NewAlloca(typ)
+	entry := g.currentFn.Blocks[0]
+	entry.Insts = append([]ir.Instruction{alloca}, entry.Insts...)
+	return alloca
+}
+
+// newLocal allocates stack space for a local variable. Shadowed names get a
+// numeric suffix since LLVM requires local names to be unique per function.
+func (g *CodeGenerator) newLocal(name string, typ types.Type) *ir.InstAlloca {
+	alloca := g.currentBlk.NewAlloca(typ)
+	alloca.SetName(g.localName(name))
+	return alloca
+}
+
+// localName makes a name for a local variable or block that is unique in
+// the current function
+func (g *CodeGenerator) localName(name string) string {
+	n := g.localNames[name]
+	g.localNames[name]++
+	if n > 0 {
+		return fmt.Sprintf("%s.%d", name, n)
+	}
+	return name
+}
+
+// declareVariable binds a name to its storage in the current scope
+func (g *CodeGenerator) declareVariable(tok Token, name string, storage value.Value) error {
+	if !g.variables.Declare(name, storage) {
+		return g.errorf(TokenSpan(tok), "%s redeclared in this scope", name)
+	}
+	return nil
+}
+
 // generateStatement generates code for a statement
 func (g *CodeGenerator) generateStatement(stmt Statement) error {
+	g.enterSource(stmt, stmtSpan(stmt))
+	defer g.leaveSource()
+	
 	switch stmt := stmt.(type) {
-	case *VarDecl:
+	case *BlockStatement:
+		return g.generateBlock(stmt)
+	case *VariableDeclarationStatement:
 		return g.generateVarDecl(stmt)
 	case *ReturnStatement:
 		return g.generateReturn(stmt)
@@ -326,39 +605,44 @@ func (g *CodeGenerator) generateStatement(stmt Statement) error {
 		return g.generateIf(stmt)
 	case *WhileStatement:
 		return g.generateWhile(stmt)
-	case *AssignStatement:
-		return g.generateAssign(stmt)
-	case *ExpressionStatement:
-		// Generate the expression but ignore its value
-		expr := stmt.Expression
-		
-		// Special case for function calls that return void
-		if callExpr, ok := expr.(*CallExpression); ok {
-			// Check if it's a function call
-			if fn, exists := g.functions[callExpr.Function]; exists && fn.Sig.RetType == types.Void {
-				// Generate the call but don't try to use its void result
-				_, err := g.generateCall(callExpr)
-				return err
-			}
+	case *ForStatement:
+		return g.generateFor(stmt)
+	case *SwitchStatement:
+		return g.generateSwitch(stmt)
+	case *UnsafeStatement:
+		return g.generateBlock(stmt.Body)
+	case *BreakStatement:
+		if len(g.loops) == 0 {
+			return g.errorf(TokenSpan(stmt.Token), "break is not in a loop or switch")
 		}
-		
-		// For other expressions, just generate and ignore the result
-		_, err := g.generateExpression(expr)
+		g.br(g.loops[len(g.loops)-1].breakBlock)
+		return nil
+	case *ContinueStatement:
+		if len(g.loops) == 0 || g.loops[len(g.loops)-1].continueBlock == nil {
+			return g.errorf(TokenSpan(stmt.Token), "continue is not in a loop")
+		}
+		g.br(g.loops[len(g.loops)-1].continueBlock)
+		return nil
+	case *ExpressionStatement:
+		// Generate the expression but ignore its value. Calls to void
+		// functions yield a placeholder that is never used.
+		_, err := g.generateExpression(stmt.Expression)
 		return err
 	default:
-		return fmt.Errorf("unsupported statement type: %T", stmt)
+		return g.errorf(compiler.Span{}, "unsupported statement type: %T", stmt)
 	}
 }
 
 // generateVarDecl generates code for a variable declaration
-func (g *CodeGenerator) generateVarDecl(varDecl *VarDecl) error {
+func (g *CodeGenerator) generateVarDecl(varDecl *VariableDeclarationStatement) error {
 	// Allocate space for the variable on the stack
 	var varType types.Type
+	var alloca *ir.InstAlloca
 	
-	if varDecl.IsTypeInferred {
+	if varDecl.Type.IsEmpty() {
 		// Type inference from the value
 		if varDecl.Value == nil {
-			return fmt.Errorf("cannot infer type for variable %s without initialization", varDecl.Name)
+			return g.errorf(TokenSpan(varDecl.Token), "cannot infer type for variable %s without initialization", varDecl.Name)
 		}
 		
 		// Generate code for the value and use its type
@@ -370,38 +654,33 @@ func (g *CodeGenerator) generateVarDecl(varDecl *VarDecl) error {
 		varType = value.Type()
 		
 		// Allocate memory for the variable
-		alloca := g.currentBlk.NewAlloca(varType)
-		alloca.SetName(varDecl.Name)
-		g.variables[varDecl.Name] = alloca
+		alloca = g.newLocal(varDecl.Name, varType)
 		
 		// Store the initial value
 		g.currentBlk.NewStore(value, alloca)
 	} else {
 		// Explicitly typed variable
-		varType = g.llvmType(varDecl.VarType)
+		varType = g.llvmType(varDecl.Type)
 		
 		// Allocate memory for the variable
-		alloca := g.currentBlk.NewAlloca(varType)
-		alloca.SetName(varDecl.Name)
-		g.variables[varDecl.Name] = alloca
+		alloca = g.newLocal(varDecl.Name, varType)
 		
-		// Store initial value if provided
+		// Store initial value if provided. The initializer is generated before
+		// the name comes into scope so it can refer to a shadowed outer variable.
 		if varDecl.Value != nil {
-			value, err := g.generateExpression(varDecl.Value)
+			value, err := g.generateExpressionAs(varDecl.Value, varType)
 			if err != nil {
 				return err
 			}
 			
-			// Check if we need type conversion
-			if !types.Equal(value.Type(), varType) {
-				value = g.convertValue(value, varType)
-			}
-			
 			g.currentBlk.NewStore(value, alloca)
+		} else {
+			// Variables without an initializer start out zeroed
+			g.currentBlk.NewStore(constant.NewZeroInitializer(varType), alloca)
 		}
 	}
 	
-	return nil
+	return g.declareVariable(varDecl.Token, varDecl.Name, alloca)
 }
 
 // generateReturn generates code for a return statement
@@ -413,7 +692,7 @@ func (g *CodeGenerator) generateReturn(ret *ReturnStatement) error {
 	}
 	
 	// Generate the return value
-	if ret.Value == nil {
+	if ret.ReturnValue == nil {
 		// Default return values for non-void functions
 		if g.currentFn.Sig.RetType == types.I32 {
 			g.currentBlk.NewRet(constant.NewInt(types.I32, 0))
@@ -432,16 +711,11 @@ func (g *CodeGenerator) generateReturn(ret *ReturnStatement) error {
 		return nil
 	}
 	
-	value, err := g.generateExpression(ret.Value)
+	value, err := g.generateExpressionAs(ret.ReturnValue, g.currentFn.Sig.RetType)
 	if err != nil {
 		return err
 	}
 	
-	// Convert return value if needed
-	if !types.Equal(value.Type(), g.currentFn.Sig.RetType) {
-		value = g.convertValue(value, g.currentFn.Sig.RetType)
-	}
-	
 	g.currentBlk.NewRet(value)
 	return nil
 }
@@ -449,227 +723,1306 @@ func (g *CodeGenerator) generateReturn(ret *ReturnStatement) error {
 // generateIf generates code for an if statement
 func (g *CodeGenerator) generateIf(ifStmt *IfStatement) error {
 	// Generate condition
-	condValue, err := g.generateExpression(ifStmt.Condition)
+	condValue, err := g.generateCondition(ifStmt.Condition)
 	if err != nil {
 		return err
 	}
 	
-	// Convert condition to boolean if needed
-	if !types.Equal(condValue.Type(), types.I1) {
-		condValue = g.convertToBool(condValue)
-	}
-	
-	// Create blocks
-	thenBlock := g.currentFn.NewBlock("")
+	// Create blocks. Each is added to the function once the code before it
+	// has been generated.
+	thenBlock := ir.NewBlock("")
 	var elseBlock *ir.Block
-	if ifStmt.ElseBlock != nil {
-		elseBlock = g.currentFn.NewBlock("")
+	if ifStmt.Alternative != nil {
+		elseBlock = ir.NewBlock("")
 	}
-	mergeBlock := g.currentFn.NewBlock("")
+	mergeBlock := ir.NewBlock("")
 	
 	// Branch based on condition
 	if elseBlock != nil {
-		g.currentBlk.NewCondBr(condValue, thenBlock, elseBlock)
+		g.condBr(condValue, thenBlock, elseBlock)
 	} else {
-		g.currentBlk.NewCondBr(condValue, thenBlock, mergeBlock)
+		g.condBr(condValue, thenBlock, mergeBlock)
 	}
 	
 	// Generate code for then block
-	g.currentBlk = thenBlock
-	if err := g.generateBlock(ifStmt.ThenBlock); err != nil {
+	g.appendBlock(thenBlock)
+	if err := g.generateBlock(ifStmt.Consequence); err != nil {
 		return err
 	}
 	if g.currentBlk.Term == nil {
-		g.currentBlk.NewBr(mergeBlock)
+		g.br(mergeBlock)
 	}
 	
 	// Generate code for else block if it exists
 	if elseBlock != nil {
-		g.currentBlk = elseBlock
-		if err := g.generateBlock(ifStmt.ElseBlock); err != nil {
+		g.appendBlock(elseBlock)
+		if err := g.generateBlock(ifStmt.Alternative); err != nil {
 			return err
 		}
 		if g.currentBlk.Term == nil {
-			g.currentBlk.NewBr(mergeBlock)
+			g.br(mergeBlock)
 		}
 	}
 	
 	// Continue at merge point
-	g.currentBlk = mergeBlock
+	g.continueAt(mergeBlock)
 	return nil
 }
 
-// generateWhile generates code for a while statement
+// generateWhile generates code for a while loop. The condition is checked
+// before every iteration, so the body may not run at all.
 func (g *CodeGenerator) generateWhile(whileStmt *WhileStatement) error {
-    // Create blocks
-    condBlock := g.currentFn.NewBlock("while.cond")
-    bodyBlock := g.currentFn.NewBlock("while.body")
-    afterBlock := g.currentFn.NewBlock("while.end")
-    
-    // Branch to condition block
-    g.currentBlk.NewBr(condBlock)
-    
-    // Generate condition code
-    g.currentBlk = condBlock
-    condValue, err := g.generateExpression(whileStmt.Condition)
-    if err != nil {
-        return err
-    }
-    
-    // Convert condition to boolean if needed
-    if !types.Equal(condValue.Type(), types.I1) {
-        condValue = g.convertToBool(condValue)
-    }
-    
-    // Branch based on condition (condition is true -> execute body, false -> exit loop)
-    g.currentBlk.NewBr(afterBlock)
-    
-    // Generate loop body
-    g.currentBlk = bodyBlock
-    if err := g.generateBlock(whileStmt.Body); err != nil {
-        return err
-    }
-    
-    // Jump back to condition
-    if g.currentBlk.Term == nil {
-        g.currentBlk.NewBr(condBlock)
-    }
-    
-    // Continue after loop
-    g.currentBlk = afterBlock
-    return nil
-}
-
-// generateAssign generates code for an assignment statement
-func (g *CodeGenerator) generateAssign(assign *AssignStatement) error {
-    // Check if variable exists
-    variable, ok := g.variables[assign.Name]
-    if !ok {
-        return fmt.Errorf("undefined variable: %s", assign.Name)
-    }
-    
-    // Generate value
-    value, err := g.generateExpression(assign.Value)
-    if err != nil {
-        return err
-    }
-    
-    // Check if variable is an alloca instruction
-    alloca, ok := variable.(*ir.InstAlloca)
-    if !ok {
-        return fmt.Errorf("cannot assign to %s: not a variable", assign.Name)
-    }
-    
-    // Check if we need to convert the value
-    ptrType, ok := alloca.Type().(*types.PointerType)
-    if !ok {
-        return fmt.Errorf("internal error: expected pointer type for variable %s", assign.Name)
-    }
-    
-    allocaType := ptrType.ElemType
-    if !types.Equal(value.Type(), allocaType) {
-        value = g.convertValue(value, allocaType)
-    }
-    
-    // Store the value
-    g.currentBlk.NewStore(value, alloca)
-    return nil
+	condBlock := g.currentFn.NewBlock(g.localName("while.cond"))
+	bodyBlock := ir.NewBlock(g.localName("while.body"))
+	exitBlock := ir.NewBlock(g.localName("while.end"))
+	g.br(condBlock)
+	
+	g.currentBlk = condBlock
+	condValue, err := g.generateCondition(whileStmt.Condition)
+	if err != nil {
+		return err
+	}
+	g.condBr(condValue, bodyBlock, exitBlock)
+	
+	// continue checks the condition again
+	g.appendBlock(bodyBlock)
+	if err := g.generateLoopBody(whileStmt.Body, exitBlock, condBlock); err != nil {
+		return err
+	}
+	if g.currentBlk.Term == nil {
+		g.br(condBlock)
+	}
+	
+	g.continueAt(exitBlock)
+	return nil
 }
 
-// generateExpression generates code for an expression
-func (g *CodeGenerator) generateExpression(expr Expression) (value.Value, error) {
-	switch expr := expr.(type) {
-	case *BinaryOp:
-		return g.generateBinaryOp(expr)
-	case *CallExpression:
-		return g.generateCall(expr)
-	case *Identifier:
-		return g.generateIdentifier(expr)
-	case *NumberLiteral:
-		return constant.NewInt(types.I32, expr.Value), nil
-	case *FloatLiteral:
-		return constant.NewFloat(types.Float, expr.Value), nil
-	case *StringLiteral:
-		return g.getStringLiteral(expr.Value), nil
-	case *BoolLiteral:
-		if expr.Value {
-			return constant.True, nil
+// generateFor generates code for a for loop. The condition, body, update and
+// exit blocks are laid out in that order, each added once the code before it
+// has been generated.
+func (g *CodeGenerator) generateFor(forStmt *ForStatement) error {
+	// Variables declared by the init clause are scoped to the loop
+	g.variables = compiler.NewScope(g.variables)
+	defer func() { g.variables = g.variables.Parent() }()
+	
+	if forStmt.Init != nil {
+		if err := g.generateStatement(forStmt.Init); err != nil {
+			return err
 		}
-		return constant.False, nil
-	default:
-		return nil, fmt.Errorf("unsupported expression type: %T", expr)
 	}
+	
+	condBlock := g.currentFn.NewBlock("")
+	bodyBlock := ir.NewBlock("")
+	updateBlock := ir.NewBlock("")
+	exitBlock := ir.NewBlock("")
+	g.br(condBlock)
+	
+	// A missing condition loops until break
+	g.currentBlk = condBlock
+	if forStmt.Condition != nil {
+		condValue, err := g.generateCondition(forStmt.Condition)
+		if err != nil {
+			return err
+		}
+		g.condBr(condValue, bodyBlock, exitBlock)
+	} else {
+		g.br(bodyBlock)
+	}
+	
+	g.appendBlock(bodyBlock)
+	if err := g.generateLoopBody(forStmt.Body, exitBlock, updateBlock); err != nil {
+		return err
+	}
+	if g.currentBlk.Term == nil {
+		g.br(updateBlock)
+	}
+	
+	// The update is skipped if the body never finishes an iteration
+	g.continueAt(updateBlock)
+	if g.currentBlk.Term == nil {
+		if forStmt.Update != nil {
+			if _, err := g.generateExpression(forStmt.Update); err != nil {
+				return err
+			}
+		}
+		g.br(condBlock)
+	}
+	
+	g.continueAt(exitBlock)
+	return nil
 }
 
-// generateBinaryOp generates code for a binary operation
-func (g *CodeGenerator) generateBinaryOp(binOp *BinaryOp) (value.Value, error) {
-	// Generate code for operands
-	left, err := g.generateExpression(binOp.Left)
+// generateCondition generates the condition of a branch as an i1. It's
+// recorded so the verifier can check that a branch uses it.
+func (g *CodeGenerator) generateCondition(expr Expression) (value.Value, error) {
+	val, err := g.generateExpression(expr)
 	if err != nil {
 		return nil, err
 	}
+	cond := g.convertToBool(val)
+	g.conditions = append(g.conditions, condition{value: cond, span: exprSpan(expr)})
+	return cond, nil
+}
+
+// generateLoopBody generates the body of a loop, with break and continue
+// jumping to the given blocks
+func (g *CodeGenerator) generateLoopBody(body *BlockStatement, breakBlock, continueBlock *ir.Block) error {
+	g.loops = append(g.loops, loopTargets{breakBlock: breakBlock, continueBlock: continueBlock})
+	defer func() { g.loops = g.loops[:len(g.loops)-1] }()
 	
-	right, err := g.generateExpression(binOp.Right)
+	return g.generateBlock(body)
+}
+
+// generateSwitch generates code for a switch statement. Integer and enum
+// switches become an LLVM switch, which LLVM turns into a jump table when the
+// cases are dense; string switches compare the value against each case in
+// turn. Cases don't fall through, and break leaves the switch early.
+func (g *CodeGenerator) generateSwitch(stmt *SwitchStatement) error {
+	val, err := g.generateExpression(stmt.Value)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	
-	// Make sure types are compatible
-	leftType := left.Type()
-	rightType := right.Type()
-	
-	// Convert types if needed
-	if types.Equal(leftType, types.I32) && types.Equal(rightType, types.Float) {
-		left = g.currentBlk.NewSIToFP(left, types.Float)
-		leftType = types.Float
-	} else if types.Equal(leftType, types.Float) && types.Equal(rightType, types.I32) {
-		right = g.currentBlk.NewSIToFP(right, types.Float)
-		rightType = types.Float
+	caseBlocks := make([]*ir.Block, len(stmt.Cases))
+	for i := range stmt.Cases {
+		caseBlocks[i] = ir.NewBlock("")
+	}
+	endBlock := ir.NewBlock("")
+	defaultBlock := endBlock
+	if stmt.Default != nil {
+		defaultBlock = ir.NewBlock("")
 	}
 	
-	// Handle operations based on operator
-	switch binOp.Operator {
-	case "+":
-		if types.Equal(leftType, types.Float) {
-			return g.currentBlk.NewFAdd(left, right), nil
+	if g.isStringType(val.Type()) {
+		if err := g.generateStringSwitch(stmt, val, caseBlocks, defaultBlock); err != nil {
+			return err
 		}
-		return g.currentBlk.NewAdd(left, right), nil
-		
-	case "-":
-		if types.Equal(leftType, types.Float) {
-			return g.currentBlk.NewFSub(left, right), nil
+	} else {
+		cases := make([]*ir.Case, 0, len(stmt.Cases))
+		for i, cs := range stmt.Cases {
+			caseVal, err := g.generateExpressionAs(cs.Value, val.Type())
+			if err != nil {
+				return err
+			}
+			c, ok := caseVal.(*constant.Int)
+			if !ok {
+				return g.errorf(exprSpan(cs.Value), "case %s is not a constant", cs.Value)
+			}
+			cases = append(cases, ir.NewCase(c, caseBlocks[i]))
 		}
-		return g.currentBlk.NewSub(left, right), nil
-		
-	case "*":
-		if types.Equal(leftType, types.Float) {
-			return g.currentBlk.NewFMul(left, right), nil
+		g.switchTo(val, defaultBlock, cases...)
+	}
+	
+	for i, cs := range stmt.Cases {
+		g.appendBlock(caseBlocks[i])
+		if err := g.generateCaseBody(cs.Block, endBlock); err != nil {
+			return err
 		}
-		return g.currentBlk.NewMul(left, right), nil
-		
-	case "/":
-		if types.Equal(leftType, types.Float) {
-			return g.currentBlk.NewFDiv(left, right), nil
+	}
+	if stmt.Default != nil {
+		g.appendBlock(defaultBlock)
+		if err := g.generateCaseBody(stmt.Default, endBlock); err != nil {
+			return err
 		}
-		return g.currentBlk.NewSDiv(left, right), nil
-		
-	// Comparison operators
-	case "==", "!=", "<", "<=", ">", ">=":
-		return g.generateComparison(binOp.Operator, left, right)
-		
-	default:
-		return nil, fmt.Errorf("unsupported binary operator: %s", binOp.Operator)
 	}
+	
+	g.continueAt(endBlock)
+	return nil
 }
 
-// generateComparison generates code for comparison operations
-func (g *CodeGenerator) generateComparison(op string, left, right value.Value) (value.Value, error) {
-	// Use appropriate comparison based on type
-	if types.Equal(left.Type(), types.Float) {
-		// Float comparison
-		var pred enum.FPred
-		switch op {
-		case "==":
+// generateStringSwitch branches to the block of the first case equal to a
+// string, or to the default block if none is
+func (g *CodeGenerator) generateStringSwitch(stmt *SwitchStatement, val value.Value, caseBlocks []*ir.Block, defaultBlock *ir.Block) error {
+	for i, cs := range stmt.Cases {
+		caseVal, err := g.generateExpression(cs.Value)
+		if err != nil {
+			return err
+		}
+		cmp := g.currentBlk.NewCall(g.stringCompare(), val, caseVal)
+		equal := g.currentBlk.NewICmp(enum.IPredEQ, cmp, constant.NewInt(types.I32, 0))
+		
+		if i == len(stmt.Cases)-1 {
+			g.condBr(equal, caseBlocks[i], defaultBlock)
+			return nil
+		}
+		next := ir.NewBlock("")
+		g.condBr(equal, caseBlocks[i], next)
+		g.appendBlock(next)
+	}
+	
+	// A switch with no cases goes straight to the default
+	g.br(defaultBlock)
+	return nil
+}
+
+// generateCaseBody generates the body of a case, which continues after the
+// switch when it ends. break leaves the switch; continue still applies to the
+// enclosing loop.
+func (g *CodeGenerator) generateCaseBody(body *BlockStatement, endBlock *ir.Block) error {
+	targets := loopTargets{breakBlock: endBlock}
+	if len(g.loops) > 0 {
+		targets.continueBlock = g.loops[len(g.loops)-1].continueBlock
+	}
+	g.loops = append(g.loops, targets)
+	defer func() { g.loops = g.loops[:len(g.loops)-1] }()
+	
+	if err := g.generateBlock(body); err != nil {
+		return err
+	}
+	if g.currentBlk.Term == nil {
+		g.br(endBlock)
+	}
+	return nil
+}
+
+// appendBlock adds a block created with ir.NewBlock to the end of the
+// current function and continues generating code in it
+func (g *CodeGenerator) appendBlock(block *ir.Block) {
+	block.Parent = g.currentFn
+	g.currentFn.Blocks = append(g.currentFn.Blocks, block)
+	g.currentBlk = block
+	g.markSource()
+}
+
+// continueAt adds a block where control flow joins, like appendBlock. A
+// block no branch reaches, such as the end of an if whose branches both
+// return, is marked unreachable so the code after it isn't generated.
+func (g *CodeGenerator) continueAt(block *ir.Block) {
+	g.appendBlock(block)
+	if g.preds[block] == 0 {
+		block.NewUnreachable()
+	}
+}
+
+// br, condBr and switchTo end the current block with a branch. The branches
+// to each block are counted as they're emitted, so continueAt doesn't have
+// to scan the function at every join.
+func (g *CodeGenerator) br(target *ir.Block) {
+	g.countBranches(g.currentBlk.NewBr(target))
+}
+
+func (g *CodeGenerator) condBr(cond value.Value, targetTrue, targetFalse *ir.Block) {
+	g.countBranches(g.currentBlk.NewCondBr(cond, targetTrue, targetFalse))
+}
+
+func (g *CodeGenerator) switchTo(x value.Value, targetDefault *ir.Block, cases ...*ir.Case) {
+	g.countBranches(g.currentBlk.NewSwitch(x, targetDefault, cases...))
+}
+
+func (g *CodeGenerator) countBranches(term ir.Terminator) {
+	for _, succ := range term.Succs() {
+		g.preds[succ]++
+	}
+}
+
+// removeDeadBlocks removes the blocks continueAt marked unreachable, so the
+// verifier only sees blocks that should be reached
+func (g *CodeGenerator) removeDeadBlocks(fn *ir.Func) {
+	preds := predecessors(fn)
+	blocks := fn.Blocks[:1]
+	for _, block := range fn.Blocks[1:] {
+		_, marked := block.Term.(*ir.TermUnreachable)
+		if marked && len(block.Insts) == 0 && preds[block] == 0 {
+			continue
+		}
+		blocks = append(blocks, block)
+	}
+	fn.Blocks = blocks
+}
+
+// generateAssignment generates code for an assignment expression. The
+// assigned value is the result of the expression.
+func (g *CodeGenerator) generateAssignment(assign *AssignmentExpression) (value.Value, error) {
+    // Find where the value goes
+    ptr, err := g.generateAddress(assign.Left)
+    if err != nil {
+        return nil, err
+    }
+    
+    // Generate value
+    elemType := ptr.Type().(*types.PointerType).ElemType
+    value, err := g.generateExpressionAs(assign.Value, elemType)
+    if err != nil {
+        return nil, err
+    }
+    
+    // Store the value
+    g.currentBlk.NewStore(value, ptr)
+    return value, nil
+}
+
+// generateCompoundAssignment generates code for x op= v. The address of x is
+// computed once, so side effects in an index, or in a call x is reached
+// through, happen once.
+func (g *CodeGenerator) generateCompoundAssignment(assign *CompoundAssignmentExpression) (value.Value, error) {
+	ptr, err := g.generateAddress(assign.Left)
+	if err != nil {
+		return nil, err
+	}
+	elemType := ptr.Type().(*types.PointerType).ElemType
+	current := g.currentBlk.NewLoad(elemType, ptr)
+	
+	var result value.Value
+	if g.isStringType(elemType) {
+		right, err := g.generateExpression(assign.Value)
+		if err != nil {
+			return nil, err
+		}
+		result = g.currentBlk.NewCall(g.stringConcat(), current, right)
+	} else if isPointerType(g.typeOf(assign.Left)) {
+		right, err := g.generateExpression(assign.Value)
+		if err != nil {
+			return nil, err
+		}
+		result = g.offsetPointer(current, right, g.typeOf(assign.Value), assign.Operator == "-=")
+	} else {
+		// The checker only allows operators whose result has the type of x,
+		// so the value is converted to it. Shift counts are too.
+		right, err := g.generateExpressionAs(assign.Value, elemType)
+		if err != nil {
+			return nil, err
+		}
+		op := strings.TrimSuffix(assign.Operator, "=")
+		result, err = g.applyBinaryOp(op, current, right, g.typeOf(assign.Left), exprSpan(assign))
+		if err != nil {
+			return nil, err
+		}
+	}
+	
+	g.currentBlk.NewStore(result, ptr)
+	return result, nil
+}
+
+// generatePostfixExpression generates code for x++ or x--, which yield the
+// value x had before
+func (g *CodeGenerator) generatePostfixExpression(postfix *PostfixExpression) (value.Value, error) {
+	ptr, err := g.generateAddress(postfix.Left)
+	if err != nil {
+		return nil, err
+	}
+	elemType := ptr.Type().(*types.PointerType).ElemType
+	old := g.currentBlk.NewLoad(elemType, ptr)
+	
+	if isPointerType(g.typeOf(postfix.Left)) {
+		updated := g.offsetPointer(old, constant.NewInt(types.I64, 1), "int64", postfix.Operator == "--")
+		g.currentBlk.NewStore(updated, ptr)
+		return old, nil
+	}
+	
+	var one value.Value
+	switch t := elemType.(type) {
+	case *types.IntType:
+		one = constant.NewInt(t, 1)
+	case *types.FloatType:
+		one = constant.NewFloat(t, 1)
+	default:
+		return nil, g.errorf(exprSpan(postfix), "cannot apply %s to %s", postfix.Operator, postfix.Left)
+	}
+	
+	op := "+"
+	if postfix.Operator == "--" {
+		op = "-"
+	}
+	updated, err := g.applyBinaryOp(op, old, one, g.typeOf(postfix.Left), exprSpan(postfix))
+	if err != nil {
+		return nil, err
+	}
+	g.currentBlk.NewStore(updated, ptr)
+	return old, nil
+}
+
+// generateAddress generates a pointer to the storage an lvalue denotes
+func (g *CodeGenerator) generateAddress(expr Expression) (value.Value, error) {
+    switch expr := expr.(type) {
+    case *Identifier:
+        // Check if variable exists
+        variable, ok := g.variables.Lookup(expr.Value)
+        if !ok {
+            return nil, g.errorf(TokenSpan(expr.Token), "undefined variable: %s", expr.Value)
+        }
+        
+        // Check if variable is an alloca instruction
+        alloca, ok := variable.(*ir.InstAlloca)
+        if !ok {
+            return nil, g.errorf(TokenSpan(expr.Token), "cannot assign to %s: not a variable", expr.Value)
+        }
+        return alloca, nil
+    case *DotExpression:
+        return g.generateFieldAddress(expr)
+    case *IndexExpression:
+        return g.generateElementAddress(expr)
+    case *PrefixExpression:
+        // *p denotes the storage p points to
+        if expr.Operator == "*" {
+            return g.generateExpression(expr.Right)
+        }
+        return nil, g.errorf(exprSpan(expr), "cannot assign to %s", expr)
+    default:
+        return nil, g.errorf(exprSpan(expr), "cannot assign to %s", expr)
+    }
+}
+
+// isAddressable checks if an expression denotes storage in memory, as
+// opposed to a temporary value. Elements always have an address since
+// temporary arrays are spilled to the stack to be indexed, and so do fields
+// reached through a pointer, even one a call returns.
+func (g *CodeGenerator) isAddressable(expr Expression) bool {
+    switch expr := expr.(type) {
+    case *Identifier, *IndexExpression:
+        return true
+    case *PrefixExpression:
+        return expr.Operator == "*"
+    case *DotExpression:
+        return g.isAddressable(expr.Object) || isPointerType(g.typeOf(expr.Object))
+    default:
+        return false
+    }
+}
+
+// generateFieldAddress generates a pointer to a struct field. The object is
+// either addressable or a pointer to a struct, and is generated once.
+func (g *CodeGenerator) generateFieldAddress(dot *DotExpression) (value.Value, error) {
+    var base value.Value
+    var err error
+    
+    if g.isAddressable(dot.Object) {
+        base, err = g.generateAddress(dot.Object)
+        if err != nil {
+            return nil, err
+        }
+        
+        // A variable holding a pointer to a struct is dereferenced once
+        elemType := base.Type().(*types.PointerType).ElemType
+        if ptrType, ok := elemType.(*types.PointerType); ok {
+            base = g.currentBlk.NewLoad(ptrType, base)
+        }
+    } else {
+        base, err = g.generateExpression(dot.Object)
+        if err != nil {
+            return nil, err
+        }
+    }
+    return g.fieldPointer(dot, base)
+}
+
+// fieldPointer generates a pointer to the field dot names in the struct base
+// points to
+func (g *CodeGenerator) fieldPointer(dot *DotExpression, base value.Value) (value.Value, error) {
+    ptrType, ok := base.Type().(*types.PointerType)
+    if !ok {
+        return nil, g.errorf(exprSpan(dot), "cannot take the address of %s", dot)
+    }
+    structType, ok := ptrType.ElemType.(*types.StructType)
+    if !ok {
+        return nil, g.errorf(exprSpan(dot), "%s is not a struct", dot.Object)
+    }
+    if rec, ok := g.cRecordDefs[structType]; ok {
+        return g.cFieldPointer(dot, rec, structType, base)
+    }
+    
+    index, err := g.fieldIndex(structType, dot.Member)
+    if err != nil {
+        return nil, err
+    }
+    
+    zero := constant.NewInt(types.I32, 0)
+    return g.currentBlk.NewGetElementPtr(structType, base, zero, constant.NewInt(types.I32, int64(index))), nil
+}
+
+// fieldIndex finds the position of a field in a struct type
+func (g *CodeGenerator) fieldIndex(structType *types.StructType, member *Identifier) (int, error) {
+    info, ok := g.structs[structType.Name()]
+    if !ok {
+        return 0, g.errorf(TokenSpan(member.Token), "unknown struct type %s", structType)
+    }
+    
+    index, ok := info.fields[member.Value]
+    if !ok {
+        return 0, g.errorf(TokenSpan(member.Token), "%s has no field %s", structType.Name(), member.Value)
+    }
+    return index, nil
+}
+
+// generateDotExpression generates code for a field access
+func (g *CodeGenerator) generateDotExpression(dot *DotExpression) (value.Value, error) {
+    if index, ok := g.enumMember(dot); ok {
+        return constant.NewInt(types.I32, int64(index)), nil
+    }
+    
+    // Struct values that live only in registers, such as call results, are
+    // read with extractvalue. A pointer that isn't stored anywhere, such as
+    // one a call returns, is only generated once, and the field is loaded
+    // through it.
+    if !g.isAddressable(dot.Object) {
+        object, err := g.generateExpression(dot.Object)
+        if err != nil {
+            return nil, err
+        }
+        
+        // C records have no field indexes of their own, and union fields
+        // overlap, so their values are spilled and read through a pointer
+        if structType, ok := object.Type().(*types.StructType); ok && g.cRecordDefs[structType] != nil {
+            tmp := g.newTemp(structType)
+            g.currentBlk.NewStore(object, tmp)
+            object = tmp
+        } else if ok {
+            index, err := g.fieldIndex(structType, dot.Member)
+            if err != nil {
+                return nil, err
+            }
+            return g.currentBlk.NewExtractValue(object, uint64(index)), nil
+        }
+        
+        ptr, err := g.fieldPointer(dot, object)
+        if err != nil {
+            return nil, err
+        }
+        return g.currentBlk.NewLoad(ptr.Type().(*types.PointerType).ElemType, ptr), nil
+    }
+    
+    ptr, err := g.generateFieldAddress(dot)
+    if err != nil {
+        return nil, err
+    }
+    return g.currentBlk.NewLoad(ptr.Type().(*types.PointerType).ElemType, ptr), nil
+}
+
+// enumMember looks up Enum.Member, unless a variable hides the enum name
+func (g *CodeGenerator) enumMember(dot *DotExpression) (int, bool) {
+	ident, ok := dot.Object.(*Identifier)
+	if !ok {
+		return 0, false
+	}
+	if _, isVar := g.variables.Lookup(ident.Value); isVar {
+		return 0, false
+	}
+	index, ok := g.enums[ident.Value][dot.Member.Value]
+	return index, ok
+}
+
+// generateStructLiteral builds a struct value field by field, in source
+// order. Fields that are left out are zero.
+func (g *CodeGenerator) generateStructLiteral(lit *StructLiteralExpression) (value.Value, error) {
+    info, ok := g.structs[lit.TypeName]
+    if !ok {
+        return nil, g.errorf(TokenSpan(lit.Token), "unknown struct type %s", lit.TypeName)
+    }
+    
+    var result value.Value = constant.NewZeroInitializer(info.typ)
+    for _, name := range lit.Names {
+        index, ok := info.fields[name.Value]
+        if !ok {
+            return nil, g.errorf(TokenSpan(name.Token), "%s has no field %s", lit.TypeName, name.Value)
+        }
+        
+        fieldValue, err := g.generateExpressionAs(lit.Fields[name.Value], info.typ.Fields[index])
+        if err != nil {
+            return nil, err
+        }
+        
+        result = g.currentBlk.NewInsertValue(result, fieldValue, uint64(index))
+    }
+    
+    return result, nil
+}
+
+// generateArrayLiteral builds an array value element by element. The
+// expected type gives the element type; a slice literal stores its elements
+// in a stack array and refers to them.
+func (g *CodeGenerator) generateArrayLiteral(lit *ArrayLiteral, expected types.Type) (value.Value, error) {
+	var elemType types.Type
+	sliceElem := sliceElemType(expected)
+	switch {
+	case sliceElem != nil:
+		elemType = sliceElem
+	case expected != nil:
+		if arrayType, ok := expected.(*types.ArrayType); ok {
+			elemType = arrayType.ElemType
+		}
+	}
+
+	elements := make([]value.Value, 0, len(lit.Elements))
+	for _, el := range lit.Elements {
+		var val value.Value
+		var err error
+		if elemType != nil {
+			val, err = g.generateExpressionAs(el, elemType)
+		} else {
+			val, err = g.generateExpression(el)
+			elemType = val.Type()
+		}
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, val)
+	}
+	if elemType == nil {
+		return nil, g.errorf(TokenSpan(lit.Token), "cannot infer the type of empty array literal")
+	}
+
+	length := uint64(len(elements))
+	if arrayType, ok := expected.(*types.ArrayType); ok {
+		length = arrayType.Len
+	}
+
+	arrayType := types.NewArray(length, elemType)
+	var result value.Value = constant.NewZeroInitializer(arrayType)
+	for i, val := range elements {
+		result = g.currentBlk.NewInsertValue(result, val, uint64(i))
+	}
+
+	if sliceElem == nil {
+		return result, nil
+	}
+
+	typ := sliceType(elemType)
+	if length == 0 {
+		return constant.NewZeroInitializer(typ), nil
+	}
+
+	storage := g.newTemp(arrayType)
+	g.currentBlk.NewStore(result, storage)
+	zero := constant.NewInt(types.I64, 0)
+	ptr := g.currentBlk.NewGetElementPtr(arrayType, storage, zero, zero)
+	n := constant.NewInt(types.I64, int64(length))
+	return g.makeSlice(typ, ptr, n, n), nil
+}
+
+// makeSlice builds a slice value from its parts
+func (g *CodeGenerator) makeSlice(typ *types.StructType, ptr, length, capacity value.Value) value.Value {
+	var result value.Value = constant.NewZeroInitializer(typ)
+	result = g.currentBlk.NewInsertValue(result, ptr, 0)
+	result = g.currentBlk.NewInsertValue(result, length, 1)
+	return g.currentBlk.NewInsertValue(result, capacity, 2)
+}
+
+// generateIndexBase generates the operand of an index or slice expression.
+// Arrays are returned as a pointer to their storage, spilling temporary
+// arrays to the stack; slices are returned as values.
+func (g *CodeGenerator) generateIndexBase(expr Expression) (value.Value, error) {
+	if g.isAddressable(expr) {
+		ptr, err := g.generateAddress(expr)
+		if err != nil {
+			return nil, err
+		}
+
+		elemType := ptr.Type().(*types.PointerType).ElemType
+		if _, ok := elemType.(*types.ArrayType); ok {
+			return ptr, nil
+		}
+		return g.currentBlk.NewLoad(elemType, ptr), nil
+	}
+
+	val, err := g.generateExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := val.Type().(*types.ArrayType); ok {
+		storage := g.newTemp(val.Type())
+		g.currentBlk.NewStore(val, storage)
+		return storage, nil
+	}
+	return val, nil
+}
+
+// generateIndex generates an index or slice bound, widened to i64
+func (g *CodeGenerator) generateIndex(expr Expression) (value.Value, error) {
+	index, err := g.generateExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+	return g.convertNumber(index, types.I64, isUnsignedType(g.typeOf(expr)), false), nil
+}
+
+// generateElementAddress generates a pointer to an element of an array or
+// slice, checking the index against the length at run time. Indexing a
+// pointer isn't checked.
+func (g *CodeGenerator) generateElementAddress(idx *IndexExpression) (value.Value, error) {
+	base, err := g.generateIndexBase(idx.Left)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := g.generateIndex(idx.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	if isPointerType(g.typeOf(idx.Left)) {
+		return g.currentBlk.NewGetElementPtr(base.Type().(*types.PointerType).ElemType, base, index), nil
+	}
+
+	if ptrType, ok := base.Type().(*types.PointerType); ok {
+		if arrayType, ok := ptrType.ElemType.(*types.ArrayType); ok {
+			length := constant.NewInt(types.I64, int64(arrayType.Len))
+			g.checkIndex(idx.Token, index, length)
+			zero := constant.NewInt(types.I64, 0)
+			return g.currentBlk.NewGetElementPtr(arrayType, base, zero, index), nil
+		}
+	}
+
+	// Strings index their bytes, and slices their elements
+	elemType := sliceElemType(base.Type())
+	if g.isStringType(base.Type()) {
+		elemType = types.I8
+	}
+	if elemType == nil {
+		return nil, g.errorf(exprSpan(idx), "cannot index %s", idx.Left)
+	}
+
+	ptr := g.currentBlk.NewExtractValue(base, 0)
+	length := g.currentBlk.NewExtractValue(base, 1)
+	g.checkIndex(idx.Token, index, length)
+	return g.currentBlk.NewGetElementPtr(elemType, ptr, index), nil
+}
+
+// generateSliceExpression generates a slice of an array or slice. The new
+// slice shares the underlying storage.
+func (g *CodeGenerator) generateSliceExpression(slice *SliceExpression) (value.Value, error) {
+	base, err := g.generateIndexBase(slice.Left)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.isStringType(base.Type()) {
+		return g.generateSubstring(slice, base)
+	}
+	
+	var elemType types.Type
+	var ptr, length, capacity value.Value
+
+	if ptrType, ok := base.Type().(*types.PointerType); ok {
+		if arrayType, ok := ptrType.ElemType.(*types.ArrayType); ok {
+			zero := constant.NewInt(types.I64, 0)
+			elemType = arrayType.ElemType
+			ptr = g.currentBlk.NewGetElementPtr(arrayType, base, zero, zero)
+			length = constant.NewInt(types.I64, int64(arrayType.Len))
+			capacity = length
+		}
+	}
+	if elemType == nil {
+		elemType = sliceElemType(base.Type())
+		if elemType == nil {
+			return nil, g.errorf(exprSpan(slice), "cannot slice %s", slice.Left)
+		}
+		ptr = g.currentBlk.NewExtractValue(base, 0)
+		length = g.currentBlk.NewExtractValue(base, 1)
+		capacity = g.currentBlk.NewExtractValue(base, 2)
+	}
+
+	var low value.Value = constant.NewInt(types.I64, 0)
+	if slice.Low != nil {
+		if low, err = g.generateIndex(slice.Low); err != nil {
+			return nil, err
+		}
+	}
+	high := length
+	if slice.High != nil {
+		if high, err = g.generateIndex(slice.High); err != nil {
+			return nil, err
+		}
+	}
+
+	g.checkSliceBounds(slice.Token, low, high, capacity)
+
+	start := g.currentBlk.NewGetElementPtr(elemType, ptr, low)
+	newLength := g.currentBlk.NewSub(high, low)
+	newCapacity := g.currentBlk.NewSub(capacity, low)
+	return g.makeSlice(sliceType(elemType), start, newLength, newCapacity), nil
+}
+
+// generateSubstring generates a slice of a string, which copies the bytes
+// into a new string
+func (g *CodeGenerator) generateSubstring(slice *SliceExpression, str value.Value) (value.Value, error) {
+	length := g.currentBlk.NewExtractValue(str, 1)
+	
+	var low value.Value = constant.NewInt(types.I64, 0)
+	var err error
+	if slice.Low != nil {
+		if low, err = g.generateIndex(slice.Low); err != nil {
+			return nil, err
+		}
+	}
+	var high value.Value = length
+	if slice.High != nil {
+		if high, err = g.generateIndex(slice.High); err != nil {
+			return nil, err
+		}
+	}
+	
+	g.checkSliceBounds(slice.Token, low, high, length)
+	return g.currentBlk.NewCall(g.stringSubstring(), str, low, high), nil
+}
+
+// generateLenCall generates code for the len and cap built-ins. The length of
+// an array is a constant.
+func (g *CodeGenerator) generateLenCall(callExpr *CallExpression, name string) (value.Value, error) {
+	if len(callExpr.Arguments) != 1 {
+		return nil, g.errorf(exprSpan(callExpr), "%s requires exactly one argument", name)
+	}
+
+	arg, err := g.generateExpression(callExpr.Arguments[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if arrayType, ok := arg.Type().(*types.ArrayType); ok {
+		return constant.NewInt(types.I32, int64(arrayType.Len)), nil
+	}
+	if sliceElemType(arg.Type()) == nil && !g.isStringType(arg.Type()) {
+		return nil, g.errorf(exprSpan(callExpr.Arguments[0]), "invalid argument %s for %s", callExpr.Arguments[0], name)
+	}
+
+	field := uint64(1)
+	if name == "cap" {
+		field = 2
+	}
+	return g.currentBlk.NewTrunc(g.currentBlk.NewExtractValue(arg, field), types.I32), nil
+}
+
+// checkIndex branches to a runtime error unless 0 <= index < length. A
+// negative index wraps around to a large unsigned value, so one unsigned
+// comparison covers both bounds.
+func (g *CodeGenerator) checkIndex(tok Token, index, length value.Value) {
+	inRange := g.currentBlk.NewICmp(enum.IPredULT, index, length)
+	fail := g.runtimeError("nova_index_out_of_range", "index out of range [%lld] with length %lld", 2)
+	g.branchToRuntimeError(inRange, fail, tok, index, length)
+}
+
+// checkSliceBounds branches to a runtime error unless 0 <= low <= high <= capacity
+func (g *CodeGenerator) checkSliceBounds(tok Token, low, high, capacity value.Value) {
+	lowOk := g.currentBlk.NewICmp(enum.IPredULE, low, high)
+	highOk := g.currentBlk.NewICmp(enum.IPredULE, high, capacity)
+	inRange := g.currentBlk.NewAnd(lowOk, highOk)
+	fail := g.runtimeError("nova_slice_out_of_range", "slice bounds out of range [%lld:%lld] with capacity %lld", 3)
+	g.branchToRuntimeError(inRange, fail, tok, low, high, capacity)
+}
+
+// branchToRuntimeError continues in a new block if ok holds, and otherwise
+// calls the runtime error function with the source position of tok
+func (g *CodeGenerator) branchToRuntimeError(ok value.Value, fail *ir.Func, tok Token, args ...value.Value) {
+	failBlock := g.currentFn.NewBlock("")
+	okBlock := g.currentFn.NewBlock("")
+	g.condBr(ok, okBlock, failBlock)
+
+	file := tok.File
+	if file == "" {
+		file = g.sourceName
+	}
+	global := g.stringGlobal(file)
+	zero := constant.NewInt(types.I32, 0)
+	
+	callArgs := []value.Value{
+		constant.NewGetElementPtr(global.ContentType, global, zero, zero),
+		constant.NewInt(types.I32, int64(tok.Line)),
+		constant.NewInt(types.I32, int64(tok.Column)),
+	}
+	failBlock.NewCall(fail, append(callArgs, args...)...)
+	failBlock.NewUnreachable()
+
+	g.currentBlk = okBlock
+}
+
+// runtimeError returns a function that prints a runtime error with its source
+// position to stderr and aborts. It takes the file, line and column followed
+// by nargs i64 values for the format.
+func (g *CodeGenerator) runtimeError(name, format string, nargs int) *ir.Func {
+	if fn, ok := g.functions[name]; ok {
+		return fn
+	}
+
+	params := []*ir.Param{
+		ir.NewParam("file", types.NewPointer(types.I8)),
+		ir.NewParam("line", types.I32),
+		ir.NewParam("column", types.I32),
+	}
+	for i := 0; i < nargs; i++ {
+		params = append(params, ir.NewParam(fmt.Sprintf("arg%d", i), types.I64))
+	}
+	fn := g.module.NewFunc(name, types.Void, params...)
+	fn.FuncAttrs = append(fn.FuncAttrs, enum.FuncAttrNoReturn, enum.FuncAttrCold)
+	g.functions[name] = fn
+
+	block := fn.NewBlock("")
+
+	// Temporarily set currentBlk so getStringLiteral can use it
+	oldBlk := g.currentBlk
+	g.currentBlk = block
+	defer func() { g.currentBlk = oldBlk }()
+
+	// abort doesn't flush stdio, so output printed before the error is
+	// flushed first. It then comes before the error when both go to the
+	// same place, as dprintf writes straight to the file descriptor.
+	fflush := g.runtimeFunc("fflush", types.I32, false, types.NewPointer(types.I8))
+	block.NewCall(fflush, constant.NewNull(types.NewPointer(types.I8)))
+
+	args := []value.Value{
+		constant.NewInt(types.I32, 2), // stderr
+		g.getStringLiteral("%s:%d:%d: " + format + "\n"),
+	}
+	for _, param := range fn.Params {
+		args = append(args, param)
+	}
+	block.NewCall(g.runtimeFunc("dprintf", types.I32, true, types.I32, types.NewPointer(types.I8)), args...)
+	block.NewCall(g.runtimeFunc("abort", types.Void, false))
+	block.NewUnreachable()
+
+	return fn
+}
+
+// runtimeFunc declares a C library function used by generated code, once
+func (g *CodeGenerator) runtimeFunc(name string, retType types.Type, variadic bool, paramTypes ...types.Type) *ir.Func {
+	for _, fn := range g.module.Funcs {
+		if fn.Name() == name {
+			return fn
+		}
+	}
+
+	params := make([]*ir.Param, 0, len(paramTypes))
+	for _, typ := range paramTypes {
+		params = append(params, ir.NewParam("", typ))
+	}
+	fn := g.module.NewFunc(name, retType, params...)
+	fn.Sig.Variadic = variadic
+	return fn
+}
+
+// intConstant makes an integer constant of type t, which value must fit.
+// Values that only fit as unsigned, such as the largest uint64, are given
+// as the negative number with the same bits, as LLVM reads constants as
+// signed.
+func intConstant(t *types.IntType, value *big.Int) *constant.Int {
+	modulus := new(big.Int).Lsh(big.NewInt(1), uint(t.BitSize))
+	x := new(big.Int).Mod(value, modulus)
+	if x.Bit(int(t.BitSize)-1) == 1 {
+		x.Sub(x, modulus)
+	}
+	return &constant.Int{Typ: t, X: x}
+}
+
+// generateExpressionAs generates code for an expression whose value is stored
+// as typ, converting it if needed. Array literals take their type from typ.
+func (g *CodeGenerator) generateExpressionAs(expr Expression, typ types.Type) (value.Value, error) {
+	// Constants are built at the expected width directly
+	if value, ok := untypedConstant(expr); ok {
+		switch t := typ.(type) {
+		case *types.IntType:
+			return intConstant(t, value), nil
+		case *types.FloatType:
+			f, _ := new(big.Float).SetInt(value).Float64()
+			return constant.NewFloat(t, f), nil
+		}
+	}
+
+	switch expr := expr.(type) {
+	case *ArrayLiteral:
+		return g.generateArrayLiteral(expr, typ)
+	case *FloatLiteral:
+		if t, ok := typ.(*types.FloatType); ok {
+			return constant.NewFloat(t, expr.Value), nil
+		}
+	case *NullLiteral:
+		if t, ok := typ.(*types.PointerType); ok {
+			return constant.NewNull(t), nil
+		}
+	}
+	
+	val, err := g.generateExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+	
+	if !types.Equal(val.Type(), typ) {
+		val = g.convertNumber(val, typ, isUnsignedType(g.typeOf(expr)), false)
+	}
+	return val, nil
+}
+
+// generateExpression generates code for an expression
+func (g *CodeGenerator) generateExpression(expr Expression) (value.Value, error) {
+	g.enterSource(expr, exprSpan(expr))
+	defer g.leaveSource()
+	
+	// Untyped constants have the type their context gave them, int by
+	// default, and are worked out by the checker rather than at run time
+	if value, ok := untypedConstant(expr); ok {
+		if typ := g.typeOf(expr); isNumericType(typ) {
+			return g.generateExpressionAs(expr, g.llvmTypeOf(typ))
+		}
+		return intConstant(types.I32, value), nil
+	}
+	
+	switch expr := expr.(type) {
+	case *PrefixExpression:
+		return g.generatePrefixExpression(expr)
+	case *InfixExpression:
+		return g.generateBinaryOp(expr)
+	case *CallExpression:
+		return g.generateCall(expr)
+	case *AssignmentExpression:
+		return g.generateAssignment(expr)
+	case *CompoundAssignmentExpression:
+		return g.generateCompoundAssignment(expr)
+	case *PostfixExpression:
+		return g.generatePostfixExpression(expr)
+	case *DotExpression:
+		return g.generateDotExpression(expr)
+	case *StructLiteralExpression:
+		return g.generateStructLiteral(expr)
+	case *ArrayLiteral:
+		return g.generateArrayLiteral(expr, nil)
+	case *IndexExpression:
+		ptr, err := g.generateElementAddress(expr)
+		if err != nil {
+			return nil, err
+		}
+		return g.currentBlk.NewLoad(ptr.Type().(*types.PointerType).ElemType, ptr), nil
+	case *SliceExpression:
+		return g.generateSliceExpression(expr)
+	case *Identifier:
+		return g.generateIdentifier(expr)
+	case *ConversionExpression:
+		return g.generateConversion(expr)
+	case *FloatLiteral:
+		if typ := g.typeOf(expr); isFloatType(typ) {
+			return g.generateExpressionAs(expr, g.llvmTypeOf(typ))
+		}
+		return constant.NewFloat(types.Float, expr.Value), nil
+	case *StringLiteral:
+		return g.stringConstant(expr.Value), nil
+	case *InterpolatedString:
+		return g.generateInterpolatedString(expr)
+	case *BooleanLiteral:
+		if expr.Value {
+			return constant.True, nil
+		}
+		return constant.False, nil
+	case *NullLiteral:
+		return constant.NewNull(types.NewPointer(types.I8)), nil
+	default:
+		return nil, g.errorf(exprSpan(expr), "unsupported expression type: %T", expr)
+	}
+}
+
+// generateConversion generates code for a conversion between numeric types
+func (g *CodeGenerator) generateConversion(conv *ConversionExpression) (value.Value, error) {
+	target := g.llvmType(conv.Type)
+	
+	val, err := g.generateExpression(conv.Value)
+	if err != nil {
+		return nil, err
+	}
+	
+	targetName := conv.Type.TypeName
+	if alias, ok := typeAliases[targetName]; ok {
+		targetName = alias
+	}
+	return g.convertNumber(val, target, isUnsignedType(g.typeOf(conv.Value)), isUnsignedType(targetName)), nil
+}
+
+// generateBinaryOp generates code for a binary operation
+func (g *CodeGenerator) generateBinaryOp(binOp *InfixExpression) (value.Value, error) {
+	if g.typeOf(binOp.Left) == "string" && g.typeOf(binOp.Right) == "string" {
+		return g.generateStringOp(binOp)
+	}
+	if binOp.Operator == "&&" || binOp.Operator == "||" {
+		return g.generateLogicalOp(binOp)
+	}
+	if isPointerLike(g.typeOf(binOp.Left)) || isPointerLike(g.typeOf(binOp.Right)) {
+		return g.generatePointerOp(binOp)
+	}
+	
+	// Both operands are converted to a common type; an integer operand
+	// mixed with a float one is converted to the float type
+	leftType, rightType := g.typeOf(binOp.Left), g.typeOf(binOp.Right)
+	operandType := leftType
+	if isFloatType(rightType) && !isFloatType(leftType) {
+		operandType = rightType
+	}
+	
+	// Generate code for operands
+	var left, right value.Value
+	var err error
+	if isNumericType(operandType) {
+		typ := g.llvmTypeOf(operandType)
+		if left, err = g.generateExpressionAs(binOp.Left, typ); err != nil {
+			return nil, err
+		}
+		if right, err = g.generateExpressionAs(binOp.Right, typ); err != nil {
+			return nil, err
+		}
+	} else {
+		if left, err = g.generateExpression(binOp.Left); err != nil {
+			return nil, err
+		}
+		if right, err = g.generateExpression(binOp.Right); err != nil {
+			return nil, err
+		}
+	}
+	
+	return g.applyBinaryOp(binOp.Operator, left, right, operandType, exprSpan(binOp))
+}
+
+// applyBinaryOp generates the instruction for a binary operator applied to
+// two values of the given operand type
+func (g *CodeGenerator) applyBinaryOp(op string, left, right value.Value, operandType string, span compiler.Span) (value.Value, error) {
+	isFloat := isFloatType(operandType)
+	unsigned := isUnsignedType(operandType)
+	
+	// Handle operations based on operator
+	switch op {
+	case "+":
+		if isFloat {
+			return g.currentBlk.NewFAdd(left, right), nil
+		}
+		return g.currentBlk.NewAdd(left, right), nil
+		
+	case "-":
+		if isFloat {
+			return g.currentBlk.NewFSub(left, right), nil
+		}
+		return g.currentBlk.NewSub(left, right), nil
+		
+	case "*":
+		if isFloat {
+			return g.currentBlk.NewFMul(left, right), nil
+		}
+		return g.currentBlk.NewMul(left, right), nil
+		
+	case "/":
+		if isFloat {
+			return g.currentBlk.NewFDiv(left, right), nil
+		}
+		if unsigned {
+			return g.currentBlk.NewUDiv(left, right), nil
+		}
+		return g.currentBlk.NewSDiv(left, right), nil
+		
+	case "%":
+		if unsigned {
+			return g.currentBlk.NewURem(left, right), nil
+		}
+		return g.currentBlk.NewSRem(left, right), nil
+		
+	// Bitwise operators
+	case "&":
+		return g.currentBlk.NewAnd(left, right), nil
+	case "|":
+		return g.currentBlk.NewOr(left, right), nil
+	case "^":
+		return g.currentBlk.NewXor(left, right), nil
+	case "<<":
+		return g.currentBlk.NewShl(left, right), nil
+	case ">>":
+		// Signed values shift in copies of the sign bit
+		if unsigned {
+			return g.currentBlk.NewLShr(left, right), nil
+		}
+		return g.currentBlk.NewAShr(left, right), nil
+		
+	// Comparison operators
+	case "==", "!=", "<", "<=", ">", ">=":
+		return g.generateComparison(op, left, right, unsigned)
+		
+	default:
+		return nil, g.errorf(span, "unsupported binary operator: %s", op)
+	}
+}
+
+// generateLogicalOp generates code for && and ||. The right operand is only
+// evaluated when the left one doesn't decide the result, and a phi picks the
+// result from whichever block reached the end.
+func (g *CodeGenerator) generateLogicalOp(binOp *InfixExpression) (value.Value, error) {
+	left, err := g.generateExpression(binOp.Left)
+	if err != nil {
+		return nil, err
+	}
+	left = g.convertToBool(left)
+	leftBlock := g.currentBlk
+	
+	rightBlock := ir.NewBlock("")
+	endBlock := ir.NewBlock("")
+	
+	// && stops at false and || stops at true
+	shortCircuit := constant.False
+	if binOp.Operator == "&&" {
+		g.condBr(left, rightBlock, endBlock)
+	} else {
+		shortCircuit = constant.True
+		g.condBr(left, endBlock, rightBlock)
+	}
+	
+	g.appendBlock(rightBlock)
+	right, err := g.generateExpression(binOp.Right)
+	if err != nil {
+		return nil, err
+	}
+	right = g.convertToBool(right)
+	
+	// The right operand may have added blocks of its own
+	rightEnd := g.currentBlk
+	g.br(endBlock)
+	
+	g.appendBlock(endBlock)
+	return g.currentBlk.NewPhi(ir.NewIncoming(shortCircuit, leftBlock), ir.NewIncoming(right, rightEnd)), nil
+}
+
+// generatePrefixExpression generates code for a unary operation. Negated
+// constants stay constants so they can be used as switch cases.
+func (g *CodeGenerator) generatePrefixExpression(prefix *PrefixExpression) (value.Value, error) {
+	switch prefix.Operator {
+	case "&":
+		return g.generateAddress(prefix.Right)
+	case "*":
+		return g.generateDereference(prefix)
+	}
+	
+	var operand value.Value
+	var err error
+	if typ := g.typeOf(prefix); isNumericType(typ) {
+		operand, err = g.generateExpressionAs(prefix.Right, g.llvmTypeOf(typ))
+	} else {
+		operand, err = g.generateExpression(prefix.Right)
+	}
+	if err != nil {
+		return nil, err
+	}
+	
+	switch prefix.Operator {
+	case "-":
+		switch c := operand.(type) {
+		case *constant.Int:
+			return constant.NewInt(c.Typ, -c.X.Int64()), nil
+		case *constant.Float:
+			f, _ := c.X.Float64()
+			return constant.NewFloat(c.Typ, -f), nil
+		}
+		if _, isFloat := operand.Type().(*types.FloatType); isFloat {
+			return g.currentBlk.NewFNeg(operand), nil
+		}
+		return g.currentBlk.NewSub(constant.NewInt(operand.Type().(*types.IntType), 0), operand), nil
+		
+	case "!":
+		return g.currentBlk.NewXor(g.convertToBool(operand), constant.True), nil
+		
+	case "~":
+		return g.currentBlk.NewXor(operand, constant.NewInt(operand.Type().(*types.IntType), -1)), nil
+		
+	default:
+		return nil, g.errorf(exprSpan(prefix), "unsupported unary operator: %s", prefix.Operator)
+	}
+}
+
+// generateComparison generates code for comparison operations. Integers are
+// ordered as unsigned if unsigned is set.
+func (g *CodeGenerator) generateComparison(op string, left, right value.Value, unsigned bool) (value.Value, error) {
+	// Use appropriate comparison based on type
+	if _, ok := left.Type().(*types.FloatType); ok {
+		// Float comparison
+		var pred enum.FPred
+		switch op {
+		case "==":
 			pred = enum.FPredOEQ
 		case "!=":
 			pred = enum.FPredONE
@@ -700,42 +2053,77 @@ func (g *CodeGenerator) generateComparison(op string, left, right value.Value) (
 		case ">=":
 			pred = enum.IPredSGE
 		}
+		if unsigned {
+			pred = unsignedPred(pred)
+		}
 		return g.currentBlk.NewICmp(pred, left, right), nil
 	}
 }
 
+// unsignedPred returns the unsigned form of a signed integer predicate
+func unsignedPred(pred enum.IPred) enum.IPred {
+	switch pred {
+	case enum.IPredSLT:
+		return enum.IPredULT
+	case enum.IPredSLE:
+		return enum.IPredULE
+	case enum.IPredSGT:
+		return enum.IPredUGT
+	case enum.IPredSGE:
+		return enum.IPredUGE
+	default:
+		return pred
+	}
+}
+
 // generateCall generates code for a function call
 func (g *CodeGenerator) generateCall(callExpr *CallExpression) (value.Value, error) {
+    if isFuncType(g.typeOf(callExpr.Function)) {
+        return g.generateIndirectCall(callExpr)
+    }
+    
+    ident, ok := callExpr.Function.(*Identifier)
+    if !ok {
+        return nil, g.errorf(exprSpan(callExpr.Function), "cannot call %s", callExpr.Function)
+    }
+    
     // Check for special built-in functions
-    if callExpr.Function == "printf" {
+    if ident.Value == "printf" {
         return g.generatePrintfCall(callExpr)
     }
+    if ext, ok := g.externs[ident.Value]; ok {
+        return g.generateExternCall(callExpr, ext)
+    }
     
-    // Check if it's a built-in print function
-    if strings.HasPrefix(callExpr.Function, "print") && callExpr.Function != "printf" {
+    // Check if it's a built-in print, len or cap function. Declared
+    // functions take precedence.
+    fn, ok := g.functions[ident.Value]
+    if !ok && isPrintBuiltin(ident.Value) {
         return g.generatePrintCall(callExpr)
     }
+    if !ok && (ident.Value == "len" || ident.Value == "cap") {
+        return g.generateLenCall(callExpr, ident.Value)
+    }
     
     // Check if function exists
-    fn, ok := g.functions[callExpr.Function]
     if !ok {
-        return nil, fmt.Errorf("undefined function: %s", callExpr.Function)
+        return nil, g.errorf(TokenSpan(ident.Token), "undefined function: %s", ident.Value)
     }
     
     // Generate code for arguments
     args := make([]value.Value, 0, len(callExpr.Arguments))
     for i, arg := range callExpr.Arguments {
-        argValue, err := g.generateExpression(arg)
-        if err != nil {
-            return nil, err
-        }
+        var argValue value.Value
+        var err error
         
         // Convert type if needed
         if i < len(fn.Params) {
-            paramType := fn.Params[i].Type()
-            if !types.Equal(argValue.Type(), paramType) {
-                argValue = g.convertValue(argValue, paramType)
-            }
+            argValue, err = g.generateExpressionAs(arg, fn.Params[i].Type())
+        } else {
+            argValue, err = g.generateExpression(arg)
+        }
+        if err != nil {
+            return nil, err
         }
         
         args = append(args, argValue)
@@ -756,7 +2144,7 @@ func (g *CodeGenerator) generateCall(callExpr *CallExpression) (value.Value, err
 // generatePrintfCall generates code for printf calls
 func (g *CodeGenerator) generatePrintfCall(callExpr *CallExpression) (value.Value, error) {
     if len(callExpr.Arguments) < 1 {
-        return nil, fmt.Errorf("printf requires at least a format string")
+        return nil, g.errorf(exprSpan(callExpr), "printf requires at least a format string")
     }
     
     // Generate code for all arguments
@@ -770,22 +2158,13 @@ func (g *CodeGenerator) generatePrintfCall(callExpr *CallExpression) (value.Valu
     
     // Make sure the format is a pointer to i8
     if !g.isStringType(formatArg.Type()) {
-        return nil, fmt.Errorf("printf first argument must be a string")
+        return nil, g.errorf(exprSpan(callExpr.Arguments[0]), "printf first argument must be a string")
     }
     
-    args = append(args, formatArg)
+    args = append(args, g.stringData(formatArg))
     
     // Add the rest of the arguments
     for i := 1; i < len(callExpr.Arguments); i++ {
-        // Skip void function calls - they can't be used as arguments
-        if callExpr, ok := callExpr.Arguments[i].(*CallExpression); ok {
-            if fn, exists := g.functions[callExpr.Function]; exists && fn.Sig.RetType == types.Void {
-                // Generate the call for its side effects, but don't use the result
-                g.generateCall(callExpr)
-                continue
-            }
-        }
-        
         arg, err := g.generateExpression(callExpr.Arguments[i])
         if err != nil {
             return nil, err
@@ -796,23 +2175,43 @@ func (g *CodeGenerator) generatePrintfCall(callExpr *CallExpression) (value.Valu
             continue
         }
         
-        args = append(args, arg)
+        args = append(args, g.promoteVariadic(arg, g.typeOf(callExpr.Arguments[i])))
     }
     
     // Call printf
     return g.currentBlk.NewCall(g.functions["printf"], args...), nil
 }
 
-// isStringType checks if a type is a string (pointer to i8)
+// promoteVariadic applies C's default argument promotions to a value passed
+// through "...": floats become doubles and integers narrower than int are
+// widened to int. Strings are passed as C strings.
+func (g *CodeGenerator) promoteVariadic(val value.Value, typ string) value.Value {
+	if g.isStringType(val.Type()) {
+		return g.stringData(val)
+	}
+	
+	switch t := val.Type().(type) {
+	case *types.FloatType:
+		if t.Kind == types.FloatKindFloat {
+			return g.currentBlk.NewFPExt(val, types.Double)
+		}
+	case *types.IntType:
+		if t.BitSize < 32 {
+			return g.convertNumber(val, types.I32, isUnsignedType(typ), false)
+		}
+	}
+	return val
+}
+
+// isStringType checks if a type is a string
 func (g *CodeGenerator) isStringType(typ types.Type) bool {
-    ptrType, ok := typ.(*types.PointerType)
-    return ok && ptrType.ElemType == types.I8
+    return types.Equal(typ, g.stringType)
 }
 
 // generatePrintCall generates code for print function calls
 func (g *CodeGenerator) generatePrintCall(callExpr *CallExpression) (value.Value, error) {
 	if len(callExpr.Arguments) != 1 {
-		return nil, fmt.Errorf("print requires exactly one argument")
+		return nil, g.errorf(exprSpan(callExpr), "print requires exactly one argument")
 	}
 	
 	// Generate code for the argument
@@ -824,22 +2223,26 @@ func (g *CodeGenerator) generatePrintCall(callExpr *CallExpression) (value.Value
 	// Determine which print function to call based on type
 	var printFunc *ir.Func
 	
+	_, isInt := arg.Type().(*types.IntType)
+	_, isFloat := arg.Type().(*types.FloatType)
+	unsigned := isUnsignedType(g.typeOf(callExpr.Arguments[0]))
+	
 	switch {
-	case types.Equal(arg.Type(), types.I32):
-		printFunc = g.functions["print_int"]
-	case types.Equal(arg.Type(), types.Float):
-		printFunc = g.functions["print_float"]
 	case types.Equal(arg.Type(), types.I1):
-		printFunc = g.functions["print_bool"]
+		printFunc = g.functions["nova_print_bool"]
+	case isInt && unsigned:
+		printFunc = g.functions["nova_print_uint"]
+		arg = g.convertNumber(arg, types.I64, true, true)
+	case isInt:
+		printFunc = g.functions["nova_print_int"]
+		arg = g.convertNumber(arg, types.I64, false, false)
+	case isFloat:
+		printFunc = g.functions["nova_print_float"]
+		arg = g.convertNumber(arg, types.Double, false, false)
 	default:
-		// Check if it's a pointer to i8 (string)
-		if ptrType, ok := arg.Type().(*types.PointerType); ok && ptrType.ElemType == types.I8 {
-			printFunc = g.functions["print_string"]
-		} else {
-			// Default to printing as string
-			arg = g.convertToString(arg)
-			printFunc = g.functions["print_string"]
-		}
+		// Anything else is printed as a string
+		arg = g.toString(arg, g.typeOf(callExpr.Arguments[0]))
+		printFunc = g.functions["nova_print_string"]
 	}
 	
 	// Call the print function and return a dummy value for void functions
@@ -850,9 +2253,12 @@ func (g *CodeGenerator) generatePrintCall(callExpr *CallExpression) (value.Value
 // generateIdentifier generates code for a variable reference
 func (g *CodeGenerator) generateIdentifier(ident *Identifier) (value.Value, error) {
 	// Check if variable exists
-	variable, ok := g.variables[ident.Name]
+	variable, ok := g.variables.Lookup(ident.Value)
 	if !ok {
-		return nil, fmt.Errorf("undefined variable: %s", ident.Name)
+		if fn, isFunc := g.functions[ident.Value]; isFunc {
+			return g.functionValue(ident, fn), nil
+		}
+		return nil, g.errorf(TokenSpan(ident.Token), "undefined variable: %s", ident.Value)
 	}
 	
 	// For allocated variables, load the value
@@ -864,8 +2270,16 @@ func (g *CodeGenerator) generateIdentifier(ident *Identifier) (value.Value, erro
 	return variable, nil
 }
 
-// convertValue converts a value from one type to another
+// convertValue converts a value from one type to another, treating integers
+// as signed
 func (g *CodeGenerator) convertValue(val value.Value, targetType types.Type) value.Value {
+	return g.convertNumber(val, targetType, false, false)
+}
+
+// convertNumber converts a value between numeric types. fromUnsigned and
+// toUnsigned pick zero extension and unsigned conversions for unsigned
+// integers.
+func (g *CodeGenerator) convertNumber(val value.Value, targetType types.Type, fromUnsigned, toUnsigned bool) value.Value {
 	sourceType := val.Type()
 	
 	// If types are already the same, no conversion needed
@@ -873,30 +2287,66 @@ func (g *CodeGenerator) convertValue(val value.Value, targetType types.Type) val
 		return val
 	}
 	
-	// Integer to Float
-	if types.Equal(sourceType, types.I32) && types.Equal(targetType, types.Float) {
-		return g.currentBlk.NewSIToFP(val, types.Float)
+	// Integer to Bool
+	if types.Equal(targetType, types.I1) {
+		return g.convertToBool(val)
 	}
 	
-	// Float to Integer
-	if types.Equal(sourceType, types.Float) && types.Equal(targetType, types.I32) {
-		return g.currentBlk.NewFPToSI(val, types.I32)
+	// Pointers, such as *void ones, are reinterpreted
+	if types.IsPointer(sourceType) && types.IsPointer(targetType) {
+		return g.currentBlk.NewBitCast(val, targetType)
 	}
 	
-	// Integer to Bool
-	if types.Equal(sourceType, types.I32) && types.Equal(targetType, types.I1) {
-		return g.currentBlk.NewICmp(enum.IPredNE, val, constant.NewInt(types.I32, 0))
-	}
+	fromInt, fromIsInt := sourceType.(*types.IntType)
+	fromFloat, fromIsFloat := sourceType.(*types.FloatType)
 	
-	// Bool to Integer
-	if types.Equal(sourceType, types.I1) && types.Equal(targetType, types.I32) {
-		return g.currentBlk.NewZExt(val, types.I32)
+	switch to := targetType.(type) {
+	case *types.IntType:
+		switch {
+		case fromIsInt && fromInt.BitSize > to.BitSize:
+			return g.currentBlk.NewTrunc(val, to)
+		case fromIsInt && (fromUnsigned || fromInt.BitSize == 1):
+			// Bools extend to 0 or 1
+			return g.currentBlk.NewZExt(val, to)
+		case fromIsInt:
+			return g.currentBlk.NewSExt(val, to)
+		case fromIsFloat && toUnsigned:
+			return g.currentBlk.NewFPToUI(val, to)
+		case fromIsFloat:
+			return g.currentBlk.NewFPToSI(val, to)
+		}
+		
+	case *types.FloatType:
+		switch {
+		case fromIsInt && fromUnsigned:
+			return g.currentBlk.NewUIToFP(val, to)
+		case fromIsInt:
+			return g.currentBlk.NewSIToFP(val, to)
+		case fromIsFloat && floatBits(fromFloat) > floatBits(to):
+			return g.currentBlk.NewFPTrunc(val, to)
+		case fromIsFloat:
+			return g.currentBlk.NewFPExt(val, to)
+		}
 	}
 	
 	// String conversion would be more complex, we'll return as is for now
 	return val
 }
 
+// floatBits returns the width of a floating point type
+func floatBits(typ *types.FloatType) int {
+	switch typ.Kind {
+	case types.FloatKindHalf:
+		return 16
+	case types.FloatKindFloat:
+		return 32
+	case types.FloatKindDouble:
+		return 64
+	default:
+		return 128
+	}
+}
+
 // convertToBool converts a value to boolean
 func (g *CodeGenerator) convertToBool(val value.Value) value.Value {
     // If already boolean, return as is
@@ -905,13 +2355,13 @@ func (g *CodeGenerator) convertToBool(val value.Value) value.Value {
     }
     
     // Integer comparison with zero
-    if types.Equal(val.Type(), types.I32) {
-        return g.currentBlk.NewICmp(enum.IPredNE, val, constant.NewInt(types.I32, 0))
+    if intType, ok := val.Type().(*types.IntType); ok {
+        return g.currentBlk.NewICmp(enum.IPredNE, val, constant.NewInt(intType, 0))
     }
     
     // Float comparison with zero
-    if types.Equal(val.Type(), types.Float) {
-        return g.currentBlk.NewFCmp(enum.FPredONE, val, constant.NewFloat(types.Float, 0.0))
+    if floatType, ok := val.Type().(*types.FloatType); ok {
+        return g.currentBlk.NewFCmp(enum.FPredONE, val, constant.NewFloat(floatType, 0.0))
     }
     
     // Pointer comparison with null
@@ -923,17 +2373,3 @@ func (g *CodeGenerator) convertToBool(val value.Value) value.Value {
     // Default to true for unknown types
     return constant.True
 }
-
-// convertToString converts a value to string representation
-func (g *CodeGenerator) convertToString(val value.Value) value.Value {
-	// For booleans, use "true" or "false"
-	if types.Equal(val.Type(), types.I1) {
-		trueStr := g.getStringLiteral("true")
-		falseStr := g.getStringLiteral("false")
-		return g.currentBlk.NewSelect(val, trueStr, falseStr)
-	}
-	
-	// Default to empty string for other types
-	emptyStr := g.getStringLiteral("")
-	return emptyStr
-}
\ No newline at end of file
@@ -0,0 +1,142 @@
+package main // ir-pointers.go
+
+import (
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Pointers are lowered to LLVM pointers to their element type. *void is i8*,
+// like C's void *, and is bitcast to and from other pointer types. Pointer
+// arithmetic counts elements: p + n is a getelementptr, and p - q divides
+// the distance in bytes by the size of an element. None of it is checked.
+//
+// Function values are pointers to LLVM functions with the C calling
+// convention, so they can be handed to C as callbacks.
+
+// generateDereference generates *p, loading the value p points to
+func (g *CodeGenerator) generateDereference(prefix *PrefixExpression) (value.Value, error) {
+	ptr, err := g.generateExpression(prefix.Right)
+	if err != nil {
+		return nil, err
+	}
+	ptrType, ok := ptr.Type().(*types.PointerType)
+	if !ok {
+		return nil, g.errorf(exprSpan(prefix), "cannot dereference %s", prefix.Right)
+	}
+	return g.currentBlk.NewLoad(ptrType.ElemType, ptr), nil
+}
+
+// generatePointerOp generates a binary operation with a pointer operand:
+// a comparison, an offset, or the difference of two pointers
+func (g *CodeGenerator) generatePointerOp(binOp *InfixExpression) (value.Value, error) {
+	left, err := g.generateExpression(binOp.Left)
+	if err != nil {
+		return nil, err
+	}
+
+	leftType, rightType := g.typeOf(binOp.Left), g.typeOf(binOp.Right)
+	switch {
+	case isPointerLike(leftType) && isPointerLike(rightType):
+		// NULL and *void operands are converted to the other pointer type
+		right, err := g.generateExpressionAs(binOp.Right, left.Type())
+		if err != nil {
+			return nil, err
+		}
+		if binOp.Operator == "-" {
+			return g.pointerDifference(left, right), nil
+		}
+		return g.generateComparison(binOp.Operator, left, right, true)
+
+	case isPointerType(leftType):
+		right, err := g.generateExpression(binOp.Right)
+		if err != nil {
+			return nil, err
+		}
+		return g.offsetPointer(left, right, rightType, binOp.Operator == "-"), nil
+
+	default:
+		// n + p
+		right, err := g.generateExpression(binOp.Right)
+		if err != nil {
+			return nil, err
+		}
+		return g.offsetPointer(right, left, leftType, false), nil
+	}
+}
+
+// offsetPointer moves a pointer forward, or back if negate is set, by n
+// elements. n has the Nova type nType.
+func (g *CodeGenerator) offsetPointer(ptr, n value.Value, nType string, negate bool) value.Value {
+	offset := g.convertNumber(n, types.I64, isUnsignedType(nType), false)
+	if negate {
+		offset = g.currentBlk.NewSub(constant.NewInt(types.I64, 0), offset)
+	}
+	elemType := ptr.Type().(*types.PointerType).ElemType
+	return g.currentBlk.NewGetElementPtr(elemType, ptr, offset)
+}
+
+// pointerDifference generates p - q, the number of elements between two
+// pointers of the same type
+func (g *CodeGenerator) pointerDifference(left, right value.Value) value.Value {
+	ptrType := left.Type().(*types.PointerType)
+	distance := g.currentBlk.NewSub(
+		g.currentBlk.NewPtrToInt(left, types.I64),
+		g.currentBlk.NewPtrToInt(right, types.I64),
+	)
+
+	// The size of an element is the address of element 1 of a null array
+	one := constant.NewInt(types.I64, 1)
+	size := constant.NewPtrToInt(constant.NewGetElementPtr(ptrType.ElemType, constant.NewNull(ptrType), one), types.I64)
+
+	diff := g.currentBlk.NewSDiv(distance, size)
+	diff.Exact = true
+	return diff
+}
+
+// functionValue returns a pointer to a function used as a value. Externs
+// are declared with their C types, so they're cast to the Nova type.
+func (g *CodeGenerator) functionValue(ident *Identifier, fn *ir.Func) value.Value {
+	typ := g.typeOf(ident)
+	if !isFuncType(typ) {
+		return fn
+	}
+	ptrType := g.llvmTypeOf(typ)
+	if types.Equal(fn.Type(), ptrType) {
+		return fn
+	}
+	return constant.NewBitCast(fn, ptrType)
+}
+
+// generateIndirectCall generates a call through a function value
+func (g *CodeGenerator) generateIndirectCall(callExpr *CallExpression) (value.Value, error) {
+	callee, err := g.generateExpression(callExpr.Function)
+	if err != nil {
+		return nil, err
+	}
+	ptrType, ok := callee.Type().(*types.PointerType)
+	if !ok {
+		return nil, g.errorf(exprSpan(callExpr.Function), "cannot call %s", callExpr.Function)
+	}
+	sig, ok := ptrType.ElemType.(*types.FuncType)
+	if !ok {
+		return nil, g.errorf(exprSpan(callExpr.Function), "cannot call %s", callExpr.Function)
+	}
+
+	args := make([]value.Value, 0, len(callExpr.Arguments))
+	for i, arg := range callExpr.Arguments {
+		val, err := g.generateExpressionAs(arg, sig.Params[i])
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, val)
+	}
+
+	result := g.currentBlk.NewCall(callee, args...)
+	if types.Equal(sig.RetType, types.Void) {
+		// Return constant int as placeholder that will be ignored
+		return constant.NewInt(types.I32, 0), nil
+	}
+	return result, nil
+}
@@ -0,0 +1,241 @@
+package main // ir-strings.go
+
+import (
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Strings are lowered to { i8*, i64 }: a pointer to the bytes and the length.
+// The bytes are always followed by a NUL so the pointer can be handed to C.
+// Strings built at run time are allocated with malloc and never freed.
+
+// declareStringType declares the named LLVM type used for strings
+func (g *CodeGenerator) declareStringType() {
+	g.stringType = types.NewStruct(types.NewPointer(types.I8), types.I64)
+	g.module.NewTypeDef("string", g.stringType)
+}
+
+// stringConstant creates a string value for a literal
+func (g *CodeGenerator) stringConstant(str string) value.Value {
+	global := g.stringGlobal(str)
+	zero := constant.NewInt(types.I32, 0)
+	ptr := constant.NewGetElementPtr(global.ContentType, global, zero, zero)
+	return constant.NewStruct(g.stringType, ptr, constant.NewInt(types.I64, int64(len(str))))
+}
+
+// stringData returns the NUL-terminated bytes of a string as a C string
+func (g *CodeGenerator) stringData(str value.Value) value.Value {
+	return g.currentBlk.NewExtractValue(str, 0)
+}
+
+// generateStringOp generates code for a binary operation on two strings
+func (g *CodeGenerator) generateStringOp(binOp *InfixExpression) (value.Value, error) {
+	left, err := g.generateExpression(binOp.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := g.generateExpression(binOp.Right)
+	if err != nil {
+		return nil, err
+	}
+
+	if binOp.Operator == "+" {
+		return g.currentBlk.NewCall(g.stringConcat(), left, right), nil
+	}
+
+	// Comparisons compare the result of nova_string_compare with zero
+	cmp := g.currentBlk.NewCall(g.stringCompare(), left, right)
+	return g.generateComparison(binOp.Operator, cmp, constant.NewInt(types.I32, 0), false)
+}
+
+// generateInterpolatedString converts each part of an interpolated string to
+// a string and concatenates them
+func (g *CodeGenerator) generateInterpolatedString(str *InterpolatedString) (value.Value, error) {
+	var result value.Value
+	for _, part := range str.Parts {
+		val, err := g.generateExpression(part)
+		if err != nil {
+			return nil, err
+		}
+		val = g.toString(val, g.typeOf(part))
+
+		if result == nil {
+			result = val
+		} else {
+			result = g.currentBlk.NewCall(g.stringConcat(), result, val)
+		}
+	}
+
+	if result == nil {
+		return g.stringConstant(""), nil
+	}
+	return result, nil
+}
+
+// toString formats a value as a string. typ is the type the checker resolved
+// for it, which tells signed and unsigned integers apart.
+func (g *CodeGenerator) toString(val value.Value, typ string) value.Value {
+	switch t := val.Type().(type) {
+	case *types.IntType:
+		if t.BitSize == 1 {
+			return g.currentBlk.NewSelect(val, g.stringConstant("true"), g.stringConstant("false"))
+		}
+		if isUnsignedType(typ) {
+			arg := g.convertNumber(val, types.I64, true, true)
+			return g.currentBlk.NewCall(g.stringFormatter("nova_string_from_uint", "%llu", types.I64), arg)
+		}
+		arg := g.convertNumber(val, types.I64, false, false)
+		return g.currentBlk.NewCall(g.stringFormatter("nova_string_from_int", "%lld", types.I64), arg)
+
+	case *types.FloatType:
+		arg := g.convertNumber(val, types.Double, false, false)
+		return g.currentBlk.NewCall(g.stringFormatter("nova_string_from_float", "%g", types.Double), arg)
+	}
+
+	if g.isStringType(val.Type()) {
+		return val
+	}
+
+	// The checker only lets strings, numbers and bools through
+	return g.stringConstant("")
+}
+
+// stringRuntimeFunc returns the string helper with the given name, defining
+// it with build on first use
+func (g *CodeGenerator) stringRuntimeFunc(name string, retType types.Type, params []*ir.Param, build func(fn *ir.Func, block *ir.Block)) *ir.Func {
+	if fn, ok := g.functions[name]; ok {
+		return fn
+	}
+
+	fn := g.module.NewFunc(name, retType, params...)
+	fn.Linkage = enum.LinkageInternal
+	g.functions[name] = fn
+
+	// Helpers are built outside the function being generated
+	oldBlk := g.currentBlk
+	g.currentBlk = fn.NewBlock("")
+	defer func() { g.currentBlk = oldBlk }()
+
+	build(fn, g.currentBlk)
+	return fn
+}
+
+// newString allocates a string of length n, including room for the NUL, and
+// returns its buffer
+func (g *CodeGenerator) newString(block *ir.Block, n value.Value) value.Value {
+	malloc := g.runtimeFunc("malloc", types.NewPointer(types.I8), false, types.I64)
+	buf := block.NewCall(malloc, block.NewAdd(n, constant.NewInt(types.I64, 1)))
+	block.NewStore(constant.NewInt(types.I8, 0), block.NewGetElementPtr(types.I8, buf, n))
+	return buf
+}
+
+// makeString builds a string value from a buffer and a length
+func (g *CodeGenerator) makeString(block *ir.Block, buf, n value.Value) value.Value {
+	var result value.Value = constant.NewZeroInitializer(g.stringType)
+	result = block.NewInsertValue(result, buf, 0)
+	return block.NewInsertValue(result, n, 1)
+}
+
+// memcpy declares memcpy from the C library
+func (g *CodeGenerator) memcpy() *ir.Func {
+	i8ptr := types.NewPointer(types.I8)
+	return g.runtimeFunc("memcpy", i8ptr, false, i8ptr, i8ptr, types.I64)
+}
+
+// stringConcat returns nova_string_concat(a, b), which copies both strings
+// into a new one
+func (g *CodeGenerator) stringConcat() *ir.Func {
+	params := []*ir.Param{ir.NewParam("a", g.stringType), ir.NewParam("b", g.stringType)}
+	return g.stringRuntimeFunc("nova_string_concat", g.stringType, params, func(fn *ir.Func, block *ir.Block) {
+		a, b := fn.Params[0], fn.Params[1]
+		aLen := block.NewExtractValue(a, 1)
+		bLen := block.NewExtractValue(b, 1)
+		n := block.NewAdd(aLen, bLen)
+
+		buf := g.newString(block, n)
+		block.NewCall(g.memcpy(), buf, block.NewExtractValue(a, 0), aLen)
+		block.NewCall(g.memcpy(), block.NewGetElementPtr(types.I8, buf, aLen), block.NewExtractValue(b, 0), bLen)
+		block.NewRet(g.makeString(block, buf, n))
+	})
+}
+
+// stringCompare returns nova_string_compare(a, b), which orders strings by
+// their bytes like memcmp, with a prefix before the longer string. The result
+// is negative, zero or positive.
+func (g *CodeGenerator) stringCompare() *ir.Func {
+	params := []*ir.Param{ir.NewParam("a", g.stringType), ir.NewParam("b", g.stringType)}
+	return g.stringRuntimeFunc("nova_string_compare", types.I32, params, func(fn *ir.Func, block *ir.Block) {
+		a, b := fn.Params[0], fn.Params[1]
+		aLen := block.NewExtractValue(a, 1)
+		bLen := block.NewExtractValue(b, 1)
+
+		shorter := block.NewICmp(enum.IPredULT, aLen, bLen)
+		n := block.NewSelect(shorter, aLen, bLen)
+
+		i8ptr := types.NewPointer(types.I8)
+		memcmp := g.runtimeFunc("memcmp", types.I32, false, i8ptr, i8ptr, types.I64)
+		bytes := block.NewCall(memcmp, block.NewExtractValue(a, 0), block.NewExtractValue(b, 0), n)
+
+		// Equal prefixes are ordered by length
+		longer := block.NewICmp(enum.IPredUGT, aLen, bLen)
+		byLength := block.NewSelect(longer, constant.NewInt(types.I32, 1), constant.NewInt(types.I32, 0))
+		byLength2 := block.NewSelect(shorter, constant.NewInt(types.I32, -1), byLength)
+
+		differ := block.NewICmp(enum.IPredNE, bytes, constant.NewInt(types.I32, 0))
+		block.NewRet(block.NewSelect(differ, bytes, byLength2))
+	})
+}
+
+// stringSubstring returns nova_string_substring(s, low, high), which copies
+// the bytes from low up to high into a new string. The bounds are checked by
+// the caller.
+func (g *CodeGenerator) stringSubstring() *ir.Func {
+	params := []*ir.Param{ir.NewParam("s", g.stringType), ir.NewParam("low", types.I64), ir.NewParam("high", types.I64)}
+	return g.stringRuntimeFunc("nova_string_substring", g.stringType, params, func(fn *ir.Func, block *ir.Block) {
+		s, low, high := fn.Params[0], fn.Params[1], fn.Params[2]
+		n := block.NewSub(high, low)
+
+		buf := g.newString(block, n)
+		src := block.NewGetElementPtr(types.I8, block.NewExtractValue(s, 0), low)
+		block.NewCall(g.memcpy(), buf, src, n)
+		block.NewRet(g.makeString(block, buf, n))
+	})
+}
+
+// stringFromC returns nova_string_from_c(p), which makes a string of a C
+// string without copying it. A null pointer is the empty string.
+func (g *CodeGenerator) stringFromC() *ir.Func {
+	i8ptr := types.NewPointer(types.I8)
+	params := []*ir.Param{ir.NewParam("p", i8ptr)}
+	return g.stringRuntimeFunc("nova_string_from_c", g.stringType, params, func(fn *ir.Func, block *ir.Block) {
+		p := fn.Params[0]
+		null := fn.NewBlock("")
+		notNull := fn.NewBlock("")
+		block.NewCondBr(block.NewICmp(enum.IPredEQ, p, constant.NewNull(i8ptr)), null, notNull)
+		null.NewRet(g.stringConstant(""))
+
+		strlen := g.runtimeFunc("strlen", types.I64, false, i8ptr)
+		notNull.NewRet(g.makeString(notNull, p, notNull.NewCall(strlen, p)))
+	})
+}
+
+// stringFormatter returns a helper that formats one value of type typ with a
+// printf format, measuring the result with snprintf before allocating it
+func (g *CodeGenerator) stringFormatter(name, format string, typ types.Type) *ir.Func {
+	params := []*ir.Param{ir.NewParam("value", typ)}
+	return g.stringRuntimeFunc(name, g.stringType, params, func(fn *ir.Func, block *ir.Block) {
+		i8ptr := types.NewPointer(types.I8)
+		snprintf := g.runtimeFunc("snprintf", types.I32, true, i8ptr, types.I64, i8ptr)
+		formatStr := g.getStringLiteral(format)
+
+		size := block.NewCall(snprintf, constant.NewNull(i8ptr), constant.NewInt(types.I64, 0), formatStr, fn.Params[0])
+		n := block.NewSExt(size, types.I64)
+
+		buf := g.newString(block, n)
+		block.NewCall(snprintf, buf, block.NewAdd(n, constant.NewInt(types.I64, 1)), formatStr, fn.Params[0])
+		block.NewRet(g.makeString(block, buf, n))
+	})
+}
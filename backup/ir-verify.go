@@ -0,0 +1,247 @@
+package main // ir-verify.go
+
+import (
+	"fmt"
+
+	"github.com/deep-neural/nova-lang/compiler"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/value"
+)
+
+// The verifier checks the IR generated for each function for signs of bugs
+// in the code generator before it reaches LLVM. The checker has already
+// accepted the program, so anything the verifier finds is an internal
+// compiler error rather than a problem with the source.
+//
+// Once every function is generated, the whole module goes through the IR
+// checker. Each block keeps marks of the statements and expressions its
+// instructions were generated for, so a problem can be reported at the
+// source that caused it.
+
+// condition is a branch condition and the source it was generated from
+type condition struct {
+	value value.Value
+	span  compiler.Span
+}
+
+// verifyFunction checks that every block of a function can be reached and
+// that every condition generated for it is branched on
+func (g *CodeGenerator) verifyFunction(fn *ir.Func, decl *FunctionDefinition) error {
+	// Give unnamed blocks their numbers so they can be reported
+	if err := fn.AssignIDs(); err != nil {
+		return g.internalError(TokenSpan(decl.Token), "%s: %v", decl.Name, err)
+	}
+
+	preds := predecessors(fn)
+	for _, block := range fn.Blocks[1:] {
+		if preds[block] == 0 {
+			return g.internalError(TokenSpan(decl.Token), "block %s of %s is unreachable", block.Ident(), decl.Name)
+		}
+	}
+
+	used := usedValues(fn)
+	for _, cond := range g.conditions {
+		if !used[cond.value] {
+			return g.internalError(cond.span, "condition is computed but never branched on")
+		}
+	}
+	return nil
+}
+
+// sourceMark records that the instructions of a block from index on were
+// generated for a span of source. Code generated after a mark made in a
+// terminated block is after the terminator, where it can never run.
+type sourceMark struct {
+	index      int
+	span       compiler.Span
+	terminated bool
+}
+
+// enterSource notes that code is being generated for a node, which covers
+// a span of source
+func (g *CodeGenerator) enterSource(node Node, span compiler.Span) {
+	g.log.Trace("codegen", span.Position(), compiler.NodeKind(node), node.TokenLiteral())
+	g.generating = append(g.generating, span)
+	g.markSource()
+}
+
+// leaveSource goes back to generating code for the enclosing span. If
+// generating panics, the span is kept so Generate can report it.
+func (g *CodeGenerator) leaveSource() {
+	if r := recover(); r != nil {
+		panic(r)
+	}
+	g.generating = g.generating[:len(g.generating)-1]
+	g.markSource()
+}
+
+// markSource marks the next instruction of the current block as generated
+// for the innermost span
+func (g *CodeGenerator) markSource() {
+	blk := g.currentBlk
+	if blk == nil || len(g.generating) == 0 {
+		return
+	}
+	mark := sourceMark{len(blk.Insts), g.generating[len(g.generating)-1], blk.Term != nil}
+
+	// No code was generated for a mark at the same place
+	marks := g.sources[blk]
+	if n := len(marks); n > 0 && marks[n-1].index == mark.index {
+		marks = marks[:n-1]
+	}
+	g.sources[blk] = append(marks, mark)
+}
+
+// sourceOf finds the span an instruction was generated for. Code before the
+// first mark of a block, such as a loop condition, belongs to that mark, and
+// blocks with no marks belong to their function.
+func (g *CodeGenerator) sourceOf(block *ir.Block, index int) compiler.Span {
+	marks := g.sources[block]
+	if len(marks) == 0 {
+		if entry := block.Parent.Blocks[0]; entry != block && len(g.sources[entry]) > 0 {
+			return g.sources[entry][0].span
+		}
+		return compiler.Span{}
+	}
+	span := marks[0].span
+	for _, mark := range marks {
+		if mark.index > index {
+			break
+		}
+		span = mark.span
+	}
+	return span
+}
+
+// verifyModule checks the IR of the module before it is written out
+func (g *CodeGenerator) verifyModule() error {
+	// The IR checker numbers the values it reports
+	problems := compiler.CheckModule(g.module)
+
+	for _, fn := range g.module.Funcs {
+		for _, block := range fn.Blocks {
+			for _, mark := range g.sources[block] {
+				if mark.terminated && mark.index < len(block.Insts) {
+					d := g.internalError(mark.span, "code generated after the terminator of block %s", block.Ident())
+					d.Notes = append(d.Notes, fmt.Sprintf("in %s: %s", fn.Ident(), block.Insts[mark.index].LLString()))
+					return d
+				}
+			}
+		}
+	}
+
+	for _, p := range problems {
+		if p.Block == nil {
+			return g.internalError(compiler.Span{}, "%s", p.Message)
+		}
+		d := g.internalError(g.sourceOf(p.Block, p.Index), "%s", p.Message)
+		if text := irText(p.Block, p.Index); text != "" {
+			d.Notes = append(d.Notes, fmt.Sprintf("in %s, block %s: %s", p.Func.Ident(), p.Block.Ident(), text))
+		}
+		return d
+	}
+	return nil
+}
+
+// recoverPanic turns a panic while generating code, such as llir rejecting
+// the operands of an instruction, into an internal compiler error at the
+// source being generated
+func (g *CodeGenerator) recoverPanic(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	var span compiler.Span
+	if n := len(g.generating); n > 0 {
+		span = g.generating[n-1]
+	}
+	*err = g.internalError(span, "%v", r)
+}
+
+// irText returns the text of the instruction at index in a block, or ""
+// for a missing terminator
+func irText(block *ir.Block, index int) string {
+	if index < len(block.Insts) {
+		return block.Insts[index].LLString()
+	}
+	if block.Term == nil {
+		return ""
+	}
+	return block.Term.LLString()
+}
+
+// stmtSpan returns the span of a statement's keyword, or of its
+// declaration or expression
+func stmtSpan(stmt Statement) compiler.Span {
+	switch stmt := stmt.(type) {
+	case *ExpressionStatement:
+		return exprSpan(stmt.Expression)
+	case *VariableDeclarationStatement:
+		if stmt.Value != nil {
+			return TokenSpan(stmt.Token).Join(exprSpan(stmt.Value))
+		}
+		return TokenSpan(stmt.Token)
+	case *ReturnStatement:
+		if stmt.ReturnValue != nil {
+			return TokenSpan(stmt.Token).Join(exprSpan(stmt.ReturnValue))
+		}
+		return TokenSpan(stmt.Token)
+	case *BlockStatement:
+		return TokenSpan(stmt.Token)
+	case *IfStatement:
+		return TokenSpan(stmt.Token)
+	case *WhileStatement:
+		return TokenSpan(stmt.Token)
+	case *ForStatement:
+		return TokenSpan(stmt.Token)
+	case *SwitchStatement:
+		return TokenSpan(stmt.Token)
+	case *UnsafeStatement:
+		return TokenSpan(stmt.Token)
+	case *BreakStatement:
+		return TokenSpan(stmt.Token)
+	case *ContinueStatement:
+		return TokenSpan(stmt.Token)
+	}
+	return compiler.Span{}
+}
+
+// internalError reports a bug in the compiler found while generating code
+// for a span of source
+func (g *CodeGenerator) internalError(span compiler.Span, format string, args ...interface{}) *compiler.Diagnostic {
+	d := compiler.NewDiagnostic(compiler.CodeInternal, span, "internal compiler error: "+format, args...)
+	d.Notes = append(d.Notes, "this is a bug in the compiler, not in the program")
+	return d
+}
+
+// predecessors counts the branches to each block of a function
+func predecessors(fn *ir.Func) map[*ir.Block]int {
+	preds := make(map[*ir.Block]int)
+	for _, block := range fn.Blocks {
+		if block.Term == nil {
+			continue
+		}
+		for _, succ := range block.Term.Succs() {
+			preds[succ]++
+		}
+	}
+	return preds
+}
+
+// usedValues finds the values used as operands anywhere in a function
+func usedValues(fn *ir.Func) map[value.Value]bool {
+	used := make(map[value.Value]bool)
+	for _, block := range fn.Blocks {
+		for _, inst := range block.Insts {
+			for _, operand := range inst.Operands() {
+				used[*operand] = true
+			}
+		}
+		if block.Term != nil {
+			for _, operand := range block.Term.Operands() {
+				used[*operand] = true
+			}
+		}
+	}
+	return used
+}
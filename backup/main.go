@@ -1,67 +1,350 @@
 package main // main.go
 
 import (
+	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
-	"path/filepath"
+	"regexp"
+	"strings"
 )
 
+// novaVersion is the version of the compiler reported by nova version
+const novaVersion = "1.0.0"
+
+// Exit codes tell scripts what kind of error stopped nova
+const (
+	exitOK        = 0
+	exitErrors    = 1 // the program has errors, or its tests failed
+	exitUsage     = 2 // the command line is wrong
+	exitIO        = 3 // a file couldn't be read or written
+	exitInternal  = 4 // nova has a bug
+	exitToolchain = 5 // native code couldn't be produced
+)
+
+// command is a nova subcommand
+type command struct {
+	name    string
+	args    string // what follows the flags
+	summary string
+	run     func(name string, args []string) int
+}
+
+// commands are the subcommands in the order usage lists them. They're set
+// up by init because usage refers to them.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"build", "<file>", "compile a program", runBuild},
+		{"run", "<file> [-- args...]", "build a program and run it", runRun},
+		{"check", "<file>", "report errors in a program without generating code", runCheck},
+		{"emit-ir", "<file>", "write the LLVM IR of a program", runEmitIR},
+		{"fmt", "<files...>", "fix the indentation of source files", runFmt},
+		{"test", "[files or directories...]", "build and run *_test.nv programs", runTest},
+		{"version", "", "print the version of nova", runVersion},
+	}
+}
+
 func main() {
-    if len(os.Args) < 2 {
-        fmt.Println("Usage: compiler <input-file> [output-file]")
-        os.Exit(1)
-    }
-
-    inputFile := os.Args[1]
-    outputFile := "output.ll"
-    
-    if len(os.Args) > 2 {
-        outputFile = os.Args[2]
-    }
-
-    // Read input file
-    source, err := ioutil.ReadFile(inputFile)
-    if err != nil {
-        fmt.Printf("Error reading file: %v\n", err)
-        os.Exit(1)
-    }
-
-    // Create tokenizer
-    tokenizer := NewTokenizer(string(source))
-
-    // Create parser
-    parser := NewParser(tokenizer)
-
-    // Parse program
-    program := parser.Parse()
-    
-    // Check for errors
-    if len(parser.Errors()) > 0 {
-        fmt.Println("Parser errors:")
-        for _, err := range parser.Errors() {
-            fmt.Println(err)
-        }
-        os.Exit(1)
-    }
-
-    // Create code generator
-    moduleName := filepath.Base(inputFile)
-    generator := NewCodeGenerator(moduleName)
-
-    // Generate LLVM IR
-    module, err := generator.Generate(program)
-    if err != nil {
-        fmt.Printf("Code generation error: %v\n", err)
-        os.Exit(1)
-    }
-
-    // Save to file
-    err = SaveModule(module, outputFile)
-    if err != nil {
-        fmt.Printf("Error saving output: %v\n", err)
-        os.Exit(1)
-    }
-
-    fmt.Printf("Successfully compiled %s to %s\n", inputFile, outputFile)
-}
\ No newline at end of file
+	os.Exit(runNova(os.Args[1:]))
+}
+
+// runNova runs the command named by the first argument and returns the
+// exit code. Without a command, as in nova main.nv -o main, it builds.
+func runNova(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		usage(os.Stdout)
+		return exitOK
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(cmd.name, args[1:])
+		}
+	}
+
+	if _, err := os.Stat(args[0]); err == nil || strings.HasPrefix(args[0], "-") {
+		return runBuild("build", args)
+	}
+	fmt.Fprintf(os.Stderr, "nova: unknown command %q\n", args[0])
+	fmt.Fprintln(os.Stderr, "Run 'nova help' for usage.")
+	return exitUsage
+}
+
+// usage lists the commands
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: nova <command> [flags] [arguments]")
+	fmt.Fprintln(w, "       nova [flags] <file>      same as nova build")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-9s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'nova <command> -h' for the flags of a command.")
+}
+
+// newFlagSet creates the flags of a command, with usage that shows how the
+// command is run
+func newFlagSet(cmd string) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.Usage = func() {
+		for _, c := range commands {
+			if c.name == cmd {
+				fmt.Fprintf(fs.Output(), "Usage: nova %s [flags] %s\n\n%s.\n\nFlags:\n", c.name, c.args, strings.ToUpper(c.summary[:1])+c.summary[1:])
+			}
+		}
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// addBuildFlags adds the flags of commands that compile a program. -O0 to
+// -O3 are handled by parseArgs, since the flag package can't parse them.
+func addBuildFlags(fs *flag.FlagSet, opts *buildOptions, lf *logFlags, emit bool) {
+	fs.StringVar(&opts.output, "o", "", "write the output to `file`")
+	fs.StringVar(&opts.target, "target", "", "generate code for the target `triple`")
+	fs.Var((*stringList)(&opts.libs), "l", "link with `library`, also written -llibrary; can be repeated")
+	fs.Var((*stringList)(&opts.libDirs), "L", "look for libraries in `dir`, also written -Ldir; can be repeated")
+	if emit {
+		fs.StringVar(&opts.emit, "emit", "exe", "what to produce: tokens, ast, ir, obj or exe")
+	}
+	addLogFlags(fs, lf)
+	usage := fs.Usage
+	fs.Usage = func() {
+		usage()
+		fmt.Fprintln(fs.Output(), "  -O0, -O1, -O2, -O3\n    \toptimization level of native code (default -O0)")
+	}
+}
+
+// addLogFlags adds the flags that control what a command logs
+func addLogFlags(fs *flag.FlagSet, lf *logFlags) {
+	fs.BoolVar(&lf.verbose, "verbose", false, "report each stage of compilation on stderr")
+	fs.StringVar(&lf.trace, "trace", "", "trace each node the `stages` visit, separated by commas: parse, codegen")
+}
+
+// stringList is a flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// optLevelFlag matches -O0 to -O3
+var optLevelFlag = regexp.MustCompile(`^-O[0-3]$`)
+
+// attachedFlag matches -lname and -Ldir, written like a C compiler's flags
+var attachedFlag = regexp.MustCompile(`^-([lL])([^=].*)$`)
+
+// parseArgs parses the flags of a command, which may come before or after
+// its arguments, and returns the arguments. Everything after "--" is an
+// argument.
+func parseArgs(fs *flag.FlagSet, args []string, opts *buildOptions) ([]string, error) {
+	var rest, positional []string
+	for i, arg := range args {
+		if arg == "--" {
+			rest = args[i+1:]
+			break
+		}
+		if opts != nil && optLevelFlag.MatchString(arg) {
+			opts.optLevel = int(arg[2] - '0')
+			continue
+		}
+		if m := attachedFlag.FindStringSubmatch(arg); opts != nil && m != nil {
+			if m[1] == "l" {
+				opts.libs = append(opts.libs, m[2])
+			} else {
+				opts.libDirs = append(opts.libDirs, m[2])
+			}
+			continue
+		}
+		positional = append(positional, arg)
+	}
+
+	var result []string
+	for {
+		if err := fs.Parse(positional); err != nil {
+			return nil, err
+		}
+		positional = fs.Args()
+		if len(positional) == 0 {
+			break
+		}
+		result = append(result, positional[0])
+		positional = positional[1:]
+	}
+	return append(result, rest...), nil
+}
+
+// parseError returns the exit code for an error parsing flags, which the
+// flag package has already reported
+func parseError(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	return exitUsage
+}
+
+// oneFile checks a command was given exactly one file
+func oneFile(cmd string, files []string) (string, bool) {
+	if len(files) != 1 {
+		fmt.Fprintf(os.Stderr, "nova %s: expected one file, got %d\n", cmd, len(files))
+		return "", false
+	}
+	return files[0], true
+}
+
+func runBuild(cmd string, args []string) int {
+	opts := &buildOptions{}
+	lf := &logFlags{}
+	fs := newFlagSet(cmd)
+	addBuildFlags(fs, opts, lf, true)
+	files, err := parseArgs(fs, args, opts)
+	if err != nil {
+		return parseError(err)
+	}
+	file, ok := oneFile(cmd, files)
+	if !ok {
+		return exitUsage
+	}
+	if !validEmit(opts.emit) {
+		fmt.Fprintf(os.Stderr, "nova %s: unknown --emit %q; want tokens, ast, ir, obj or exe\n", cmd, opts.emit)
+		return exitUsage
+	}
+	if opts.log, ok = lf.logger(cmd); !ok {
+		return exitUsage
+	}
+	return build(file, opts)
+}
+
+func runEmitIR(cmd string, args []string) int {
+	opts := &buildOptions{emit: "ir"}
+	lf := &logFlags{}
+	fs := newFlagSet(cmd)
+	addBuildFlags(fs, opts, lf, false)
+	files, err := parseArgs(fs, args, opts)
+	if err != nil {
+		return parseError(err)
+	}
+	file, ok := oneFile(cmd, files)
+	if !ok {
+		return exitUsage
+	}
+	if opts.log, ok = lf.logger(cmd); !ok {
+		return exitUsage
+	}
+	return build(file, opts)
+}
+
+func runRun(cmd string, args []string) int {
+	// Arguments after "--" are the program's
+	var programArgs []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, programArgs = args[:i], args[i+1:]
+			break
+		}
+	}
+
+	opts := &buildOptions{emit: "exe"}
+	lf := &logFlags{}
+	fs := newFlagSet(cmd)
+	addBuildFlags(fs, opts, lf, false)
+	files, err := parseArgs(fs, args, opts)
+	if err != nil {
+		return parseError(err)
+	}
+	file, ok := oneFile(cmd, files)
+	if !ok {
+		return exitUsage
+	}
+	if opts.log, ok = lf.logger(cmd); !ok {
+		return exitUsage
+	}
+	return run(file, opts, programArgs)
+}
+
+func runCheck(cmd string, args []string) int {
+	lf := &logFlags{}
+	fs := newFlagSet(cmd)
+	addLogFlags(fs, lf)
+	files, err := parseArgs(fs, args, nil)
+	if err != nil {
+		return parseError(err)
+	}
+	file, ok := oneFile(cmd, files)
+	if !ok {
+		return exitUsage
+	}
+	log, ok := lf.logger(cmd)
+	if !ok {
+		return exitUsage
+	}
+	_, code := compile(file, log)
+	return code
+}
+
+func runFmt(cmd string, args []string) int {
+	fs := newFlagSet(cmd)
+	write := fs.Bool("w", false, "write the result to the file instead of printing it")
+	files, err := parseArgs(fs, args, nil)
+	if err != nil {
+		return parseError(err)
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "nova %s: no files to format\n", cmd)
+		return exitUsage
+	}
+
+	code := exitOK
+	for _, file := range files {
+		if c := formatFile(file, *write); c > code {
+			code = c
+		}
+	}
+	return code
+}
+
+func runTest(cmd string, args []string) int {
+	opts := &buildOptions{emit: "exe"}
+	lf := &logFlags{}
+	fs := newFlagSet(cmd)
+	addBuildFlags(fs, opts, lf, false)
+	paths, err := parseArgs(fs, args, opts)
+	if err != nil {
+		return parseError(err)
+	}
+	if opts.output != "" {
+		fmt.Fprintf(os.Stderr, "nova %s: -o can't be used with test\n", cmd)
+		return exitUsage
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	var ok bool
+	if opts.log, ok = lf.logger(cmd); !ok {
+		return exitUsage
+	}
+	return test(paths, opts, lf)
+}
+
+func runVersion(cmd string, args []string) int {
+	fs := newFlagSet(cmd)
+	if _, err := parseArgs(fs, args, nil); err != nil {
+		return parseError(err)
+	}
+	fmt.Printf("nova version %s\n", novaVersion)
+	return exitOK
+}
@@ -0,0 +1,952 @@
+package main // modules.go
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/deep-neural/nova-lang/compiler"
+)
+
+// moduleExtension is the file extension of imported modules
+const moduleExtension = ".nv"
+
+// headerExtension is the file extension of the clang AST dumps that virtual
+// imports of C headers read
+const headerExtension = ".json"
+
+// bundledHeaders are the header dumps checked in with the compiler. They're
+// built into it, so a compiler built with go build or go run finds them
+// wherever it is run from.
+//
+//go:embed headers/*.json headers/*/*.json
+var bundledHeaders embed.FS
+
+// bundledHeaderPrefix marks the file of a bundled header in messages
+const bundledHeaderPrefix = "<built-in>/"
+
+// Module is one source file of a program. Every top-level function, struct,
+// enum and interface of an imported module is exported under the module's
+// namespace, so importers refer to them as math.sqrt.
+type Module struct {
+	Path    string // import path, empty for the main module
+	File    string
+	Name    string // qualifies the module's symbols, empty for the main module
+	Program *Program
+	Header  *CHeader           // set instead of Program for a C header
+	Imports map[string]*Module // namespace to imported module
+	Symbols map[string]*Module // selectively imported name to its module
+
+	functions map[string]bool // names declared by the module itself
+	types     map[string]bool
+	unbound   map[string]*ImportStatement // the last part of an import path to an aliased or selective import of it
+}
+
+// qualify returns the name a symbol declared by the module has once modules
+// are linked
+func (m *Module) qualify(name string) string {
+	if m.Name == "" {
+		return name
+	}
+	return m.Name + "." + name
+}
+
+// declares checks if the module itself declares a function or type, or for
+// a C header, a function, enum constant or type
+func (m *Module) declares(name string) bool {
+	if m.Header != nil {
+		_, isFunc := m.Header.Functions[name]
+		_, isConst := m.Header.Constants[name]
+		return isFunc || isConst || m.types[name]
+	}
+	return m.functions[name] || m.types[name]
+}
+
+// displayName names the module in messages
+func (m *Module) displayName() string {
+	if m.Path == "" {
+		return filepath.Base(m.File)
+	}
+	return m.Path
+}
+
+// ModuleLoader locates and parses the modules of a program and links them
+// into a single program. Import paths are resolved relative to the module
+// root, and each module is parsed once however often it is imported.
+type ModuleLoader struct {
+	root        string
+	main        *Module
+	modules     map[string]*Module // by import path
+	order       []*Module          // each module after the modules it imports
+	loading     []*Module          // import chain being loaded, innermost last
+	sources     map[string]string  // file to source text
+	headers     map[string]*Module // C headers by import path
+	headerDirs  []string
+	externs     map[string]*ExternFunction // C functions used, by Nova name
+	externOrder []*ExternFunction
+	diagnostics compiler.DiagnosticList
+	log         *compiler.Logger
+}
+
+// NewModuleLoader creates a loader that resolves imports relative to root.
+// C headers are looked for in the headers directory under root, then in the
+// one next to the compiler, and last among the headers built into it.
+// Directories found first can override a bundled header.
+func NewModuleLoader(root string) *ModuleLoader {
+	l := &ModuleLoader{
+		root:       root,
+		modules:    make(map[string]*Module),
+		sources:    make(map[string]string),
+		headers:    make(map[string]*Module),
+		headerDirs: []string{filepath.Join(root, "headers")},
+		externs:    make(map[string]*ExternFunction),
+	}
+	if exe, err := os.Executable(); err == nil {
+		l.headerDirs = append(l.headerDirs, filepath.Join(filepath.Dir(exe), "headers"))
+	}
+	return l
+}
+
+// Diagnostics returns any problems found while parsing modules and resolving
+// imports
+func (l *ModuleLoader) Diagnostics() compiler.DiagnosticList {
+	return l.diagnostics
+}
+
+// Renderer creates a diagnostic renderer that can show excerpts of every
+// loaded file
+func (l *ModuleLoader) Renderer() *compiler.DiagnosticRenderer {
+	r := compiler.NewDiagnosticRenderer(l.main.File, l.sources[l.main.File])
+	for file, source := range l.sources {
+		r.AddFile(file, source)
+	}
+	return r
+}
+
+// Load parses the main file of a program and every module it imports,
+// directly or indirectly
+func (l *ModuleLoader) Load(file string) error {
+	source, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	l.main = l.parseModule(file, "", string(source))
+	return nil
+}
+
+// parseModule parses a module and then loads its imports
+func (l *ModuleLoader) parseModule(file, importPath, source string) *Module {
+	l.sources[file] = source
+
+	l.log.Verbosef("parsing %s", file)
+	parser := NewParser(NewFileTokenizer(file, source))
+	parser.log = l.log
+	program := parser.Parse()
+	l.diagnostics = append(l.diagnostics, parser.Diagnostics()...)
+
+	mod := &Module{
+		Path:      importPath,
+		File:      file,
+		Name:      strings.ReplaceAll(importPath, "/", "."),
+		Program:   program,
+		Imports:   make(map[string]*Module),
+		Symbols:   make(map[string]*Module),
+		functions: make(map[string]bool),
+		types:     make(map[string]bool),
+		unbound:   make(map[string]*ImportStatement),
+	}
+	l.modules[importPath] = mod
+
+	for _, fn := range program.Functions {
+		mod.functions[fn.Name] = true
+	}
+	for _, ext := range program.Externs {
+		mod.functions[ext.Name] = true
+	}
+	for _, st := range program.Structs {
+		mod.types[st.Name] = true
+	}
+	for _, enum := range program.Enums {
+		mod.types[enum.Name] = true
+	}
+	for _, intf := range program.Interfaces {
+		mod.types[intf.Name] = true
+	}
+
+	l.loading = append(l.loading, mod)
+	for _, imp := range program.Imports {
+		l.resolveImport(mod, imp)
+	}
+	l.loading = l.loading[:len(l.loading)-1]
+
+	l.order = append(l.order, mod)
+	return mod
+}
+
+// resolveImport loads the module an import refers to and makes it available
+// to the importing module, either under a namespace or, for a selective
+// import, as the names it selects
+func (l *ModuleLoader) resolveImport(mod *Module, imp *ImportStatement) {
+	importPath := imp.Path
+	switch imp.From {
+	case "":
+	case "virtual":
+		l.resolveHeaderImport(mod, imp)
+		return
+	default:
+		// from "video" import "codec" is the module video/codec
+		importPath = imp.From + "/" + imp.Path
+	}
+
+	if !isValidImportPath(importPath) {
+		l.addError(TokenSpan(imp.Token), compiler.CodeImport, "invalid import path %q", importPath)
+		return
+	}
+
+	// A module that is still being loaded imports itself through the chain
+	for i, loading := range l.loading {
+		if loading.Path == importPath {
+			chain := []string{}
+			for _, m := range l.loading[i:] {
+				chain = append(chain, m.displayName())
+			}
+			chain = append(chain, importPath)
+			l.addError(TokenSpan(imp.Token), compiler.CodeImport, "import cycle not allowed: %s", strings.Join(chain, " -> "))
+			return
+		}
+	}
+
+	dep, ok := l.modules[importPath]
+	if !ok {
+		file := filepath.Join(l.root, filepath.FromSlash(importPath)+moduleExtension)
+		source, err := ioutil.ReadFile(file)
+		if err != nil {
+			d := l.addError(TokenSpan(imp.Token), compiler.CodeImport, "cannot find module %q", importPath)
+			if os.IsNotExist(err) {
+				d.Notes = append(d.Notes, fmt.Sprintf("looked for %s", file))
+			} else {
+				d.Notes = append(d.Notes, err.Error())
+			}
+			return
+		}
+		dep = l.parseModule(file, importPath, string(source))
+	}
+
+	if imp.Version != "" && !l.checkVersion(imp, dep) {
+		return
+	}
+	l.bindImport(mod, imp, dep)
+}
+
+// resolveHeaderImport loads the C header a virtual import refers to, as in
+// from "virtual" import "stdio"
+func (l *ModuleLoader) resolveHeaderImport(mod *Module, imp *ImportStatement) {
+	if !isValidImportPath(imp.Path) {
+		l.addError(TokenSpan(imp.Token), compiler.CodeImport, "invalid import path %q", imp.Path)
+		return
+	}
+	if imp.Version != "" {
+		l.addError(TokenSpan(imp.Token), compiler.CodeImport, "C header %q can't have a version constraint", imp.Path)
+		return
+	}
+
+	dep, ok := l.headers[imp.Path]
+	if !ok {
+		header, err := l.loadHeader(imp.Path)
+		if err != nil {
+			d := l.addError(TokenSpan(imp.Token), compiler.CodeImport, "cannot read C header %q", imp.Path)
+			d.Notes = append(d.Notes, err.Error())
+			return
+		}
+		if header == nil {
+			d := l.addError(TokenSpan(imp.Token), compiler.CodeImport, "cannot find C header %q", imp.Path)
+			for _, dir := range l.headerDirs {
+				d.Notes = append(d.Notes, fmt.Sprintf("looked for %s", filepath.Join(dir, filepath.FromSlash(imp.Path)+headerExtension)))
+			}
+			d.Notes = append(d.Notes, "and among the headers built into nova")
+			return
+		}
+		dep = &Module{
+			Path:    imp.Path,
+			File:    header.File,
+			Name:    strings.ReplaceAll(imp.Path, "/", "."),
+			Header:  header,
+			Imports: make(map[string]*Module),
+			Symbols: make(map[string]*Module),
+			types:   make(map[string]bool),
+		}
+		for name := range header.Types {
+			dep.types[name] = true
+		}
+		l.headers[imp.Path] = dep
+	}
+
+	l.bindImport(mod, imp, dep)
+}
+
+// loadHeader finds and reads the dump of a C header, returning nil if there
+// is none
+func (l *ModuleLoader) loadHeader(importPath string) (*CHeader, error) {
+	name := filepath.FromSlash(importPath) + headerExtension
+	for _, dir := range l.headerDirs {
+		file := filepath.Join(dir, name)
+		if _, err := os.Stat(file); err == nil {
+			return LoadCHeader(importPath, file)
+		}
+	}
+
+	bundled := "headers/" + importPath + headerExtension
+	data, err := fs.ReadFile(bundledHeaders, bundled)
+	if err != nil {
+		return nil, nil
+	}
+	return ParseCHeader(importPath, bundledHeaderPrefix+bundled, data)
+}
+
+// bindImport makes an imported module available to the importing module,
+// under a namespace or as the names a selective import selects
+func (l *ModuleLoader) bindImport(mod *Module, imp *ImportStatement, dep *Module) {
+	// The namespace the import would have bound by default is remembered,
+	// so using it can be reported as not imported rather than undefined
+	namespace := path.Base(dep.Path)
+	if len(imp.Names) > 0 || (imp.Alias != "" && imp.Alias != namespace) {
+		mod.unbound[namespace] = imp
+	}
+	if len(imp.Names) > 0 {
+		l.importNames(mod, imp, dep)
+		return
+	}
+
+	if imp.Alias != "" {
+		namespace = imp.Alias
+	}
+	if prev, exists := mod.Imports[namespace]; exists {
+		d := l.addError(TokenSpan(imp.Token), compiler.CodeRedeclared, "%s redeclared by import %q", namespace, dep.Path)
+		d.Notes = append(d.Notes, fmt.Sprintf("%s already refers to module %q", namespace, prev.Path))
+		return
+	}
+	mod.Imports[namespace] = dep
+}
+
+// importNames makes the names a selective import selects refer to the
+// symbols of the imported module. The rest of the module stays out of scope.
+func (l *ModuleLoader) importNames(mod *Module, imp *ImportStatement, dep *Module) {
+	for _, name := range imp.Names {
+		if !dep.declares(name.Value) {
+			l.addError(TokenSpan(name.Token), compiler.CodeImport, "module %q has no symbol %s", dep.Path, name.Value)
+			continue
+		}
+		if mod.declares(name.Value) {
+			l.addError(TokenSpan(name.Token), compiler.CodeRedeclared, "%s redeclared by import %q", name.Value, dep.Path)
+			continue
+		}
+		if prev, exists := mod.Symbols[name.Value]; exists && prev != dep {
+			d := l.addError(TokenSpan(name.Token), compiler.CodeRedeclared, "%s redeclared by import %q", name.Value, dep.Path)
+			d.Notes = append(d.Notes, fmt.Sprintf("%s was already imported from module %q", name.Value, prev.Path))
+			continue
+		}
+		mod.Symbols[name.Value] = dep
+	}
+}
+
+// checkVersion checks that an imported module declares a version satisfying
+// the import's constraint
+func (l *ModuleLoader) checkVersion(imp *ImportStatement, dep *Module) bool {
+	constraint, err := parseVersionConstraint(imp.Version)
+	if err != nil {
+		l.addError(TokenSpan(imp.Token), compiler.CodeImport, "invalid version constraint %q: %s", imp.Version, err)
+		return false
+	}
+
+	decl := dep.Program.Module
+	if decl == nil || decl.Version == "" {
+		d := l.addError(TokenSpan(imp.Token), compiler.CodeImport, "module %q does not declare a version", dep.Path)
+		d.Notes = append(d.Notes, fmt.Sprintf("import requires version %s", imp.Version))
+		return false
+	}
+	version, err := parseVersion(decl.Version)
+	if err != nil {
+		l.addError(TokenSpan(decl.Token), compiler.CodeImport, "invalid module version %q: %s", decl.Version, err)
+		return false
+	}
+
+	if !constraint.allows(version) {
+		d := l.addError(TokenSpan(imp.Token), compiler.CodeImport, "module %q version %s does not satisfy %q", dep.Path, decl.Version, imp.Version)
+		d.Notes = append(d.Notes, fmt.Sprintf("%s declares version %s", dep.File, decl.Version))
+		return false
+	}
+	return true
+}
+
+// isValidImportPath checks that an import path is a sequence of identifiers
+// separated by slashes, so it names a file under the module root and its
+// last element can be used as a namespace
+func isValidImportPath(importPath string) bool {
+	if importPath == "" {
+		return false
+	}
+	for _, elem := range strings.Split(importPath, "/") {
+		if elem == "" || !isLetter([]rune(elem)[0]) {
+			return false
+		}
+		for _, r := range elem {
+			if !isLetter(r) && !isDigit(r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Link combines the loaded modules into one program. Symbols of imported
+// modules are renamed to their qualified names and references to them are
+// rewritten, so later stages see a single namespace.
+func (l *ModuleLoader) Link() *Program {
+	program := &Program{
+		Imports:    []*ImportStatement{},
+		Interfaces: []*InterfaceDefinition{},
+		Structs:    []*StructDefinition{},
+		Enums:      []*EnumDefinition{},
+		Functions:  []*FunctionDefinition{},
+		Headers:    make(map[string]*CHeader),
+	}
+	for _, header := range l.headers {
+		program.Headers[header.Name] = header.Header
+	}
+
+	for _, mod := range l.order {
+		linker := &moduleLinker{loader: l, mod: mod, locals: compiler.NewScope[bool](nil)}
+		linker.linkProgram(mod.Program)
+
+		program.Interfaces = append(program.Interfaces, mod.Program.Interfaces...)
+		program.Structs = append(program.Structs, mod.Program.Structs...)
+		program.Enums = append(program.Enums, mod.Program.Enums...)
+		program.Functions = append(program.Functions, mod.Program.Functions...)
+		program.Externs = append(program.Externs, mod.Program.Externs...)
+		program.Links = append(program.Links, mod.Program.Links...)
+	}
+	program.Externs = append(program.Externs, l.externOrder...)
+
+	return program
+}
+
+// externFunction returns the extern for a function of a C header, creating
+// it the first time the function is used. The function's C types are
+// mapped to Nova types; a function using C types Nova has no equivalent for
+// can't be called.
+func (l *ModuleLoader) externFunction(header *Module, fn *CFunction, tok Token) *ExternFunction {
+	name := header.qualify(fn.Name)
+	if ext, ok := l.externs[name]; ok {
+		return ext
+	}
+
+	ext := &ExternFunction{Token: tok, Name: name, Symbol: fn.Name, Variadic: fn.Variadic, C: fn}
+	for i, param := range fn.Params {
+		typ, err := param.NovaType(true)
+		if err != nil {
+			d := l.addError(TokenSpan(tok), compiler.CodeUnsupported, "cannot call C function %s", fn.Name)
+			d.Notes = append(d.Notes, fmt.Sprintf("parameter %d: %v", i+1, err))
+			return nil
+		}
+		ext.Parameters = append(ext.Parameters, &ParameterDefinition{
+			Token: tok,
+			Name:  fmt.Sprintf("arg%d", i),
+			Type:  typeSpecifierOf(typ, tok),
+		})
+	}
+	typ, err := fn.Return.NovaType(true)
+	if err != nil {
+		d := l.addError(TokenSpan(tok), compiler.CodeUnsupported, "cannot call C function %s", fn.Name)
+		d.Notes = append(d.Notes, fmt.Sprintf("result: %v", err))
+		return nil
+	}
+	ext.ReturnType = typeSpecifierOf(typ, tok)
+
+	l.externs[name] = ext
+	l.externOrder = append(l.externOrder, ext)
+	return ext
+}
+
+// cTypeNamed finds a type exported by an imported C header, such as
+// x11.XEvent, and returns the Nova type it stands for. A struct or union is
+// a type of its own, named by the name the header calls it by, so its tag
+// and its typedef are the same type. Any other typedef stands for the Nova
+// type of what it names, so glib.gpointer is *void. The type is nil if name
+// isn't a header type.
+func cTypeNamed(headers map[string]*CHeader, name string) (*CType, string, error) {
+	dot := strings.LastIndex(name, ".")
+	if dot < 0 {
+		return nil, "", nil
+	}
+	namespace, member := name[:dot], name[dot+1:]
+	header, ok := headers[namespace]
+	if !ok {
+		return nil, "", nil
+	}
+	ct, ok := header.Types[member]
+	if !ok {
+		return nil, "", nil
+	}
+	if ct.Kind == CKindRecord {
+		return ct, namespace + "." + header.RecordName(ct.Record), nil
+	}
+	typ, err := header.novaValueType(namespace, ct)
+	return ct, typ, err
+}
+
+// typeSpecifierOf makes a type specifier for a named, pointer or function
+// type string
+func typeSpecifierOf(typ string, tok Token) TypeSpecifier {
+	if params, ret, ok := splitFuncType(typ); ok {
+		fn := &FuncType{}
+		for _, param := range params {
+			fn.Params = append(fn.Params, typeSpecifierOf(param, tok))
+		}
+		if ret != "void" {
+			fn.ReturnType = typeSpecifierOf(ret, tok)
+		}
+		return TypeSpecifier{Token: tok, Func: fn}
+	}
+	if strings.HasPrefix(typ, "*") {
+		elem := typeSpecifierOf(typ[1:], tok)
+		return TypeSpecifier{Token: tok, IsPointer: true, Elem: &elem}
+	}
+	return TypeSpecifier{Token: tok, TypeName: typ}
+}
+
+// addError reports an error and returns it so notes can be attached
+func (l *ModuleLoader) addError(span compiler.Span, code string, format string, args ...interface{}) *compiler.Diagnostic {
+	d := compiler.NewDiagnostic(code, span, format, args...)
+	l.diagnostics = append(l.diagnostics, d)
+	return d
+}
+
+// moduleLinker rewrites the names in one module to their linked form. Local
+// variables are tracked so they aren't mistaken for module symbols or
+// namespaces.
+type moduleLinker struct {
+	loader *ModuleLoader
+	mod    *Module
+	locals *compiler.Scope[bool]
+}
+
+// linkProgram renames a module's declarations and the references in them
+func (ml *moduleLinker) linkProgram(program *Program) {
+	for _, intf := range program.Interfaces {
+		intf.Name = ml.mod.qualify(intf.Name)
+		for _, field := range intf.Fields {
+			ml.linkType(&field.Type)
+		}
+	}
+	for _, st := range program.Structs {
+		st.Name = ml.mod.qualify(st.Name)
+		for _, field := range st.Fields {
+			ml.linkType(&field.Type)
+		}
+	}
+	for _, enum := range program.Enums {
+		enum.Name = ml.mod.qualify(enum.Name)
+	}
+
+	for _, ext := range program.Externs {
+		ext.Name = ml.mod.qualify(ext.Name)
+		ml.linkType(&ext.ReturnType)
+		for _, param := range ext.Parameters {
+			ml.linkType(&param.Type)
+		}
+	}
+
+	for _, fn := range program.Functions {
+		fn.Name = ml.mod.qualify(fn.Name)
+		ml.linkType(&fn.ReturnType)
+
+		ml.locals = compiler.NewScope(ml.locals)
+		for _, param := range fn.Parameters {
+			ml.linkType(&param.Type)
+			ml.locals.Declare(param.Name, true)
+		}
+		if fn.Body != nil {
+			ml.linkBlock(fn.Body)
+		}
+		ml.locals = ml.locals.Parent()
+	}
+}
+
+// linkType resolves the type names in a type specifier. Qualified names such
+// as math.Vec refer to a type exported by an imported module.
+func (ml *moduleLinker) linkType(ts *TypeSpecifier) {
+	if ts.Func != nil {
+		for i := range ts.Func.Params {
+			ml.linkType(&ts.Func.Params[i])
+		}
+		if !ts.Func.ReturnType.IsEmpty() {
+			ml.linkType(&ts.Func.ReturnType)
+		}
+		return
+	}
+	if ts.Elem != nil {
+		ml.linkType(ts.Elem)
+		return
+	}
+	ts.TypeName = ml.linkTypeName(ts.TypeName)
+}
+
+// linkTypeName resolves a possibly qualified type name. Names that don't
+// resolve are left for the checker to report.
+func (ml *moduleLinker) linkTypeName(name string) string {
+	if namespace, member, ok := strings.Cut(name, "."); ok {
+		if dep, isImport := ml.mod.Imports[namespace]; isImport && dep.types[member] {
+			return dep.qualify(member)
+		}
+		return name
+	}
+	if ml.mod.types[name] {
+		return ml.mod.qualify(name)
+	}
+	if dep, ok := ml.mod.Symbols[name]; ok && dep.types[name] {
+		return dep.qualify(name)
+	}
+	return name
+}
+
+// linkBlock rewrites the statements of a block in a new scope
+func (ml *moduleLinker) linkBlock(block *BlockStatement) {
+	if block == nil {
+		return
+	}
+	ml.locals = compiler.NewScope(ml.locals)
+	defer func() { ml.locals = ml.locals.Parent() }()
+
+	for _, stmt := range block.Statements {
+		ml.linkStatement(stmt)
+	}
+}
+
+// linkStatement rewrites the names in a statement
+func (ml *moduleLinker) linkStatement(stmt Statement) {
+	switch stmt := stmt.(type) {
+	case *BlockStatement:
+		ml.linkBlock(stmt)
+	case *VariableDeclarationStatement:
+		ml.linkType(&stmt.Type)
+		if stmt.Value != nil {
+			stmt.Value = ml.linkExpression(stmt.Value)
+		}
+		ml.locals.Declare(stmt.Name, true)
+	case *ReturnStatement:
+		if stmt.ReturnValue != nil {
+			stmt.ReturnValue = ml.linkExpression(stmt.ReturnValue)
+		}
+	case *IfStatement:
+		stmt.Condition = ml.linkExpression(stmt.Condition)
+		ml.linkBlock(stmt.Consequence)
+		ml.linkBlock(stmt.Alternative)
+	case *WhileStatement:
+		stmt.Condition = ml.linkExpression(stmt.Condition)
+		ml.linkBlock(stmt.Body)
+	case *ForStatement:
+		ml.locals = compiler.NewScope(ml.locals)
+		if stmt.Init != nil {
+			ml.linkStatement(stmt.Init)
+		}
+		if stmt.Condition != nil {
+			stmt.Condition = ml.linkExpression(stmt.Condition)
+		}
+		if stmt.Update != nil {
+			stmt.Update = ml.linkExpression(stmt.Update)
+		}
+		ml.linkBlock(stmt.Body)
+		ml.locals = ml.locals.Parent()
+	case *SwitchStatement:
+		stmt.Value = ml.linkExpression(stmt.Value)
+		for _, cs := range stmt.Cases {
+			cs.Value = ml.linkExpression(cs.Value)
+			ml.linkBlock(cs.Block)
+		}
+		ml.linkBlock(stmt.Default)
+	case *UnsafeStatement:
+		ml.linkBlock(stmt.Body)
+	case *ExpressionStatement:
+		if stmt.Expression != nil {
+			stmt.Expression = ml.linkExpression(stmt.Expression)
+		}
+	}
+}
+
+// linkExpression rewrites the names in an expression and returns the
+// rewritten expression. A member of an imported namespace, such as
+// math.sqrt, becomes an identifier holding the qualified name.
+func (ml *moduleLinker) linkExpression(expr Expression) Expression {
+	switch e := expr.(type) {
+	case *Identifier:
+		if _, isLocal := ml.locals.Lookup(e.Value); !isLocal {
+			if ml.mod.declares(e.Value) {
+				e.Value = ml.mod.qualify(e.Value)
+			} else if dep, ok := ml.mod.Symbols[e.Value]; ok {
+				return ml.member(dep, e.Token, e.Value, e.Value)
+			}
+		}
+	case *DotExpression:
+		if ident, ok := e.Object.(*Identifier); ok {
+			if dep := ml.namespace(ident); dep != nil {
+				tok := qualifiedToken(ident.Token, e.Member.Value)
+				return ml.member(dep, tok, e.Member.Value, ident.Value+"."+e.Member.Value)
+			}
+			if ml.checkUnbound(ident, e.Member) {
+				return e
+			}
+		}
+		e.Object = ml.linkExpression(e.Object)
+	case *CallExpression:
+		e.Function = ml.linkExpression(e.Function)
+		for i, arg := range e.Arguments {
+			e.Arguments[i] = ml.linkExpression(arg)
+		}
+	case *PrefixExpression:
+		e.Right = ml.linkExpression(e.Right)
+	case *InfixExpression:
+		e.Left = ml.linkExpression(e.Left)
+		e.Right = ml.linkExpression(e.Right)
+	case *PostfixExpression:
+		e.Left = ml.linkExpression(e.Left)
+	case *AssignmentExpression:
+		e.Left = ml.linkExpression(e.Left)
+		e.Value = ml.linkExpression(e.Value)
+	case *CompoundAssignmentExpression:
+		e.Left = ml.linkExpression(e.Left)
+		e.Value = ml.linkExpression(e.Value)
+	case *IndexExpression:
+		e.Left = ml.linkExpression(e.Left)
+		e.Index = ml.linkExpression(e.Index)
+	case *SliceExpression:
+		e.Left = ml.linkExpression(e.Left)
+		if e.Low != nil {
+			e.Low = ml.linkExpression(e.Low)
+		}
+		if e.High != nil {
+			e.High = ml.linkExpression(e.High)
+		}
+	case *ArrayLiteral:
+		for i, elem := range e.Elements {
+			e.Elements[i] = ml.linkExpression(elem)
+		}
+	case *StructLiteralExpression:
+		if e.TypeName != "" {
+			e.TypeName = ml.linkTypeName(e.TypeName)
+		}
+		for name, value := range e.Fields {
+			e.Fields[name] = ml.linkExpression(value)
+		}
+	case *ConversionExpression:
+		e.Value = ml.linkExpression(e.Value)
+	case *InterpolatedString:
+		for i, part := range e.Parts {
+			e.Parts[i] = ml.linkExpression(part)
+		}
+	}
+	return expr
+}
+
+// member links a reference to a symbol of an imported module. Members the
+// module doesn't declare keep the name as written so the checker reports
+// them the way the user spelled them. A C function becomes an extern and an
+// enum constant of a C header becomes its value.
+func (ml *moduleLinker) member(dep *Module, tok Token, name, written string) Expression {
+	if dep.Header != nil {
+		if fn, ok := dep.Header.Functions[name]; ok {
+			if ext := ml.loader.externFunction(dep, fn, tok); ext != nil {
+				return &Identifier{Token: tok, Value: ext.Name}
+			}
+		}
+		if value, ok := dep.Header.Constants[name]; ok {
+			if value < 0 {
+				return &PrefixExpression{Token: tok, Operator: "-", Right: &IntegerLiteral{Token: tok, Value: uint64(-value)}}
+			}
+			return &IntegerLiteral{Token: tok, Value: uint64(value)}
+		}
+		return &Identifier{Token: tok, Value: written}
+	}
+
+	if dep.declares(name) {
+		return &Identifier{Token: tok, Value: dep.qualify(name)}
+	}
+	return &Identifier{Token: tok, Value: written}
+}
+
+// namespace returns the module an identifier refers to when it names an
+// import rather than a local variable
+func (ml *moduleLinker) namespace(ident *Identifier) *Module {
+	if _, isLocal := ml.locals.Lookup(ident.Value); isLocal {
+		return nil
+	}
+	return ml.mod.Imports[ident.Value]
+}
+
+// checkUnbound reports a member of a namespace the module imports only
+// under an alias or by selecting some of its names, as in math.sqrt after
+// import math as m, and returns whether it did
+func (ml *moduleLinker) checkUnbound(ident, member *Identifier) bool {
+	imp, ok := ml.mod.unbound[ident.Value]
+	if !ok || ml.mod.declares(ident.Value) {
+		return false
+	}
+	if _, isLocal := ml.locals.Lookup(ident.Value); isLocal {
+		return false
+	}
+
+	span := TokenSpan(qualifiedToken(ident.Token, member.Value))
+	if len(imp.Names) == 0 {
+		d := ml.loader.addError(span, compiler.CodeImport, "namespace %s is not imported", ident.Value)
+		d.Notes = append(d.Notes, fmt.Sprintf("did you mean %s? the import at line %d names it %s", imp.Alias, imp.Token.Line, imp.Alias))
+		return true
+	}
+	names := make([]string, len(imp.Names))
+	for i, name := range imp.Names {
+		if name.Value == member.Value {
+			d := ml.loader.addError(span, compiler.CodeImport, "namespace %s is not imported", ident.Value)
+			d.Notes = append(d.Notes, fmt.Sprintf("the import at line %d imports %s by name, so call it %s", imp.Token.Line, member.Value, member.Value))
+			return true
+		}
+		names[i] = name.Value
+	}
+	d := ml.loader.addError(span, compiler.CodeImport, "%s is not imported from %s", member.Value, ident.Value)
+	d.Notes = append(d.Notes, fmt.Sprintf("the import at line %d imports only %s", imp.Token.Line, strings.Join(names, ", ")))
+	return true
+}
+
+// qualifiedToken makes the token for namespace.member, spanning both
+func qualifiedToken(tok Token, member string) Token {
+	tok.Type = TOKEN_IDENT
+	tok.Literal += "." + member
+	return tok
+}
+
+// moduleVersion is a major.minor.patch version. Missing parts are zero, so
+// "1.2" is 1.2.0.
+type moduleVersion [3]int
+
+// parseVersion parses a version of one to three numbers separated by dots
+func parseVersion(s string) (moduleVersion, error) {
+	v, _, err := parseVersionParts(s)
+	return v, err
+}
+
+// parseVersionParts parses a version and also returns how many parts it was
+// written with, which a ~ constraint needs
+func parseVersionParts(s string) (moduleVersion, int, error) {
+	var v moduleVersion
+	parts := strings.Split(s, ".")
+	if len(parts) > len(v) {
+		return v, 0, fmt.Errorf("too many parts")
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || part[0] == '+' {
+			return v, 0, fmt.Errorf("%q is not a version number", part)
+		}
+		v[i] = n
+	}
+	return v, len(parts), nil
+}
+
+// compare orders two versions, returning -1, 0 or 1
+func (v moduleVersion) compare(other moduleVersion) int {
+	for i := range v {
+		if v[i] != other[i] {
+			if v[i] < other[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// versionBound is one comparison a version must pass, such as >=1.2.0
+type versionBound struct {
+	op      string
+	version moduleVersion
+}
+
+// versionConstraint is a set of bounds that must all hold
+type versionConstraint []versionBound
+
+// parseVersionConstraint parses bounds separated by spaces or commas, such as
+// ">=1.0 <2.0". Each bound is a version after one of = > >= < <= ^ ~. A bare
+// version means ^: "1.2" accepts any version from 1.2.0 up to, but not
+// including, 2.0.0.
+func parseVersionConstraint(s string) (versionConstraint, error) {
+	terms := strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' })
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("empty constraint")
+	}
+
+	var c versionConstraint
+	for _, term := range terms {
+		op := ""
+		for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+			if strings.HasPrefix(term, prefix) {
+				op = prefix
+				break
+			}
+		}
+
+		v, parts, err := parseVersionParts(strings.TrimSpace(term[len(op):]))
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case "", "^":
+			// Changes that keep the leftmost non-zero part are compatible
+			upper := moduleVersion{v[0] + 1, 0, 0}
+			if v[0] == 0 && v[1] != 0 {
+				upper = moduleVersion{0, v[1] + 1, 0}
+			} else if v[0] == 0 && parts == 3 {
+				upper = moduleVersion{0, v[1], v[2] + 1}
+			}
+			c = append(c, versionBound{">=", v}, versionBound{"<", upper})
+		case "~":
+			// Patch changes are compatible, or minor ones if only the major
+			// version is given
+			upper := moduleVersion{v[0], v[1] + 1, 0}
+			if parts == 1 {
+				upper = moduleVersion{v[0] + 1, 0, 0}
+			}
+			c = append(c, versionBound{">=", v}, versionBound{"<", upper})
+		default:
+			c = append(c, versionBound{op, v})
+		}
+	}
+	return c, nil
+}
+
+// allows checks if a version satisfies every bound of the constraint
+func (c versionConstraint) allows(v moduleVersion) bool {
+	for _, bound := range c {
+		cmp := v.compare(bound.version)
+		var ok bool
+		switch bound.op {
+		case "=":
+			ok = cmp == 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -3,805 +3,1861 @@ package main // parser.go
 import (
 	"fmt"
 	"strconv"
-)
+	"strings"
+	"unicode/utf8"
 
-// NodeType represents the type of AST node
-type NodeType int
-
-const (
-	NODE_PROGRAM NodeType = iota
-	NODE_FUNCTION_DECL
-	NODE_BLOCK
-	NODE_VAR_DECL
-	NODE_RETURN
-	NODE_IF
-	NODE_WHILE
-	NODE_ASSIGN
-	NODE_BINARY_OP
-	NODE_CALL
-	NODE_IDENT
-	NODE_NUMBER
-	NODE_FLOAT
-	NODE_STRING
-	NODE_BOOL
+	"github.com/deep-neural/nova-lang/compiler"
 )
 
-// Node represents an AST node
-type Node interface {
-	Type() NodeType
-	String() string
+// Parser parses tokens into the AST defined in ast.go
+type Parser struct {
+	tokenizer   *Tokenizer
+	prevToken   Token
+	currToken   Token
+	peekToken   Token
+	diagnostics compiler.DiagnosticList
+	panicking   bool             // set after an error until the parser resynchronises
+	exprLevel   int              // < 0 in control clauses, where '{' starts the body rather than a struct literal
+	log         *compiler.Logger // traces the statements and expressions parsed
 }
 
-// Program is the root node of the AST
-type Program struct {
-	Functions []*FunctionDecl
-}
+// NewParser creates a new parser
+func NewParser(tokenizer *Tokenizer) *Parser {
+	p := &Parser{tokenizer: tokenizer}
 
-func (p *Program) Type() NodeType { return NODE_PROGRAM }
-func (p *Program) String() string { return "Program" }
+	// Read two tokens to set currToken and peekToken
+	p.nextToken()
+	p.nextToken()
 
-// FunctionDecl represents a function declaration
-type FunctionDecl struct {
-	Name       string
-	Parameters []*VarDecl
-	ReturnType string
-	Body       *Block
+	return p
 }
 
-func (f *FunctionDecl) Type() NodeType { return NODE_FUNCTION_DECL }
-func (f *FunctionDecl) String() string {
-	return fmt.Sprintf("FunctionDecl(%s)", f.Name)
+// Diagnostics returns any problems found while tokenizing and parsing,
+// ordered by source position
+func (p *Parser) Diagnostics() compiler.DiagnosticList {
+	diagnostics := append(compiler.DiagnosticList{}, p.tokenizer.Diagnostics()...)
+	diagnostics = append(diagnostics, p.diagnostics...)
+	diagnostics.Sort()
+	return diagnostics
 }
 
-// Block represents a block of statements
-type Block struct {
-	Statements []Statement
+// nextToken advances to the next token
+func (p *Parser) nextToken() {
+	p.prevToken = p.currToken
+	p.currToken = p.peekToken
+	p.peekToken = p.tokenizer.NextToken()
+
+	// Comments carry no meaning and illegal characters were already
+	// reported by the tokenizer
+	for p.peekToken.Type == TOKEN_COMMENT || p.peekToken.Type == TOKEN_ILLEGAL {
+		p.peekToken = p.tokenizer.NextToken()
+	}
 }
 
-func (b *Block) Type() NodeType { return NODE_BLOCK }
-func (b *Block) String() string { return "Block" }
+// Parse parses the program. Syntax errors don't stop parsing: the parser
+// reports them, resynchronises and keeps going so every error in the file is
+// found in one run.
+func (p *Parser) Parse() *Program {
+	program := &Program{
+		Imports:    []*ImportStatement{},
+		Interfaces: []*InterfaceDefinition{},
+		Structs:    []*StructDefinition{},
+		Enums:      []*EnumDefinition{},
+		Functions:  []*FunctionDefinition{},
+	}
 
-// Statement represents a statement
-type Statement interface {
-	Node
-	statementNode()
-}
+	for p.currToken.Type != TOKEN_EOF {
+		switch p.currToken.Type {
+		case TOKEN_MODULE:
+			if program.Module != nil {
+				p.addError("module declared more than once")
+				p.skipDeclaration()
+			} else {
+				program.Module = p.parseModuleDeclaration()
+			}
+		case TOKEN_IMPORT, TOKEN_FROM:
+			if imp := p.parseImportStatement(); imp != nil {
+				program.Imports = append(program.Imports, imp)
+			}
+		case TOKEN_INTERFACE:
+			program.Interfaces = append(program.Interfaces, p.parseInterfaceDefinition())
+		case TOKEN_STRUCT:
+			program.Structs = append(program.Structs, p.parseStructDefinition())
+		case TOKEN_ENUM:
+			program.Enums = append(program.Enums, p.parseEnumDefinition())
+		case TOKEN_FUNC:
+			tok := p.currToken
+			fn := p.parseFunctionDefinition()
+			p.trace(fn, tok)
+			program.Functions = append(program.Functions, fn)
+		case TOKEN_AT, TOKEN_EXTERN:
+			attrs := p.parseAttributes()
+			if p.currToken.Type != TOKEN_EXTERN {
+				p.addError("expected extern declaration after attributes, got %s", describeToken(p.currToken))
+				p.skipDeclaration()
+				break
+			}
+			if ext := p.parseExternFunction(attrs); ext != nil {
+				program.Externs = append(program.Externs, ext)
+			}
+		case TOKEN_SEMICOLON:
+			p.nextToken()
+		default:
+			// link isn't a keyword, so C functions can still be called link
+			if p.currToken.Type == TOKEN_IDENT && p.currToken.Literal == "link" {
+				if link := p.parseLinkDirective(); link != nil {
+					program.Links = append(program.Links, link)
+				}
+				break
+			}
+			// Skip ahead to the next declaration
+			p.addError("expected declaration, got %s", describeToken(p.currToken))
+			for !isDeclarationKeyword(p.currToken.Type) && p.currToken.Type != TOKEN_EOF {
+				p.nextToken()
+			}
+		}
+		p.panicking = false
+	}
 
-// Expression represents an expression
-type Expression interface {
-	Node
-	expressionNode()
+	return program
 }
 
-// VarDecl represents a variable declaration
-type VarDecl struct {
-	Name       string
-	VarType    string
-	Value      Expression
-	IsTypeInferred bool
-}
+// parseLinkDirective parses a directive to link with a pkg-config package:
+// link "glib-2.0";
+func (p *Parser) parseLinkDirective() *LinkDirective {
+	link := &LinkDirective{Token: p.currToken}
+	p.nextToken() // Skip 'link'
 
-func (v *VarDecl) Type() NodeType { return NODE_VAR_DECL }
-func (v *VarDecl) String() string {
-	return fmt.Sprintf("VarDecl(%s: %s)", v.Name, v.VarType)
-}
-func (v *VarDecl) statementNode() {}
+	if p.currToken.Type != TOKEN_STRING || p.currToken.Literal == "" {
+		p.addError("expected package name after link, got %s", describeToken(p.currToken))
+		p.skipDeclaration()
+		return nil
+	}
+	link.Package = p.currToken.Literal
+	p.nextToken()
 
-// ReturnStatement represents a return statement
-type ReturnStatement struct {
-	Value Expression
+	if p.currToken.Type == TOKEN_SEMICOLON {
+		p.nextToken() // Skip ';'
+	}
+	return link
 }
 
-func (r *ReturnStatement) Type() NodeType { return NODE_RETURN }
-func (r *ReturnStatement) String() string { return "Return" }
-func (r *ReturnStatement) statementNode() {}
+// parseModuleDeclaration parses the declaration a module makes about itself:
+// module "name" version "1.2.0"
+func (p *Parser) parseModuleDeclaration() *ModuleDeclaration {
+	md := &ModuleDeclaration{Token: p.currToken}
+	p.nextToken() // Skip 'module'
 
-// IfStatement represents an if statement
-type IfStatement struct {
-	Condition Expression
-	ThenBlock *Block
-	ElseBlock *Block
-}
+	if p.currToken.Type != TOKEN_STRING {
+		p.addError("expected module name, got %s", describeToken(p.currToken))
+		p.skipDeclaration()
+		return nil
+	}
+	md.Name = p.currToken.Literal
+	p.nextToken()
 
-func (i *IfStatement) Type() NodeType { return NODE_IF }
-func (i *IfStatement) String() string { return "If" }
-func (i *IfStatement) statementNode() {}
+	if p.currToken.Type == TOKEN_IDENT && p.currToken.Literal == "version" {
+		p.nextToken() // Skip 'version'
+		if p.currToken.Type != TOKEN_STRING {
+			p.addError("expected version string, got %s", describeToken(p.currToken))
+			p.skipDeclaration()
+			return nil
+		}
+		md.Version = p.currToken.Literal
+		p.nextToken()
+	}
 
-// WhileStatement represents a while statement
-type WhileStatement struct {
-	Condition Expression
-	Body      *Block
+	if p.currToken.Type == TOKEN_SEMICOLON {
+		p.nextToken() // Skip ';'
+	}
+
+	return md
 }
 
-func (w *WhileStatement) Type() NodeType { return NODE_WHILE }
-func (w *WhileStatement) String() string { return "While" }
-func (w *WhileStatement) statementNode() {}
+// parseImportStatement parses an import. The path is a string or a dotted
+// name, and can be followed by the names to select, an alias and a version
+// constraint:
+//
+//	import "math"
+//	import crypto.sha256 as sha
+//	import fs.{read_file, write_file}
+//	import "net/http" version "1.2"
+//	from "virtual" import "stdio"
+func (p *Parser) parseImportStatement() *ImportStatement {
+	imp := &ImportStatement{Token: p.currToken}
 
-// AssignStatement represents an assignment
-type AssignStatement struct {
-	Name  string
-	Value Expression
-}
+	if p.currToken.Type == TOKEN_FROM {
+		p.nextToken() // Skip 'from'
+		if p.currToken.Type != TOKEN_STRING {
+			p.addError("expected import source, got %s", describeToken(p.currToken))
+			p.skipDeclaration()
+			return nil
+		}
+		imp.From = p.currToken.Literal
+		p.nextToken()
 
-func (a *AssignStatement) Type() NodeType { return NODE_ASSIGN }
-func (a *AssignStatement) String() string { return fmt.Sprintf("Assign(%s)", a.Name) }
-func (a *AssignStatement) statementNode() {}
+		if p.currToken.Type != TOKEN_IMPORT {
+			p.addError("expected 'import' after import source, got %s", describeToken(p.currToken))
+			p.skipDeclaration()
+			return nil
+		}
+	}
+	p.nextToken() // Skip 'import'
 
-// ExpressionStatement wraps an expression as a statement
-type ExpressionStatement struct {
-	Expression Expression
-}
+	switch p.currToken.Type {
+	case TOKEN_STRING:
+		imp.Path = p.currToken.Literal
+		p.nextToken()
+	case TOKEN_IDENT:
+		// A dotted name is the path with its slashes written as dots
+		elems := []string{p.currToken.Literal}
+		p.nextToken()
+		for p.currToken.Type == TOKEN_DOT && p.peekToken.Type == TOKEN_IDENT {
+			p.nextToken() // Skip '.'
+			elems = append(elems, p.currToken.Literal)
+			p.nextToken()
+		}
+		imp.Path = strings.Join(elems, "/")
+	default:
+		p.addError("expected import path, got %s", describeToken(p.currToken))
+		p.skipDeclaration()
+		return nil
+	}
 
-func (e *ExpressionStatement) Type() NodeType { return e.Expression.Type() }
-func (e *ExpressionStatement) String() string { return e.Expression.String() }
-func (e *ExpressionStatement) statementNode() {}
+	if p.currToken.Type == TOKEN_DOT && p.peekToken.Type == TOKEN_LBRACE {
+		p.nextToken() // Skip '.'
+		if !p.parseImportNames(imp) {
+			return nil
+		}
+	}
 
-// BinaryOp represents a binary operation
-type BinaryOp struct {
-	Left     Expression
-	Operator string
-	Right    Expression
-}
+	// The alias and version can come in either order
+	for {
+		if p.currToken.Type == TOKEN_AS && imp.Alias == "" {
+			p.nextToken() // Skip 'as'
+			if p.currToken.Type != TOKEN_IDENT {
+				p.addError("expected import alias, got %s", describeToken(p.currToken))
+				p.skipDeclaration()
+				return nil
+			}
+			if len(imp.Names) > 0 {
+				p.addError("a selective import can't have an alias")
+			}
+			imp.Alias = p.currToken.Literal
+			p.nextToken()
+		} else if p.currToken.Type == TOKEN_IDENT && p.currToken.Literal == "version" && imp.Version == "" {
+			p.nextToken() // Skip 'version'
+			if p.currToken.Type != TOKEN_STRING {
+				p.addError("expected version constraint, got %s", describeToken(p.currToken))
+				p.skipDeclaration()
+				return nil
+			}
+			imp.Version = p.currToken.Literal
+			p.nextToken()
+		} else {
+			break
+		}
+	}
 
-func (b *BinaryOp) Type() NodeType { return NODE_BINARY_OP }
-func (b *BinaryOp) String() string { return fmt.Sprintf("BinaryOp(%s)", b.Operator) }
-func (b *BinaryOp) expressionNode() {}
+	if p.currToken.Type == TOKEN_SEMICOLON {
+		p.nextToken() // Skip ';'
+	}
 
-// CallExpression represents a function call
-type CallExpression struct {
-	Function string
-	Arguments []Expression
+	return imp
 }
 
-func (c *CallExpression) Type() NodeType { return NODE_CALL }
-func (c *CallExpression) String() string { return fmt.Sprintf("Call(%s)", c.Function) }
-func (c *CallExpression) expressionNode() {}
+// parseImportNames parses the names a selective import brings into scope:
+// { name, name, ... }
+func (p *Parser) parseImportNames(imp *ImportStatement) bool {
+	p.nextToken() // Skip '{'
 
-// Identifier represents a variable reference
-type Identifier struct {
-	Name string
-}
+	for p.currToken.Type != TOKEN_RBRACE {
+		if p.currToken.Type != TOKEN_IDENT {
+			p.addError("expected imported name, got %s", describeToken(p.currToken))
+			p.skipDeclaration()
+			return false
+		}
+		imp.Names = append(imp.Names, &Identifier{Token: p.currToken, Value: p.currToken.Literal})
+		p.nextToken()
 
-func (i *Identifier) Type() NodeType { return NODE_IDENT }
-func (i *Identifier) String() string { return fmt.Sprintf("Ident(%s)", i.Name) }
-func (i *Identifier) expressionNode() {}
+		if p.currToken.Type == TOKEN_COMMA {
+			p.nextToken()
+		} else if p.currToken.Type != TOKEN_RBRACE {
+			p.addError("expected ',' or '}' after imported name, got %s", describeToken(p.currToken))
+			p.skipDeclaration()
+			return false
+		}
+	}
+	p.nextToken() // Skip '}'
 
-// NumberLiteral represents an integer literal
-type NumberLiteral struct {
-	Value int64
+	if len(imp.Names) == 0 {
+		p.addError("expected at least one imported name")
+	}
+	return true
 }
 
-func (n *NumberLiteral) Type() NodeType { return NODE_NUMBER }
-func (n *NumberLiteral) String() string { return fmt.Sprintf("Number(%d)", n.Value) }
-func (n *NumberLiteral) expressionNode() {}
+// parseInterfaceDefinition parses an interface definition:
+// interface Name { field: type; ... }
+func (p *Parser) parseInterfaceDefinition() *InterfaceDefinition {
+	intf := &InterfaceDefinition{Token: p.currToken, Fields: []*FieldDefinition{}}
+	p.nextToken() // Skip 'interface'
 
-// FloatLiteral represents a float literal
-type FloatLiteral struct {
-	Value float64
+	if p.currToken.Type != TOKEN_IDENT {
+		p.addError("expected interface name, got %s", describeToken(p.currToken))
+		p.skipDeclaration()
+		return intf
+	}
+	intf.Token = p.currToken
+	intf.Name = p.currToken.Literal
+	p.nextToken()
+
+	intf.Fields = p.parseFieldBlock("interface")
+	return intf
 }
 
-func (f *FloatLiteral) Type() NodeType { return NODE_FLOAT }
-func (f *FloatLiteral) String() string { return fmt.Sprintf("Float(%f)", f.Value) }
-func (f *FloatLiteral) expressionNode() {}
+// parseStructDefinition parses a struct definition:
+// struct Name { field: type; ... }
+func (p *Parser) parseStructDefinition() *StructDefinition {
+	st := &StructDefinition{Token: p.currToken, Fields: []*FieldDefinition{}}
+	p.nextToken() // Skip 'struct'
+
+	if p.currToken.Type != TOKEN_IDENT {
+		p.addError("expected struct name, got %s", describeToken(p.currToken))
+		p.skipDeclaration()
+		return st
+	}
+	st.Token = p.currToken
+	st.Name = p.currToken.Literal
+	p.nextToken()
 
-// StringLiteral represents a string literal
-type StringLiteral struct {
-	Value string
+	st.Fields = p.parseFieldBlock("struct")
+	return st
 }
 
-func (s *StringLiteral) Type() NodeType { return NODE_STRING }
-func (s *StringLiteral) String() string { return fmt.Sprintf("String(%s)", s.Value) }
-func (s *StringLiteral) expressionNode() {}
+// parseEnumDefinition parses an enum definition:
+// enum Name { A, B, C }
+func (p *Parser) parseEnumDefinition() *EnumDefinition {
+	ed := &EnumDefinition{Token: p.currToken, Members: []*Identifier{}}
+	p.nextToken() // Skip 'enum'
 
-// BoolLiteral represents a boolean literal
-type BoolLiteral struct {
-	Value bool
-}
+	if p.currToken.Type != TOKEN_IDENT {
+		p.addError("expected enum name, got %s", describeToken(p.currToken))
+		p.skipDeclaration()
+		return ed
+	}
+	ed.Token = p.currToken
+	ed.Name = p.currToken.Literal
+	p.nextToken()
+
+	if p.currToken.Type != TOKEN_LBRACE {
+		p.addError("expected '{' after enum name, got %s", describeToken(p.currToken))
+		p.skipDeclaration()
+		return ed
+	}
+	p.nextToken() // Skip '{'
 
-func (b *BoolLiteral) Type() NodeType { return NODE_BOOL }
-func (b *BoolLiteral) String() string { return fmt.Sprintf("Bool(%t)", b.Value) }
-func (b *BoolLiteral) expressionNode() {}
+	for p.currToken.Type != TOKEN_RBRACE && !isDeclarationKeyword(p.currToken.Type) && p.currToken.Type != TOKEN_EOF {
+		if p.currToken.Type != TOKEN_IDENT {
+			p.addError("expected enum member, got %s", describeToken(p.currToken))
+			p.skipDeclaration()
+			return ed
+		}
+		ed.Members = append(ed.Members, &Identifier{Token: p.currToken, Value: p.currToken.Literal})
+		p.nextToken()
 
-// Parser parses tokens into an AST
-type Parser struct {
-	tokenizer *Tokenizer
-	currToken Token
-	peekToken Token
-	errors    []string
+		// Members are separated by commas, with an optional trailing comma
+		if p.currToken.Type == TOKEN_COMMA {
+			p.nextToken()
+		} else if p.currToken.Type != TOKEN_RBRACE {
+			p.addError("expected ',' or '}' after enum member, got %s", describeToken(p.currToken))
+			p.skipDeclaration()
+			return ed
+		}
+	}
+
+	if p.currToken.Type != TOKEN_RBRACE {
+		p.addError("expected '}', got %s", describeToken(p.currToken))
+		return ed
+	}
+	p.nextToken() // Skip '}'
+
+	if p.currToken.Type == TOKEN_SEMICOLON {
+		p.nextToken() // Skip ';'
+	}
+
+	return ed
 }
 
-// NewParser creates a new parser
-func NewParser(tokenizer *Tokenizer) *Parser {
-	p := &Parser{tokenizer: tokenizer}
-	
-	// Read two tokens to set currToken and peekToken
-	p.nextToken()
-	p.nextToken()
-	
-	return p
+// parseFieldBlock parses the braced field list of an interface or struct
+// definition
+func (p *Parser) parseFieldBlock(keyword string) []*FieldDefinition {
+	fields := []*FieldDefinition{}
+
+	if p.currToken.Type != TOKEN_LBRACE {
+		p.addError("expected '{' after %s name, got %s", keyword, describeToken(p.currToken))
+		p.skipDeclaration()
+		return fields
+	}
+	p.nextToken() // Skip '{'
+
+	for p.currToken.Type != TOKEN_RBRACE && !isDeclarationKeyword(p.currToken.Type) && p.currToken.Type != TOKEN_EOF {
+		if field := p.parseFieldDefinition(); field != nil {
+			fields = append(fields, field)
+		}
+	}
+
+	if p.currToken.Type != TOKEN_RBRACE {
+		p.addError("expected '}', got %s", describeToken(p.currToken))
+		return fields
+	}
+	p.nextToken() // Skip '}'
+
+	if p.currToken.Type == TOKEN_SEMICOLON {
+		p.nextToken() // Skip ';'
+	}
+
+	return fields
 }
 
-// Errors returns any errors encountered during parsing
-func (p *Parser) Errors() []string {
-	return p.errors
+// parseFieldDefinition parses a field written either as name: type or, in
+// C style, as type name. A malformed field is skipped up to the next ';'.
+func (p *Parser) parseFieldDefinition() *FieldDefinition {
+	field := &FieldDefinition{Token: p.currToken}
+
+	if p.currToken.Type == TOKEN_IDENT && p.peekToken.Type == TOKEN_COLON {
+		field.Name = p.currToken.Literal
+		p.nextToken() // Skip name
+		p.nextToken() // Skip ':'
+
+		typ, ok := p.parseType()
+		if !ok {
+			p.skipField()
+			return nil
+		}
+		field.Type = typ
+	} else {
+		typ, ok := p.parseType()
+		if !ok {
+			p.skipField()
+			return nil
+		}
+		field.Type = typ
+
+		if p.currToken.Type != TOKEN_IDENT {
+			p.addError("expected field name, got %s", describeToken(p.currToken))
+			p.skipField()
+			return nil
+		}
+		field.Token = p.currToken
+		field.Name = p.currToken.Literal
+		p.nextToken()
+	}
+
+	if p.currToken.Type == TOKEN_SEMICOLON || p.currToken.Type == TOKEN_COMMA {
+		p.nextToken() // Skip separator
+	}
+
+	return field
 }
 
-// nextToken advances to the next token
-func (p *Parser) nextToken() {
-	p.currToken = p.peekToken
-	p.peekToken = p.tokenizer.NextToken()
+// skipField skips the rest of a malformed field definition
+func (p *Parser) skipField() {
+	for p.currToken.Type != TOKEN_SEMICOLON && p.currToken.Type != TOKEN_RBRACE &&
+		!isDeclarationKeyword(p.currToken.Type) && p.currToken.Type != TOKEN_EOF {
+		p.nextToken()
+	}
+	if p.currToken.Type == TOKEN_SEMICOLON {
+		p.nextToken()
+	}
+	p.panicking = false
 }
 
-// Parse parses the program
-func (p *Parser) Parse() *Program {
-	program := &Program{
-		Functions: []*FunctionDecl{},
+// parseFunctionDefinition parses a function definition
+func (p *Parser) parseFunctionDefinition() *FunctionDefinition {
+	fn := &FunctionDefinition{
+		Token:      p.currToken,
+		Parameters: []*ParameterDefinition{},
+		ReturnType: TypeSpecifier{Token: p.currToken, TypeName: "void"}, // Default return type
+		Body:       &BlockStatement{Token: p.currToken, Statements: []Statement{}},
 	}
-	
-	for p.currToken.Type != TOKEN_EOF {
-		// Handle top-level statements (only functions for now)
-		if p.currToken.Type == TOKEN_FUNC {
-			if fn := p.parseFunctionDecl(); fn != nil {
-				program.Functions = append(program.Functions, fn)
-			}
-		} else {
-			p.nextToken() // Skip unexpected tokens at the top level
+	p.nextToken() // Skip 'func'
+
+	if p.parseFunctionSignature(fn) && p.currToken.Type != TOKEN_LBRACE {
+		p.addError("expected '{' to start function body, got %s", describeToken(p.currToken))
+	}
+
+	// After a bad signature, resume at the body if there is one
+	if p.panicking {
+		p.skipTo(TOKEN_LBRACE)
+		if p.currToken.Type != TOKEN_LBRACE {
+			return fn
 		}
 	}
-	
-	return program
+
+	fn.Body = p.parseBlockStatement()
+	return fn
 }
 
-// parseFunctionDecl parses a function declaration
-func (p *Parser) parseFunctionDecl() *FunctionDecl {
-	// Check 'func' keyword
-	if p.currToken.Type != TOKEN_FUNC {
-		p.addError("expected 'func', got %s", p.currToken.Literal)
-		return nil
-	}
-	p.nextToken()
-	
+// parseFunctionSignature parses the name, parameters and return type of a
+// function definition. It returns false if a syntax error was reported.
+func (p *Parser) parseFunctionSignature(fn *FunctionDefinition) bool {
 	// Parse function name
 	if p.currToken.Type != TOKEN_IDENT {
-		p.addError("expected function name, got %s", p.currToken.Literal)
-		return nil
+		p.addError("expected function name, got %s", describeToken(p.currToken))
+		return false
 	}
-	functionName := p.currToken.Literal
+	fn.Token = p.currToken
+	fn.Name = p.currToken.Literal
 	p.nextToken()
-	
-	// Parse parameters
+
+	params, ok := p.parseParameterList(nil)
+	fn.Parameters = params
+	if !ok {
+		return false
+	}
+
+	// Parse return type
+	if p.currToken.Type == TOKEN_ARROW {
+		p.nextToken() // Skip '->'
+
+		typ, ok := p.parseType()
+		if !ok {
+			return false
+		}
+		fn.ReturnType = typ
+	}
+
+	return true
+}
+
+// parseParameterList parses a parenthesized parameter list. If variadic
+// isn't nil, the list may end with '...', which sets it.
+func (p *Parser) parseParameterList(variadic *bool) ([]*ParameterDefinition, bool) {
+	params := []*ParameterDefinition{}
+
 	if p.currToken.Type != TOKEN_LPAREN {
-		p.addError("expected '(' after function name, got %s", p.currToken.Literal)
-		return nil
+		p.addError("expected '(' after function name, got %s", describeToken(p.currToken))
+		return params, false
 	}
 	p.nextToken() // Skip '('
-	
-	params := []*VarDecl{}
-	
-	// Parse parameter list
-	if p.currToken.Type != TOKEN_RPAREN {
-		// At least one parameter
-		param := p.parseParameter()
-		if param != nil {
-			params = append(params, param)
-		}
-		
-		// Parse additional parameters
-		for p.currToken.Type == TOKEN_COMMA {
-			p.nextToken() // Skip ','
-			param = p.parseParameter()
-			if param != nil {
-				params = append(params, param)
+
+	for p.currToken.Type != TOKEN_RPAREN {
+		if p.currToken.Type == TOKEN_ELLIPSIS {
+			if variadic == nil {
+				p.addError("only extern functions can be variadic")
+				return params, false
 			}
+			*variadic = true
+			p.nextToken() // Skip '...'
+			break
+		}
+
+		params = append(params, p.parseParameter())
+		if p.currToken.Type != TOKEN_COMMA {
+			break
+		}
+		p.nextToken() // Skip ','
+		if p.currToken.Type == TOKEN_RPAREN {
+			p.addError("expected parameter name, got %s", describeToken(p.currToken))
+			return params, false
 		}
 	}
-	
+
 	// Check for closing parenthesis
 	if p.currToken.Type != TOKEN_RPAREN {
-		p.addError("expected ')' after parameters, got %s", p.currToken.Literal)
-		return nil
+		p.addError("expected ')' after parameters, got %s", describeToken(p.currToken))
+		return params, false
 	}
 	p.nextToken() // Skip ')'
-	
-	// Parse return type
-	var returnType string = "void" // Default return type
-	
-	if p.currToken.Type == TOKEN_ARROW {
-		p.nextToken() // Skip '->'
-		
-		switch p.currToken.Type {
-		case TOKEN_TYPE_INT:
-			returnType = "int"
-		case TOKEN_TYPE_FLOAT:
-			returnType = "float"
-		case TOKEN_TYPE_STRING:
-			returnType = "string"
-		case TOKEN_TYPE_BOOL:
-			returnType = "bool"
-		case TOKEN_TYPE_VOID:
-			returnType = "void"
-		default:
-			p.addError("expected return type, got %s", p.currToken.Literal)
-			return nil
+
+	return params, true
+}
+
+// parseAttributes parses the attributes before a declaration:
+// @name("arg", ...). The parentheses may be left out when there are no
+// arguments.
+func (p *Parser) parseAttributes() []*Attribute {
+	attrs := []*Attribute{}
+	for p.currToken.Type == TOKEN_AT {
+		p.nextToken() // Skip '@'
+		if p.currToken.Type != TOKEN_IDENT {
+			p.addError("expected attribute name after '@', got %s", describeToken(p.currToken))
+			return attrs
 		}
-		p.nextToken() // Skip return type
+		attr := &Attribute{Token: p.currToken, Name: p.currToken.Literal, Args: []string{}}
+		attrs = append(attrs, attr)
+		p.nextToken()
+
+		if p.currToken.Type != TOKEN_LPAREN {
+			continue
+		}
+		p.nextToken() // Skip '('
+		for p.currToken.Type == TOKEN_STRING {
+			attr.Args = append(attr.Args, p.currToken.Literal)
+			p.nextToken()
+			if p.currToken.Type != TOKEN_COMMA {
+				break
+			}
+			p.nextToken() // Skip ','
+		}
+		if p.currToken.Type != TOKEN_RPAREN {
+			p.addError("expected string or ')' in attribute @%s, got %s", attr.Name, describeToken(p.currToken))
+			return attrs
+		}
+		p.nextToken() // Skip ')'
 	}
-	
-	// Parse function body
-	if p.currToken.Type != TOKEN_LBRACE {
-		p.addError("expected '{' to start function body, got %s", p.currToken.Literal)
+	return attrs
+}
+
+// parseExternFunction parses the declaration of a function implemented
+// outside Nova: extern "C" func name(params, ...) -> type;
+func (p *Parser) parseExternFunction(attrs []*Attribute) *ExternFunction {
+	ext := &ExternFunction{
+		Token:      p.currToken,
+		ABI:        "C",
+		Attributes: attrs,
+		ReturnType: TypeSpecifier{Token: p.currToken, TypeName: "void"},
+	}
+	p.nextToken() // Skip 'extern'
+
+	if p.currToken.Type == TOKEN_STRING {
+		ext.ABI = p.currToken.Literal
+		p.nextToken()
+	}
+	if p.currToken.Type != TOKEN_FUNC {
+		p.addError("expected 'func' after extern, got %s", describeToken(p.currToken))
+		p.skipDeclaration()
+		return nil
+	}
+	p.nextToken() // Skip 'func'
+
+	if p.currToken.Type != TOKEN_IDENT {
+		p.addError("expected function name, got %s", describeToken(p.currToken))
+		p.skipDeclaration()
+		return nil
+	}
+	ext.Token = p.currToken
+	ext.Name = p.currToken.Literal
+	ext.Symbol = ext.Name
+	p.nextToken()
+
+	params, ok := p.parseParameterList(&ext.Variadic)
+	ext.Parameters = params
+	if ok && p.currToken.Type == TOKEN_ARROW {
+		p.nextToken() // Skip '->'
+		ext.ReturnType, ok = p.parseType()
+	}
+	if !ok {
+		p.skipDeclaration()
 		return nil
 	}
-	
-	body := p.parseBlock()
-	
-	return &FunctionDecl{
-		Name:       functionName,
-		Parameters: params,
-		ReturnType: returnType,
-		Body:       body,
+
+	if p.currToken.Type == TOKEN_LBRACE {
+		p.addError("extern function %s can't have a body", ext.Name)
+		p.skipDeclaration()
+		return nil
 	}
+	p.skipSemicolon()
+	return ext
 }
 
-// parseParameter parses a function parameter
-func (p *Parser) parseParameter() *VarDecl {
+// parseParameter parses a function parameter. A malformed parameter is
+// skipped up to the next ',' or ')' so the rest of the list can be parsed.
+func (p *Parser) parseParameter() *ParameterDefinition {
+	param := &ParameterDefinition{Token: p.currToken}
+
 	// Get parameter name
 	if p.currToken.Type != TOKEN_IDENT {
-		p.addError("expected parameter name, got %s", p.currToken.Literal)
-		return nil
+		p.addError("expected parameter name, got %s", describeToken(p.currToken))
+		p.skipParameter()
+		return param
 	}
-	paramName := p.currToken.Literal
+	param.Name = p.currToken.Literal
 	p.nextToken()
-	
+
 	// Check for type separator
 	if p.currToken.Type != TOKEN_COLON {
-		p.addError("expected ':' after parameter name, got %s", p.currToken.Literal)
-		return nil
+		p.addError("expected ':' after parameter name, got %s", describeToken(p.currToken))
+		p.skipParameter()
+		return param
 	}
 	p.nextToken() // Skip ':'
-	
+
 	// Get parameter type
-	var paramType string
+	typ, ok := p.parseType()
+	if !ok {
+		p.skipParameter()
+		return param
+	}
+	param.Type = typ
+
+	return param
+}
+
+// skipParameter skips the rest of a malformed parameter. Panic mode ends
+// only at a ',' or ')', where the list can go on; stopping at the body's '{'
+// leaves the missing ')' to the error already reported.
+func (p *Parser) skipParameter() {
+	for p.currToken.Type != TOKEN_COMMA && p.currToken.Type != TOKEN_RPAREN &&
+		p.currToken.Type != TOKEN_LBRACE && p.currToken.Type != TOKEN_EOF {
+		p.nextToken()
+	}
+	if p.currToken.Type == TOKEN_COMMA || p.currToken.Type == TOKEN_RPAREN {
+		p.panicking = false
+	}
+}
+
+// parseType parses a type specifier: a named type, *T, [N]T or []T. The C
+// style T* is accepted for pointers too.
+func (p *Parser) parseType() (TypeSpecifier, bool) {
+	tok := p.currToken
+	var typ TypeSpecifier
+
 	switch p.currToken.Type {
-	case TOKEN_TYPE_INT:
-		paramType = "int"
-	case TOKEN_TYPE_FLOAT:
-		paramType = "float"
-	case TOKEN_TYPE_STRING:
-		paramType = "string"
-	case TOKEN_TYPE_BOOL:
-		paramType = "bool"
+	case TOKEN_STAR:
+		p.nextToken() // Skip '*'
+
+		elem, ok := p.parseType()
+		if !ok {
+			return elem, false
+		}
+		typ = TypeSpecifier{Token: tok, IsPointer: true, Elem: &elem}
+
+	case TOKEN_LBRACKET:
+		p.nextToken() // Skip '['
+
+		// No length means a slice
+		length := -1
+		if p.currToken.Type == TOKEN_NUMBER {
+			n, err := strconv.Atoi(p.currToken.Literal)
+			if err != nil {
+				p.addError("invalid array length: %s", p.currToken.Literal)
+				return typ, false
+			}
+			length = n
+			p.nextToken() // Skip length
+		}
+
+		if p.currToken.Type != TOKEN_RBRACKET {
+			p.addError("expected ']' in array type, got %s", describeToken(p.currToken))
+			return typ, false
+		}
+		p.nextToken() // Skip ']'
+
+		elem, ok := p.parseType()
+		if !ok {
+			return elem, false
+		}
+		typ = TypeSpecifier{Token: tok, Elem: &elem, Length: length}
+
+	case TOKEN_FUNC:
+		fn, ok := p.parseFuncType()
+		if !ok {
+			return typ, false
+		}
+		typ = TypeSpecifier{Token: tok, Func: fn}
+
+	case TOKEN_IDENT:
+		typ = TypeSpecifier{Token: tok, TypeName: p.currToken.Literal}
+		p.nextToken() // Skip type name
+
+		// A type from another module is qualified: math.Vec
+		if p.currToken.Type == TOKEN_DOT {
+			p.nextToken() // Skip '.'
+			if p.currToken.Type != TOKEN_IDENT {
+				p.addError("expected type name after '%s.', got %s", typ.TypeName, describeToken(p.currToken))
+				return typ, false
+			}
+			typ.TypeName += "." + p.currToken.Literal
+			p.nextToken() // Skip type name
+		}
+
 	default:
-		p.addError("expected type, got %s", p.currToken.Literal)
-		return nil
+		if isTypeKeyword(p.currToken.Type) {
+			typ = TypeSpecifier{Token: tok, TypeName: p.currToken.Literal}
+			p.nextToken() // Skip type name
+			break
+		}
+
+		p.addError("expected type, got %s", describeToken(p.currToken))
+		return typ, false
+	}
+
+	for p.currToken.Type == TOKEN_STAR {
+		elem := typ
+		typ = TypeSpecifier{Token: tok, IsPointer: true, Elem: &elem}
+		p.nextToken() // Skip '*'
+	}
+
+	return typ, true
+}
+
+// parseFuncType parses the signature of a function type:
+// func(type, ...) [-> type]
+func (p *Parser) parseFuncType() (*FuncType, bool) {
+	p.nextToken() // Skip 'func'
+
+	if p.currToken.Type != TOKEN_LPAREN {
+		p.addError("expected '(' in function type, got %s", describeToken(p.currToken))
+		return nil, false
+	}
+	p.nextToken() // Skip '('
+
+	fn := &FuncType{}
+	for p.currToken.Type != TOKEN_RPAREN {
+		param, ok := p.parseType()
+		if !ok {
+			return nil, false
+		}
+		fn.Params = append(fn.Params, param)
+
+		if p.currToken.Type == TOKEN_COMMA {
+			p.nextToken() // Skip ','
+			if p.currToken.Type == TOKEN_RPAREN {
+				p.addError("expected type after ',' in function type")
+				return nil, false
+			}
+		} else if p.currToken.Type != TOKEN_RPAREN {
+			p.addError("expected ',' or ')' in function type, got %s", describeToken(p.currToken))
+			return nil, false
+		}
 	}
-	p.nextToken() // Skip type
-	
-	return &VarDecl{
-		Name:    paramName,
-		VarType: paramType,
+	p.nextToken() // Skip ')'
+
+	if p.currToken.Type == TOKEN_ARROW {
+		p.nextToken() // Skip '->'
+		ret, ok := p.parseType()
+		if !ok {
+			return nil, false
+		}
+		fn.ReturnType = ret
 	}
+	return fn, true
 }
 
-// parseBlock parses a block of statements
-func (p *Parser) parseBlock() *Block {
-	block := &Block{
+// parseBlockStatement parses a block of statements
+func (p *Parser) parseBlockStatement() *BlockStatement {
+	block := &BlockStatement{
+		Token:      p.currToken,
 		Statements: []Statement{},
 	}
-	
+
 	if p.currToken.Type != TOKEN_LBRACE {
-		p.addError("expected '{', got %s", p.currToken.Literal)
+		p.addError("expected '{', got %s", describeToken(p.currToken))
 		return block
 	}
 	p.nextToken() // Skip '{'
-	
-	for p.currToken.Type != TOKEN_RBRACE && p.currToken.Type != TOKEN_EOF {
-		// Skip comments
-		if p.currToken.Type == TOKEN_COMMENT {
+
+	// A declaration keyword can't appear in a block, so it means the closing
+	// brace is missing and the next declaration has started
+	for p.currToken.Type != TOKEN_RBRACE && !isDeclarationKeyword(p.currToken.Type) && p.currToken.Type != TOKEN_EOF {
+		// Stray semicolons are empty statements
+		if p.currToken.Type == TOKEN_SEMICOLON {
 			p.nextToken()
 			continue
 		}
-		
-		stmt := p.parseStatement()
-		if stmt != nil {
-			block.Statements = append(block.Statements, stmt)
-		}
+		block.Statements = append(block.Statements, p.parseStatement())
 	}
-	
+
 	if p.currToken.Type != TOKEN_RBRACE {
-		p.addError("expected '}', got %s", p.currToken.Literal)
+		// Already at a point the caller can resume from
+		p.addError("expected '}', got %s", describeToken(p.currToken))
+		p.panicking = false
 		return block
 	}
 	p.nextToken() // Skip '}'
-	
+
 	return block
 }
 
-// parseStatement parses a statement
+// parseStatement parses a statement. If the statement contains a syntax error,
+// the parser skips to the next statement boundary and returns an ErrorStatement.
 func (p *Parser) parseStatement() Statement {
+	startToken := p.currToken
+	stmt := p.parseStatementKind()
+
+	if !p.panicking && p.currToken != startToken {
+		p.trace(stmt, startToken)
+		return stmt
+	}
+
+	// Always make progress, even on a token no statement can start with
+	if p.currToken == startToken {
+		p.addError("unexpected %s", describeToken(p.currToken))
+		p.nextToken()
+	}
+	p.synchronize()
+
+	return &ErrorStatement{Token: startToken}
+}
+
+// trace traces a node that has been parsed, starting at a token
+func (p *Parser) trace(node Node, start Token) {
+	if p.log.Tracing("parse") {
+		p.log.Trace("parse", TokenSpan(start).Position(), compiler.NodeKind(node), node.TokenLiteral())
+	}
+}
+
+// parseStatementKind dispatches on the first token of a statement
+func (p *Parser) parseStatementKind() Statement {
 	switch p.currToken.Type {
-	case TOKEN_TYPE_INT, TOKEN_TYPE_FLOAT, TOKEN_TYPE_STRING, TOKEN_TYPE_BOOL:
-		return p.parseVarDecl()
+	case TOKEN_LBRACE:
+		return p.parseBlockStatement()
 	case TOKEN_VAR:
-		return p.parseInferredVarDecl()
+		return p.parseVarStatement()
 	case TOKEN_RETURN:
 		return p.parseReturnStatement()
 	case TOKEN_IF:
 		return p.parseIfStatement()
 	case TOKEN_WHILE:
 		return p.parseWhileStatement()
+	case TOKEN_FOR:
+		return p.parseForStatement()
+	case TOKEN_SWITCH:
+		return p.parseSwitchStatement()
+	case TOKEN_UNSAFE:
+		stmt := &UnsafeStatement{Token: p.currToken}
+		p.nextToken() // Skip 'unsafe'
+		stmt.Body = p.parseBlockStatement()
+		return stmt
+	case TOKEN_BREAK:
+		stmt := &BreakStatement{Token: p.currToken}
+		p.nextToken() // Skip 'break'
+		p.skipSemicolon()
+		return stmt
+	case TOKEN_CONTINUE:
+		stmt := &ContinueStatement{Token: p.currToken}
+		p.nextToken() // Skip 'continue'
+		p.skipSemicolon()
+		return stmt
 	case TOKEN_IDENT:
-		// This could be an assignment or a function call
-		if p.peekToken.Type == TOKEN_EQUALS {
-			return p.parseAssignStatement()
-		} else {
-			expr := p.parseExpression()
-			if p.currToken.Type == TOKEN_SEMICOLON {
-				p.nextToken() // Skip ';'
-			}
-			return &ExpressionStatement{Expression: expr}
+		// Two identifiers in a row declare a variable of a named type
+		if p.peekToken.Type == TOKEN_IDENT {
+			return p.parseTypedDeclaration()
 		}
+		return p.parseExpressionStatement()
 	default:
-		// Try to parse as expression (function call, etc.)
-		expr := p.parseExpression()
-		if p.currToken.Type == TOKEN_SEMICOLON {
-			p.nextToken() // Skip ';'
+		// A type keyword starts a C-style declaration, unless it's a
+		// conversion like int64(x). Only void* declares a variable.
+		if isTypeKeyword(p.currToken.Type) && p.peekToken.Type != TOKEN_LPAREN &&
+			(p.currToken.Type != TOKEN_TYPE_VOID || p.peekToken.Type == TOKEN_STAR) {
+			return p.parseTypedDeclaration()
 		}
-		return &ExpressionStatement{Expression: expr}
+
+		// Try to parse as expression (function call, assignment, etc.)
+		return p.parseExpressionStatement()
 	}
 }
 
-// parseVarDecl parses a variable declaration with explicit type
-func (p *Parser) parseVarDecl() *VarDecl {
-	// Get type
-	var varType string
-	switch p.currToken.Type {
-	case TOKEN_TYPE_INT:
-		varType = "int"
-	case TOKEN_TYPE_FLOAT:
-		varType = "float"
-	case TOKEN_TYPE_STRING:
-		varType = "string"
-	case TOKEN_TYPE_BOOL:
-		varType = "bool"
-	default:
-		p.addError("expected type, got %s", p.currToken.Literal)
-		return nil
-	}
-	p.nextToken() // Skip type
-	
+// parseVarStatement parses a declaration of the form
+// var name [: type] [= value]. Without a type, the type is inferred from
+// the value.
+func (p *Parser) parseVarStatement() *VariableDeclarationStatement {
+	p.nextToken() // Skip 'var'
+
 	// Get variable name
 	if p.currToken.Type != TOKEN_IDENT {
-		p.addError("expected variable name, got %s", p.currToken.Literal)
+		p.addError("expected variable name, got %s", describeToken(p.currToken))
 		return nil
 	}
-	varName := p.currToken.Literal
+	decl := &VariableDeclarationStatement{Token: p.currToken, Name: p.currToken.Literal}
 	p.nextToken() // Skip variable name
-	
-	// Check for initialization
-	var value Expression
+
+	// Optional type annotation
+	if p.currToken.Type == TOKEN_COLON {
+		p.nextToken() // Skip ':'
+
+		typ, ok := p.parseType()
+		if !ok {
+			return nil
+		}
+		decl.Type = typ
+	}
+
 	if p.currToken.Type == TOKEN_EQUALS {
 		p.nextToken() // Skip '='
-		value = p.parseExpression()
-	}
-	
-	// Check for semicolon
-	if p.currToken.Type == TOKEN_SEMICOLON {
-		p.nextToken() // Skip ';'
-	}
-	
-	return &VarDecl{
-		Name:    varName,
-		VarType: varType,
-		Value:   value,
+		decl.Value = p.parseExpression()
+	} else if decl.Type.IsEmpty() {
+		p.addError("expected ':' or '=' after variable name, got %s", describeToken(p.currToken))
+		return nil
 	}
+
+	p.skipSemicolon()
+	return decl
 }
 
-// parseInferredVarDecl parses a variable declaration with type inference
-func (p *Parser) parseInferredVarDecl() *VarDecl {
-	// Skip 'var' keyword
-	p.nextToken()
-	
+// parseTypedDeclaration parses a C-style declaration: type name [= value]
+func (p *Parser) parseTypedDeclaration() *VariableDeclarationStatement {
+	typ, ok := p.parseType()
+	if !ok {
+		return nil
+	}
+
 	// Get variable name
 	if p.currToken.Type != TOKEN_IDENT {
-		p.addError("expected variable name, got %s", p.currToken.Literal)
+		p.addError("expected variable name, got %s", describeToken(p.currToken))
 		return nil
 	}
-	varName := p.currToken.Literal
+	decl := &VariableDeclarationStatement{Token: p.currToken, Type: typ, Name: p.currToken.Literal}
 	p.nextToken() // Skip variable name
-	
+
 	// Check for initialization
-	if p.currToken.Type != TOKEN_EQUALS {
-		p.addError("expected '=', got %s", p.currToken.Literal)
-		return nil
-	}
-	p.nextToken() // Skip '='
-	
-	value := p.parseExpression()
-	
-	// Check for semicolon
-	if p.currToken.Type == TOKEN_SEMICOLON {
-		p.nextToken() // Skip ';'
-	}
-	
-	return &VarDecl{
-		Name:           varName,
-		Value:          value,
-		IsTypeInferred: true,
+	if p.currToken.Type == TOKEN_EQUALS {
+		p.nextToken() // Skip '='
+		decl.Value = p.parseExpression()
 	}
+
+	p.skipSemicolon()
+	return decl
 }
 
 // parseReturnStatement parses a return statement
 func (p *Parser) parseReturnStatement() *ReturnStatement {
 	// Skip 'return' keyword
+	ret := &ReturnStatement{Token: p.currToken}
 	p.nextToken()
-	
-	var value Expression
-	if p.currToken.Type != TOKEN_SEMICOLON {
-		value = p.parseExpression()
-	}
-	
-	// Check for semicolon
-	if p.currToken.Type == TOKEN_SEMICOLON {
-		p.nextToken() // Skip ';'
+
+	if p.currToken.Type != TOKEN_SEMICOLON && p.currToken.Type != TOKEN_RBRACE {
+		ret.ReturnValue = p.parseExpression()
 	}
-	
-	return &ReturnStatement{Value: value}
+
+	p.skipSemicolon()
+	return ret
 }
 
-// parseIfStatement parses an if statement
+// parseExpressionStatement parses an expression used as a statement
+func (p *Parser) parseExpressionStatement() *ExpressionStatement {
+	stmt := &ExpressionStatement{Token: p.currToken}
+	stmt.Expression = p.parseExpression()
+
+	p.skipSemicolon()
+	return stmt
+}
+
+// parseIfStatement parses an if statement. An else-if chain is represented
+// as an else block holding a single nested if statement.
 func (p *Parser) parseIfStatement() *IfStatement {
 	// Skip 'if' keyword
+	stmt := &IfStatement{Token: p.currToken}
 	p.nextToken()
-	
-	// Parse condition
-	if p.currToken.Type != TOKEN_LPAREN {
-		p.addError("expected '(' after 'if', got %s", p.currToken.Literal)
-		return nil
-	}
-	p.nextToken() // Skip '('
-	
-	condition := p.parseExpression()
-	
-	if p.currToken.Type != TOKEN_RPAREN {
-		p.addError("expected ')' after condition, got %s", p.currToken.Literal)
-		return nil
-	}
-	p.nextToken() // Skip ')'
-	
+
+	stmt.Condition = p.parseCondition("if")
+
 	// Parse then block
-	thenBlock := p.parseBlock()
-	
+	stmt.Consequence = p.parseBlockStatement()
+
 	// Parse optional else block
-	var elseBlock *Block
 	if p.currToken.Type == TOKEN_ELSE {
 		p.nextToken() // Skip 'else'
-		elseBlock = p.parseBlock()
+
+		if p.currToken.Type == TOKEN_IF {
+			elseToken := p.currToken
+			stmt.Alternative = &BlockStatement{
+				Token:      elseToken,
+				Statements: []Statement{p.parseIfStatement()},
+			}
+		} else {
+			stmt.Alternative = p.parseBlockStatement()
+		}
+	}
+
+	return stmt
+}
+
+// parseWhileStatement parses a while statement
+func (p *Parser) parseWhileStatement() *WhileStatement {
+	// Skip 'while' keyword
+	stmt := &WhileStatement{Token: p.currToken}
+	p.nextToken()
+
+	stmt.Condition = p.parseCondition("while")
+
+	// Parse body
+	stmt.Body = p.parseBlockStatement()
+
+	return stmt
+}
+
+// parseForStatement parses a C-style for loop:
+// for (init; condition; update) { ... }
+// Each of the three clauses may be empty.
+func (p *Parser) parseForStatement() *ForStatement {
+	stmt := &ForStatement{Token: p.currToken}
+	p.nextToken() // Skip 'for'
+
+	if p.currToken.Type != TOKEN_LPAREN {
+		p.addError("expected '(' after 'for', got %s", describeToken(p.currToken))
+		p.skipTo(TOKEN_LBRACE)
+		stmt.Body = p.parseBlockStatement()
+		return stmt
+	}
+	p.nextToken() // Skip '('
+
+	// The init clause is a declaration or expression statement, which
+	// consumes its own ';'
+	if p.currToken.Type == TOKEN_SEMICOLON {
+		p.nextToken()
+	} else {
+		stmt.Init = p.parseStatementKind()
+		if !p.panicking && p.prevToken.Type != TOKEN_SEMICOLON {
+			p.addError("expected ';' after for loop initializer, got %s", describeToken(p.currToken))
+		}
+	}
+
+	if !p.panicking && p.currToken.Type != TOKEN_SEMICOLON {
+		stmt.Condition = p.parseExpression()
+	}
+	if !p.panicking && p.currToken.Type != TOKEN_SEMICOLON {
+		p.addError("expected ';' after for loop condition, got %s", describeToken(p.currToken))
+	}
+	if p.currToken.Type == TOKEN_SEMICOLON {
+		p.nextToken() // Skip ';'
+	}
+
+	if !p.panicking && p.currToken.Type != TOKEN_RPAREN {
+		stmt.Update = p.parseExpression()
 	}
-	
-	return &IfStatement{
-		Condition: condition,
-		ThenBlock: thenBlock,
-		ElseBlock: elseBlock,
+	if !p.panicking && p.currToken.Type != TOKEN_RPAREN {
+		p.addError("expected ')' after for clauses, got %s", describeToken(p.currToken))
 	}
+
+	if p.panicking {
+		p.skipTo(TOKEN_LBRACE)
+	} else {
+		p.nextToken() // Skip ')'
+	}
+
+	stmt.Body = p.parseBlockStatement()
+	return stmt
 }
 
-// parseWhileStatement parses a while statement
-func (p *Parser) parseWhileStatement() *WhileStatement {
-	// Skip 'while' keyword
-	p.nextToken()
-	
-	// Parse condition
-	if p.currToken.Type != TOKEN_LPAREN {
-		p.addError("expected '(' after 'while', got %s", p.currToken.Literal)
-		return nil
+// parseSwitchStatement parses a switch statement. Each case runs until the
+// next case or default label:
+// switch value { case 1: ... default: ... }
+func (p *Parser) parseSwitchStatement() *SwitchStatement {
+	stmt := &SwitchStatement{Token: p.currToken, Cases: []*CaseStatement{}}
+	p.nextToken() // Skip 'switch'
+
+	stmt.Value = p.parseCondition("switch")
+
+	if p.currToken.Type != TOKEN_LBRACE {
+		p.addError("expected '{' after switch value, got %s", describeToken(p.currToken))
+		return stmt
 	}
-	p.nextToken() // Skip '('
-	
-	condition := p.parseExpression()
-	
-	if p.currToken.Type != TOKEN_RPAREN {
-		p.addError("expected ')' after condition, got %s", p.currToken.Literal)
-		return nil
+	p.nextToken() // Skip '{'
+
+	for p.currToken.Type == TOKEN_CASE || p.currToken.Type == TOKEN_DEFAULT {
+		labelToken := p.currToken
+		p.nextToken() // Skip 'case' or 'default'
+
+		var value Expression
+		if labelToken.Type == TOKEN_CASE {
+			value = p.parseExpression()
+		}
+
+		if p.currToken.Type != TOKEN_COLON {
+			p.addError("expected ':' after %s label, got %s", labelToken.Literal, describeToken(p.currToken))
+			p.skipTo(TOKEN_COLON)
+		}
+		if p.currToken.Type == TOKEN_COLON {
+			p.nextToken() // Skip ':'
+		}
+
+		block := p.parseCaseBody(labelToken)
+
+		if labelToken.Type == TOKEN_DEFAULT {
+			if stmt.Default != nil {
+				p.diagnostics = append(p.diagnostics, compiler.NewDiagnostic(compiler.CodeSyntax, TokenSpan(labelToken),
+					"multiple default labels in switch"))
+			}
+			stmt.Default = block
+			continue
+		}
+		stmt.Cases = append(stmt.Cases, &CaseStatement{Token: labelToken, Value: value, Block: block})
 	}
-	p.nextToken() // Skip ')'
-	
-	// Parse body
-	body := p.parseBlock()
-	
-	return &WhileStatement{
-		Condition: condition,
-		Body:      body,
+
+	if p.currToken.Type != TOKEN_RBRACE {
+		p.addError("expected 'case', 'default' or '}', got %s", describeToken(p.currToken))
+		return stmt
 	}
+	p.nextToken() // Skip '}'
+
+	return stmt
 }
 
-// parseAssignStatement parses an assignment statement
-func (p *Parser) parseAssignStatement() *AssignStatement {
-	// Get variable name
-	varName := p.currToken.Literal
-	p.nextToken() // Skip variable name
-	
-	// Skip '='
-	if p.currToken.Type != TOKEN_EQUALS {
-		p.addError("expected '=', got %s", p.currToken.Literal)
-		return nil
+// parseCaseBody parses the statements following a case or default label
+func (p *Parser) parseCaseBody(labelToken Token) *BlockStatement {
+	block := &BlockStatement{Token: labelToken, Statements: []Statement{}}
+
+	for p.currToken.Type != TOKEN_CASE && p.currToken.Type != TOKEN_DEFAULT &&
+		p.currToken.Type != TOKEN_RBRACE && !isDeclarationKeyword(p.currToken.Type) &&
+		p.currToken.Type != TOKEN_EOF {
+		if p.currToken.Type == TOKEN_SEMICOLON {
+			p.nextToken()
+			continue
+		}
+		block.Statements = append(block.Statements, p.parseStatement())
 	}
-	p.nextToken() // Skip '='
-	
-	// Parse value
-	value := p.parseExpression()
-	
-	// Check for semicolon
-	if p.currToken.Type == TOKEN_SEMICOLON {
-		p.nextToken() // Skip ';'
+
+	return block
+}
+
+// parseCondition parses the condition of an if, while or switch statement.
+// Parentheses are optional. A malformed condition is skipped up to the '{'
+// of the body so the body itself is still parsed.
+func (p *Parser) parseCondition(keyword string) Expression {
+	startToken := p.currToken
+
+	if p.currToken.Type == TOKEN_LBRACE {
+		p.addError("missing condition after '%s'", keyword)
+		return &ErrorExpression{Token: startToken}
 	}
-	
-	return &AssignStatement{
-		Name:  varName,
-		Value: value,
+
+	// A '{' after the condition starts the body, not a struct literal
+	outer := p.exprLevel
+	p.exprLevel = -1
+	condition := p.parseExpression()
+	p.exprLevel = outer
+
+	if p.panicking || p.currToken.Type != TOKEN_LBRACE {
+		p.addError("expected '{' after %s condition, got %s", keyword, describeToken(p.currToken))
+		p.skipTo(TOKEN_LBRACE)
+		return &ErrorExpression{Token: startToken}
 	}
+
+	return condition
 }
 
-// parseExpression parses an expression
+// parseExpression parses an expression, including assignments. Assignment
+// has the lowest precedence and is right associative.
 func (p *Parser) parseExpression() Expression {
-	left := p.parsePrimaryExpression()
-	
+	startToken := p.currToken
+	left := p.parseUnaryExpression()
+
 	// Check for binary operators
 	if p.isBinaryOp(p.currToken) {
-		return p.parseBinaryOp(left, 0)
+		left = p.parseBinaryOp(left, 0)
+	}
+
+	switch p.currToken.Type {
+	case TOKEN_EQUALS:
+		tok := p.currToken
+		p.nextToken() // Skip '='
+		left = &AssignmentExpression{Token: tok, Left: left, Value: p.parseExpression()}
+	case TOKEN_PLUS_EQUALS, TOKEN_MINUS_EQUALS, TOKEN_STAR_EQUALS, TOKEN_SLASH_EQUALS,
+		TOKEN_PERCENT_EQUALS, TOKEN_AMPERSAND_EQUALS, TOKEN_PIPE_EQUALS, TOKEN_CARET_EQUALS,
+		TOKEN_SHIFT_LEFT_EQUALS, TOKEN_SHIFT_RIGHT_EQUALS:
+		tok := p.currToken
+		p.nextToken() // Skip operator
+		left = &CompoundAssignmentExpression{Token: tok, Left: left, Operator: tok.Literal, Value: p.parseExpression()}
 	}
-	
+
+	p.trace(left, startToken)
 	return left
 }
 
 // parseBinaryOp parses a binary operation with precedence
 func (p *Parser) parseBinaryOp(left Expression, minPrecedence int) Expression {
 	for p.isBinaryOp(p.currToken) && p.getPrecedence(p.currToken) >= minPrecedence {
+		opToken := p.currToken
 		op := p.currToken.Literal
 		precedence := p.getPrecedence(p.currToken)
 		p.nextToken() // Skip operator
-		
-		right := p.parsePrimaryExpression()
-		
+
+		right := p.parseUnaryExpression()
+
 		// Check for higher precedence operators on the right
 		for p.isBinaryOp(p.currToken) && p.getPrecedence(p.currToken) > precedence {
 			right = p.parseBinaryOp(right, p.getPrecedence(p.currToken))
 		}
-		
+
 		// Create binary operation
-		left = &BinaryOp{
+		left = &InfixExpression{
+			Token:    opToken,
 			Left:     left,
 			Operator: op,
 			Right:    right,
 		}
 	}
-	
+
 	return left
 }
 
-// parsePrimaryExpression parses a primary expression (literal, identifier, or function call)
-func (p *Parser) parsePrimaryExpression() Expression {
+// parseUnaryExpression parses a postfix expression preceded by any number of
+// unary operators, which bind less tightly than calls and indexing: -a[i] is
+// -(a[i])
+func (p *Parser) parseUnaryExpression() Expression {
 	switch p.currToken.Type {
-	case TOKEN_IDENT:
-		// This could be a variable reference or function call
-		id := p.currToken.Literal
-		p.nextToken()
-		
-		if p.currToken.Type == TOKEN_LPAREN {
+	case TOKEN_MINUS, TOKEN_EXCLAMATION, TOKEN_TILDE, TOKEN_AMPERSAND, TOKEN_STAR:
+		tok := p.currToken
+		p.nextToken() // Skip operator
+		return &PrefixExpression{Token: tok, Operator: tok.Literal, Right: p.parseUnaryExpression()}
+	default:
+		return p.parsePostfixExpression()
+	}
+}
+
+// parsePostfixExpression parses a primary expression followed by any number
+// of calls, index operations and member accesses
+func (p *Parser) parsePostfixExpression() Expression {
+	expr := p.parsePrimaryExpression()
+
+	for !p.panicking {
+		tok := p.currToken
+
+		switch p.currToken.Type {
+		case TOKEN_LPAREN:
 			// Function call
 			p.nextToken() // Skip '('
-			
+			p.exprLevel++
+
 			args := []Expression{}
-			
+
 			// Parse arguments
 			if p.currToken.Type != TOKEN_RPAREN {
 				args = append(args, p.parseExpression())
-				
+
 				for p.currToken.Type == TOKEN_COMMA {
 					p.nextToken() // Skip ','
 					args = append(args, p.parseExpression())
 				}
 			}
-			
+			p.exprLevel--
+
 			if p.currToken.Type != TOKEN_RPAREN {
-				p.addError("expected ')' after arguments, got %s", p.currToken.Literal)
-				return nil
+				p.addError("expected ')' after arguments, got %s", describeToken(p.currToken))
+				return &ErrorExpression{Token: tok}
 			}
 			p.nextToken() // Skip ')'
-			
-			return &CallExpression{
-				Function:  id,
-				Arguments: args,
+
+			expr = &CallExpression{Token: tok, Function: expr, Arguments: args}
+
+		case TOKEN_LBRACKET:
+			// Index or slice operation
+			p.nextToken() // Skip '['
+			p.exprLevel++
+
+			var index Expression
+			if p.currToken.Type != TOKEN_COLON {
+				index = p.parseExpression()
+			}
+
+			isSlice := p.currToken.Type == TOKEN_COLON
+			var high Expression
+			if isSlice {
+				p.nextToken() // Skip ':'
+				if p.currToken.Type != TOKEN_RBRACKET {
+					high = p.parseExpression()
+				}
+			}
+			p.exprLevel--
+
+			if p.currToken.Type != TOKEN_RBRACKET {
+				p.addError("expected ']' after index, got %s", describeToken(p.currToken))
+				return &ErrorExpression{Token: tok}
+			}
+			p.nextToken() // Skip ']'
+
+			if isSlice {
+				expr = &SliceExpression{Token: tok, Left: expr, Low: index, High: high}
+			} else {
+				expr = &IndexExpression{Token: tok, Left: expr, Index: index}
+			}
+
+		case TOKEN_DOT:
+			// Member access
+			p.nextToken() // Skip '.'
+
+			if p.currToken.Type != TOKEN_IDENT {
+				p.addError("expected member name after '.', got %s", describeToken(p.currToken))
+				return &ErrorExpression{Token: tok}
+			}
+			member := &Identifier{Token: p.currToken, Value: p.currToken.Literal}
+			p.nextToken() // Skip member name
+
+			expr = &DotExpression{Token: tok, Object: expr, Member: member}
+
+			// module.Name { ... } is a struct literal of a type from another
+			// module
+			if ident, ok := expr.(*DotExpression).Object.(*Identifier); ok &&
+				p.currToken.Type == TOKEN_LBRACE && p.exprLevel >= 0 {
+				return p.parseStructLiteral(ident.Value + "." + member.Value)
 			}
+
+		case TOKEN_PLUS_PLUS, TOKEN_MINUS_MINUS:
+			p.nextToken() // Skip operator
+			expr = &PostfixExpression{Token: tok, Left: expr, Operator: tok.Literal}
+
+		default:
+			return expr
+		}
+	}
+
+	return expr
+}
+
+// parsePrimaryExpression parses a primary expression (literal, identifier,
+// struct literal or parenthesized expression)
+func (p *Parser) parsePrimaryExpression() Expression {
+	tok := p.currToken
+
+	switch p.currToken.Type {
+	case TOKEN_IDENT:
+		p.nextToken()
+
+		// Name { field: value, ... } is a struct literal, except in a
+		// control clause where the '{' starts the body
+		if p.currToken.Type == TOKEN_LBRACE && p.exprLevel >= 0 {
+			return p.parseStructLiteral(tok.Literal)
 		}
-		
-		// Variable reference
-		return &Identifier{Name: id}
-		
+
+		// Variable or function reference
+		return &Identifier{Token: tok, Value: tok.Literal}
+
 	case TOKEN_NUMBER:
-		// Integer literal
-		value, err := strconv.ParseInt(p.currToken.Literal, 10, 64)
+		// Integer literal. Whether it fits its type is checked once the
+		// type is known.
+		value, err := strconv.ParseUint(p.currToken.Literal, 10, 64)
 		if err != nil {
-			p.addError("invalid integer: %s", p.currToken.Literal)
-			return nil
+			p.addError("integer %s is larger than any integer type", p.currToken.Literal)
+			return &ErrorExpression{Token: tok}
 		}
 		p.nextToken()
-		return &NumberLiteral{Value: value}
-		
+		return &IntegerLiteral{Token: tok, Value: value}
+
 	case TOKEN_FLOAT:
 		// Float literal
 		value, err := strconv.ParseFloat(p.currToken.Literal, 64)
 		if err != nil {
 			p.addError("invalid float: %s", p.currToken.Literal)
-			return nil
+			return &ErrorExpression{Token: tok}
 		}
 		p.nextToken()
-		return &FloatLiteral{Value: value}
-		
+		return &FloatLiteral{Token: tok, Value: value}
+
 	case TOKEN_STRING:
-		// String literal
-		value := p.currToken.Literal
+		// String literal, possibly with interpolations
 		p.nextToken()
-		return &StringLiteral{Value: value}
-		
+		return p.parseStringLiteral(tok)
+
 	case TOKEN_TRUE:
 		// Boolean true
 		p.nextToken()
-		return &BoolLiteral{Value: true}
-		
+		return &BooleanLiteral{Token: tok, Value: true}
+
 	case TOKEN_FALSE:
 		// Boolean false
 		p.nextToken()
-		return &BoolLiteral{Value: false}
-		
+		return &BooleanLiteral{Token: tok, Value: false}
+
+	case TOKEN_NULL:
+		p.nextToken()
+		return &NullLiteral{Token: tok}
+
+	case TOKEN_LBRACKET:
+		// Array literal
+		return p.parseArrayLiteral()
+
+	case TOKEN_LBRACE:
+		// Untyped struct literal, whose type comes from context
+		if p.exprLevel < 0 {
+			break
+		}
+		return p.parseStructLiteral("")
+
 	case TOKEN_LPAREN:
 		// Parenthesized expression
 		p.nextToken() // Skip '('
+		p.exprLevel++
 		expr := p.parseExpression()
-		
+		p.exprLevel--
+
 		if p.currToken.Type != TOKEN_RPAREN {
-			p.addError("expected ')', got %s", p.currToken.Literal)
-			return nil
+			p.addError("expected ')', got %s", describeToken(p.currToken))
+			return &ErrorExpression{Token: tok}
 		}
 		p.nextToken() // Skip ')'
 		return expr
-		
-	default:
-		p.addError("unexpected token: %s", p.currToken.Literal)
-		p.nextToken() // Skip unexpected token
-		return nil
 	}
+
+	// A type keyword followed by '(' is a conversion, like int64(x)
+	if isTypeKeyword(p.currToken.Type) && p.peekToken.Type == TOKEN_LPAREN {
+		return p.parseConversion()
+	}
+
+	// Leave the token for the statement parser to resynchronise on
+	p.addError("expected expression, got %s", describeToken(p.currToken))
+	return &ErrorExpression{Token: tok}
+}
+
+// stringEscapes maps the character after a backslash to the character the
+// escape stands for
+var stringEscapes = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'0':  0,
+	'\\': '\\',
+	'"':  '"',
+	'#':  '#',
+}
+
+// parseStringLiteral processes the escapes and #{...} interpolations in a
+// string token. Each interpolated expression is parsed by its own parser that
+// starts at the expression's position in the source.
+func (p *Parser) parseStringLiteral(tok Token) Expression {
+	raw := tok.Literal
+	line, column := tok.Line, tok.Column+1 // position of raw[0]
+
+	var parts []Expression
+	var text strings.Builder
+	interpolated := false
+
+	// flush ends the text before an interpolation as a literal part
+	flush := func() {
+		if text.Len() > 0 {
+			parts = append(parts, &StringLiteral{Token: tok, Value: text.String()})
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(raw); {
+		r, size := utf8.DecodeRuneInString(raw[i:])
+
+		switch {
+		case r == '\\' && i+1 < len(raw):
+			escaped, escSize := utf8.DecodeRuneInString(raw[i+1:])
+			value, ok := stringEscapes[escaped]
+			if !ok {
+				span := compiler.Span{File: tok.File, StartLine: line, StartColumn: column, EndLine: line, EndColumn: column + 2}
+				p.diagnostics = append(p.diagnostics, compiler.NewDiagnostic(compiler.CodeSyntax, span, "unknown escape sequence \\%c", escaped))
+				value = escaped
+			}
+			text.WriteRune(value)
+			i += size + escSize
+			column += 2
+
+		case r == '#' && strings.HasPrefix(raw[i+1:], "{"):
+			end := interpolationEnd(raw, i+1)
+			if end < 0 {
+				span := compiler.Span{File: tok.File, StartLine: line, StartColumn: column, EndLine: line, EndColumn: column + 2}
+				p.diagnostics = append(p.diagnostics, compiler.NewDiagnostic(compiler.CodeSyntax, span, "unterminated interpolation in string"))
+				return &ErrorExpression{Token: tok}
+			}
+
+			flush()
+			interpolated = true
+			parts = append(parts, p.parseInterpolation(raw[i+2:end], line, column+2))
+
+			// Step over the interpolation, which may span lines
+			for _, c := range raw[i : end+1] {
+				if c == '\n' {
+					line++
+					column = 0
+				}
+				column++
+			}
+			i = end + 1
+
+		default:
+			text.WriteRune(r)
+			i += size
+			column++
+			if r == '\n' {
+				line++
+				column = 1
+			}
+		}
+	}
+
+	if !interpolated {
+		return &StringLiteral{Token: tok, Value: text.String()}
+	}
+	flush()
+	return &InterpolatedString{Token: tok, Parts: parts}
+}
+
+// interpolationEnd finds the '}' closing the interpolation whose '{' is at
+// raw[start], skipping braces inside nested strings. It returns -1 if there
+// is none.
+func interpolationEnd(raw string, start int) int {
+	depth := 0
+	inString := false
+	for i := start; i < len(raw); i++ {
+		switch c := raw[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseInterpolation parses the expression inside #{...}
+func (p *Parser) parseInterpolation(src string, line, column int) Expression {
+	file := p.tokenizer.file
+	sub := NewParser(newTokenizerAt(file, src, line, column))
+	if sub.currToken.Type == TOKEN_EOF {
+		span := compiler.Span{File: file, StartLine: line, StartColumn: column - 2, EndLine: line, EndColumn: column + 1}
+		p.diagnostics = append(p.diagnostics, compiler.NewDiagnostic(compiler.CodeSyntax, span, "empty interpolation in string"))
+		return &ErrorExpression{Token: sub.currToken}
+	}
+
+	expr := sub.parseExpression()
+	if sub.currToken.Type != TOKEN_EOF {
+		sub.addError("unexpected %s in interpolation", describeToken(sub.currToken))
+	}
+	p.diagnostics = append(p.diagnostics, sub.Diagnostics()...)
+	return expr
+}
+
+// parseConversion parses a conversion to a built-in type: T(value)
+func (p *Parser) parseConversion() Expression {
+	conv := &ConversionExpression{
+		Token: p.currToken,
+		Type:  TypeSpecifier{Token: p.currToken, TypeName: p.currToken.Literal},
+	}
+	p.nextToken() // Skip type name
+	p.nextToken() // Skip '('
+	p.exprLevel++
+	conv.Value = p.parseExpression()
+	p.exprLevel--
+
+	if p.currToken.Type != TOKEN_RPAREN {
+		p.addError("expected ')' after conversion, got %s", describeToken(p.currToken))
+		return &ErrorExpression{Token: conv.Token}
+	}
+	p.nextToken() // Skip ')'
+
+	return conv
+}
+
+// parseArrayLiteral parses an array literal: [a, b, c]
+func (p *Parser) parseArrayLiteral() Expression {
+	lit := &ArrayLiteral{Token: p.currToken, Elements: []Expression{}}
+	p.nextToken() // Skip '['
+	p.exprLevel++
+	defer func() { p.exprLevel-- }()
+
+	for p.currToken.Type != TOKEN_RBRACKET {
+		lit.Elements = append(lit.Elements, p.parseExpression())
+
+		if p.currToken.Type != TOKEN_COMMA {
+			break
+		}
+		p.nextToken() // Skip ','
+	}
+
+	if p.currToken.Type != TOKEN_RBRACKET {
+		p.addError("expected ']' after array elements, got %s", describeToken(p.currToken))
+		return &ErrorExpression{Token: lit.Token}
+	}
+	p.nextToken() // Skip ']'
+
+	return lit
+}
+
+// parseStructLiteral parses the { field: value, ... } part of a struct
+// literal. The current token is the '{'.
+func (p *Parser) parseStructLiteral(typeName string) Expression {
+	lit := &StructLiteralExpression{Token: p.currToken, TypeName: typeName, Fields: map[string]Expression{}}
+	p.nextToken() // Skip '{'
+	p.exprLevel++
+	defer func() { p.exprLevel-- }()
+
+	for p.currToken.Type != TOKEN_RBRACE {
+		if p.currToken.Type != TOKEN_IDENT || p.peekToken.Type != TOKEN_COLON {
+			p.addError("expected field name and ':' in struct literal, got %s", describeToken(p.currToken))
+			p.skipStructLiteral()
+			return &ErrorExpression{Token: lit.Token}
+		}
+		nameToken := p.currToken
+		p.nextToken() // Skip name
+		p.nextToken() // Skip ':'
+
+		value := p.parseExpression()
+		if _, exists := lit.Fields[nameToken.Literal]; exists {
+			p.diagnostics = append(p.diagnostics, compiler.NewDiagnostic(compiler.CodeSyntax, TokenSpan(nameToken),
+				"duplicate field %s in struct literal", nameToken.Literal))
+		} else {
+			lit.Names = append(lit.Names, &Identifier{Token: nameToken, Value: nameToken.Literal})
+		}
+		lit.Fields[nameToken.Literal] = value
+
+		if p.currToken.Type != TOKEN_COMMA {
+			break
+		}
+		p.nextToken() // Skip ','
+	}
+
+	if p.currToken.Type != TOKEN_RBRACE {
+		p.addError("expected '}' after struct literal fields, got %s", describeToken(p.currToken))
+		p.skipStructLiteral()
+		return &ErrorExpression{Token: lit.Token}
+	}
+	p.nextToken() // Skip '}'
+
+	return lit
 }
 
 // isBinaryOp checks if the token is a binary operator
 func (p *Parser) isBinaryOp(token Token) bool {
 	switch token.Type {
-	case TOKEN_PLUS, TOKEN_MINUS, TOKEN_STAR, TOKEN_SLASH,
+	case TOKEN_PLUS, TOKEN_MINUS, TOKEN_STAR, TOKEN_SLASH, TOKEN_PERCENT,
+		TOKEN_AMPERSAND, TOKEN_PIPE, TOKEN_CARET, TOKEN_SHIFT_LEFT, TOKEN_SHIFT_RIGHT,
 		TOKEN_EQ_EQUALS, TOKEN_NOT_EQUALS, TOKEN_LESS, TOKEN_LESS_EQUALS,
-		TOKEN_GREATER, TOKEN_GREATER_EQUALS:
+		TOKEN_GREATER, TOKEN_GREATER_EQUALS, TOKEN_AND_AND, TOKEN_OR_OR:
 		return true
 	default:
 		return false
 	}
 }
 
-// getPrecedence returns the precedence of an operator
+// getPrecedence returns the precedence of an operator. As in Go, shifts and
+// & bind like multiplication and | and ^ like addition, so a & mask == 0
+// compares the masked value.
 func (p *Parser) getPrecedence(token Token) int {
 	switch token.Type {
-	case TOKEN_STAR, TOKEN_SLASH:
+	case TOKEN_STAR, TOKEN_SLASH, TOKEN_PERCENT, TOKEN_SHIFT_LEFT, TOKEN_SHIFT_RIGHT, TOKEN_AMPERSAND:
 		return 5
-	case TOKEN_PLUS, TOKEN_MINUS:
+	case TOKEN_PLUS, TOKEN_MINUS, TOKEN_PIPE, TOKEN_CARET:
 		return 4
 	case TOKEN_EQ_EQUALS, TOKEN_NOT_EQUALS, TOKEN_LESS, TOKEN_LESS_EQUALS,
 		TOKEN_GREATER, TOKEN_GREATER_EQUALS:
 		return 3
+	case TOKEN_AND_AND:
+		return 2
+	case TOKEN_OR_OR:
+		return 1
 	default:
 		return 0
 	}
 }
 
-// addError adds an error message
+// skipSemicolon consumes the optional ';' ending a statement
+func (p *Parser) skipSemicolon() {
+	if p.currToken.Type == TOKEN_SEMICOLON {
+		p.nextToken() // Skip ';'
+	}
+}
+
+// addError reports a syntax error at the current token and enters panic mode.
+// Errors are suppressed while panicking, since they are usually caused by the
+// first one.
 func (p *Parser) addError(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	p.errors = append(p.errors, fmt.Sprintf("line %d: %s", p.currToken.Line, msg))
-}
\ No newline at end of file
+	if p.panicking {
+		return
+	}
+	p.diagnostics = append(p.diagnostics, compiler.NewDiagnostic(compiler.CodeSyntax, TokenSpan(p.currToken), format, args...))
+	p.panicking = true
+}
+
+// isDeclarationKeyword checks if a token type starts a top-level declaration
+func isDeclarationKeyword(tt TokenType) bool {
+	switch tt {
+	case TOKEN_FUNC, TOKEN_IMPORT, TOKEN_FROM, TOKEN_MODULE, TOKEN_INTERFACE, TOKEN_STRUCT, TOKEN_ENUM,
+		TOKEN_EXTERN, TOKEN_AT:
+		return true
+	default:
+		return false
+	}
+}
+
+// isTypeKeyword checks if a token type names a built-in type
+func isTypeKeyword(tt TokenType) bool {
+	switch tt {
+	case TOKEN_TYPE_INT, TOKEN_TYPE_FLOAT, TOKEN_TYPE_STRING, TOKEN_TYPE_BOOL, TOKEN_TYPE_VOID,
+		TOKEN_TYPE_INT8, TOKEN_TYPE_INT16, TOKEN_TYPE_INT32, TOKEN_TYPE_INT64,
+		TOKEN_TYPE_UINT8, TOKEN_TYPE_UINT16, TOKEN_TYPE_UINT32, TOKEN_TYPE_UINT64, TOKEN_TYPE_UINTPTR,
+		TOKEN_TYPE_FLOAT32, TOKEN_TYPE_FLOAT64, TOKEN_TYPE_BYTE, TOKEN_TYPE_RUNE:
+		return true
+	default:
+		return false
+	}
+}
+
+// atBoundary checks if the current token ends or begins a statement or declaration
+func (p *Parser) atBoundary() bool {
+	switch p.currToken.Type {
+	case TOKEN_SEMICOLON, TOKEN_RBRACE, TOKEN_EOF:
+		return true
+	default:
+		return isDeclarationKeyword(p.currToken.Type)
+	}
+}
+
+// synchronize leaves panic mode by skipping to the next statement boundary.
+// A ';' is consumed; a '}' or declaration keyword is left for the enclosing
+// block or program to handle.
+func (p *Parser) synchronize() {
+	// The failed statement may already have consumed its ';'
+	if p.prevToken.Type != TOKEN_SEMICOLON {
+		for !p.atBoundary() {
+			p.nextToken()
+		}
+		if p.currToken.Type == TOKEN_SEMICOLON {
+			p.nextToken()
+		}
+	}
+	p.panicking = false
+}
+
+// skipStructLiteral skips the rest of a malformed struct literal, up to and
+// including its '}', so the '}' isn't taken for the end of the block the
+// literal is in. A ';' stops it first if the '}' is missing.
+func (p *Parser) skipStructLiteral() {
+	depth := 0
+	for p.currToken.Type != TOKEN_SEMICOLON && p.currToken.Type != TOKEN_EOF {
+		switch p.currToken.Type {
+		case TOKEN_LBRACE:
+			depth++
+		case TOKEN_RBRACE:
+			if depth == 0 {
+				p.nextToken() // Skip '}'
+				return
+			}
+			depth--
+		}
+		p.nextToken()
+	}
+}
+
+// skipTo skips tokens up to the given token type, stopping early at a
+// statement boundary. Panic mode ends only if the token was found.
+func (p *Parser) skipTo(tt TokenType) {
+	for p.currToken.Type != tt && !p.atBoundary() {
+		p.nextToken()
+	}
+	if p.currToken.Type == tt {
+		p.panicking = false
+	}
+}
+
+// skipDeclaration skips a malformed top-level declaration up to the next one
+func (p *Parser) skipDeclaration() {
+	p.nextToken()
+	for !isDeclarationKeyword(p.currToken.Type) && p.currToken.Type != TOKEN_EOF {
+		p.nextToken()
+	}
+}
+
+// describeToken describes a token for use in error messages
+func describeToken(tok Token) string {
+	switch tok.Type {
+	case TOKEN_EOF:
+		return "end of file"
+	case TOKEN_STRING:
+		return fmt.Sprintf("string %q", tok.Literal)
+	default:
+		return fmt.Sprintf("'%s'", tok.Literal)
+	}
+}
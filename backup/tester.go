@@ -0,0 +1,280 @@
+package main // tester.go
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// nova test builds and runs test programs, the files ending in _test.nv. A
+// test passes if its main returns 0. Comments in a test can also say what
+// the compiler and the program should do:
+//
+//	x = "a";  // error[E0202]: cannot assign
+//	var y: Unknown; // warning[W0301]
+//
+// expects a diagnostic with that severity and code on the line of the
+// comment, whose message contains the text after the colon, if any. A test
+// fails if building it reports a diagnostic no comment expects, and one
+// that expects errors isn't run. A comment block starting with
+//
+//	// Output:
+//
+// gives the lines the program should print, each in a comment of its own.
+
+// testSuffix marks the programs nova test runs
+const testSuffix = "_test" + moduleExtension
+
+// expectation is a diagnostic a test expects on one of its lines
+type expectation struct {
+	line     int
+	severity string
+	code     string
+	message  string
+	found    bool
+}
+
+func (e *expectation) String() string {
+	s := fmt.Sprintf("%d: %s[%s]", e.line, e.severity, e.code)
+	if e.message != "" {
+		s += ": " + e.message
+	}
+	return s
+}
+
+// expectationComment matches a comment that expects a diagnostic
+var expectationComment = regexp.MustCompile(`//\s*(error|warning)\[(\w+)\](?::\s*(.*?))?\s*$`)
+
+// renderedDiagnostic matches the first line of a rendered diagnostic
+var renderedDiagnostic = regexp.MustCompile(`^(.+):(\d+):\d+: (error|warning)\[(\w+)\]: (.*)$`)
+
+// testSpec is what a test program expects
+type testSpec struct {
+	expects []*expectation
+	output  *string // nil if the output isn't checked
+}
+
+// readTestSpec reads the expectations in the comments of a test
+func readTestSpec(source string) *testSpec {
+	spec := &testSpec{}
+	var output []string
+	inOutput := false
+	for i, line := range strings.Split(source, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if inOutput {
+			if !strings.HasPrefix(trimmed, "//") {
+				inOutput = false
+			} else {
+				text := strings.TrimPrefix(trimmed, "//")
+				output = append(output, strings.TrimPrefix(text, " "))
+				continue
+			}
+		}
+		if trimmed == "// Output:" {
+			inOutput = true
+			output = []string{}
+			continue
+		}
+		if m := expectationComment.FindStringSubmatch(line); m != nil {
+			spec.expects = append(spec.expects, &expectation{line: i + 1, severity: m[1], code: m[2], message: m[3]})
+		}
+	}
+	if output != nil {
+		text := strings.Join(output, "\n")
+		if len(output) > 0 {
+			text += "\n"
+		}
+		spec.output = &text
+	}
+	return spec
+}
+
+// expectsErrors checks if a test expects its build to fail
+func (spec *testSpec) expectsErrors() bool {
+	for _, e := range spec.expects {
+		if e.severity == "error" {
+			return true
+		}
+	}
+	return false
+}
+
+// match checks the diagnostics a build of file rendered against the
+// expectations, returning what doesn't match
+func (spec *testSpec) match(file, rendered string) []string {
+	var problems []string
+	for _, text := range strings.Split(rendered, "\n") {
+		m := renderedDiagnostic.FindStringSubmatch(text)
+		if m == nil {
+			continue
+		}
+		line, _ := strconv.Atoi(m[2])
+		found := false
+		if filepath.Base(m[1]) == filepath.Base(file) {
+			for _, e := range spec.expects {
+				if !e.found && e.line == line && e.severity == m[3] && e.code == m[4] && strings.Contains(m[5], e.message) {
+					e.found = true
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("unexpected %s: %s[%s]: %s", m[2], m[3], m[4], m[5]))
+		}
+	}
+	for _, e := range spec.expects {
+		if !e.found {
+			problems = append(problems, "missing "+e.String())
+		}
+	}
+	return problems
+}
+
+// test builds and runs the test programs among paths. Directories are
+// searched for test programs, including their subdirectories.
+func test(paths []string, opts *buildOptions, lf *logFlags) int {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "nova: %v\n", err)
+			return exitIO
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && strings.HasSuffix(file, testSuffix) {
+				files = append(files, file)
+			}
+			return err
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "nova: %v\n", err)
+			return exitIO
+		}
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "nova: no test programs found")
+		return exitOK
+	}
+
+	dir, err := ioutil.TempDir("", "nova-test")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nova: %v\n", err)
+		return exitIO
+	}
+	defer os.RemoveAll(dir)
+
+	// Tests are built by another nova, so the diagnostics it renders can be
+	// compared with the ones expected
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nova: %v\n", err)
+		return exitIO
+	}
+
+	failed := 0
+	for _, file := range files {
+		result, code := runTestProgram(self, dir, file, opts, lf)
+		if code != exitOK {
+			return code
+		}
+		if result != "" {
+			fmt.Printf("FAIL  %s (%s)\n", file, result)
+			failed++
+		} else {
+			fmt.Printf("ok    %s\n", file)
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d tests failed\n", failed, len(files))
+		return exitErrors
+	}
+	return exitOK
+}
+
+// runTestProgram builds and runs one test. It returns why the test failed,
+// or "" if it passed, and an exit code other than exitOK if nova test
+// should stop, as every other test would fail the same way.
+func runTestProgram(self, dir, file string, opts *buildOptions, lf *logFlags) (string, int) {
+	source, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nova: %v\n", err)
+		return "", exitIO
+	}
+	spec := readTestSpec(string(source))
+
+	exe := filepath.Join(dir, strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)))
+	args := append([]string{"build", "-o", exe}, opts.flags(lf)...)
+	var stderr bytes.Buffer
+	code, err := runCommand(self, append(args, file), os.Stdout, &stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nova: %v\n", err)
+		return "", exitIO
+	}
+
+	// What the build reported is shown if the test fails
+	result := ""
+	switch problems := spec.match(file, stderr.String()); {
+	case code != exitOK && code != exitErrors && code != exitInternal:
+		os.Stderr.Write(stderr.Bytes())
+		return "", code
+	case len(problems) > 0:
+		result = strings.Join(problems, "; ")
+	case code != exitOK && !spec.expectsErrors():
+		result = "build failed"
+	}
+	if result != "" || lf.verbose || lf.trace != "" {
+		os.Stderr.Write(stderr.Bytes())
+	}
+	if result != "" || code != exitOK {
+		return result, exitOK
+	}
+
+	var stdout bytes.Buffer
+	var out io.Writer = os.Stdout
+	if spec.output != nil {
+		out = &stdout
+	}
+	status, err := runCommand(exe, nil, out, os.Stderr)
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "nova: %v\n", err)
+		return "", exitIO
+	case status != 0:
+		return fmt.Sprintf("exit status %d", status), exitOK
+	case spec.output != nil && stdout.String() != *spec.output:
+		return fmt.Sprintf("output %q, want %q", stdout.String(), *spec.output), exitOK
+	}
+	return "", exitOK
+}
+
+// flags returns the command line flags that build with the same options
+func (opts *buildOptions) flags(lf *logFlags) []string {
+	flags := []string{fmt.Sprintf("-O%d", opts.optLevel)}
+	if opts.target != "" {
+		flags = append(flags, "--target", opts.target)
+	}
+	for _, lib := range opts.libs {
+		flags = append(flags, "-l"+lib)
+	}
+	for _, dir := range opts.libDirs {
+		flags = append(flags, "-L"+dir)
+	}
+	if lf.verbose {
+		flags = append(flags, "--verbose")
+	}
+	if lf.trace != "" {
+		flags = append(flags, "--trace="+lf.trace)
+	}
+	return flags
+}
@@ -5,6 +5,8 @@ import (
 	"strings"
 	"unicode"
 	"unicode/utf8"
+
+	"github.com/deep-neural/nova-lang/compiler"
 )
 
 // TokenType represents the type of token
@@ -38,6 +40,33 @@ const (
 	TOKEN_GREATER_EQUALS
 	TOKEN_ARROW
 	TOKEN_COMMENT
+	TOKEN_ILLEGAL
+	TOKEN_LBRACKET
+	TOKEN_RBRACKET
+	TOKEN_DOT
+	TOKEN_PLUS_EQUALS
+	TOKEN_MINUS_EQUALS
+	TOKEN_STAR_EQUALS
+	TOKEN_SLASH_EQUALS
+	TOKEN_PERCENT
+	TOKEN_AMPERSAND
+	TOKEN_PIPE
+	TOKEN_CARET
+	TOKEN_TILDE
+	TOKEN_AND_AND
+	TOKEN_OR_OR
+	TOKEN_SHIFT_LEFT
+	TOKEN_SHIFT_RIGHT
+	TOKEN_PERCENT_EQUALS
+	TOKEN_AMPERSAND_EQUALS
+	TOKEN_PIPE_EQUALS
+	TOKEN_CARET_EQUALS
+	TOKEN_SHIFT_LEFT_EQUALS
+	TOKEN_SHIFT_RIGHT_EQUALS
+	TOKEN_PLUS_PLUS
+	TOKEN_MINUS_MINUS
+	TOKEN_ELLIPSIS
+	TOKEN_AT
 	
 	// Keywords
 	TOKEN_FUNC
@@ -48,6 +77,22 @@ const (
 	TOKEN_TRUE
 	TOKEN_FALSE
 	TOKEN_VAR
+	TOKEN_IMPORT
+	TOKEN_INTERFACE
+	TOKEN_STRUCT
+	TOKEN_ENUM
+	TOKEN_FOR
+	TOKEN_SWITCH
+	TOKEN_CASE
+	TOKEN_DEFAULT
+	TOKEN_BREAK
+	TOKEN_CONTINUE
+	TOKEN_AS
+	TOKEN_FROM
+	TOKEN_MODULE
+	TOKEN_EXTERN
+	TOKEN_NULL
+	TOKEN_UNSAFE
 	
 	// Type keywords
 	TOKEN_TYPE_INT
@@ -55,12 +100,26 @@ const (
 	TOKEN_TYPE_STRING
 	TOKEN_TYPE_BOOL
 	TOKEN_TYPE_VOID
+	TOKEN_TYPE_INT8
+	TOKEN_TYPE_INT16
+	TOKEN_TYPE_INT32
+	TOKEN_TYPE_INT64
+	TOKEN_TYPE_UINT8
+	TOKEN_TYPE_UINT16
+	TOKEN_TYPE_UINT32
+	TOKEN_TYPE_UINT64
+	TOKEN_TYPE_UINTPTR
+	TOKEN_TYPE_FLOAT32
+	TOKEN_TYPE_FLOAT64
+	TOKEN_TYPE_BYTE
+	TOKEN_TYPE_RUNE
 )
 
 // Token represents a lexical token
 type Token struct {
 	Type    TokenType
 	Literal string
+	File    string // empty when the source isn't read from a file
 	Line    int
 	Column  int
 }
@@ -71,24 +130,52 @@ type Tokenizer struct {
 	position     int
 	readPosition int
 	ch           rune
+	file         string
 	line         int
 	column       int
+	diagnostics  compiler.DiagnosticList
 }
 
 // NewTokenizer creates a new tokenizer
 func NewTokenizer(input string) *Tokenizer {
+	return NewFileTokenizer("", input)
+}
+
+// NewFileTokenizer creates a tokenizer for the contents of a file. Tokens
+// record the file so diagnostics can point into it.
+func NewFileTokenizer(file, input string) *Tokenizer {
 	t := &Tokenizer{
 		input: input,
+		file:  file,
 		line:  1,
 	}
 	t.readChar()
 	return t
 }
 
+// newTokenizerAt creates a tokenizer for source text that starts at the given
+// position in a file, such as an expression interpolated into a string
+func newTokenizerAt(file, input string, line, column int) *Tokenizer {
+	t := &Tokenizer{
+		input:  input,
+		file:   file,
+		line:   line,
+		column: column - 1,
+	}
+	t.readChar()
+	return t
+}
+
+// Diagnostics returns any problems found while tokenizing
+func (t *Tokenizer) Diagnostics() compiler.DiagnosticList {
+	return t.diagnostics
+}
+
 // readChar reads the next character and advances the position
 func (t *Tokenizer) readChar() {
 	if t.readPosition >= len(t.input) {
 		t.ch = 0 // EOF
+		t.position = len(t.input) // so a token ending at EOF is sliced whole
 	} else {
 		r, size := utf8.DecodeRuneInString(t.input[t.readPosition:])
 		t.ch = r
@@ -115,32 +202,85 @@ func (t *Tokenizer) NextToken() Token {
 	t.skipWhitespace()
 	
 	// Set token position
-	tok.Line = t.line
-	tok.Column = t.column
+	line, column := t.line, t.column
+	tok.File = t.file
+	tok.Line = line
+	tok.Column = column
 	
 	switch t.ch {
 	case '+':
-		tok = newToken(TOKEN_PLUS, t.ch)
+		if t.peekChar() == '=' {
+			tok = t.readTwoCharToken(TOKEN_PLUS_EQUALS)
+		} else if t.peekChar() == '+' {
+			tok = t.readTwoCharToken(TOKEN_PLUS_PLUS)
+		} else {
+			tok = newToken(TOKEN_PLUS, t.ch)
+		}
 	case '-':
 		if t.peekChar() == '>' {
 			ch := t.ch
 			t.readChar()
 			literal := string(ch) + string(t.ch)
 			tok = Token{Type: TOKEN_ARROW, Literal: literal, Line: tok.Line, Column: tok.Column}
+		} else if t.peekChar() == '=' {
+			tok = t.readTwoCharToken(TOKEN_MINUS_EQUALS)
+		} else if t.peekChar() == '-' {
+			tok = t.readTwoCharToken(TOKEN_MINUS_MINUS)
 		} else {
 			tok = newToken(TOKEN_MINUS, t.ch)
 		}
 	case '*':
-		tok = newToken(TOKEN_STAR, t.ch)
+		if t.peekChar() == '=' {
+			tok = t.readTwoCharToken(TOKEN_STAR_EQUALS)
+		} else {
+			tok = newToken(TOKEN_STAR, t.ch)
+		}
 	case '/':
 		if t.peekChar() == '/' {
 			// Comment
 			t.readChar() // skip the second '/'
 			comment := t.readComment()
 			tok = Token{Type: TOKEN_COMMENT, Literal: comment, Line: tok.Line, Column: tok.Column}
+		} else if t.peekChar() == '*' {
+			// Block comment
+			t.readChar() // skip the '*'
+			comment := t.readBlockComment()
+			tok = Token{Type: TOKEN_COMMENT, Literal: comment, Line: tok.Line, Column: tok.Column}
+		} else if t.peekChar() == '=' {
+			tok = t.readTwoCharToken(TOKEN_SLASH_EQUALS)
 		} else {
 			tok = newToken(TOKEN_SLASH, t.ch)
 		}
+	case '%':
+		if t.peekChar() == '=' {
+			tok = t.readTwoCharToken(TOKEN_PERCENT_EQUALS)
+		} else {
+			tok = newToken(TOKEN_PERCENT, t.ch)
+		}
+	case '&':
+		if t.peekChar() == '&' {
+			tok = t.readTwoCharToken(TOKEN_AND_AND)
+		} else if t.peekChar() == '=' {
+			tok = t.readTwoCharToken(TOKEN_AMPERSAND_EQUALS)
+		} else {
+			tok = newToken(TOKEN_AMPERSAND, t.ch)
+		}
+	case '|':
+		if t.peekChar() == '|' {
+			tok = t.readTwoCharToken(TOKEN_OR_OR)
+		} else if t.peekChar() == '=' {
+			tok = t.readTwoCharToken(TOKEN_PIPE_EQUALS)
+		} else {
+			tok = newToken(TOKEN_PIPE, t.ch)
+		}
+	case '^':
+		if t.peekChar() == '=' {
+			tok = t.readTwoCharToken(TOKEN_CARET_EQUALS)
+		} else {
+			tok = newToken(TOKEN_CARET, t.ch)
+		}
+	case '~':
+		tok = newToken(TOKEN_TILDE, t.ch)
 	case '(':
 		tok = newToken(TOKEN_LPAREN, t.ch)
 	case ')':
@@ -149,6 +289,20 @@ func (t *Tokenizer) NextToken() Token {
 		tok = newToken(TOKEN_LBRACE, t.ch)
 	case '}':
 		tok = newToken(TOKEN_RBRACE, t.ch)
+	case '[':
+		tok = newToken(TOKEN_LBRACKET, t.ch)
+	case ']':
+		tok = newToken(TOKEN_RBRACKET, t.ch)
+	case '.':
+		if t.peekChar() == '.' && t.readPosition+1 < len(t.input) && t.input[t.readPosition+1] == '.' {
+			t.readChar()
+			t.readChar()
+			tok = Token{Type: TOKEN_ELLIPSIS, Literal: "..."}
+		} else {
+			tok = newToken(TOKEN_DOT, t.ch)
+		}
+	case '@':
+		tok = newToken(TOKEN_AT, t.ch)
 	case ',':
 		tok = newToken(TOKEN_COMMA, t.ch)
 	case ';':
@@ -179,6 +333,12 @@ func (t *Tokenizer) NextToken() Token {
 			t.readChar()
 			literal := string(ch) + string(t.ch)
 			tok = Token{Type: TOKEN_LESS_EQUALS, Literal: literal, Line: tok.Line, Column: tok.Column}
+		} else if t.peekChar() == '<' {
+			tok = t.readTwoCharToken(TOKEN_SHIFT_LEFT)
+			if t.peekChar() == '=' {
+				t.readChar()
+				tok = Token{Type: TOKEN_SHIFT_LEFT_EQUALS, Literal: "<<="}
+			}
 		} else {
 			tok = newToken(TOKEN_LESS, t.ch)
 		}
@@ -188,12 +348,22 @@ func (t *Tokenizer) NextToken() Token {
 			t.readChar()
 			literal := string(ch) + string(t.ch)
 			tok = Token{Type: TOKEN_GREATER_EQUALS, Literal: literal, Line: tok.Line, Column: tok.Column}
+		} else if t.peekChar() == '>' {
+			tok = t.readTwoCharToken(TOKEN_SHIFT_RIGHT)
+			if t.peekChar() == '=' {
+				t.readChar()
+				tok = Token{Type: TOKEN_SHIFT_RIGHT_EQUALS, Literal: ">>="}
+			}
 		} else {
 			tok = newToken(TOKEN_GREATER, t.ch)
 		}
 	case '"':
 		tok.Type = TOKEN_STRING
 		tok.Literal = t.readString()
+		if t.ch != '"' {
+			t.diagnostics = append(t.diagnostics, compiler.NewDiagnostic(compiler.CodeUnterminatedString,
+				TokenSpan(Token{Literal: "\"", File: t.file, Line: line, Column: column}), "unterminated string literal"))
+		}
 	case 0:
 		tok.Literal = ""
 		tok.Type = TOKEN_EOF
@@ -203,13 +373,22 @@ func (t *Tokenizer) NextToken() Token {
 			tok.Type = lookupIdent(tok.Literal)
 			return tok
 		} else if isDigit(t.ch) {
-			return t.readNumber()
+			tok = t.readNumber()
+			tok.File = t.file
+			tok.Line = line
+			tok.Column = column
+			return tok
 		} else {
-			tok = newToken(TOKEN_EOF, t.ch)
+			tok = newToken(TOKEN_ILLEGAL, t.ch)
+			t.diagnostics = append(t.diagnostics, compiler.NewDiagnostic(compiler.CodeIllegalCharacter,
+				TokenSpan(Token{Literal: tok.Literal, File: t.file, Line: line, Column: column}), "unexpected character %q", t.ch))
 		}
 	}
 	
 	t.readChar()
+	tok.File = t.file
+	tok.Line = line
+	tok.Column = column
 	return tok
 }
 
@@ -218,6 +397,13 @@ func newToken(tokenType TokenType, ch rune) Token {
 	return Token{Type: tokenType, Literal: string(ch)}
 }
 
+// readTwoCharToken reads a token made of the current and next characters
+func (t *Tokenizer) readTwoCharToken(tokenType TokenType) Token {
+	ch := t.ch
+	t.readChar()
+	return Token{Type: tokenType, Literal: string(ch) + string(t.ch)}
+}
+
 // readIdentifier reads an identifier
 func (t *Tokenizer) readIdentifier() string {
 	position := t.position
@@ -248,28 +434,101 @@ func (t *Tokenizer) readNumber() Token {
 	return Token{Type: TOKEN_NUMBER, Literal: number}
 }
 
-// readString reads a string
+// readString reads a string. Escapes and #{...} interpolations are kept as
+// written for the parser; they're only skipped over here so a quote inside
+// them doesn't end the string.
 func (t *Tokenizer) readString() string {
 	t.readChar() // skip opening quote
 	position := t.position
 	
-	for t.ch != '"' && t.ch != 0 {
-		t.readChar()
-	}
+	t.skipStringBody()
 	
 	// Get string without closing quote
 	return t.input[position:t.position]
 }
 
+// skipStringBody advances to the quote that closes the current string
+func (t *Tokenizer) skipStringBody() {
+	for t.ch != '"' && t.ch != 0 {
+		switch {
+		case t.ch == '\\':
+			t.readChar() // the escaped character is skipped below
+		case t.ch == '#' && t.peekChar() == '{':
+			t.readChar()
+			t.skipInterpolation()
+			continue
+		case t.ch == '\n':
+			t.line++
+			t.column = 0
+		}
+		t.readChar()
+	}
+}
+
+// skipInterpolation advances past the '}' that closes an interpolation,
+// including any strings nested inside it. It starts on the '{'.
+func (t *Tokenizer) skipInterpolation() {
+	depth := 0
+	for t.ch != 0 {
+		switch t.ch {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '"':
+			t.readChar()
+			t.skipStringBody()
+		case '\n':
+			t.line++
+			t.column = 0
+		}
+		if t.ch == 0 {
+			return
+		}
+		t.readChar()
+		if depth == 0 {
+			return
+		}
+	}
+}
+
 // readComment reads a comment
 func (t *Tokenizer) readComment() string {
+	position := t.position + 1
+	
+	// Stop on the last character of the comment; the newline is left for
+	// skipWhitespace so the line count stays right
+	for t.peekChar() != '\n' && t.peekChar() != 0 {
+		t.readChar()
+	}
+	
+	return strings.TrimSpace(t.input[position : t.position+1])
+}
+
+// readBlockComment reads a /* */ comment, leaving the tokenizer on the
+// closing '/'
+func (t *Tokenizer) readBlockComment() string {
+	t.readChar() // skip the '*'
 	position := t.position
 	
-	for t.ch != '\n' && t.ch != 0 {
+	for t.ch != 0 && !(t.ch == '*' && t.peekChar() == '/') {
+		if t.ch == '\n' {
+			t.line++
+			t.column = 0
+		}
 		t.readChar()
 	}
+	comment := t.input[position:t.position]
+	
+	if t.ch == 0 {
+		t.diagnostics = append(t.diagnostics, compiler.NewDiagnostic(compiler.CodeUnterminatedString,
+			compiler.Span{File: t.file, StartLine: t.line, StartColumn: t.column, EndLine: t.line, EndColumn: t.column + 1},
+			"unterminated block comment"))
+		return strings.TrimSpace(comment)
+	}
 	
-	return strings.TrimSpace(t.input[position:t.position])
+	t.readChar() // skip the '*'
+	return strings.TrimSpace(comment)
 }
 
 // skipWhitespace skips whitespace
@@ -303,11 +562,40 @@ var keywords = map[string]TokenType{
 	"true":   TOKEN_TRUE,
 	"false":  TOKEN_FALSE,
 	"var":    TOKEN_VAR,
+	"import":    TOKEN_IMPORT,
+	"interface": TOKEN_INTERFACE,
+	"struct":    TOKEN_STRUCT,
+	"enum":      TOKEN_ENUM,
+	"for":       TOKEN_FOR,
+	"switch":    TOKEN_SWITCH,
+	"case":      TOKEN_CASE,
+	"default":   TOKEN_DEFAULT,
+	"break":     TOKEN_BREAK,
+	"continue":  TOKEN_CONTINUE,
+	"as":        TOKEN_AS,
+	"from":      TOKEN_FROM,
+	"module":    TOKEN_MODULE,
+	"extern":    TOKEN_EXTERN,
+	"NULL":      TOKEN_NULL,
+	"unsafe":    TOKEN_UNSAFE,
 	"int":    TOKEN_TYPE_INT,
 	"float":  TOKEN_TYPE_FLOAT,
 	"string": TOKEN_TYPE_STRING,
 	"bool":   TOKEN_TYPE_BOOL,
 	"void":   TOKEN_TYPE_VOID,
+	"int8":     TOKEN_TYPE_INT8,
+	"int16":    TOKEN_TYPE_INT16,
+	"int32":    TOKEN_TYPE_INT32,
+	"int64":    TOKEN_TYPE_INT64,
+	"uint8":    TOKEN_TYPE_UINT8,
+	"uint16":   TOKEN_TYPE_UINT16,
+	"uint32":   TOKEN_TYPE_UINT32,
+	"uint64":   TOKEN_TYPE_UINT64,
+	"uintptr":  TOKEN_TYPE_UINTPTR,
+	"float32":  TOKEN_TYPE_FLOAT32,
+	"float64":  TOKEN_TYPE_FLOAT64,
+	"byte":     TOKEN_TYPE_BYTE,
+	"rune":     TOKEN_TYPE_RUNE,
 }
 
 // lookupIdent checks if an identifier is a keyword
@@ -373,6 +661,60 @@ func TokenTypeString(tt TokenType) string {
 		return "ARROW"
 	case TOKEN_COMMENT:
 		return "COMMENT"
+	case TOKEN_ILLEGAL:
+		return "ILLEGAL"
+	case TOKEN_LBRACKET:
+		return "LBRACKET"
+	case TOKEN_RBRACKET:
+		return "RBRACKET"
+	case TOKEN_DOT:
+		return "DOT"
+	case TOKEN_PLUS_EQUALS:
+		return "PLUS_EQUALS"
+	case TOKEN_MINUS_EQUALS:
+		return "MINUS_EQUALS"
+	case TOKEN_STAR_EQUALS:
+		return "STAR_EQUALS"
+	case TOKEN_SLASH_EQUALS:
+		return "SLASH_EQUALS"
+	case TOKEN_PERCENT:
+		return "PERCENT"
+	case TOKEN_AMPERSAND:
+		return "AMPERSAND"
+	case TOKEN_PIPE:
+		return "PIPE"
+	case TOKEN_CARET:
+		return "CARET"
+	case TOKEN_TILDE:
+		return "TILDE"
+	case TOKEN_AND_AND:
+		return "AND_AND"
+	case TOKEN_OR_OR:
+		return "OR_OR"
+	case TOKEN_SHIFT_LEFT:
+		return "SHIFT_LEFT"
+	case TOKEN_SHIFT_RIGHT:
+		return "SHIFT_RIGHT"
+	case TOKEN_PERCENT_EQUALS:
+		return "PERCENT_EQUALS"
+	case TOKEN_AMPERSAND_EQUALS:
+		return "AMPERSAND_EQUALS"
+	case TOKEN_PIPE_EQUALS:
+		return "PIPE_EQUALS"
+	case TOKEN_CARET_EQUALS:
+		return "CARET_EQUALS"
+	case TOKEN_SHIFT_LEFT_EQUALS:
+		return "SHIFT_LEFT_EQUALS"
+	case TOKEN_SHIFT_RIGHT_EQUALS:
+		return "SHIFT_RIGHT_EQUALS"
+	case TOKEN_PLUS_PLUS:
+		return "PLUS_PLUS"
+	case TOKEN_MINUS_MINUS:
+		return "MINUS_MINUS"
+	case TOKEN_ELLIPSIS:
+		return "ELLIPSIS"
+	case TOKEN_AT:
+		return "AT"
 	case TOKEN_FUNC:
 		return "FUNC"
 	case TOKEN_RETURN:
@@ -389,6 +731,38 @@ func TokenTypeString(tt TokenType) string {
 		return "FALSE"
 	case TOKEN_VAR:
 		return "VAR"
+	case TOKEN_IMPORT:
+		return "IMPORT"
+	case TOKEN_INTERFACE:
+		return "INTERFACE"
+	case TOKEN_STRUCT:
+		return "STRUCT"
+	case TOKEN_ENUM:
+		return "ENUM"
+	case TOKEN_FOR:
+		return "FOR"
+	case TOKEN_SWITCH:
+		return "SWITCH"
+	case TOKEN_CASE:
+		return "CASE"
+	case TOKEN_DEFAULT:
+		return "DEFAULT"
+	case TOKEN_BREAK:
+		return "BREAK"
+	case TOKEN_CONTINUE:
+		return "CONTINUE"
+	case TOKEN_AS:
+		return "AS"
+	case TOKEN_FROM:
+		return "FROM"
+	case TOKEN_MODULE:
+		return "MODULE"
+	case TOKEN_EXTERN:
+		return "EXTERN"
+	case TOKEN_NULL:
+		return "NULL"
+	case TOKEN_UNSAFE:
+		return "UNSAFE"
 	case TOKEN_TYPE_INT:
 		return "TYPE_INT"
 	case TOKEN_TYPE_FLOAT:
@@ -399,6 +773,32 @@ func TokenTypeString(tt TokenType) string {
 		return "TYPE_BOOL"
 	case TOKEN_TYPE_VOID:
 		return "TYPE_VOID"
+	case TOKEN_TYPE_INT8:
+		return "TYPE_INT8"
+	case TOKEN_TYPE_INT16:
+		return "TYPE_INT16"
+	case TOKEN_TYPE_INT32:
+		return "TYPE_INT32"
+	case TOKEN_TYPE_INT64:
+		return "TYPE_INT64"
+	case TOKEN_TYPE_UINT8:
+		return "TYPE_UINT8"
+	case TOKEN_TYPE_UINT16:
+		return "TYPE_UINT16"
+	case TOKEN_TYPE_UINT32:
+		return "TYPE_UINT32"
+	case TOKEN_TYPE_UINT64:
+		return "TYPE_UINT64"
+	case TOKEN_TYPE_UINTPTR:
+		return "TYPE_UINTPTR"
+	case TOKEN_TYPE_FLOAT32:
+		return "TYPE_FLOAT32"
+	case TOKEN_TYPE_FLOAT64:
+		return "TYPE_FLOAT64"
+	case TOKEN_TYPE_BYTE:
+		return "TYPE_BYTE"
+	case TOKEN_TYPE_RUNE:
+		return "TYPE_RUNE"
 	default:
 		return fmt.Sprintf("UNKNOWN(%d)", tt)
 	}
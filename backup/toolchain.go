@@ -0,0 +1,258 @@
+package main // toolchain.go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/deep-neural/nova-lang/compiler"
+	"github.com/llir/llvm/ir"
+)
+
+// Native code is produced by the LLVM tools on the PATH. clang compiles and
+// links the IR in one step. Without clang, llc compiles the IR to an object
+// file and the system C compiler links it. The IR is written to a temporary
+// directory, so what the tools report about it is rewritten to refer to the
+// Nova program.
+
+// toolchain is the set of tools found to produce native code
+type toolchain struct {
+	clang string // empty when only llc was found
+	llc   string
+	cc    string // links what llc produces
+}
+
+// newestLLVM and oldestLLVM bound the versions tried for tools installed
+// with a version suffix, such as clang-15
+const (
+	newestLLVM = 20
+	oldestLLVM = 11
+)
+
+// lookTool finds an LLVM tool on the PATH, preferring the unversioned name
+// and then the newest version
+func lookTool(name string) string {
+	if path, err := exec.LookPath(name); err == nil {
+		return path
+	}
+	for v := newestLLVM; v >= oldestLLVM; v-- {
+		if path, err := exec.LookPath(fmt.Sprintf("%s-%d", name, v)); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// findToolchain finds the tools needed to emit an object file or executable
+func findToolchain(emit string) (*toolchain, error) {
+	tc := &toolchain{clang: lookTool("clang")}
+	if tc.clang != "" {
+		return tc, nil
+	}
+
+	tc.llc = lookTool("llc")
+	if tc.llc == "" {
+		return nil, errors.New("neither clang nor llc was found on PATH")
+	}
+	if emit != "exe" {
+		return tc, nil
+	}
+	for _, name := range []string{"cc", "gcc"} {
+		if path, err := exec.LookPath(name); err == nil {
+			tc.cc = path
+			return tc, nil
+		}
+	}
+	return nil, errors.New("llc was found on PATH, but no C compiler (cc or gcc) to link with")
+}
+
+// nativeBuild is a module being compiled by the toolchain
+type nativeBuild struct {
+	c      *compilation
+	tc     *toolchain
+	dir    string // temporary directory for the IR and objects
+	irFile string
+}
+
+// buildNative compiles a module to an object file or executable with the
+// toolchain
+func (c *compilation) buildNative(module *ir.Module, opts *buildOptions, output string) int {
+	tc, err := findToolchain(opts.emit)
+	if err != nil {
+		d := compiler.NewDiagnostic(compiler.CodeToolchain, compiler.Span{}, "cannot produce native code: %v", err)
+		d.Notes = append(d.Notes, "install clang, or use --emit=ir and compile the IR yourself")
+		c.renderer.Render(os.Stderr, d)
+		return exitToolchain
+	}
+
+	var linkFlags []string
+	if opts.emit == "exe" {
+		var diagnostics compiler.DiagnosticList
+		linkFlags, diagnostics = c.linkFlags(opts)
+		if len(diagnostics) > 0 {
+			c.renderer.RenderAll(os.Stderr, diagnostics)
+			return exitToolchain
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "nova-build")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nova: %v\n", err)
+		return exitIO
+	}
+	defer os.RemoveAll(dir)
+
+	base := strings.TrimSuffix(filepath.Base(c.file), filepath.Ext(c.file))
+	b := &nativeBuild{c: c, tc: tc, dir: dir, irFile: filepath.Join(dir, base+".ll")}
+	if err := ioutil.WriteFile(b.irFile, []byte(module.String()), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "nova: %v\n", err)
+		return exitIO
+	}
+
+	optFlag := fmt.Sprintf("-O%d", opts.optLevel)
+	if tc.clang != "" {
+		args := []string{optFlag}
+		if opts.target != "" {
+			args = append(args, "--target="+opts.target)
+		}
+		if opts.emit == "obj" {
+			args = append(args, "-c")
+		}
+		args = append(args, b.irFile, "-o", output)
+		return b.run(tc.clang, append(args, linkFlags...)...)
+	}
+
+	// llc makes position-independent code so the C compiler can link it
+	// into a PIE, which is what it makes by default
+	obj := output
+	if opts.emit == "exe" {
+		obj = filepath.Join(dir, base+".o")
+	}
+	args := []string{optFlag, "-filetype=obj", "-relocation-model=pic"}
+	if opts.target != "" {
+		args = append(args, "-mtriple="+opts.target)
+	}
+	if code := b.run(tc.llc, append(args, b.irFile, "-o", obj)...); code != exitOK || opts.emit == "obj" {
+		return code
+	}
+	return b.run(tc.cc, append([]string{obj, "-o", output}, linkFlags...)...)
+}
+
+// linkFlags returns the flags that link a program with its libraries: -L
+// and -l from the command line, then the libraries of each package named by
+// a link directive, as pkg-config gives them
+func (c *compilation) linkFlags(opts *buildOptions) ([]string, compiler.DiagnosticList) {
+	var flags []string
+	for _, dir := range opts.libDirs {
+		flags = append(flags, "-L"+dir)
+	}
+	for _, lib := range opts.libs {
+		flags = append(flags, "-l"+lib)
+	}
+
+	var diagnostics compiler.DiagnosticList
+	seen := make(map[string]bool)
+	for _, link := range c.program.Links {
+		if seen[link.Package] {
+			continue
+		}
+		seen[link.Package] = true
+
+		var stderr bytes.Buffer
+		c.log.Verbosef("running pkg-config --libs %s", link.Package)
+		cmd := exec.Command("pkg-config", "--libs", link.Package)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			d := compiler.NewDiagnostic(compiler.CodeLink, TokenSpan(link.Token), "cannot link package %q", link.Package)
+			if errors.Is(err, exec.ErrNotFound) {
+				d.Notes = append(d.Notes, "pkg-config was not found on PATH")
+			}
+			d.Notes = append(d.Notes, toolLines(stderr.String())...)
+			diagnostics = append(diagnostics, d)
+			continue
+		}
+		flags = append(flags, strings.Fields(string(out))...)
+	}
+	return flags, diagnostics
+}
+
+// run runs a tool, passing on what it reports. If it fails, what it said
+// is reported against the Nova program.
+func (b *nativeBuild) run(tool string, args ...string) int {
+	b.c.log.Verbosef("running %s %s", filepath.Base(tool), strings.Join(args, " "))
+	var stderr bytes.Buffer
+	cmd := exec.Command(tool, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	output := b.rewritePaths(stderr.String())
+	if err == nil {
+		// Warnings
+		fmt.Fprint(os.Stderr, output)
+		return exitOK
+	}
+
+	diagnostics := b.undefinedSymbols(output)
+	if len(diagnostics) == 0 {
+		d := compiler.NewDiagnostic(compiler.CodeToolchain, compiler.Span{}, "%s failed: %v", filepath.Base(tool), err)
+		d.Notes = append(d.Notes, toolLines(output)...)
+		if strings.Contains(output, filepath.Base(b.irFile)) {
+			d.Notes = append(d.Notes, fmt.Sprintf("%s is the LLVM IR of %s; nova emit-ir writes it out", filepath.Base(b.irFile), b.c.file))
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	b.c.renderer.RenderAll(os.Stderr, diagnostics)
+	return exitToolchain
+}
+
+// rewritePaths removes the temporary directory from what a tool reports,
+// leaving the names of the files in it
+func (b *nativeBuild) rewritePaths(output string) string {
+	return strings.ReplaceAll(output, b.dir+string(filepath.Separator), "")
+}
+
+// undefinedSymbol matches the linker errors of GNU ld, lld and the macOS
+// linker for a symbol no object or library defines
+var undefinedSymbol = regexp.MustCompile("undefined (?:reference to|symbol:?) [`'\"]?_?([A-Za-z_][A-Za-z0-9_.$]*)")
+
+// undefinedSymbols finds the symbols the linker couldn't find, reporting
+// each at the extern function that refers to it
+func (b *nativeBuild) undefinedSymbols(output string) compiler.DiagnosticList {
+	externs := make(map[string]*ExternFunction)
+	for _, ext := range b.c.program.Externs {
+		externs[ext.Symbol] = ext
+	}
+
+	var diagnostics compiler.DiagnosticList
+	reported := make(map[string]bool)
+	for _, m := range undefinedSymbol.FindAllStringSubmatch(output, -1) {
+		ext, ok := externs[m[1]]
+		if !ok || reported[m[1]] {
+			continue
+		}
+		reported[m[1]] = true
+		d := compiler.NewDiagnostic(compiler.CodeLink, TokenSpan(ext.Token), "undefined symbol %s", ext.Symbol)
+		d.Notes = append(d.Notes, "no library the program is linked with defines it; link one with -l or a link directive")
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics
+}
+
+// toolLines splits what a tool reported into lines for notes
+func toolLines(output string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line = strings.TrimRight(line, " \t\r"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
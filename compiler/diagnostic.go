@@ -0,0 +1,239 @@
+package compiler // diagnostic.go
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Severity represents how serious a diagnostic is
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityNote
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityNote:
+		return "note"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// Diagnostic codes, grouped by the stage that reports them. Both compilers
+// report the same problem with the same code.
+const (
+	// Tokenizer
+	CodeIllegalCharacter   = "E0001"
+	CodeUnterminatedString = "E0002"
+
+	// Parser
+	CodeSyntax = "E0100"
+
+	// Module loader
+	CodeImport = "E0110"
+
+	// Checker
+	CodeUndefined        = "E0200"
+	CodeRedeclared       = "E0201"
+	CodeTypeMismatch     = "E0202"
+	CodeInvalidOperation = "E0203"
+	CodeArgumentCount    = "E0204"
+	CodeNoValue          = "E0205"
+	CodeUnsupported      = "E0206"
+	CodeDuplicateCase    = "E0207"
+	CodeMissingCases     = "E0208"
+	CodeImplicitExtern   = "W0209"
+	CodeMissingReturn    = "E0210"
+
+	// Code generator
+	CodeCodegen     = "E0300"
+	CodeUnknownType = "W0301"
+	CodeInternal    = "E0302"
+
+	// Toolchain
+	CodeToolchain = "E0400"
+	CodeLink      = "E0401"
+)
+
+// Span is a range of source text. Lines and columns are 1-based and the end
+// column is exclusive. An empty File means the main source file.
+type Span struct {
+	File        string
+	StartLine   int
+	StartColumn int
+	EndLine     int
+	EndColumn   int
+}
+
+// Position formats the start of a span as file:line:col
+func (s Span) Position() string {
+	return fmt.Sprintf("%s:%d:%d", s.File, s.StartLine, s.StartColumn)
+}
+
+// Join returns the smallest span covering both s and other
+func (s Span) Join(other Span) Span {
+	if other.StartLine == 0 {
+		return s
+	}
+	if s.StartLine == 0 {
+		return other
+	}
+
+	joined := s
+	if other.StartLine < s.StartLine || (other.StartLine == s.StartLine && other.StartColumn < s.StartColumn) {
+		joined.StartLine, joined.StartColumn = other.StartLine, other.StartColumn
+	}
+	if other.EndLine > s.EndLine || (other.EndLine == s.EndLine && other.EndColumn > s.EndColumn) {
+		joined.EndLine, joined.EndColumn = other.EndLine, other.EndColumn
+	}
+	return joined
+}
+
+// Diagnostic is a positioned message reported by any compiler stage
+type Diagnostic struct {
+	Severity Severity
+	Code     string
+	Message  string
+	Span     Span
+	Notes    []string
+}
+
+// Error formats the diagnostic on a single line, so it can be returned as an error
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("%d:%d: %s[%s]: %s", d.Span.StartLine, d.Span.StartColumn, d.Severity, d.Code, d.Message)
+}
+
+// DiagnosticList is a collection of diagnostics
+type DiagnosticList []*Diagnostic
+
+// HasErrors checks if any diagnostic in the list is an error
+func (l DiagnosticList) HasErrors() bool {
+	for _, d := range l {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Sort orders the diagnostics by source position
+func (l DiagnosticList) Sort() {
+	sort.SliceStable(l, func(i, j int) bool {
+		if l[i].Span.File != l[j].Span.File {
+			return l[i].Span.File < l[j].Span.File
+		}
+		if l[i].Span.StartLine != l[j].Span.StartLine {
+			return l[i].Span.StartLine < l[j].Span.StartLine
+		}
+		return l[i].Span.StartColumn < l[j].Span.StartColumn
+	})
+}
+
+// NewDiagnostic creates an error diagnostic
+func NewDiagnostic(code string, span Span, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{
+		Severity: SeverityError,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Span:     span,
+	}
+}
+
+// DiagnosticRenderer prints diagnostics with an excerpt of the source they refer to
+type DiagnosticRenderer struct {
+	filename string
+	files    map[string][]string // lines of each source file
+}
+
+// NewDiagnosticRenderer creates a renderer for diagnostics in the given source file
+func NewDiagnosticRenderer(filename, source string) *DiagnosticRenderer {
+	r := &DiagnosticRenderer{filename: filename, files: make(map[string][]string)}
+	r.AddFile(filename, source)
+	return r
+}
+
+// AddFile makes the source of another file available for excerpts
+func (r *DiagnosticRenderer) AddFile(filename, source string) {
+	r.files[filename] = strings.Split(source, "\n")
+}
+
+// Render writes a diagnostic in the form
+//
+//	file:line:col: severity[code]: message
+//	   12 |     int a = "hello";
+//	      |             ^~~~~~~
+//	      = note: ...
+//
+// The first line follows the file:line:col convention editors and CI tools recognise.
+func (r *DiagnosticRenderer) Render(w io.Writer, d *Diagnostic) {
+	span := d.Span
+	filename := span.File
+	if filename == "" {
+		filename = r.filename
+	}
+
+	if span.StartLine == 0 {
+		fmt.Fprintf(w, "%s: %s[%s]: %s\n", filename, d.Severity, d.Code, d.Message)
+	} else {
+		fmt.Fprintf(w, "%s:%d:%d: %s[%s]: %s\n", filename, span.StartLine, span.StartColumn,
+			d.Severity, d.Code, d.Message)
+	}
+
+	gutter := strings.Repeat(" ", len(fmt.Sprint(span.StartLine))+1)
+
+	lines := r.files[filename]
+	if span.StartLine > 0 && span.StartLine <= len(lines) {
+		line := strings.TrimRight(lines[span.StartLine-1], "\r")
+		fmt.Fprintf(w, " %d | %s\n", span.StartLine, line)
+		fmt.Fprintf(w, "%s | %s\n", gutter, caretLine(line, span))
+	}
+
+	for _, note := range d.Notes {
+		fmt.Fprintf(w, "%s = note: %s\n", gutter, note)
+	}
+}
+
+// RenderAll writes every diagnostic in the list
+func (r *DiagnosticRenderer) RenderAll(w io.Writer, diagnostics DiagnosticList) {
+	for _, d := range diagnostics {
+		r.Render(w, d)
+	}
+}
+
+// caretLine builds the underline for a span on a source line. Tabs before the
+// span are kept so the caret lines up however the terminal expands them.
+func caretLine(line string, span Span) string {
+	var out strings.Builder
+
+	runes := []rune(line)
+	for i := 0; i < span.StartColumn-1 && i < len(runes); i++ {
+		if runes[i] == '\t' {
+			out.WriteRune('\t')
+		} else {
+			out.WriteRune(' ')
+		}
+	}
+
+	// Spans that continue onto later lines are underlined to the end of this one
+	end := span.EndColumn
+	if span.EndLine > span.StartLine {
+		end = len(runes) + 1
+	}
+
+	out.WriteRune('^')
+	for col := span.StartColumn + 1; col < end; col++ {
+		out.WriteRune('~')
+	}
+
+	return out.String()
+}
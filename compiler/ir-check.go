@@ -0,0 +1,482 @@
+// Package compiler holds what the nova compilers share: diagnostics, lexical
+// scopes, the logger and the IR checker.
+package compiler // ir-check.go
+
+import (
+	"fmt"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// The IR checker finds IR that LLVM would reject before it is written out.
+// It only sees the module, so the compiler maps each problem it finds back
+// to the source the instruction was generated for.
+
+// Problem is something wrong with an instruction. Index is the position of
+// the instruction in its block, where the terminator is len(Block.Insts).
+// Problems with a global have no block, and no function unless the global
+// is one.
+type Problem struct {
+	Func    *ir.Func
+	Block   *ir.Block
+	Index   int
+	Message string
+}
+
+// irChecker checks the IR of one function
+type irChecker struct {
+	fn       *ir.Func
+	defs     map[value.Value]irPosition // where each instruction is defined
+	dom      *domTree
+	problems []Problem
+}
+
+// irPosition is where an instruction is in a function
+type irPosition struct {
+	block *ir.Block
+	index int
+}
+
+// CheckModule checks no two globals or functions have the same name, every
+// block ends in a terminator, no two local values of a function have the
+// same name, every value is defined in a block that dominates its uses, and
+// every instruction has operands of the types it takes, including the
+// arguments of calls
+func CheckModule(m *ir.Module) []Problem {
+	problems := checkGlobalNames(m)
+	for _, fn := range m.Funcs {
+		if len(fn.Blocks) == 0 {
+			continue
+		}
+		c := &irChecker{fn: fn, defs: make(map[value.Value]irPosition)}
+		c.checkFunc()
+		problems = append(problems, c.problems...)
+	}
+	return problems
+}
+
+// checkGlobalNames checks no two globals or functions of a module have the
+// same name, which LLVM rejects as a redefinition
+func checkGlobalNames(m *ir.Module) []Problem {
+	var problems []Problem
+	seen := make(map[string]bool)
+	for _, global := range m.Globals {
+		if seen[global.Name()] {
+			problems = append(problems, Problem{Message: fmt.Sprintf("%s is defined more than once", global.Ident())})
+		}
+		seen[global.Name()] = true
+	}
+	for _, fn := range m.Funcs {
+		if seen[fn.Name()] {
+			p := Problem{Func: fn, Message: fmt.Sprintf("%s is defined more than once", fn.Ident())}
+			if len(fn.Blocks) > 0 {
+				p.Block = fn.Blocks[0]
+			}
+			problems = append(problems, p)
+		}
+		seen[fn.Name()] = true
+	}
+	return problems
+}
+
+func (c *irChecker) report(block *ir.Block, index int, format string, args ...interface{}) {
+	c.problems = append(c.problems, Problem{c.fn, block, index, fmt.Sprintf(format, args...)})
+}
+
+func (c *irChecker) checkFunc() {
+	// Number unnamed values so they can be reported
+	if err := c.fn.AssignIDs(); err != nil {
+		c.report(c.fn.Blocks[0], 0, "%v", err)
+		return
+	}
+
+	for _, block := range c.fn.Blocks {
+		for i, inst := range block.Insts {
+			if v, ok := inst.(value.Value); ok {
+				c.defs[v] = irPosition{block, i}
+			}
+		}
+	}
+	c.checkNames()
+	c.dom = dominators(c.fn)
+
+	for _, block := range c.fn.Blocks {
+		for i, inst := range block.Insts {
+			c.checkUses(block, i, inst)
+			c.checkInst(block, i, inst)
+		}
+		if block.Term == nil {
+			c.report(block, len(block.Insts), "block %s has no terminator", block.Ident())
+			continue
+		}
+		c.checkUses(block, len(block.Insts), block.Term)
+		c.checkTerm(block, block.Term)
+	}
+}
+
+// checkNames checks no two parameters, blocks or instructions of the
+// function have the same name, which LLVM rejects as a redefinition
+func (c *irChecker) checkNames() {
+	seen := make(map[string]bool)
+	for _, param := range c.fn.Params {
+		if !param.IsUnnamed() {
+			seen[param.Name()] = true
+		}
+	}
+	for _, block := range c.fn.Blocks {
+		if !block.IsUnnamed() {
+			if seen[block.Name()] {
+				c.report(block, 0, "%s is defined more than once in %s", block.Ident(), c.fn.Ident())
+			}
+			seen[block.Name()] = true
+		}
+		for i, inst := range block.Insts {
+			named, ok := inst.(interface {
+				value.Named
+				IsUnnamed() bool
+			})
+			if !ok || named.IsUnnamed() {
+				continue
+			}
+			if seen[named.Name()] {
+				c.report(block, i, "%s is defined more than once in %s", named.Ident(), c.fn.Ident())
+			}
+			seen[named.Name()] = true
+		}
+	}
+}
+
+// checkUses checks the operands of an instruction are defined where they
+// can be used
+func (c *irChecker) checkUses(block *ir.Block, index int, user value.User) {
+	if phi, ok := user.(*ir.InstPhi); ok {
+		// An incoming value is used at the end of its predecessor
+		for _, inc := range phi.Incs {
+			pred, ok := inc.Pred.(*ir.Block)
+			if !ok {
+				continue
+			}
+			c.checkUse(block, index, inc.X, pred, len(pred.Insts)+1)
+		}
+		return
+	}
+	for _, operand := range user.Operands() {
+		c.checkUse(block, index, *operand, block, index)
+	}
+}
+
+// checkUse checks a value used by the instruction at block and index is
+// defined before the point it is used at
+func (c *irChecker) checkUse(block *ir.Block, index int, v value.Value, useBlock *ir.Block, useIndex int) {
+	switch x := v.(type) {
+	case *ir.Param:
+		for _, param := range c.fn.Params {
+			if param == x {
+				return
+			}
+		}
+		c.report(block, index, "uses parameter %s of another function", v.Ident())
+	case ir.Instruction:
+		def, ok := c.defs[v]
+		if !ok {
+			c.report(block, index, "uses %s, which is not in %s", v.Ident(), c.fn.Ident())
+			return
+		}
+		// Uses in blocks that can't be reached have nothing to dominate them
+		if !c.dom.reaches(useBlock) {
+			return
+		}
+		if def.block == useBlock && def.index < useIndex || def.block != useBlock && c.dom.dominates(def.block, useBlock) {
+			return
+		}
+		c.report(block, index, "%s is used where its definition in %s doesn't dominate it", v.Ident(), def.block.Ident())
+	}
+}
+
+// checkInst checks the operands of an instruction have the types it takes
+func (c *irChecker) checkInst(block *ir.Block, index int, inst ir.Instruction) {
+	switch inst := inst.(type) {
+	case *ir.InstAdd:
+		c.checkIntOperands(block, index, "add", inst.X, inst.Y)
+	case *ir.InstSub:
+		c.checkIntOperands(block, index, "sub", inst.X, inst.Y)
+	case *ir.InstMul:
+		c.checkIntOperands(block, index, "mul", inst.X, inst.Y)
+	case *ir.InstUDiv:
+		c.checkIntOperands(block, index, "udiv", inst.X, inst.Y)
+	case *ir.InstSDiv:
+		c.checkIntOperands(block, index, "sdiv", inst.X, inst.Y)
+	case *ir.InstURem:
+		c.checkIntOperands(block, index, "urem", inst.X, inst.Y)
+	case *ir.InstSRem:
+		c.checkIntOperands(block, index, "srem", inst.X, inst.Y)
+	case *ir.InstShl:
+		c.checkIntOperands(block, index, "shl", inst.X, inst.Y)
+	case *ir.InstLShr:
+		c.checkIntOperands(block, index, "lshr", inst.X, inst.Y)
+	case *ir.InstAShr:
+		c.checkIntOperands(block, index, "ashr", inst.X, inst.Y)
+	case *ir.InstAnd:
+		c.checkIntOperands(block, index, "and", inst.X, inst.Y)
+	case *ir.InstOr:
+		c.checkIntOperands(block, index, "or", inst.X, inst.Y)
+	case *ir.InstXor:
+		c.checkIntOperands(block, index, "xor", inst.X, inst.Y)
+	case *ir.InstFAdd:
+		c.checkFloatOperands(block, index, "fadd", inst.X, inst.Y)
+	case *ir.InstFSub:
+		c.checkFloatOperands(block, index, "fsub", inst.X, inst.Y)
+	case *ir.InstFMul:
+		c.checkFloatOperands(block, index, "fmul", inst.X, inst.Y)
+	case *ir.InstFDiv:
+		c.checkFloatOperands(block, index, "fdiv", inst.X, inst.Y)
+	case *ir.InstFRem:
+		c.checkFloatOperands(block, index, "frem", inst.X, inst.Y)
+	case *ir.InstICmp:
+		if !types.Equal(inst.X.Type(), inst.Y.Type()) {
+			c.report(block, index, "icmp compares %s with %s", inst.X.Type(), inst.Y.Type())
+		} else if !types.IsInt(inst.X.Type()) && !types.IsPointer(inst.X.Type()) {
+			c.report(block, index, "icmp compares %s, not integers or pointers", inst.X.Type())
+		}
+	case *ir.InstFCmp:
+		c.checkFloatOperands(block, index, "fcmp", inst.X, inst.Y)
+	case *ir.InstLoad:
+		ptr, ok := inst.Src.Type().(*types.PointerType)
+		if !ok {
+			c.report(block, index, "load from %s, which is not a pointer", inst.Src.Type())
+		} else if !types.Equal(ptr.ElemType, inst.ElemType) {
+			c.report(block, index, "load of %s through %s", inst.ElemType, ptr)
+		}
+	case *ir.InstStore:
+		ptr, ok := inst.Dst.Type().(*types.PointerType)
+		if !ok {
+			c.report(block, index, "store to %s, which is not a pointer", inst.Dst.Type())
+		} else if !types.Equal(ptr.ElemType, inst.Src.Type()) {
+			c.report(block, index, "store of %s through %s", inst.Src.Type(), ptr)
+		}
+	case *ir.InstGetElementPtr:
+		ptr, ok := inst.Src.Type().(*types.PointerType)
+		if !ok {
+			c.report(block, index, "getelementptr on %s, which is not a pointer", inst.Src.Type())
+		} else if !types.Equal(ptr.ElemType, inst.ElemType) {
+			c.report(block, index, "getelementptr into %s through %s", inst.ElemType, ptr)
+		}
+	case *ir.InstPhi:
+		for _, inc := range inst.Incs {
+			if !types.Equal(inc.X.Type(), inst.Type()) {
+				c.report(block, index, "phi of %s has an incoming %s", inst.Type(), inc.X.Type())
+			}
+		}
+	case *ir.InstSelect:
+		if !types.Equal(inst.Cond.Type(), types.I1) {
+			c.report(block, index, "select on %s, not i1", inst.Cond.Type())
+		}
+		if !types.Equal(inst.ValueTrue.Type(), inst.ValueFalse.Type()) {
+			c.report(block, index, "select between %s and %s", inst.ValueTrue.Type(), inst.ValueFalse.Type())
+		}
+	case *ir.InstCall:
+		c.checkCall(block, index, inst)
+	}
+}
+
+// checkIntOperands checks the operands of an integer operation
+func (c *irChecker) checkIntOperands(block *ir.Block, index int, op string, x, y value.Value) {
+	if !types.Equal(x.Type(), y.Type()) {
+		c.report(block, index, "%s of %s and %s", op, x.Type(), y.Type())
+	} else if !types.IsInt(x.Type()) {
+		c.report(block, index, "%s of %s, not integers", op, x.Type())
+	}
+}
+
+// checkFloatOperands checks the operands of a floating-point operation
+func (c *irChecker) checkFloatOperands(block *ir.Block, index int, op string, x, y value.Value) {
+	if !types.Equal(x.Type(), y.Type()) {
+		c.report(block, index, "%s of %s and %s", op, x.Type(), y.Type())
+	} else if !types.IsFloat(x.Type()) {
+		c.report(block, index, "%s of %s, not floating-point values", op, x.Type())
+	}
+}
+
+// checkCall checks a call passes the arguments its callee takes. Arguments
+// after the fixed parameters of a variadic function can have any type.
+func (c *irChecker) checkCall(block *ir.Block, index int, call *ir.InstCall) {
+	ptr, ok := call.Callee.Type().(*types.PointerType)
+	if !ok {
+		c.report(block, index, "call of %s, which is not a function pointer", call.Callee.Type())
+		return
+	}
+	sig, ok := ptr.ElemType.(*types.FuncType)
+	if !ok {
+		c.report(block, index, "call of %s, which is not a function pointer", ptr)
+		return
+	}
+
+	name := call.Callee.Ident()
+	switch {
+	case sig.Variadic && len(call.Args) < len(sig.Params):
+		c.report(block, index, "%s takes at least %d arguments, got %d", name, len(sig.Params), len(call.Args))
+		return
+	case !sig.Variadic && len(call.Args) != len(sig.Params):
+		c.report(block, index, "%s takes %d arguments, got %d", name, len(sig.Params), len(call.Args))
+		return
+	}
+	for i, param := range sig.Params {
+		if !types.Equal(call.Args[i].Type(), param) {
+			c.report(block, index, "argument %d of %s is %s, want %s", i+1, name, call.Args[i].Type(), param)
+		}
+	}
+}
+
+// checkTerm checks the operands of a terminator have the types it takes
+func (c *irChecker) checkTerm(block *ir.Block, term ir.Terminator) {
+	index := len(block.Insts)
+	switch term := term.(type) {
+	case *ir.TermRet:
+		retType := c.fn.Sig.RetType
+		switch {
+		case term.X == nil && !types.Equal(retType, types.Void):
+			c.report(block, index, "ret void from %s, which returns %s", c.fn.Ident(), retType)
+		case term.X != nil && !types.Equal(term.X.Type(), retType):
+			c.report(block, index, "ret %s from %s, which returns %s", term.X.Type(), c.fn.Ident(), retType)
+		}
+	case *ir.TermCondBr:
+		if !types.Equal(term.Cond.Type(), types.I1) {
+			c.report(block, index, "br on %s, not i1", term.Cond.Type())
+		}
+	case *ir.TermSwitch:
+		for _, cs := range term.Cases {
+			if !types.Equal(cs.X.Type(), term.X.Type()) {
+				c.report(block, index, "switch on %s has a case of %s", term.X.Type(), cs.X.Type())
+			}
+		}
+	}
+}
+
+// domTree is the dominator tree of the blocks of a function that can be
+// reached from its entry. Blocks are numbered in the order a walk of the
+// tree enters and leaves them, so a block dominates another if the walk
+// enters it before the other and leaves it after.
+type domTree struct {
+	idom        map[*ir.Block]*ir.Block // immediate dominator of each block
+	enter, exit map[*ir.Block]int
+}
+
+// reaches checks if a block can be reached from the entry
+func (t *domTree) reaches(block *ir.Block) bool {
+	_, ok := t.idom[block]
+	return ok
+}
+
+// dominates checks if every path from the entry to b goes through a
+func (t *domTree) dominates(a, b *ir.Block) bool {
+	return t.enter[a] <= t.enter[b] && t.exit[b] <= t.exit[a]
+}
+
+// dominators builds the dominator tree of a function with the algorithm of
+// Cooper, Harvey and Kennedy, "A Simple, Fast Dominance Algorithm": blocks
+// are visited in reverse postorder, and the immediate dominator of each is
+// where the dominator tree paths of its predecessors meet.
+func dominators(fn *ir.Func) *domTree {
+	entry := fn.Blocks[0]
+
+	// Number the blocks in postorder, walking with an explicit stack so a
+	// function with thousands of blocks doesn't recurse as deep
+	type frame struct {
+		block *ir.Block
+		next  []*ir.Block // the blocks still to walk to from it
+	}
+	succs := func(block *ir.Block) []*ir.Block {
+		if block.Term == nil {
+			return nil
+		}
+		return block.Term.Succs()
+	}
+	post := make(map[*ir.Block]int)
+	var order []*ir.Block // postorder
+	visited := map[*ir.Block]bool{entry: true}
+	stack := []frame{{entry, succs(entry)}}
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if len(top.next) == 0 {
+			post[top.block] = len(order)
+			order = append(order, top.block)
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		succ := top.next[0]
+		top.next = top.next[1:]
+		if !visited[succ] {
+			visited[succ] = true
+			stack = append(stack, frame{succ, succs(succ)})
+		}
+	}
+
+	preds := make(map[*ir.Block][]*ir.Block)
+	for _, block := range order {
+		for _, succ := range succs(block) {
+			preds[succ] = append(preds[succ], block)
+		}
+	}
+
+	intersect := func(idom map[*ir.Block]*ir.Block, a, b *ir.Block) *ir.Block {
+		for a != b {
+			for post[a] < post[b] {
+				a = idom[a]
+			}
+			for post[b] < post[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+
+	idom := map[*ir.Block]*ir.Block{entry: entry}
+	for changed := true; changed; {
+		changed = false
+		for i := len(order) - 2; i >= 0; i-- {
+			block := order[i]
+			var next *ir.Block
+			for _, pred := range preds[block] {
+				if _, ok := idom[pred]; !ok {
+					continue
+				}
+				if next == nil {
+					next = pred
+				} else {
+					next = intersect(idom, pred, next)
+				}
+			}
+			if idom[block] != next {
+				idom[block] = next
+				changed = true
+			}
+		}
+	}
+
+	// Number the tree, again without recursing
+	children := make(map[*ir.Block][]*ir.Block)
+	for i := len(order) - 2; i >= 0; i-- {
+		block := order[i]
+		children[idom[block]] = append(children[idom[block]], block)
+	}
+	t := &domTree{idom: idom, enter: make(map[*ir.Block]int), exit: make(map[*ir.Block]int)}
+	clock := 0
+	walk := []frame{{entry, children[entry]}}
+	t.enter[entry] = clock
+	for len(walk) > 0 {
+		top := &walk[len(walk)-1]
+		clock++
+		if len(top.next) == 0 {
+			t.exit[top.block] = clock
+			walk = walk[:len(walk)-1]
+			continue
+		}
+		child := top.next[0]
+		top.next = top.next[1:]
+		t.enter[child] = clock
+		walk = append(walk, frame{child, children[child]})
+	}
+	return t
+}
@@ -0,0 +1,166 @@
+package compiler // ir-check_test.go
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// diamonds builds a function of n if/else diamonds in a row, the CFG a long
+// function of ifs compiles to. The value defined in the then block of each
+// diamond is returned with the blocks, so a test can use it elsewhere.
+func diamonds(n int) (*ir.Module, *ir.Func, []*ir.Block, []value.Value) {
+	m := ir.NewModule()
+	x := ir.NewParam("x", types.I32)
+	fn := m.NewFunc("f", types.I32, x)
+	block := fn.NewBlock("entry")
+	var thens []*ir.Block
+	var defs []value.Value
+	for i := 0; i < n; i++ {
+		then := fn.NewBlock("")
+		els := fn.NewBlock("")
+		join := fn.NewBlock("")
+		block.NewCondBr(block.NewICmp(enum.IPredSGT, x, constant.NewInt(types.I32, int64(i))), then, els)
+		defs = append(defs, then.NewAdd(x, constant.NewInt(types.I32, 1)))
+		then.NewBr(join)
+		els.NewBr(join)
+		thens = append(thens, then)
+		block = join
+	}
+	block.NewRet(x)
+	return m, fn, thens, defs
+}
+
+func TestDominatorsOfManyDiamonds(t *testing.T) {
+	const n = 5000
+	_, fn, thens, _ := diamonds(n)
+	start := time.Now()
+	dom := dominators(fn)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("dominators of %d blocks took %v", len(fn.Blocks), elapsed)
+	}
+
+	entry, exit := fn.Blocks[0], fn.Blocks[len(fn.Blocks)-1]
+	for i, block := range fn.Blocks {
+		if !dom.reaches(block) {
+			t.Fatalf("block %d can't be reached", i)
+		}
+		if !dom.dominates(entry, block) {
+			t.Fatalf("entry doesn't dominate block %d", i)
+		}
+	}
+	// The join of each diamond dominates everything after it, while its then
+	// and else blocks dominate only themselves
+	for i, then := range thens {
+		join := fn.Blocks[3*i+3]
+		if dom.idom[then] != fn.Blocks[3*i] || dom.idom[join] != fn.Blocks[3*i] {
+			t.Fatalf("diamond %d: wrong immediate dominators", i)
+		}
+		if !dom.dominates(join, exit) || dom.dominates(then, join) || dom.dominates(then, exit) {
+			t.Fatalf("diamond %d: wrong dominance", i)
+		}
+	}
+}
+
+func TestDominatorsOfLoop(t *testing.T) {
+	m := ir.NewModule()
+	fn := m.NewFunc("f", types.Void)
+	entry := fn.NewBlock("entry")
+	head := fn.NewBlock("head")
+	body := fn.NewBlock("body")
+	latch := fn.NewBlock("latch")
+	exit := fn.NewBlock("exit")
+	dead := fn.NewBlock("dead")
+	entry.NewBr(head)
+	head.NewCondBr(constant.True, body, exit)
+	body.NewCondBr(constant.True, latch, head)
+	latch.NewBr(head)
+	exit.NewRet(nil)
+	dead.NewBr(body)
+
+	dom := dominators(fn)
+	want := map[*ir.Block]*ir.Block{entry: entry, head: entry, body: head, latch: body, exit: head}
+	for block, idom := range want {
+		if dom.idom[block] != idom {
+			t.Errorf("idom of %s is %v, want %s", block.Ident(), dom.idom[block], idom.Ident())
+		}
+	}
+	if dom.reaches(dead) {
+		t.Errorf("%s can't be reached from the entry", dead.Ident())
+	}
+	if dom.dominates(body, exit) || !dom.dominates(head, latch) {
+		t.Errorf("wrong dominance in the loop")
+	}
+}
+
+func TestCheckModuleReportsUseNotDominated(t *testing.T) {
+	m, fn, _, defs := diamonds(1000)
+	if problems := CheckModule(m); len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems[0].Message)
+	}
+
+	// Return the value of the last then block from after its join
+	exit := fn.Blocks[len(fn.Blocks)-1]
+	exit.Term = ir.NewRet(defs[len(defs)-1])
+	problems := CheckModule(m)
+	if len(problems) != 1 || problems[0].Block != exit ||
+		!strings.Contains(problems[0].Message, "doesn't dominate") {
+		t.Fatalf("got %v, want one use that isn't dominated", problems)
+	}
+}
+
+func TestCheckModuleReportsDuplicateFunctions(t *testing.T) {
+	m := ir.NewModule()
+	m.NewFunc("print_int", types.Void, ir.NewParam("", types.I64))
+	fn := m.NewFunc("print_int", types.Void, ir.NewParam("", types.I64))
+	fn.NewBlock("").NewRet(nil)
+	m.NewGlobalDef(".str.0", constant.NewCharArrayFromString("a"))
+	m.NewGlobalDef(".str.0", constant.NewCharArrayFromString("b"))
+
+	problems := CheckModule(m)
+	if len(problems) != 2 {
+		t.Fatalf("got %d problems, want 2", len(problems))
+	}
+	if problems[0].Func != nil || problems[0].Message != "@.str.0 is defined more than once" {
+		t.Errorf("got %q for the global", problems[0].Message)
+	}
+	if problems[1].Func != fn || problems[1].Block != fn.Blocks[0] {
+		t.Errorf("got %q in %v, want the second print_int", problems[1].Message, problems[1].Func)
+	}
+}
+
+func TestCheckModuleReportsBadTerminators(t *testing.T) {
+	m := ir.NewModule()
+	x := ir.NewParam("x", types.I32)
+	fn := m.NewFunc("f", types.I32, x)
+	entry := fn.NewBlock("entry")
+	then := fn.NewBlock("then")
+	els := fn.NewBlock("else")
+	open := fn.NewBlock("open")
+	entry.NewCondBr(x, then, els)
+	then.NewRet(nil)
+	els.NewRet(constant.NewInt(types.I64, 0))
+	open.NewAdd(x, x)
+
+	want := []string{
+		"br on i32, not i1",
+		"ret void from @f, which returns i32",
+		"ret i64 from @f, which returns i32",
+		"block %open has no terminator",
+	}
+	problems := CheckModule(m)
+	if len(problems) != len(want) {
+		t.Fatalf("got %d problems, want %d", len(problems), len(want))
+	}
+	for i, p := range problems {
+		if p.Block != fn.Blocks[i] || p.Message != want[i] {
+			t.Errorf("got %q in %s, want %q in %s", p.Message, p.Block.Ident(), want[i], fn.Blocks[i].Ident())
+		}
+	}
+}
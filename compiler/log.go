@@ -0,0 +1,104 @@
+package compiler // log.go
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The logger reports what the compiler is doing on stderr, so it never mixes
+// with what the compiler writes to stdout. Warnings and errors are always
+// written. --verbose adds a line for each stage of compilation, and
+// --trace=parse,codegen adds a line for every node the traced stages visit,
+// with its kind and source position.
+
+// logLevel is how much a Logger writes
+type logLevel int
+
+const (
+	logQuiet   logLevel = iota // warnings and errors only
+	logVerbose                 // and each stage of compilation
+	logTrace                   // and each node of the traced stages
+)
+
+// traceStages are the stages --trace can name
+var traceStages = []string{"parse", "codegen"}
+
+// Logger writes leveled messages. A nil Logger writes nothing.
+type Logger struct {
+	w      io.Writer
+	level  logLevel
+	stages map[string]bool // stages being traced
+}
+
+// NewLogger creates a logger that writes to w. trace names the stages to
+// trace, separated by commas; tracing implies verbose.
+func NewLogger(w io.Writer, verbose bool, trace string) (*Logger, error) {
+	l := &Logger{w: w, stages: make(map[string]bool)}
+	if verbose {
+		l.level = logVerbose
+	}
+	if trace == "" {
+		return l, nil
+	}
+
+	for _, stage := range strings.Split(trace, ",") {
+		stage = strings.TrimSpace(stage)
+		known := false
+		for _, s := range traceStages {
+			known = known || s == stage
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown trace stage %q; want %s", stage, strings.Join(traceStages, " or "))
+		}
+		l.stages[stage] = true
+	}
+	l.level = logTrace
+	return l, nil
+}
+
+// Errorf reports an error
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.printf(logQuiet, "Error: "+format, args...)
+}
+
+// Warnf reports a warning
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.printf(logQuiet, "Warning: "+format, args...)
+}
+
+// Verbosef reports a stage of compilation
+func (l *Logger) Verbosef(format string, args ...interface{}) {
+	l.printf(logVerbose, format, args...)
+}
+
+// Tracing reports whether a stage is being traced, so callers can skip
+// working out what to trace
+func (l *Logger) Tracing(stage string) bool {
+	return l != nil && l.level >= logTrace && l.stages[stage]
+}
+
+// Trace reports a node visited by a stage: its position, its kind and a
+// short detail such as the token it starts with
+func (l *Logger) Trace(stage, pos, kind, detail string) {
+	if !l.Tracing(stage) {
+		return
+	}
+	if detail != "" {
+		kind += " " + detail
+	}
+	fmt.Fprintf(l.w, "trace %s: %s: %s\n", stage, pos, kind)
+}
+
+// NodeKind names the kind of a node from its type, such as IfStatement
+func NodeKind(node interface{}) string {
+	kind := fmt.Sprintf("%T", node)
+	return kind[strings.LastIndex(kind, ".")+1:]
+}
+
+func (l *Logger) printf(level logLevel, format string, args ...interface{}) {
+	if l == nil || l.level < level {
+		return
+	}
+	fmt.Fprintf(l.w, strings.TrimSuffix(format, "\n")+"\n", args...)
+}
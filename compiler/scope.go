@@ -0,0 +1,49 @@
+package compiler // scope.go
+
+// Scope is one level of a lexical scope chain. Each block, loop body and
+// function gets its own Scope whose parent is the enclosing one.
+type Scope[T any] struct {
+	parent  *Scope[T]
+	symbols map[string]T
+}
+
+// NewScope creates a new scope nested inside parent (nil for the outermost)
+func NewScope[T any](parent *Scope[T]) *Scope[T] {
+	return &Scope[T]{
+		parent:  parent,
+		symbols: make(map[string]T),
+	}
+}
+
+// Parent returns the enclosing scope
+func (s *Scope[T]) Parent() *Scope[T] {
+	return s.parent
+}
+
+// Declare adds a symbol to this scope. It returns false if the name is
+// already declared in this scope; outer declarations may be shadowed.
+func (s *Scope[T]) Declare(name string, sym T) bool {
+	if _, exists := s.symbols[name]; exists {
+		return false
+	}
+	s.symbols[name] = sym
+	return true
+}
+
+// Declared checks if a name is declared in this scope itself, not counting
+// the scopes around it
+func (s *Scope[T]) Declared(name string) bool {
+	_, exists := s.symbols[name]
+	return exists
+}
+
+// Lookup resolves a name, searching from the innermost scope outwards
+func (s *Scope[T]) Lookup(name string) (T, bool) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if sym, ok := scope.symbols[name]; ok {
+			return sym, true
+		}
+	}
+	var zero T
+	return zero, false
+}
@@ -4,6 +4,7 @@ go 1.24.1
 
 require (
 	github.com/antlr4-go/antlr/v4 v4.13.1
+	github.com/deep-neural/nova-lang/compiler v0.0.0
 	github.com/llir/llvm v0.3.6
 )
 
@@ -15,3 +16,7 @@ require (
 	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/tools v0.21.0 // indirect
 )
+
+// The IR checker, logger and scopes are shared with the nova compiler in
+// backup/
+replace github.com/deep-neural/nova-lang/compiler => ../compiler
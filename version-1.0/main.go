@@ -1,12 +1,15 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/antlr4-go/antlr/v4"
+	"github.com/deep-neural/nova-lang/compiler"
 	"github.com/llir/llvm/ir"
 	"github.com/llir/llvm/ir/constant"
 	"github.com/llir/llvm/ir/types"
@@ -19,9 +22,32 @@ type CustomVisitor struct {
 	Module        *ir.Module
 	CurrentFunc   *ir.Func
 	CurrentBlock  *ir.Block
-	SymbolTable   map[string]value.Value
+	SymbolTable   *compiler.Scope[value.Value]
 	StringCounter int
 	FuncMap       map[string]*ir.Func
+	
+	// AllowImplicitExtern declares unknown functions from their first call
+	// instead of reporting them
+	AllowImplicitExtern bool
+	Implicit            map[string]bool
+	
+	// Diagnostics are the errors and warnings found, rendered with an
+	// excerpt of the source once the whole program has been visited
+	Diagnostics compiler.DiagnosticList
+	
+	// Sources marks where the code of each block came from, so problems
+	// found in the IR can be reported at the statement that caused them
+	Sources map[*ir.Block][]sourceMark
+	
+	// Log receives warnings and errors, and traces each node visited
+	Log *compiler.Logger
+}
+
+// sourceMark records that the instructions of a block from index on were
+// generated for the statement starting at a token
+type sourceMark struct {
+	index int
+	tok   antlr.Token
 }
 
 func NewCustomVisitor() *CustomVisitor {
@@ -36,16 +62,138 @@ func NewCustomVisitor() *CustomVisitor {
 	// system declaration
 	systemParams := []*ir.Param{ir.NewParam("command", types.NewPointer(types.I8))}
 	system := mod.NewFunc("system", types.I32, systemParams...)
+	
+	// Errors and warnings are written even when the caller sets no logger
+	log, _ := compiler.NewLogger(os.Stderr, false, "")
 
 	return &CustomVisitor{
 		Module:        mod,
-		SymbolTable:   make(map[string]value.Value),
+		SymbolTable:   compiler.NewScope[value.Value](nil),
 		StringCounter: 0,
 		FuncMap: map[string]*ir.Func{
 			"printf": printf,
 			"system": system,
 		},
+		Implicit: map[string]bool{},
+		Sources:  map[*ir.Block][]sourceMark{},
+		Log:      log,
+	}
+}
+
+// Report an error at a token; any error stops the IR from being written
+func (v *CustomVisitor) errorf(tok antlr.Token, code string, format string, args ...interface{}) *compiler.Diagnostic {
+	d := compiler.NewDiagnostic(code, tokenSpan(tok), format, args...)
+	v.Diagnostics = append(v.Diagnostics, d)
+	return d
+}
+
+// Report a warning at a token
+func (v *CustomVisitor) warnf(tok antlr.Token, code string, format string, args ...interface{}) {
+	d := v.errorf(tok, code, format, args...)
+	d.Severity = compiler.SeverityWarning
+}
+
+// Mark the next instruction of the current block as generated for the
+// construct starting at a token
+func (v *CustomVisitor) markSource(tok antlr.Token) {
+	blk := v.CurrentBlock
+	v.Sources[blk] = append(v.Sources[blk], sourceMark{len(blk.Insts), tok})
+}
+
+// Report a problem the IR checker found at the statement it was generated
+// for; the program was accepted, so it is a bug in the compiler
+func (v *CustomVisitor) internalError(p compiler.Problem) {
+	var tok antlr.Token
+	for _, mark := range v.Sources[p.Block] {
+		if mark.index > p.Index && tok != nil {
+			break
+		}
+		tok = mark.tok
 	}
+	
+	d := v.internalErrorAt(tok, "%s", p.Message)
+	if p.Block != nil {
+		d.Notes = append(d.Notes, fmt.Sprintf("in %s, block %s", p.Func.Ident(), p.Block.Ident()))
+	}
+}
+
+// Report a bug in the compiler at a token, or without a position if tok is
+// nil
+func (v *CustomVisitor) internalErrorAt(tok antlr.Token, format string, args ...interface{}) *compiler.Diagnostic {
+	var span compiler.Span
+	if tok != nil {
+		span = tokenSpan(tok)
+	}
+	d := compiler.NewDiagnostic(compiler.CodeInternal, span, "internal compiler error: "+format, args...)
+	d.Notes = append(d.Notes, "this is a bug in the compiler, not in the program")
+	v.Diagnostics = append(v.Diagnostics, d)
+	return d
+}
+
+// Generate the module for a parse tree. A panic while generating code, such
+// as llir rejecting the operands of an instruction, is reported as an
+// internal compiler error at the statement being generated.
+func (v *CustomVisitor) generate(tree antlr.ParseTree) (result interface{}) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		var tok antlr.Token
+		if marks := v.Sources[v.CurrentBlock]; len(marks) > 0 {
+			tok = marks[len(marks)-1].tok
+		}
+		v.internalErrorAt(tok, "%v", r)
+		result = nil
+	}()
+	return v.Visit(tree)
+}
+
+// Format the position of a token as file:line:col
+func tokenPos(tok antlr.Token) string {
+	return tokenSpan(tok).Position()
+}
+
+// Return the source covered by a token
+func tokenSpan(tok antlr.Token) compiler.Span {
+	width := utf8.RuneCountInString(tok.GetText())
+	if width == 0 || tok.GetTokenType() == antlr.TokenEOF {
+		width = 1
+	}
+	column := tok.GetColumn() + 1
+	return compiler.Span{
+		File:        tok.GetInputStream().GetSourceName(),
+		StartLine:   tok.GetLine(),
+		StartColumn: column,
+		EndLine:     tok.GetLine(),
+		EndColumn:   column + width,
+	}
+}
+
+// syntaxErrors reports the errors of the lexer and parser as diagnostics
+type syntaxErrors struct {
+	*antlr.DefaultErrorListener
+	visitor *CustomVisitor
+}
+
+func (l *syntaxErrors) SyntaxError(recognizer antlr.Recognizer, offendingSymbol interface{}, line, column int, msg string, e antlr.RecognitionException) {
+	span := compiler.Span{StartLine: line, StartColumn: column + 1, EndLine: line, EndColumn: column + 2}
+	if tok, ok := offendingSymbol.(antlr.Token); ok {
+		span = tokenSpan(tok)
+	}
+	l.visitor.Diagnostics = append(l.visitor.Diagnostics, compiler.NewDiagnostic(compiler.CodeSyntax, span, "%s", msg))
+}
+
+// parseTracer traces each rule the parser enters
+type parseTracer struct {
+	antlr.BaseParseTreeListener
+	log       *compiler.Logger
+	ruleNames []string
+}
+
+func (t *parseTracer) EnterEveryRule(ctx antlr.ParserRuleContext) {
+	start := ctx.GetStart()
+	t.log.Trace("parse", tokenPos(start), t.ruleNames[ctx.GetRuleIndex()], start.GetText())
 }
 
 // Convert our language types to LLVM types
@@ -67,7 +215,7 @@ func getLLVMType(typeName string) types.Type {
 // Create a global string constant
 func (v *CustomVisitor) createStringConstant(text string) value.Value {
 	if v.CurrentBlock == nil {
-		fmt.Println("Error: No current block when creating string constant")
+		v.Log.Errorf("internal compiler error: no current block when creating string constant")
 		return constant.NewNull(types.NewPointer(types.I8))
 	}
 
@@ -94,6 +242,11 @@ func (v *CustomVisitor) createStringConstant(text string) value.Value {
 
 // Implementation of antlr.ParseTreeVisitor interface
 func (v *CustomVisitor) Visit(tree antlr.ParseTree) interface{} {
+	if ctx, ok := tree.(antlr.ParserRuleContext); ok && v.Log.Tracing("codegen") {
+		start := ctx.GetStart()
+		v.Log.Trace("codegen", tokenPos(start), strings.TrimSuffix(compiler.NodeKind(ctx), "Context"), start.GetText())
+	}
+	
 	switch ctx := tree.(type) {
 	case *ProgramContext:
 		return v.VisitProgram(ctx)
@@ -124,7 +277,7 @@ func (v *CustomVisitor) Visit(tree antlr.ParseTree) interface{} {
 	case *ParenExprContext:
 		return v.VisitParenExpr(ctx)
 	default:
-		fmt.Printf("Unhandled context type: %T\n", ctx)
+		v.Log.Warnf("Unhandled context type: %T", ctx)
 		return nil
 	}
 }
@@ -149,7 +302,10 @@ func (v *CustomVisitor) VisitErrorNode(node antlr.ErrorNode) interface{} {
 
 // Visit program node
 func (v *CustomVisitor) VisitProgram(ctx *ProgramContext) interface{} {
-	fmt.Println("Visiting program...")
+	// Declare every function first so calls can come before definitions
+	for _, funcCtx := range ctx.AllFunction() {
+		v.declareFunction(funcCtx)
+	}
 	
 	// Visit all function declarations
 	for _, funcCtx := range ctx.AllFunction() {
@@ -159,12 +315,14 @@ func (v *CustomVisitor) VisitProgram(ctx *ProgramContext) interface{} {
 	return v.Module
 }
 
-// Visit function node
-func (v *CustomVisitor) VisitFunction(ctx *FunctionContext) interface{} {
-	fmt.Println("Visiting function:", ctx.ID().GetText())
-	
-	// Get function name and return type
+// Declare a function from its signature
+func (v *CustomVisitor) declareFunction(ctx IFunctionContext) {
 	funcName := ctx.ID().GetText()
+	if _, exists := v.FuncMap[funcName]; exists {
+		v.errorf(ctx.ID().GetSymbol(), compiler.CodeRedeclared, "function %s already declared", funcName)
+		return
+	}
+	
 	returnType := getLLVMType(ctx.Type_().GetText())
 	
 	// Process parameters
@@ -178,25 +336,29 @@ func (v *CustomVisitor) VisitFunction(ctx *FunctionContext) interface{} {
 		}
 	}
 	
-	// Create the function or use existing one
-	var f *ir.Func
-	if existing, exists := v.FuncMap[funcName]; exists {
-		// Keep existing function but update if needed
-		f = existing
-	} else {
-		f = v.Module.NewFunc(funcName, returnType, params...)
-		v.FuncMap[funcName] = f
+	v.FuncMap[funcName] = v.Module.NewFunc(funcName, returnType, params...)
+}
+
+// Visit function node
+func (v *CustomVisitor) VisitFunction(ctx *FunctionContext) interface{} {
+	// The function was declared by VisitProgram
+	funcName := ctx.ID().GetText()
+	f := v.FuncMap[funcName]
+	if len(f.Blocks) > 0 || f == v.FuncMap["printf"] || f == v.FuncMap["system"] {
+		// A redeclaration, which has already been reported
+		return nil
 	}
+	returnType := f.Sig.RetType
 	
 	v.CurrentFunc = f
 	
 	// Create entry block
 	entryBlock := f.NewBlock("entry")
 	v.CurrentBlock = entryBlock
+	v.markSource(ctx.ID().GetSymbol())
 	
-	// Save old symbol table
-	oldSymbolTable := v.SymbolTable
-	v.SymbolTable = make(map[string]value.Value)
+	// Enter the function scope; parameters share it with the function body
+	v.SymbolTable = compiler.NewScope(v.SymbolTable)
 	
 	// Create allocas for parameters and store parameter values in them
 	for _, param := range f.Params {
@@ -208,14 +370,15 @@ func (v *CustomVisitor) VisitFunction(ctx *FunctionContext) interface{} {
 		entryBlock.NewStore(param, alloca)
 		
 		// Store the alloca in the symbol table with the original parameter name
-		v.SymbolTable[param.Name()] = alloca
+		v.SymbolTable.Declare(param.Name(), alloca)
 	}
 	
 	// Process function body
-	v.Visit(ctx.Block())
+	v.visitStatements(ctx.Block().AllStatement())
 	
 	// Make sure the function has a return if needed
 	if v.CurrentBlock.Term == nil {
+		v.markSource(ctx.Block().GetStop())
 		if returnType == types.Void {
 			v.CurrentBlock.NewRet(nil)
 		} else if intType, ok := returnType.(*types.IntType); ok {
@@ -229,18 +392,25 @@ func (v *CustomVisitor) VisitFunction(ctx *FunctionContext) interface{} {
 		}
 	}
 	
-	// Restore symbol table
-	v.SymbolTable = oldSymbolTable
+	// Leave the function scope
+	v.SymbolTable = v.SymbolTable.Parent()
 	
 	return nil
 }
 
 // Visit block node
 func (v *CustomVisitor) VisitBlock(ctx *BlockContext) interface{} {
-	fmt.Println("Visiting block...")
+	// Each block gets its own scope
+	v.SymbolTable = compiler.NewScope(v.SymbolTable)
+	v.visitStatements(ctx.AllStatement())
+	v.SymbolTable = v.SymbolTable.Parent()
 	
-	// Visit all statements
-	for _, stmt := range ctx.AllStatement() {
+	return nil
+}
+
+// Visit a list of statements in the current scope
+func (v *CustomVisitor) visitStatements(stmts []IStatementContext) {
+	for _, stmt := range stmts {
 		v.Visit(stmt)
 		
 		// If we've added a terminator, stop processing
@@ -248,13 +418,11 @@ func (v *CustomVisitor) VisitBlock(ctx *BlockContext) interface{} {
 			break
 		}
 	}
-	
-	return nil
 }
 
 // Visit statement node - this is the new method we need
 func (v *CustomVisitor) VisitStatement(ctx *StatementContext) interface{} {
-	fmt.Println("Visiting statement...")
+	v.markSource(ctx.GetStart())
 	
 	// Check which child we have and visit it
 	if varDecl := ctx.VariableDecl(); varDecl != nil {
@@ -269,60 +437,66 @@ func (v *CustomVisitor) VisitStatement(ctx *StatementContext) interface{} {
 		return v.Visit(returnStmt)
 	}
 	
-	fmt.Println("Warning: Unhandled statement type")
+	v.Log.Warnf("Unhandled statement type")
 	return nil
 }
 
 // Visit variable declaration
 func (v *CustomVisitor) VisitVariableDecl(ctx *VariableDeclContext) interface{} {
-	fmt.Println("Visiting variable declaration:", ctx.ID().GetText())
+	varName := ctx.ID().GetText()
+	if v.SymbolTable.Declared(varName) {
+		// A second alloca would give the function two locals of one name
+		v.errorf(ctx.ID().GetSymbol(), compiler.CodeRedeclared, "variable %s already declared in this scope", varName)
+		return nil
+	}
 	
-	var varType types.Type
+	// Process initializer before the name comes into scope
+	val, ok := v.Visit(ctx.Expr()).(value.Value)
+	if !ok {
+		v.errorf(ctx.Expr().GetStart(), compiler.CodeNoValue, "invalid initializer for variable %s", varName)
+		return nil
+	}
 	
+	// A 'var' declaration takes the type of its initializer
+	varType := val.Type()
 	if typeCtx := ctx.Type_(); typeCtx != nil {
 		varType = getLLVMType(typeCtx.GetText())
-	} else {
-		// For 'var' declarations, default to string pointer
-		varType = types.NewPointer(types.I8)
+		if !types.Equal(val.Type(), varType) {
+			v.errorf(ctx.Expr().GetStart(), compiler.CodeTypeMismatch, "cannot initialize %s of type %s with %s", varName, typeCtx.GetText(), val.Type())
+			return nil
+		}
 	}
 	
-	varName := ctx.ID().GetText()
-	
-	// Create alloca instruction
 	alloca := v.CurrentBlock.NewAlloca(varType)
 	alloca.SetName(varName)
-	v.SymbolTable[varName] = alloca
-	
-	// Process initializer
-	exprValue := v.Visit(ctx.Expr())
-	if val, ok := exprValue.(value.Value); ok {
-		v.CurrentBlock.NewStore(val, alloca)
-	} else {
-		fmt.Printf("Warning: Invalid initializer for variable %s\n", varName)
-	}
+	v.CurrentBlock.NewStore(val, alloca)
+	v.SymbolTable.Declare(varName, alloca)
 	
 	return nil
 }
 
 // Visit assignment statement
 func (v *CustomVisitor) VisitAssignment(ctx *AssignmentContext) interface{} {
-	fmt.Println("Visiting assignment:", ctx.ID().GetText())
-	
 	varName := ctx.ID().GetText()
-	alloca, exists := v.SymbolTable[varName]
+	alloca, exists := v.SymbolTable.Lookup(varName)
 	
 	if !exists {
-		fmt.Printf("Error: Variable %s not declared\n", varName)
+		v.errorf(ctx.ID().GetSymbol(), compiler.CodeUndefined, "variable %s not declared", varName)
 		return nil
 	}
 	
 	// Process value
-	exprValue := v.Visit(ctx.Expr())
-	if val, ok := exprValue.(value.Value); ok {
-		v.CurrentBlock.NewStore(val, alloca)
-	} else {
-		fmt.Printf("Warning: Invalid value for assignment to %s\n", varName)
+	val, ok := v.Visit(ctx.Expr()).(value.Value)
+	if !ok {
+		v.errorf(ctx.Expr().GetStart(), compiler.CodeNoValue, "invalid value for assignment to %s", varName)
+		return nil
 	}
+	varType := alloca.Type().(*types.PointerType).ElemType
+	if !types.Equal(val.Type(), varType) {
+		v.errorf(ctx.Expr().GetStart(), compiler.CodeTypeMismatch, "cannot assign %s to %s of type %s", val.Type(), varName, varType)
+		return nil
+	}
+	v.CurrentBlock.NewStore(val, alloca)
 	
 	return nil
 }
@@ -330,17 +504,6 @@ func (v *CustomVisitor) VisitAssignment(ctx *AssignmentContext) interface{} {
 // Visit function call statement
 func (v *CustomVisitor) VisitFunctionCall(ctx *FunctionCallContext) interface{} {
 	funcName := ctx.ID().GetText()
-	fmt.Println("Visiting function call:", funcName)
-	
-	// Get the function
-	fn, exists := v.FuncMap[funcName]
-	if !exists {
-		fmt.Printf("Warning: Undefined function %s, creating forward declaration\n", funcName)
-		// Create a generic forward declaration (variadic with i8* return)
-		fn = v.Module.NewFunc(funcName, types.I32, ir.NewParam("", types.NewPointer(types.I8)))
-		fn.Sig.Variadic = true
-		v.FuncMap[funcName] = fn
-	}
 	
 	// Process arguments
 	var args []value.Value
@@ -353,21 +516,63 @@ func (v *CustomVisitor) VisitFunctionCall(ctx *FunctionCallContext) interface{}
 		}
 	}
 	
+	// Get the function
+	fn, exists := v.FuncMap[funcName]
+	if !exists {
+		if !v.AllowImplicitExtern {
+			v.errorf(ctx.ID().GetSymbol(), compiler.CodeUndefined, "undefined function %s", funcName)
+			return constant.NewInt(types.I32, 0)
+		}
+		fn = v.declareImplicitExtern(ctx.ID().GetSymbol(), args)
+	}
+	
+	if v.Implicit[funcName] && !sameArgTypes(fn, args) {
+		v.errorf(ctx.ID().GetSymbol(), compiler.CodeTypeMismatch, "call to %s doesn't match its implicit declaration %s", funcName, fn.Sig)
+		return constant.NewInt(types.I32, 0)
+	}
+	
 	// Create call instruction
 	call := v.CurrentBlock.NewCall(fn, args...)
 	return call
 }
 
+// Declare an unknown function as an extern taking the types of the arguments
+// of its first call and returning int, as C89 did
+func (v *CustomVisitor) declareImplicitExtern(nameTok antlr.Token, args []value.Value) *ir.Func {
+	funcName := nameTok.GetText()
+	var params []*ir.Param
+	for _, arg := range args {
+		params = append(params, ir.NewParam("", arg.Type()))
+	}
+	
+	fn := v.Module.NewFunc(funcName, types.I32, params...)
+	v.warnf(nameTok, compiler.CodeImplicitExtern, "implicitly declaring extern function %s as %s", funcName, fn.Sig)
+	v.FuncMap[funcName] = fn
+	v.Implicit[funcName] = true
+	return fn
+}
+
+// Check that arguments have the types of a function's parameters
+func sameArgTypes(fn *ir.Func, args []value.Value) bool {
+	if len(args) != len(fn.Params) {
+		return false
+	}
+	for i, arg := range args {
+		if !arg.Type().Equal(fn.Params[i].Type()) {
+			return false
+		}
+	}
+	return true
+}
+
 // Visit return statement
 func (v *CustomVisitor) VisitReturnStmt(ctx *ReturnStmtContext) interface{} {
-	fmt.Println("Visiting return statement")
-	
 	// Process return value
 	retValue := v.Visit(ctx.Expr())
 	if val, ok := retValue.(value.Value); ok {
 		v.CurrentBlock.NewRet(val)
 	} else {
-		fmt.Println("Warning: Invalid return value, using default")
+		v.errorf(ctx.GetStart(), compiler.CodeNoValue, "invalid return value")
 		v.CurrentBlock.NewRet(constant.NewInt(types.I32, 0))
 	}
 	
@@ -376,8 +581,6 @@ func (v *CustomVisitor) VisitReturnStmt(ctx *ReturnStmtContext) interface{} {
 
 // Visit multiplication/division expression
 func (v *CustomVisitor) VisitMulDivExpr(ctx *MulDivExprContext) interface{} {
-	fmt.Println("Visiting multiplication/division expression")
-	
 	// Process left and right operands
 	leftValue := v.Visit(ctx.Expr(0))
 	rightValue := v.Visit(ctx.Expr(1))
@@ -386,12 +589,16 @@ func (v *CustomVisitor) VisitMulDivExpr(ctx *MulDivExprContext) interface{} {
 	right, rightOk := rightValue.(value.Value)
 	
 	if !leftOk || !rightOk {
-		fmt.Println("Warning: Invalid operands in multiplication/division")
+		v.errorf(ctx.GetStart(), compiler.CodeNoValue, "invalid operands in multiplication/division")
 		return constant.NewInt(types.I32, 0)
 	}
 	
 	// Get operation type
 	op := ctx.GetChild(1).(antlr.TerminalNode).GetText()
+	if _, ok := left.Type().(*types.IntType); !ok || !types.Equal(left.Type(), right.Type()) {
+		v.errorf(ctx.GetChild(1).(antlr.TerminalNode).GetSymbol(), compiler.CodeTypeMismatch, "invalid operands to %s: %s and %s", op, left.Type(), right.Type())
+		return constant.NewInt(types.I32, 0)
+	}
 	
 	if op == "*" {
 		return v.CurrentBlock.NewMul(left, right)
@@ -402,8 +609,6 @@ func (v *CustomVisitor) VisitMulDivExpr(ctx *MulDivExprContext) interface{} {
 
 // Visit addition/subtraction expression
 func (v *CustomVisitor) VisitAddSubExpr(ctx *AddSubExprContext) interface{} {
-	fmt.Println("Visiting addition/subtraction expression")
-	
 	// Process left and right operands
 	leftValue := v.Visit(ctx.Expr(0))
 	rightValue := v.Visit(ctx.Expr(1))
@@ -412,12 +617,16 @@ func (v *CustomVisitor) VisitAddSubExpr(ctx *AddSubExprContext) interface{} {
 	right, rightOk := rightValue.(value.Value)
 	
 	if !leftOk || !rightOk {
-		fmt.Println("Warning: Invalid operands in addition/subtraction")
+		v.errorf(ctx.GetStart(), compiler.CodeNoValue, "invalid operands in addition/subtraction")
 		return constant.NewInt(types.I32, 0)
 	}
 	
 	// Get operation type
 	op := ctx.GetChild(1).(antlr.TerminalNode).GetText()
+	if _, ok := left.Type().(*types.IntType); !ok || !types.Equal(left.Type(), right.Type()) {
+		v.errorf(ctx.GetChild(1).(antlr.TerminalNode).GetSymbol(), compiler.CodeTypeMismatch, "invalid operands to %s: %s and %s", op, left.Type(), right.Type())
+		return constant.NewInt(types.I32, 0)
+	}
 	
 	if op == "+" {
 		return v.CurrentBlock.NewAdd(left, right)
@@ -428,8 +637,6 @@ func (v *CustomVisitor) VisitAddSubExpr(ctx *AddSubExprContext) interface{} {
 
 // Visit function call expression
 func (v *CustomVisitor) VisitFunctionCallExpr(ctx *FunctionCallExprContext) interface{} {
-	fmt.Println("Visiting function call expression")
-	
 	// Process as regular function call
 	return v.Visit(ctx.FunctionCall())
 }
@@ -437,11 +644,10 @@ func (v *CustomVisitor) VisitFunctionCallExpr(ctx *FunctionCallExprContext) inte
 // Visit variable expression
 func (v *CustomVisitor) VisitVariableExpr(ctx *VariableExprContext) interface{} {
 	varName := ctx.ID().GetText()
-	fmt.Println("Visiting variable expression:", varName)
 	
-	alloca, exists := v.SymbolTable[varName]
+	alloca, exists := v.SymbolTable.Lookup(varName)
 	if !exists {
-		fmt.Printf("Error: Variable %s not declared\n", varName)
+		v.errorf(ctx.ID().GetSymbol(), compiler.CodeUndefined, "variable %s not declared", varName)
 		return constant.NewInt(types.I32, 0)
 	}
 	
@@ -450,14 +656,12 @@ func (v *CustomVisitor) VisitVariableExpr(ctx *VariableExprContext) interface{}
 		return v.CurrentBlock.NewLoad(ptr.ElemType, alloca)
 	}
 	
-	fmt.Printf("Error: Variable %s has invalid type\n", varName)
+	v.Log.Errorf("internal compiler error: variable %s has invalid type", varName)
 	return constant.NewInt(types.I32, 0)
 }
 
 // Visit literal expression
 func (v *CustomVisitor) VisitLiteralExpr(ctx *LiteralExprContext) interface{} {
-	fmt.Println("Visiting literal expression")
-	
 	literalCtx := ctx.Literal()
 	
 	if intLit := literalCtx.INT_LITERAL(); intLit != nil {
@@ -481,74 +685,128 @@ func (v *CustomVisitor) VisitLiteralExpr(ctx *LiteralExprContext) interface{} {
 
 // Visit parenthesized expression
 func (v *CustomVisitor) VisitParenExpr(ctx *ParenExprContext) interface{} {
-	fmt.Println("Visiting parenthesized expression")
-	
 	// Just visit the inner expression
 	return v.Visit(ctx.Expr())
 }
 
-func main() {
-	// Add panic recovery
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Println("Recovered from panic:", r)
-			fmt.Println("Stack trace will be printed above")
+// Render diagnostics sorted by position and return the exit status for
+// them, 1 if any is an error
+func report(renderer *compiler.DiagnosticRenderer, diagnostics compiler.DiagnosticList) int {
+	diagnostics.Sort()
+	renderer.RenderAll(os.Stderr, diagnostics)
+	
+	errors := 0
+	for _, d := range diagnostics {
+		if d.Severity == compiler.SeverityError {
+			errors++
 		}
-	}()
-	
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: go run main.go <input-file>")
+	}
+	if errors > 0 {
+		fmt.Fprintf(os.Stderr, "%d error(s), not writing LLVM IR\n", errors)
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	allowImplicitExtern := flag.Bool("allow-implicit-extern", false,
+		"declare unknown functions as externs taking the types of their arguments")
+	outputFile := flag.String("o", "program.ll", "write the LLVM IR to `file`")
+	verbose := flag.Bool("verbose", false, "report each stage on stderr")
+	trace := flag.String("trace", "", "trace each node the `stages` visit, separated by commas: parse, codegen")
+	flag.Parse()
+	
+	if flag.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: go run main.go [--allow-implicit-extern] [--verbose] [--trace=stages] [-o file] <input-file>")
 		os.Exit(1)
 	}
+	inputFile := flag.Arg(0)
 	
-	fmt.Printf("Processing file: %s\n", os.Args[1])
+	// Everything but the IR goes to stderr, so stdout stays quiet
+	log, err := compiler.NewLogger(os.Stderr, *verbose, *trace)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	log.Verbosef("Processing file: %s", inputFile)
 	
 	// Create input stream
-	input, err := antlr.NewFileStream(os.Args[1])
+	input, err := antlr.NewFileStream(inputFile)
 	if err != nil {
-		fmt.Println("Error reading input file:", err)
+		log.Errorf("reading input file: %v", err)
 		os.Exit(1)
 	}
 	
+	// The visitor collects the diagnostics of every stage, which are shown
+	// with an excerpt of the source
+	visitor := NewCustomVisitor()
+	visitor.AllowImplicitExtern = *allowImplicitExtern
+	visitor.Log = log
+	renderer := compiler.NewDiagnosticRenderer(inputFile, input.String())
+	syntax := &syntaxErrors{DefaultErrorListener: antlr.NewDefaultErrorListener(), visitor: visitor}
+	
 	// Create lexer
 	lexer := NewCustomLanguageLexer(input)
+	lexer.RemoveErrorListeners()
+	lexer.AddErrorListener(syntax)
 	stream := antlr.NewCommonTokenStream(lexer, antlr.TokenDefaultChannel)
 	
 	// Create parser
 	parser := NewCustomLanguageParser(stream)
+	parser.RemoveErrorListeners()
+	parser.AddErrorListener(syntax)
 	parser.AddErrorListener(antlr.NewDiagnosticErrorListener(true))
 	
+	if log.Tracing("parse") {
+		parser.AddParseListener(&parseTracer{log: log, ruleNames: parser.GetRuleNames()})
+	}
+	
 	// Parse the input
-	fmt.Println("Parsing input...")
+	log.Verbosef("Parsing input...")
 	tree := parser.Program()
+	if visitor.Diagnostics.HasErrors() {
+		os.Exit(report(renderer, visitor.Diagnostics))
+	}
 	
-	// Create and run visitor
-	fmt.Println("Running visitor...")
-	visitor := NewCustomVisitor()
-	result := visitor.Visit(tree)
+	// Run the visitor
+	log.Verbosef("Running visitor...")
+	result := visitor.generate(tree)
+	
+	if code := report(renderer, visitor.Diagnostics); code != 0 {
+		os.Exit(code)
+	}
 	
 	if result == nil {
-		fmt.Println("Error: Visitor returned nil")
+		log.Errorf("Visitor returned nil")
 		os.Exit(1)
 	}
 	
 	module, ok := result.(*ir.Module)
 	if !ok {
-		fmt.Printf("Error: Expected *ir.Module, got %T\n", result)
+		log.Errorf("Expected *ir.Module, got %T", result)
 		os.Exit(1)
 	}
 	
+	// Check the IR here rather than leaving clang to reject it
+	if problems := compiler.CheckModule(module); len(problems) > 0 {
+		// Warnings have been shown already
+		visitor.Diagnostics = nil
+		for _, p := range problems {
+			visitor.internalError(p)
+		}
+		os.Exit(report(renderer, visitor.Diagnostics))
+	}
+	
 	// Output LLVM IR to file
-	outputFile := "program.ll"
-	fmt.Printf("Writing LLVM IR to %s...\n", outputFile)
+	log.Verbosef("Writing LLVM IR to %s...", *outputFile)
 	
-	file, err := os.Create(outputFile)
+	file, err := os.Create(*outputFile)
 	if err != nil {
-		fmt.Println("Error creating output file:", err)
+		log.Errorf("creating output file: %v", err)
 		os.Exit(1)
 	}
 	defer file.Close()
 	
 	file.WriteString(module.String())
-	fmt.Println("LLVM IR generation completed successfully!")
+	log.Verbosef("LLVM IR generation completed successfully!")
 }
\ No newline at end of file